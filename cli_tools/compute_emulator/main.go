@@ -0,0 +1,67 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// compute_emulator serves an in-memory emulation of the Compute Engine API,
+// for running Daisy workflows without a GCP project.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute/emulator"
+)
+
+var (
+	address    = flag.String("address", "localhost:8080", "address to listen on")
+	config     = flag.String("config", "", "path to a JSON file with the Images that exist at startup and the Instances scripts")
+	zones      = flag.String("zones", "", "comma separated list of the zones of the projects, overrides Zones in the config file")
+	opDuration = flag.Duration("operation_duration", time.Second, "how long operations take")
+)
+
+func main() {
+	flag.Parse()
+
+	var cfg emulator.Config
+	if *config != "" {
+		b, err := ioutil.ReadFile(*config)
+		if err != nil {
+			fmt.Println("Error reading config file:", err)
+			os.Exit(1)
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			fmt.Println("Error parsing config file:", err)
+			os.Exit(1)
+		}
+	}
+	if *zones != "" {
+		cfg.Zones = strings.Split(*zones, ",")
+	}
+	cfg.OperationDuration = *opDuration
+
+	e, err := emulator.New(cfg)
+	if err != nil {
+		fmt.Println("Error creating emulator:", err)
+		os.Exit(1)
+	}
+	log.Printf("Serving the Compute API on %s, use -compute_endpoint_override http://%s%s", *address, *address, emulator.BasePath)
+	log.Fatal(http.ListenAndServe(*address, e))
+}
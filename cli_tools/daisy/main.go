@@ -17,18 +17,22 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy"
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/option"
 )
 
 var (
@@ -39,12 +43,33 @@ var (
 	variables          = flag.String("variables", "", "comma separated list of variables, in the form 'key=value'")
 	print              = flag.Bool("print", false, "print out the parsed workflow for debugging")
 	validate           = flag.Bool("validate", false, "validate the workflow and exit")
+	plan               = flag.Bool("plan", false, "validate the workflow, print the API mutations a run would issue without issuing them, and exit")
 	format             = flag.Bool("format_workflow", false, "format the workflow file(s) and exit")
+	schema             = flag.Bool("schema", false, "print the JSON Schema of workflow files and exit")
+	validateSchema     = flag.Bool("validate_schema", false, "check the workflow file(s) against the workflow JSON Schema, without API access, and exit")
+	describe           = flag.Bool("describe", false, "print the Vars of the workflow file(s), with their types, defaults and allowed values, and exit")
+	formatAs           = flag.String("format_as", "", "with -format_workflow, convert the workflow file(s) to json or yaml, written next to the original file(s)")
+	graph              = flag.String("graph", "", "print the populated step DAG of the workflow in the given format, dot or mermaid, and exit")
+	graphResources     = flag.Bool("graph_resources", false, "with -graph, validate the workflow and add edges from the creator of each resource to its users and deleter")
 	defaultTimeout     = flag.String("default_timeout", "", "sets the default timeout for the workflow")
 	ce                 = flag.String("compute_endpoint_override", "", "API endpoint to override default")
 	gcsLogsDisabled    = flag.Bool("disable_gcs_logging", false, "do not stream logs to GCS")
 	cloudLogsDisabled  = flag.Bool("disable_cloud_logging", false, "do not stream logs to Cloud Logging")
 	stdoutLogsDisabled = flag.Bool("disable_stdout_logging", false, "do not display individual workflow logs on stdout")
+	resumable          = flag.Bool("resumable", false, "write a checkpoint after each step and keep resources created by completed steps if the workflow fails, so the run can be continued with -resume")
+	resume             = flag.String("resume", "", "continue a failed resumable run, identified by its scratch path or ID; implies -resumable")
+	summaryPath        = flag.String("summary_path", "", "local file to also write the run summary to, it is always written to ${OUTSPATH}/summary.json")
+	maxConcurrentSteps = flag.Int("max_concurrent_steps", 0, "maximum number of steps that run at once across all workflows, 0 means no limit; overrides the limits set in the workflows")
+	concurrencyLimits  = flag.String("concurrency_limits", "", "comma separated list of per resource kind limits on concurrent API operations across all workflows, in the form 'kind=n', e.g. 'instance=5,disk=10'; overrides the limits set in the workflows")
+	keepOnFailure      = flag.Bool("keep_on_failure", false, "do not clean up the resources of a workflow if it fails, delete them later with 'daisy cleanup'")
+	skipQuotaCheck     = flag.Bool("skip_quota_check", false, "do not check during validation that the projects have enough quota for the resources the workflow creates")
+	logFormat          = flag.String("log_format", daisy.LogFormatText, "format of the logs on stdout and in -log_file, text or json")
+	logLevel           = flag.String("log_level", "info", "minimum severity of the logs on stdout and in -log_file: debug, info, warn or error; all logs are sent to GCS and Cloud Logging")
+	logFile            = flag.String("log_file", "", "local file to also write the logs to, appended to if it exists")
+	traceFile          = flag.String("trace_file", "", "local file to write a trace of the run to, in the Chrome trace event format")
+	traceEndpoint      = flag.String("trace_otlp_endpoint", "", "OTLP/HTTP endpoint of an OpenTelemetry collector to send the spans of the run to, e.g. http://localhost:4318")
+	webhook            = flag.String("webhook", "", "URL to POST the lifecycle events of the workflows to, as JSON")
+	webhookSecret      = flag.String("webhook_secret", "", "key to sign the -webhook requests with, defaults to the DAISY_WEBHOOK_SECRET environment variable")
 )
 
 const (
@@ -78,15 +103,10 @@ func parseWorkflow(ctx context.Context, path string, varMap map[string]string, p
 	if err != nil {
 		return nil, err
 	}
-Loop:
 	for k, v := range varMap {
-		for wv := range w.Vars {
-			if k == wv {
-				w.AddVar(k, v)
-				continue Loop
-			}
+		if err := w.SetVar(k, v); err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("unknown workflow Var %q passed to Workflow %q", k, w.Name)
 	}
 
 	if project != "" {
@@ -157,7 +177,32 @@ func addFlags(args []string) {
 	}
 }
 
-func fmtWorkflow(path string) error {
+// convertedPath returns path with its json or yaml extension replaced by the
+// extension of format, or path if it already has that format.
+func convertedPath(path, format string) (string, error) {
+	switch format {
+	case "":
+		return path, nil
+	case "json":
+		if !daisy.IsYAML(path) {
+			return path, nil
+		}
+		return strings.TrimSuffix(path, filepath.Ext(path)) + ".json", nil
+	case "yaml":
+		if daisy.IsYAML(path) {
+			return path, nil
+		}
+		return strings.TrimSuffix(path, ".json") + ".yaml", nil
+	}
+	return "", fmt.Errorf("unknown workflow format %q, want json or yaml", format)
+}
+
+func fmtWorkflow(path, format string) error {
+	out, err := convertedPath(path, format)
+	if err != nil {
+		return err
+	}
+
 	f, err := os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
 		return err
@@ -169,15 +214,21 @@ func fmtWorkflow(path string) error {
 	}
 
 	var w *daisy.Workflow
-	if err := json.Unmarshal(data, &w); err != nil {
-		return daisy.JSONError(path, data, err)
+	if err := daisy.UnmarshalWorkflow(path, data, &w); err != nil {
+		return err
 	}
 
-	newData, err := json.MarshalIndent(w, "", "  ")
+	newData, err := daisy.MarshalWorkflow(out, w)
 	if err != nil {
 		return err
 	}
 
+	if out != path {
+		f.Close()
+		fmt.Printf("[Daisy] Writing workflow file %q\n", out)
+		return ioutil.WriteFile(out, newData, 0644)
+	}
+
 	if err := f.Truncate(0); err != nil {
 		return err
 	}
@@ -193,10 +244,83 @@ func fmtWorkflow(path string) error {
 	return nil
 }
 
+// cleanupMain runs 'daisy cleanup', which deletes the resources created by
+// Daisy workflows, found by their daisy-workflow-id labels.
+func cleanupMain(args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	project := fs.String("project", "", "project to clean up")
+	id := fs.String("id", "", "delete the resources created by the workflow run with this ID")
+	olderThan := fs.Duration("older_than", 0, "delete the resources created by workflows more than this long ago, e.g. 24h")
+	oauth := fs.String("oauth", "", "path to oauth json file")
+	ce := fs.String("compute_endpoint_override", "", "API endpoint to override default")
+	dryRun := fs.Bool("dry_run", false, "list the resources that would be deleted without deleting them")
+	fs.Parse(args)
+
+	if *id == "" && *olderThan == 0 {
+		return fmt.Errorf("one of -id or -older_than must be set")
+	}
+	ctx := context.Background()
+	if *project == "" {
+		if !metadata.OnGCE() {
+			return fmt.Errorf("-project must be set")
+		}
+		p, err := metadata.ProjectID()
+		if err != nil {
+			return err
+		}
+		*project = p
+	}
+
+	opts := []option.ClientOption{option.WithCredentialsFile(*oauth)}
+	if *ce != "" {
+		opts = append(opts, option.WithEndpoint(*ce))
+		if strings.HasPrefix(*ce, "http://") {
+			opts = append(opts, option.WithoutAuthentication())
+		}
+	}
+	client, err := daisyCompute.NewClient(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	rs, err := daisy.FindResources(ctx, client, *project, daisy.CleanupFilter{ID: *id, OlderThan: *olderThan})
+	if err != nil {
+		return err
+	}
+	if len(rs) == 0 {
+		fmt.Println("[Daisy] No resources to clean up.")
+		return nil
+	}
+	for _, r := range rs {
+		fmt.Printf("[Daisy] %s %s (workflow %q, id=%s, created %s)\n", r.Kind, r.Link(), r.WorkflowName, r.WorkflowID, r.Created.Format(time.RFC3339))
+	}
+	if *dryRun {
+		return nil
+	}
+	fmt.Printf("[Daisy] Deleting %d resources\n", len(rs))
+	return daisy.CleanupResources(ctx, client, rs)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cleanup" {
+		if err := cleanupMain(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	addFlags(os.Args[1:])
 	flag.Parse()
 
+	if *schema {
+		s, err := daisy.Schema()
+		if err != nil {
+			log.Fatalf("error generating schema: %v", err)
+		}
+		fmt.Println(string(s))
+		return
+	}
+
 	if len(flag.Args()) == 0 {
 		log.Fatal("Not enough args, first arg needs to be the path to a workflow.")
 	}
@@ -204,15 +328,94 @@ func main() {
 	if *format {
 		for _, path := range flag.Args() {
 			fmt.Printf("[Daisy] Formating workflow file %q\n", path)
-			if err := fmtWorkflow(path); err != nil {
+			if err := fmtWorkflow(path, *formatAs); err != nil {
 				fmt.Print(err)
 			}
 		}
 		return
 	}
 
+	if *validateSchema {
+		valid := true
+		for _, path := range flag.Args() {
+			fmt.Printf("[Daisy] Validating workflow file %q against the schema\n", path)
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, err := range daisy.ValidateSchema(path, data) {
+				fmt.Fprintln(os.Stderr, err)
+				valid = false
+			}
+		}
+		if !valid {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *describe {
+		for _, path := range flag.Args() {
+			w, err := daisy.NewFromFile(path)
+			if err != nil {
+				log.Fatalf("error parsing workflow %q: %v", path, err)
+			}
+			fmt.Printf("[Daisy] Vars of workflow %q\n", w.Name)
+			fmt.Print(w.DescribeVars())
+		}
+		return
+	}
+
 	ctx := context.Background()
 
+	if *resume != "" && len(flag.Args()) > 1 {
+		log.Fatal("-resume can only be used with a single workflow.")
+	}
+	if *summaryPath != "" && len(flag.Args()) > 1 {
+		log.Fatal("-summary_path can only be used with a single workflow.")
+	}
+	if *traceFile != "" && len(flag.Args()) > 1 {
+		log.Fatal("-trace_file can only be used with a single workflow.")
+	}
+
+	// Workflows share one scheduler, so the limits apply to all of them.
+	var sch *daisy.Scheduler
+	if *maxConcurrentSteps != 0 || *concurrencyLimits != "" {
+		limits, err := daisy.ParseConcurrencyLimits(*concurrencyLimits)
+		if err != nil {
+			log.Fatalf("error parsing -concurrency_limits: %v", err)
+		}
+		if sch, err = daisy.NewScheduler(*maxConcurrentSteps, limits); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	level, err := daisy.ParseSeverity(*logLevel)
+	if err != nil {
+		log.Fatalf("error parsing -log_level: %v", err)
+	}
+	var logW io.Writer
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("error opening -log_file: %v", err)
+		}
+		defer f.Close()
+		logW = f
+	}
+
+	// Workflows share one webhook, the events of each have its workflow ID.
+	var hook *daisy.Webhook
+	if *webhook != "" {
+		secret := *webhookSecret
+		if secret == "" {
+			secret = os.Getenv("DAISY_WEBHOOK_SECRET")
+		}
+		if hook, err = daisy.NewWebhook(*webhook, secret); err != nil {
+			log.Fatalf("error parsing -webhook: %v", err)
+		}
+	}
+
 	var ws []*daisy.Workflow
 	varMap := populateVars(*variables)
 
@@ -221,21 +424,61 @@ func main() {
 		if err != nil {
 			log.Fatalf("error parsing workflow %q: %v", path, err)
 		}
+		if *plan || *graph != "" {
+			// Only the plan or graph itself is printed.
+			w.DisableGCSLogging()
+			w.DisableCloudLogging()
+			w.DisableStdoutLogging()
+		}
+		if *resume != "" {
+			w.ResumeFrom(*resume)
+		} else if *resumable {
+			w.EnableCheckpointing()
+		}
+		if *summaryPath != "" {
+			w.SetSummaryPath(*summaryPath)
+		}
+		if *keepOnFailure {
+			w.EnableKeepOnFailure()
+		}
+		if *skipQuotaCheck {
+			w.SkipQuotaCheck = true
+		}
+		if *traceFile != "" {
+			w.SetTraceFile(*traceFile)
+		}
+		if *traceEndpoint != "" {
+			w.SetTraceEndpoint(*traceEndpoint)
+		}
+		if hook != nil {
+			w.AddEventSink(hook)
+		}
+		if err := w.SetLogFormat(*logFormat); err != nil {
+			log.Fatalf("error parsing -log_format: %v", err)
+		}
+		w.SetLogLevel(level)
+		if logW != nil {
+			w.AddLogWriter(logW)
+		}
+		if sch != nil {
+			w.SetScheduler(sch)
+		}
 		ws = append(ws, w)
 	}
 
 	errors := make(chan error, len(ws))
 	var wg sync.WaitGroup
 	for _, w := range ws {
+		ctx, cancel := context.WithCancel(ctx)
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
 		go func(w *daisy.Workflow) {
 			select {
 			case <-c:
 				fmt.Printf("\nCtrl-C caught, sending cancel signal to %q...\n", w.Name)
-				close(w.Cancel)
+				cancel()
 				errors <- fmt.Errorf("workflow %q was canceled", w.Name)
-			case <-w.Cancel:
+			case <-ctx.Done():
 			}
 		}(w)
 		if *print {
@@ -246,16 +489,36 @@ func main() {
 		if *validate {
 			fmt.Printf("[Daisy] Validating workflow %q\n", w.Name)
 			if err := w.Validate(ctx); err != nil {
-				fmt.Fprintf(os.Stderr, "[Daisy] Error validating workflow %q: %v\n", w.Name, err)
+				fmt.Fprintf(os.Stderr, "[Daisy] Error validating workflow %q: %s\n", w.Name, w.Redact(err.Error()))
+			}
+			continue
+		}
+		if *plan {
+			fmt.Printf("[Daisy] Planning workflow %q\n", w.Name)
+			p, err := w.Plan(ctx)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[Daisy] Error planning workflow %q: %s\n", w.Name, w.Redact(err.Error()))
+				continue
+			}
+			fmt.Print(p)
+			continue
+		}
+		if *graph != "" {
+			g, err := w.Graph(ctx, *graph, *graphResources)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[Daisy] Error graphing workflow %q: %s\n", w.Name, w.Redact(err.Error()))
+				continue
 			}
+			fmt.Print(g)
 			continue
 		}
 		wg.Add(1)
 		go func(w *daisy.Workflow) {
 			defer wg.Done()
+			defer cancel()
 			fmt.Printf("[Daisy] Running workflow %q (id=%s)\n", w.Name, w.ID())
 			if err := w.Run(ctx); err != nil {
-				errors <- fmt.Errorf("%s: %v", w.Name, err)
+				errors <- fmt.Errorf("%s: %s", w.Name, w.Redact(err.Error()))
 				return
 			}
 			fmt.Printf("[Daisy] Workflow %q finished\n", w.Name)
@@ -277,7 +540,7 @@ func main() {
 			}
 		}
 	default:
-		if !*print && !*validate {
+		if !*print && !*validate && !*plan && *graph == "" {
 			fmt.Println("[Daisy] All workflows completed successfully.")
 		}
 	}
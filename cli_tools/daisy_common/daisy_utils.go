@@ -16,7 +16,6 @@ package daisycommon
 
 import (
 	"context"
-	"fmt"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy"
@@ -28,15 +27,10 @@ func ParseWorkflow(ctx context.Context, path string, varMap map[string]string, p
 	if err != nil {
 		return nil, err
 	}
-Loop:
 	for k, v := range varMap {
-		for wv := range w.Vars {
-			if k == wv {
-				w.AddVar(k, v)
-				continue Loop
-			}
+		if err := w.SetVar(k, v); err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("unknown workflow Var %q passed to Workflow %q", k, w.Name)
 	}
 
 	if project != "" {
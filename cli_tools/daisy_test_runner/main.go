@@ -57,6 +57,8 @@ var (
 	filter        = flag.String("filter", "", "test name filter")
 	outPath       = flag.String("out_path", "junit.xml", "junit xml path")
 	parallelCount = flag.Int("parallel_count", 0, "TestParallelCount")
+	maxSteps      = flag.Int("max_concurrent_steps", 0, "maximum number of steps that run at once across all test cases, 0 means no limit; overrides the limits set in the test workflows")
+	limits        = flag.String("concurrency_limits", "", "comma separated list of per resource kind limits on concurrent API operations across all test cases, in the form 'kind=n', e.g. 'instance=5,disk=10'; overrides the limits set in the test workflows")
 
 	funcMap = map[string]interface{}{
 		"randItem": randItem,
@@ -516,17 +518,19 @@ func runTestCase(ctx context.Context, test *test, tc *junitTestCase, errors chan
 		return
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		select {
 		case <-c:
 			fmt.Printf("\nCtrl-C caught, sending cancel signal to %q...\n", test.name)
-			close(test.testCase.w.Cancel)
+			cancel()
 			err := fmt.Errorf("test case %q was canceled", test.name)
 			errors <- err
 			tc.Failure = &junitFailure{FailMessage: err.Error(), FailType: "Canceled"}
-		case <-test.testCase.w.Cancel:
+		case <-ctx.Done():
 		}
 	}()
 
@@ -582,7 +586,7 @@ func runTestCase(ctx context.Context, test *test, tc *junitTestCase, errors chan
 	}()
 
 	select {
-	case <-test.testCase.w.Cancel:
+	case <-ctx.Done():
 		return
 	default:
 	}
@@ -623,6 +627,18 @@ func main() {
 		}
 	}
 
+	// Test cases share one scheduler, so the limits apply to all of them.
+	var sch *daisy.Scheduler
+	if *maxSteps != 0 || *limits != "" {
+		kindLimits, err := daisy.ParseConcurrencyLimits(*limits)
+		if err != nil {
+			log.Fatalln("-concurrency_limits flag not valid:", err)
+		}
+		if sch, err = daisy.NewScheduler(*maxSteps, kindLimits); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
 	ctx := context.Background()
 
 	ts, err := createTestSuite(ctx, flag.Arg(0), varMap, regex)
@@ -632,6 +648,13 @@ func main() {
 	if ts == nil {
 		return
 	}
+	if sch != nil {
+		for _, t := range ts.Tests {
+			if t.w != nil {
+				t.w.SetScheduler(sch)
+			}
+		}
+	}
 
 	errors := make(chan error, len(ts.Tests))
 	// Retry failed locks 2x as many tests in the test case.
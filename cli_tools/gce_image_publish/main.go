@@ -685,21 +685,23 @@ func main() {
 
 	var wg sync.WaitGroup
 	for _, w := range ws {
+		ctx, cancel := context.WithCancel(ctx)
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
 		go func(w *daisy.Workflow) {
 			select {
 			case <-c:
 				fmt.Printf("\nCtrl-C caught, sending cancel signal to %q...\n", w.Name)
-				close(w.Cancel)
+				cancel()
 				errors <- fmt.Errorf("workflow %q was canceled", w.Name)
-			case <-w.Cancel:
+			case <-ctx.Done():
 			}
 		}(w)
 
 		wg.Add(1)
 		go func(w *daisy.Workflow) {
 			defer wg.Done()
+			defer cancel()
 			fmt.Printf("[Publish] Running workflow %q\n", w.Name)
 			if err := w.Run(ctx); err != nil {
 				errors <- fmt.Errorf("%s: %v", w.Name, err)
@@ -68,6 +68,7 @@ var (
 	kmsProject           = flag.String("kms_project", "", "The Cloud project for the key")
 	noExternalIP         = flag.Bool("no_external_ip", false, "VPC doesn't allow external IPs")
 	labels               = flag.String("labels", "", "List of label KEY=VALUE pairs to add. Keys must start with a lowercase character and contain only hyphens (-), underscores (_), lowercase characters, and numbers. Values must contain only hyphens (-), underscores (_), lowercase characters, and numbers.")
+	summaryPath          = flag.String("summary_path", "", "local file to also write the run summary of the import workflow to, it is always written to the outs path of the workflow")
 
 	region    *string
 	buildID   = os.Getenv("BUILD_ID")
@@ -208,7 +209,7 @@ func getTranslateWorkflowPath(os *string) string {
 
 func fatalIfError(f func() error) {
 	if err := f(); err != nil {
-		log.Fatalf(err.Error())
+		log.Fatal(err)
 	}
 }
 
@@ -399,10 +400,16 @@ func main() {
 		*scratchBucketGcsPath, *oauth, *timeout, *ce, *gcsLogsDisabled, *cloudLogsDisabled,
 		*stdoutLogsDisabled)
 	if err != nil {
+		// The workflow is not populated yet, its Vars are not resolved and
+		// errors about the values of secret Vars mask them.
 		log.Fatalf("Error parsing workflow %q: %v", importWorkflowPath, err)
 	}
 
+	if *summaryPath != "" {
+		workflow.SetSummaryPath(*summaryPath)
+	}
+
 	if err := workflow.RunWithModifier(ctx, updateWorkflow); err != nil {
-		log.Fatalf("%s: %v", workflow.Name, err)
+		log.Fatalf("%s: %s", workflow.Name, workflow.Redact(err.Error()))
 	}
 }
@@ -0,0 +1,355 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	checkpointFile = "daisy-checkpoint.json"
+	// checkpointWriteTimeout bounds a checkpoint write, which isn't stopped
+	// with the run so that a canceled step is still recorded.
+	checkpointWriteTimeout = time.Minute
+
+	stepStatusDone   = "Done"
+	stepStatusFailed = "Failed"
+)
+
+// checkpoint records the progress of a workflow run in its scratch path so
+// that a failed run can be resumed.
+type checkpoint struct {
+	// Autovars each workflow in the tree was populated with, keyed by the
+	// fullName of the step that owns the workflow ("" for the root workflow).
+	Workflows map[string]map[string]string
+	// Results of finished steps, keyed by step fullName.
+	Steps map[string]*stepCheckpoint
+
+	failed bool
+	mx     sync.Mutex
+}
+
+type stepCheckpoint struct {
+	Status string
+	// Order in which the steps were recorded, across resumed runs.
+	Seq   int
+	Error string `json:",omitempty"`
+	// Partial URLs of the resources this step created, deleted, stopped and
+	// started.
+	Created []string `json:",omitempty"`
+	Deleted []string `json:",omitempty"`
+	Stopped []string `json:",omitempty"`
+	Started []string `json:",omitempty"`
+	// Outputs this step published, keyed by output key.
+	Outputs map[string]string `json:",omitempty"`
+}
+
+func newCheckpoint() *checkpoint {
+	return &checkpoint{Workflows: map[string]map[string]string{}, Steps: map[string]*stepCheckpoint{}}
+}
+
+// EnableCheckpointing makes the workflow write a checkpoint to its scratch
+// path after each step. If the workflow fails, resources created by steps that
+// completed are not cleaned up so the run can be continued with ResumeFrom.
+func (w *Workflow) EnableCheckpointing() {
+	w.checkpointing = true
+}
+
+// ResumeFrom continues a failed checkpointed run, identified by either its
+// scratch path (gs://bucket/path/daisy-NAME-DATETIME-ID) or its ID. Steps
+// that completed in the previous run are skipped and the resources they
+// created are reused.
+func (w *Workflow) ResumeFrom(ref string) {
+	w.checkpointing = true
+	w.resumeFrom = ref
+}
+
+func (w *Workflow) root() *Workflow {
+	for w.parent != nil {
+		w = w.parent
+	}
+	return w
+}
+
+// ownerName returns the fullName of the step that owns w, or "" for the
+// root workflow.
+func (w *Workflow) ownerName() string {
+	if st := w.ownerStep(); st != nil {
+		return st.fullName()
+	}
+	return ""
+}
+
+// loadCheckpoint reads the checkpoint of the run referenced by w.resumeFrom.
+func (w *Workflow) loadCheckpoint(ctx context.Context) dErr {
+	bkt, obj, err := splitGCSPath(w.resumeFrom)
+	if err == nil {
+		if err := w.checkStorePath(w.resumeFrom); err != nil {
+			return err
+		}
+		obj = path.Join(obj, checkpointFile)
+	} else if bkt, obj, err = w.findCheckpoint(ctx, w.resumeFrom); err != nil {
+		return err
+	}
+
+	u := w.store().URL(bkt, obj)
+	r, rErr := w.store().NewReader(ctx, bkt, obj)
+	if rErr != nil {
+		return errf("error reading checkpoint %s: %v", u, rErr)
+	}
+	defer r.Close()
+	data, rErr := ioutil.ReadAll(r)
+	if rErr != nil {
+		return errf("error reading checkpoint %s: %v", u, rErr)
+	}
+
+	cp := newCheckpoint()
+	if err := json.Unmarshal(data, cp); err != nil {
+		return errf("error parsing checkpoint %s: %v", u, err)
+	}
+	if _, ok := cp.Workflows[""]["ID"]; !ok {
+		return errf("checkpoint %s does not record a workflow ID", u)
+	}
+	w.checkpoint = cp
+	return nil
+}
+
+// findCheckpoint searches the workflow's GCSPath for the checkpoint of the
+// run with the given ID.
+func (w *Workflow) findCheckpoint(ctx context.Context, id string) (string, string, dErr) {
+	var replacements []string
+	for k, v := range w.Vars {
+		replacements = append(replacements, fmt.Sprintf("${%s}", k), v.Value)
+	}
+	gcsPath := strings.NewReplacer(replacements...).Replace(w.GCSPath)
+	if gcsPath == "" {
+		dBkt, err := daisyBkt(ctx, w.StorageClient, w.Project)
+		if err != nil {
+			return "", "", err
+		}
+		gcsPath = "gs://" + dBkt
+	}
+	bkt, p, err := splitGCSPath(gcsPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	suffix := fmt.Sprintf("-%s/%s", id, checkpointFile)
+	objs, lErr := w.store().List(ctx, bkt, path.Join(p, "daisy-"))
+	if lErr != nil {
+		return "", "", typedErr(apiError, lErr)
+	}
+	for _, objAttr := range objs {
+		if strings.HasSuffix(objAttr.Name, suffix) {
+			return bkt, objAttr.Name, nil
+		}
+	}
+	return "", "", typedErrf(resourceDNEError, "no checkpoint found for workflow ID %q in %s", id, gcsPath)
+}
+
+// resumedAutovars returns the autovars w was populated with in the run being
+// resumed, or nil if w is not being resumed.
+func (w *Workflow) resumedAutovars() map[string]string {
+	root := w.root()
+	if root.resumeFrom == "" || root.checkpoint == nil {
+		return nil
+	}
+	root.checkpoint.mx.Lock()
+	defer root.checkpoint.mx.Unlock()
+	return root.checkpoint.Workflows[w.ownerName()]
+}
+
+// recordAutovars saves w's autovars in the checkpoint.
+func (w *Workflow) recordAutovars() {
+	root := w.root()
+	if root.checkpoint == nil {
+		return
+	}
+	av := map[string]string{}
+	for k, v := range w.autovars {
+		av[k] = v
+	}
+	root.checkpoint.mx.Lock()
+	root.checkpoint.Workflows[w.ownerName()] = av
+	root.checkpoint.mx.Unlock()
+}
+
+// stepDone returns true if s completed in this run or in the run being resumed.
+func (w *Workflow) stepDone(s *Step) bool {
+	root := w.root()
+	if root.checkpoint == nil {
+		return false
+	}
+	root.checkpoint.mx.Lock()
+	defer root.checkpoint.mx.Unlock()
+	sc, ok := root.checkpoint.Steps[s.fullName()]
+	return ok && sc.Status == stepStatusDone
+}
+
+// recordStep saves the result of s in the checkpoint and writes the
+// checkpoint to the scratch path.
+func (w *Workflow) recordStep(ctx context.Context, s *Step, err dErr) {
+	root := w.root()
+	if root.checkpoint == nil {
+		return
+	}
+	sc := &stepCheckpoint{Status: stepStatusDone}
+	if err != nil {
+		sc.Status = stepStatusFailed
+		sc.Error = root.Redact(err.Error())
+	} else {
+		for _, r := range s.w.resourceRegistries() {
+			r.mx.Lock()
+			for _, res := range r.m {
+				if res.creator == s {
+					sc.Created = append(sc.Created, res.link)
+				}
+				if res.deleter == s && res.deleted {
+					sc.Deleted = append(sc.Deleted, res.link)
+				}
+			}
+			r.mx.Unlock()
+		}
+		if s.StopInstances != nil {
+			sc.Stopped = s.w.instanceLinks(s.StopInstances.Instances)
+		}
+		if s.StartInstances != nil {
+			sc.Started = s.w.instanceLinks(s.StartInstances.Instances)
+		}
+		if outs := s.w.stepOutputs(s.name); len(outs) > 0 {
+			sc.Outputs = outs
+		}
+	}
+
+	// The checkpoint is marshalled and written under one lock, so that an
+	// older snapshot never overwrites a newer one.
+	root.checkpointMx.Lock()
+	defer root.checkpointMx.Unlock()
+	root.checkpoint.mx.Lock()
+	for _, other := range root.checkpoint.Steps {
+		if other.Seq >= sc.Seq {
+			sc.Seq = other.Seq + 1
+		}
+	}
+	root.checkpoint.Steps[s.fullName()] = sc
+	if err != nil {
+		root.checkpoint.failed = true
+	}
+	data, mErr := json.MarshalIndent(root.checkpoint, "", "  ")
+	root.checkpoint.mx.Unlock()
+	if mErr != nil {
+		root.logWorkflow(SeverityError, "Error marshalling checkpoint: %v", mErr)
+		return
+	}
+
+	wctx, cancel := context.WithTimeout(withSpanOf(context.Background(), ctx), checkpointWriteTimeout)
+	defer cancel()
+	wc := root.store().NewWriter(wctx, root.bucket, path.Join(root.scratchPath, checkpointFile), "application/json")
+	if _, err := wc.Write(data); err != nil {
+		root.logWorkflow(SeverityError, "Error writing checkpoint: %v", err)
+		return
+	}
+	if err := wc.Close(); err != nil {
+		root.logWorkflow(SeverityError, "Error writing checkpoint: %v", err)
+	}
+}
+
+// instanceLinks returns the partial URLs of the instances in the registry.
+func (w *Workflow) instanceLinks(instances []string) []string {
+	var links []string
+	for _, i := range instances {
+		if res, ok := w.instances.get(i); ok {
+			links = append(links, res.link)
+		}
+	}
+	return links
+}
+
+// skipDoneStep restores the registry state and outputs a step that completed
+// in the run being resumed left behind, from the checkpoint records of the
+// step and of the steps of its included and sub workflows.
+func (w *Workflow) skipDoneStep(s *Step) {
+	w.LogWorkflowInfo("Step %q completed in a previous run, skipping.", s.name)
+	name := s.fullName()
+	root := w.root()
+	root.checkpoint.mx.Lock()
+	var records []*stepCheckpoint
+	for n, sc := range root.checkpoint.Steps {
+		if n == name || strings.HasPrefix(n, name+".") {
+			records = append(records, sc)
+		}
+	}
+	sc := root.checkpoint.Steps[name]
+	root.checkpoint.mx.Unlock()
+	sort.Slice(records, func(i, j int) bool { return records[i].Seq < records[j].Seq })
+
+	regs := map[string]*baseResourceRegistry{}
+	byLink := map[string]*Resource{}
+	for _, r := range s.w.resourceRegistries() {
+		r.mx.Lock()
+		for _, res := range r.m {
+			regs[res.link] = r
+			byLink[res.link] = res
+		}
+		r.mx.Unlock()
+	}
+	set := func(links []string, f func(res *Resource)) {
+		for _, l := range links {
+			if res, ok := byLink[l]; ok {
+				regs[l].mx.Lock()
+				f(res)
+				regs[l].mx.Unlock()
+			}
+		}
+	}
+	for _, rec := range records {
+		for _, l := range rec.Created {
+			if _, ok := byLink[l]; !ok {
+				w.logWorkflow(SeverityWarn, "Resource %q created in a previous run is not in the workflow and will not be cleaned up.", l)
+			}
+		}
+		set(rec.Stopped, func(res *Resource) { res.stopped = true })
+		set(rec.Started, func(res *Resource) { res.stopped = false })
+		set(rec.Deleted, func(res *Resource) { res.deleted = true })
+	}
+
+	for k, v := range sc.Outputs {
+		s.w.setOutput(s.name, k, v)
+	}
+}
+
+// keepForResume returns true if res must survive cleanup so a failed run can
+// be resumed: the run failed and the step that created res completed.
+func (w *Workflow) keepForResume(res *Resource) bool {
+	if w == nil || res.creator == nil {
+		return false
+	}
+	root := w.root()
+	if root.checkpoint == nil {
+		return false
+	}
+	root.checkpoint.mx.Lock()
+	failed := root.checkpoint.failed
+	root.checkpoint.mx.Unlock()
+	return failed && w.stepDone(res.creator)
+}
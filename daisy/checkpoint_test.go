@@ -0,0 +1,203 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordStep(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.checkpoint = newCheckpoint()
+	s, _ := w.NewStep("s")
+	other, _ := w.NewStep("other")
+	stop, _ := w.NewStep("stop")
+	stop.StopInstances = &StopInstances{Instances: []string{"i"}}
+	w.disks.m = map[string]*Resource{
+		"d1": {link: "projects/p/zones/z/disks/d1", creator: s},
+		"d2": {link: "projects/p/zones/z/disks/d2", creator: other},
+		"d3": {link: "projects/p/zones/z/disks/d3", deleter: s, deleted: true},
+	}
+	w.instances.m = map[string]*Resource{"i": {link: "projects/p/zones/z/instances/i", stopped: true}}
+
+	if w.stepDone(s) {
+		t.Error("step should not be done before it is recorded")
+	}
+	w.recordStep(ctx, s, nil)
+	if !w.stepDone(s) {
+		t.Error("step should be done after it is recorded")
+	}
+	want := &stepCheckpoint{Status: stepStatusDone, Created: []string{"projects/p/zones/z/disks/d1"}, Deleted: []string{"projects/p/zones/z/disks/d3"}}
+	if got := w.checkpoint.Steps["s"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected checkpoint, want: %+v, got: %+v", want, got)
+	}
+	if w.checkpoint.failed {
+		t.Error("checkpoint should not be marked failed")
+	}
+
+	w.recordStep(ctx, other, errf("fail"))
+	if w.stepDone(other) {
+		t.Error("failed step should not be done")
+	}
+	want = &stepCheckpoint{Status: stepStatusFailed, Seq: 1, Error: "fail"}
+	if got := w.checkpoint.Steps["other"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected checkpoint, want: %+v, got: %+v", want, got)
+	}
+
+	w.recordStep(ctx, stop, nil)
+	want = &stepCheckpoint{Status: stepStatusDone, Seq: 2, Stopped: []string{"projects/p/zones/z/instances/i"}}
+	if got := w.checkpoint.Steps["stop"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected checkpoint, want: %+v, got: %+v", want, got)
+	}
+	if !w.checkpoint.failed {
+		t.Error("checkpoint should be marked failed")
+	}
+}
+
+// ctxStorage is a fileStorage that records the error of the context its
+// writers are created with.
+type ctxStorage struct {
+	fileStorage
+	ctxErr error
+}
+
+func (s *ctxStorage) NewWriter(ctx context.Context, bkt, obj, contentType string) io.WriteCloser {
+	s.ctxErr = ctx.Err()
+	return s.fileStorage.NewWriter(ctx, bkt, obj, contentType)
+}
+
+func TestRecordStepCanceled(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := testWorkflow()
+	st := &ctxStorage{}
+	w.Storage = st
+	w.bucket = strings.TrimPrefix(td, "/")
+	w.checkpoint = newCheckpoint()
+	s, _ := w.NewStep("s")
+
+	w.recordStep(ctx, s, errf("step %q was canceled", "s"))
+	if st.ctxErr != nil {
+		t.Errorf("checkpoint written with a done context: %v", st.ctxErr)
+	}
+	data, err := ioutil.ReadFile(st.file(w.bucket, path.Join(w.scratchPath, checkpointFile)))
+	if err != nil {
+		t.Fatalf("checkpoint not written: %v", err)
+	}
+	var got checkpoint
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("error reading checkpoint: %v", err)
+	}
+	if sc := got.Steps["s"]; sc == nil || sc.Status != stepStatusFailed {
+		t.Errorf("canceled step not recorded as failed, got: %+v", sc)
+	}
+}
+
+func TestRunStepSkipsDoneStep(t *testing.T) {
+	w := testWorkflow()
+	w.checkpoint = newCheckpoint()
+	// The included step stopped the instance and a later one started it.
+	w.checkpoint.Steps["s"] = &stepCheckpoint{Status: stepStatusDone, Seq: 3, Deleted: []string{"projects/p/zones/z/disks/d"}}
+	w.checkpoint.Steps["s.stop"] = &stepCheckpoint{Status: stepStatusDone, Seq: 0, Stopped: []string{"projects/p/zones/z/instances/i", "projects/p/zones/z/instances/j"}}
+	w.checkpoint.Steps["s.start"] = &stepCheckpoint{Status: stepStatusDone, Seq: 1, Started: []string{"projects/p/zones/z/instances/j"}}
+	w.checkpoint.Steps["other"] = &stepCheckpoint{Status: stepStatusDone, Seq: 2, Deleted: []string{"projects/p/zones/z/disks/o"}}
+	s, _ := w.NewStep("s")
+	called := false
+	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		called = true
+		return nil
+	}}
+	d := &Resource{link: "projects/p/zones/z/disks/d", deleter: s}
+	o := &Resource{link: "projects/p/zones/z/disks/o"}
+	w.disks.m = map[string]*Resource{"d": d, "o": o}
+	i := &Resource{link: "projects/p/zones/z/instances/i"}
+	j := &Resource{link: "projects/p/zones/z/instances/j"}
+	w.instances.m = map[string]*Resource{"i": i, "j": j}
+
+	if err := w.runStep(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if called {
+		t.Error("step completed in a previous run should not run again")
+	}
+	if !d.deleted {
+		t.Error("resource deleted by a completed step should be marked deleted")
+	}
+	if o.deleted {
+		t.Error("resource deleted by another step should not be marked deleted")
+	}
+	if !i.stopped || j.stopped {
+		t.Errorf("instances not restored as stopped and started, got stopped %t and %t", i.stopped, j.stopped)
+	}
+}
+
+func TestCleanupKeepsResumableResources(t *testing.T) {
+	w := testWorkflow()
+	w.checkpoint = newCheckpoint()
+	done, _ := w.NewStep("done")
+	failed, _ := w.NewStep("failed")
+	w.checkpoint.Steps["done"] = &stepCheckpoint{Status: stepStatusDone}
+	w.checkpoint.Steps["failed"] = &stepCheckpoint{Status: stepStatusFailed}
+	w.checkpoint.failed = true
+
+	kept := &Resource{RealName: "kept", link: "link", creator: done}
+	cleaned := &Resource{RealName: "cleaned", link: "link", creator: failed}
+	w.disks.m = map[string]*Resource{"kept": kept, "cleaned": cleaned}
+
+	w.cleanup()
+
+	if kept.deleted {
+		t.Error("cleanup deleted a resource created by a completed step")
+	}
+	if !cleaned.deleted {
+		t.Error("cleanup didn't delete a resource created by a failed step")
+	}
+}
+
+func TestPopulateResumed(t *testing.T) {
+	w := testWorkflow()
+	w.ResumeFrom("gs://test-bucket/daisy-test-wf-20170714-02:40:00-xyz")
+	w.checkpoint = newCheckpoint()
+	w.checkpoint.Workflows[""] = map[string]string{"ID": "xyz", "TIMESTAMP": "1500000000"}
+	w.Steps = map[string]*Step{"s": {testType: &mockStep{}}}
+
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	if w.id != "xyz" {
+		t.Errorf("resumed workflow should reuse the previous ID, got: %q", w.id)
+	}
+	if want := "daisy-test-wf-20170714-02:40:00-xyz"; w.scratchPath != want {
+		t.Errorf("resumed workflow should reuse the previous scratch path, want: %q, got: %q", want, w.scratchPath)
+	}
+	if got := w.checkpoint.Workflows[""]["DATE"]; got != "20170714" {
+		t.Errorf("resumed autovars not recorded, got DATE: %q", got)
+	}
+}
@@ -0,0 +1,333 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+)
+
+// Labels identifying the run of the workflow tree that created a resource.
+const (
+	workflowIDLabel   = "daisy-workflow-id"
+	workflowNameLabel = "daisy-workflow-name"
+)
+
+// workflowMarkerRgx matches the workflow labels in the description of
+// resources without labels, see addWorkflowMarker.
+var workflowMarkerRgx = regexp.MustCompile(`\[` + workflowIDLabel + `=([-_a-z0-9]*) ` + workflowNameLabel + `=([-_a-z0-9]*)]`)
+
+// cleanupOrder are the resource kinds FindResources finds, in the order
+// CleanupResources deletes them: users before the resources they use.
+var cleanupOrder = []string{"forwardingRule", "targetInstance", "instance", "image", "disk", "firewallRule", "subnetwork", "network"}
+
+// EnableKeepOnFailure makes the workflow keep the resources it created if the
+// run fails, instead of cleaning them up, so they can be inspected. They can
+// be deleted later with CleanupResources.
+func (w *Workflow) EnableKeepOnFailure() {
+	w.keepOnFailure = true
+}
+
+// keptOnFailure returns true if the resources of the workflow tree must
+// survive cleanup: keep on failure is enabled and a step or the run failed.
+func (w *Workflow) keptOnFailure() bool {
+	root := w.root()
+	root.failedMx.Lock()
+	defer root.failedMx.Unlock()
+	return root.keepOnFailure && (root.failedStep != "" || root.runFailed)
+}
+
+// labelValue returns s as a valid label value.
+func labelValue(s string) string {
+	s = strings.ToLower(s)
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+// addWorkflowLabels adds the labels identifying the run of the workflow tree
+// of w to labels of res. Resources with NoCleanup, like the images workflows
+// produce, are not labeled so that CleanupResources never deletes them.
+func (w *Workflow) addWorkflowLabels(res *Resource, labels map[string]string) map[string]string {
+	if res.NoCleanup {
+		return labels
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	root := w.root()
+	labels[workflowIDLabel] = labelValue(root.id)
+	labels[workflowNameLabel] = labelValue(root.Name)
+	return labels
+}
+
+// addWorkflowMarker adds the labels identifying the run of the workflow tree
+// of w to the description of a resource that has no labels, like networks,
+// firewall rules and forwarding rules. Like labels, the marker is not added
+// to resources with NoCleanup.
+func (w *Workflow) addWorkflowMarker(res *Resource, description string) string {
+	if res.NoCleanup {
+		return description
+	}
+	root := w.root()
+	m := fmt.Sprintf("[%s=%s %s=%s]", workflowIDLabel, labelValue(root.id), workflowNameLabel, labelValue(root.Name))
+	if description == "" {
+		return m
+	}
+	return description + " " + m
+}
+
+// CleanupFilter selects the resources created by Daisy that FindResources
+// finds. At least one field must be set, resources match all fields that
+// are set.
+type CleanupFilter struct {
+	// ID of the workflow run that created the resources.
+	ID string
+	// Minimum age of the resources.
+	OlderThan time.Duration
+}
+
+// CreatedResource is a resource created by a Daisy workflow.
+type CreatedResource struct {
+	// Kind of the resource: forwardingRule, targetInstance, instance, image,
+	// disk, firewallRule, subnetwork or network.
+	Kind    string
+	Project string
+	// Zone or region of the resource, empty for global resources.
+	Location     string
+	Name         string
+	WorkflowID   string
+	WorkflowName string
+	Created      time.Time
+}
+
+// Link returns the partial URL of r.
+func (r *CreatedResource) Link() string {
+	switch r.Kind {
+	case "forwardingRule":
+		return fmt.Sprintf("projects/%s/regions/%s/forwardingRules/%s", r.Project, r.Location, r.Name)
+	case "targetInstance":
+		return fmt.Sprintf("projects/%s/zones/%s/targetInstances/%s", r.Project, r.Location, r.Name)
+	case "instance":
+		return fmt.Sprintf("projects/%s/zones/%s/instances/%s", r.Project, r.Location, r.Name)
+	case "disk":
+		return fmt.Sprintf("projects/%s/zones/%s/disks/%s", r.Project, r.Location, r.Name)
+	case "image":
+		return fmt.Sprintf("projects/%s/global/images/%s", r.Project, r.Name)
+	case "firewallRule":
+		return fmt.Sprintf("projects/%s/global/firewalls/%s", r.Project, r.Name)
+	case "subnetwork":
+		return fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", r.Project, r.Location, r.Name)
+	case "network":
+		return fmt.Sprintf("projects/%s/global/networks/%s", r.Project, r.Name)
+	}
+	return r.Name
+}
+
+type resourceFinder struct {
+	f       CleanupFilter
+	project string
+	now     time.Time
+	found   []*CreatedResource
+}
+
+func (rf *resourceFinder) add(kind, location, name string, labels map[string]string, created string) {
+	id := labels[workflowIDLabel]
+	if id == "" || (rf.f.ID != "" && id != rf.f.ID) {
+		return
+	}
+	t, err := time.Parse(time.RFC3339, created)
+	if rf.f.OlderThan > 0 && (err != nil || rf.now.Sub(t) < rf.f.OlderThan) {
+		return
+	}
+	rf.found = append(rf.found, &CreatedResource{
+		Kind:         kind,
+		Project:      rf.project,
+		Location:     location,
+		Name:         name,
+		WorkflowID:   id,
+		WorkflowName: labels[workflowNameLabel],
+		Created:      t,
+	})
+}
+
+// markerLabels returns the workflow labels in description.
+func markerLabels(description string) map[string]string {
+	m := workflowMarkerRgx.FindStringSubmatch(description)
+	if m == nil {
+		return nil
+	}
+	return map[string]string{workflowIDLabel: m[1], workflowNameLabel: m[2]}
+}
+
+// FindResources finds the resources of project created by Daisy workflows
+// that match f, in the order CleanupResources deletes them. Disks, images
+// and instances are found by their labels, the other kinds, which have no
+// labels, by their description.
+func FindResources(ctx context.Context, client daisyCompute.Client, project string, f CleanupFilter) ([]*CreatedResource, error) {
+	if f.ID == "" && f.OlderThan <= 0 {
+		return nil, errf("either the workflow ID or the minimum age of the resources must be set")
+	}
+	client = client.WithContext(ctx)
+	rf := &resourceFinder{f: f, project: project, now: time.Now()}
+
+	zones, err := client.ListZones(project)
+	if err != nil {
+		return nil, err
+	}
+	for _, z := range zones {
+		is, err := client.ListInstances(project, z.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range is {
+			rf.add("instance", z.Name, i.Name, i.Labels, i.CreationTimestamp)
+		}
+		ds, err := client.ListDisks(project, z.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range ds {
+			rf.add("disk", z.Name, d.Name, d.Labels, d.CreationTimestamp)
+		}
+		tis, err := client.ListTargetInstances(project, z.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ti := range tis {
+			rf.add("targetInstance", z.Name, ti.Name, markerLabels(ti.Description), ti.CreationTimestamp)
+		}
+	}
+	images, err := client.ListImages(project)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range images {
+		rf.add("image", "", i.Name, i.Labels, i.CreationTimestamp)
+	}
+	firewalls, err := client.ListFirewallRules(project)
+	if err != nil {
+		return nil, err
+	}
+	for _, fw := range firewalls {
+		rf.add("firewallRule", "", fw.Name, markerLabels(fw.Description), fw.CreationTimestamp)
+	}
+	regions, err := client.ListRegions(project)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range regions {
+		sns, err := client.ListSubnetworks(project, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, sn := range sns {
+			rf.add("subnetwork", r.Name, sn.Name, markerLabels(sn.Description), sn.CreationTimestamp)
+		}
+		frs, err := client.ListForwardingRules(project, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, fr := range frs {
+			rf.add("forwardingRule", r.Name, fr.Name, markerLabels(fr.Description), fr.CreationTimestamp)
+		}
+	}
+	networks, err := client.ListNetworks(project)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range networks {
+		rf.add("network", "", n.Name, markerLabels(n.Description), n.CreationTimestamp)
+	}
+
+	sort.SliceStable(rf.found, func(i, j int) bool {
+		return kindIndex(rf.found[i].Kind) < kindIndex(rf.found[j].Kind)
+	})
+	return rf.found, nil
+}
+
+func kindIndex(kind string) int {
+	for i, k := range cleanupOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(cleanupOrder)
+}
+
+// CleanupResources deletes rs, kind by kind in dependency order: forwarding
+// rules, target instances, instances, images, disks, firewall rules,
+// subnetworks and then networks. Resources of
+// the same kind are deleted concurrently. It returns the errors of all
+// deletions that failed.
+func CleanupResources(ctx context.Context, client daisyCompute.Client, rs []*CreatedResource) error {
+	client = client.WithContext(ctx)
+	byKind := map[string][]*CreatedResource{}
+	for _, r := range rs {
+		byKind[r.Kind] = append(byKind[r.Kind], r)
+	}
+
+	var errs dErr
+	var mx sync.Mutex
+	for _, kind := range cleanupOrder {
+		var wg sync.WaitGroup
+		for _, r := range byKind[kind] {
+			wg.Add(1)
+			go func(r *CreatedResource) {
+				defer wg.Done()
+				if err := deleteCreatedResource(client, r); err != nil {
+					mx.Lock()
+					errs = addErrs(errs, errf("error deleting %s %q: %v", r.Kind, r.Link(), err))
+					mx.Unlock()
+				}
+			}(r)
+		}
+		wg.Wait()
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+func deleteCreatedResource(client daisyCompute.Client, r *CreatedResource) error {
+	switch r.Kind {
+	case "forwardingRule":
+		return client.DeleteForwardingRule(r.Project, r.Location, r.Name)
+	case "targetInstance":
+		return client.DeleteTargetInstance(r.Project, r.Location, r.Name)
+	case "instance":
+		return client.DeleteInstance(r.Project, r.Location, r.Name)
+	case "disk":
+		return client.DeleteDisk(r.Project, r.Location, r.Name)
+	case "image":
+		return client.DeleteImage(r.Project, r.Name)
+	case "firewallRule":
+		return client.DeleteFirewallRule(r.Project, r.Name)
+	case "subnetwork":
+		return client.DeleteSubnetwork(r.Project, r.Location, r.Name)
+	case "network":
+		return client.DeleteNetwork(r.Project, r.Name)
+	}
+	return errf("unknown resource kind %q", r.Kind)
+}
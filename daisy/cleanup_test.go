@@ -0,0 +1,228 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestWorkflowLabels(t *testing.T) {
+	w := testWorkflow()
+	w.Name = "Test-WF"
+	iw := w.NewIncludedWorkflow()
+	iw.id = "other"
+
+	// Resources of included workflows are labeled with the run of the root.
+	got := iw.addWorkflowLabels(&Resource{}, map[string]string{"foo": "bar"})
+	want := map[string]string{"foo": "bar", "daisy-workflow-id": "abcdef", "daisy-workflow-name": "test-wf"}
+	if diffRes := diff(got, want, 0); diffRes != "" {
+		t.Errorf("labels do not match expectation: (-got +want)\n%s", diffRes)
+	}
+
+	desc := iw.addWorkflowMarker(&Resource{}, "desc")
+	if want := "desc [daisy-workflow-id=abcdef daisy-workflow-name=test-wf]"; desc != want {
+		t.Errorf("got description %q, want %q", desc, want)
+	}
+	if diffRes := diff(markerLabels(desc), map[string]string{"daisy-workflow-id": "abcdef", "daisy-workflow-name": "test-wf"}, 0); diffRes != "" {
+		t.Errorf("marker labels do not match expectation: (-got +want)\n%s", diffRes)
+	}
+	if markerLabels("desc") != nil {
+		t.Error("expected no marker labels")
+	}
+
+	// Resources that are not cleaned up are not marked.
+	if got := iw.addWorkflowLabels(&Resource{NoCleanup: true}, map[string]string{"foo": "bar"}); len(got) != 1 {
+		t.Errorf("got labels %v for a NoCleanup resource", got)
+	}
+	if got := iw.addWorkflowMarker(&Resource{NoCleanup: true}, "desc"); got != "desc" {
+		t.Errorf("got description %q for a NoCleanup resource", got)
+	}
+}
+
+func TestFindResources(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	old := now.Add(-48 * time.Hour).Format(time.RFC3339)
+	recent := now.Add(-time.Hour).Format(time.RFC3339)
+	labels := func(id string) map[string]string {
+		return map[string]string{workflowIDLabel: id, workflowNameLabel: "wf"}
+	}
+	marker := func(id string) string {
+		return "desc [daisy-workflow-id=" + id + " daisy-workflow-name=wf]"
+	}
+
+	c := &daisyCompute.TestClient{}
+	c.ListZonesFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Zone, error) {
+		return []*compute.Zone{{Name: "z"}}, nil
+	}
+	c.ListRegionsFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Region, error) {
+		return []*compute.Region{{Name: "r"}}, nil
+	}
+	c.ListInstancesFn = func(_, _ string, _ ...daisyCompute.ListCallOption) ([]*compute.Instance, error) {
+		return []*compute.Instance{
+			{Name: "i1", Labels: labels("id1"), CreationTimestamp: old},
+			{Name: "i2", Labels: labels("id2"), CreationTimestamp: recent},
+			{Name: "i3", CreationTimestamp: old},
+		}, nil
+	}
+	c.ListDisksFn = func(_, _ string, _ ...daisyCompute.ListCallOption) ([]*compute.Disk, error) {
+		return []*compute.Disk{{Name: "d1", Labels: labels("id1"), CreationTimestamp: old}}, nil
+	}
+	c.ListImagesFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Image, error) {
+		return []*compute.Image{{Name: "im2", Labels: labels("id2"), CreationTimestamp: recent}}, nil
+	}
+	c.ListFirewallRulesFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Firewall, error) {
+		return []*compute.Firewall{{Name: "f1", Description: marker("id1"), CreationTimestamp: old}}, nil
+	}
+	c.ListSubnetworksFn = func(_, _ string, _ ...daisyCompute.ListCallOption) ([]*compute.Subnetwork, error) {
+		return []*compute.Subnetwork{{Name: "s1", Description: marker("id1"), CreationTimestamp: old}}, nil
+	}
+	c.ListNetworksFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Network, error) {
+		return []*compute.Network{{Name: "n1", Description: marker("id1"), CreationTimestamp: old}, {Name: "n2", Description: "desc", CreationTimestamp: old}}, nil
+	}
+	c.ListForwardingRulesFn = func(_, _ string, _ ...daisyCompute.ListCallOption) ([]*compute.ForwardingRule, error) {
+		return []*compute.ForwardingRule{{Name: "fr1", Description: marker("id1"), CreationTimestamp: old}}, nil
+	}
+	c.ListTargetInstancesFn = func(_, _ string, _ ...daisyCompute.ListCallOption) ([]*compute.TargetInstance, error) {
+		return []*compute.TargetInstance{{Name: "ti2", Description: marker("id2"), CreationTimestamp: recent}}, nil
+	}
+
+	links := func(rs []*CreatedResource) []string {
+		var ls []string
+		for _, r := range rs {
+			ls = append(ls, r.Link())
+		}
+		return ls
+	}
+	id1 := []string{
+		"projects/p/regions/r/forwardingRules/fr1",
+		"projects/p/zones/z/instances/i1",
+		"projects/p/zones/z/disks/d1",
+		"projects/p/global/firewalls/f1",
+		"projects/p/regions/r/subnetworks/s1",
+		"projects/p/global/networks/n1",
+	}
+	tests := []struct {
+		desc string
+		f    CleanupFilter
+		want []string
+	}{
+		{"id", CleanupFilter{ID: "id2"}, []string{"projects/p/zones/z/targetInstances/ti2", "projects/p/zones/z/instances/i2", "projects/p/global/images/im2"}},
+		{"older than", CleanupFilter{OlderThan: 24 * time.Hour}, id1},
+		{"id and older than", CleanupFilter{ID: "id2", OlderThan: 24 * time.Hour}, nil},
+	}
+	for _, tt := range tests {
+		rs, err := FindResources(ctx, c, "p", tt.f)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if diffRes := diff(links(rs), tt.want, 0); diffRes != "" {
+			t.Errorf("%s: resources do not match expectation: (-got +want)\n%s", tt.desc, diffRes)
+		}
+	}
+
+	if _, err := FindResources(ctx, c, "p", CleanupFilter{}); err == nil {
+		t.Error("expected error for empty filter")
+	}
+}
+
+func TestFindResourcesNoCleanup(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	kept := &Image{Image: compute.Image{Name: "kept"}, Resource: Resource{NoCleanup: true}}
+	tmp := &Image{Image: compute.Image{Name: "tmp"}}
+	for _, im := range []*Image{kept, tmp} {
+		im.populate(ctx, s)
+		im.Image.CreationTimestamp = time.Now().Add(-48 * time.Hour).Format(time.RFC3339)
+	}
+
+	c := &daisyCompute.TestClient{}
+	c.ListZonesFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Zone, error) { return nil, nil }
+	c.ListRegionsFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Region, error) { return nil, nil }
+	c.ListImagesFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Image, error) {
+		return []*compute.Image{&kept.Image, &tmp.Image}, nil
+	}
+	c.ListFirewallRulesFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Firewall, error) { return nil, nil }
+	c.ListNetworksFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Network, error) { return nil, nil }
+
+	// Images kept by the workflow, like its outputs, are never cleaned up.
+	rs, err := FindResources(ctx, c, "p", CleanupFilter{OlderThan: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || rs[0].Name != tmp.Name {
+		t.Errorf("got resources %v, want only image %q", rs, tmp.Name)
+	}
+}
+
+func TestCleanupResources(t *testing.T) {
+	var mx sync.Mutex
+	var deleted []string
+	del := func(name string) error {
+		mx.Lock()
+		deleted = append(deleted, name)
+		mx.Unlock()
+		return nil
+	}
+	c := &daisyCompute.TestClient{}
+	c.DeleteInstanceFn = func(_, _, name string) error { return del(name) }
+	c.DeleteDiskFn = func(_, _, name string) error { return del(name) }
+	c.DeleteNetworkFn = func(_, name string) error { return del(name) }
+	c.DeleteImageFn = func(_, name string) error { return errf("image error") }
+	c.DeleteForwardingRuleFn = func(_, _, name string) error { return del(name) }
+	c.DeleteTargetInstanceFn = func(_, _, name string) error { return del(name) }
+
+	rs := []*CreatedResource{
+		{Kind: "network", Project: "p", Name: "n"},
+		{Kind: "targetInstance", Project: "p", Location: "z", Name: "ti"},
+		{Kind: "forwardingRule", Project: "p", Location: "r", Name: "fr"},
+		{Kind: "disk", Project: "p", Location: "z", Name: "d"},
+		{Kind: "image", Project: "p", Name: "im"},
+		{Kind: "instance", Project: "p", Location: "z", Name: "i"},
+	}
+	err := CleanupResources(context.Background(), c, rs)
+	if want := `error deleting image "projects/p/global/images/im": image error`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if diffRes := diff(deleted, []string{"fr", "ti", "i", "d", "n"}, 0); diffRes != "" {
+		t.Errorf("resources not deleted in dependency order: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestKeepOnFailure(t *testing.T) {
+	for _, failed := range []bool{false, true} {
+		w := testWorkflow()
+		w.EnableKeepOnFailure()
+		d := &Resource{RealName: "d", link: "link"}
+		w.disks.m = map[string]*Resource{"d": d}
+		if failed {
+			s, _ := w.NewStep("s")
+			w.recordFailure(s, errf("error"))
+		}
+
+		w.cleanup()
+		if d.deleted == failed {
+			t.Errorf("failed run: %t, got disk deleted %t", failed, d.deleted)
+		}
+	}
+}
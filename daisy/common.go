@@ -156,7 +156,8 @@ func (w *Workflow) substituteSourceVars(ctx context.Context, v reflect.Value) dE
 
 // traverseData traverses complex data structures and runs
 // a function, f, on its basic data types.
-// Traverses arrays, maps, slices, and public fields of structs.
+// Traverses arrays, maps, slices, and public fields of structs, except the
+// fields tagged `daisy:"-"`.
 // For example, f will be run on bool, int, string, etc.
 // Slices, maps, and structs will not have f called on them, but will
 // traverse their subelements.
@@ -174,6 +175,10 @@ func traverseData(v reflect.Value, f func(reflect.Value) dErr) dErr {
 		if v.IsNil() {
 			return nil
 		}
+		// Custom step types can only be changed through their pointers.
+		if _, ok := v.Interface().(StepImpl); ok && v.Kind() == reflect.Interface && v.Elem().Kind() == reflect.Ptr {
+			return traverseData(v.Elem().Elem(), f)
+		}
 		// I'm a pointer, dereference me.
 		return traverseData(v.Elem(), f)
 	}
@@ -210,6 +215,9 @@ func traverseData(v reflect.Value, f func(reflect.Value) dErr) dErr {
 		}
 	case reflect.Struct:
 		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Tag.Get("daisy") == "-" {
+				continue
+			}
 			if err := traverseData(v.Field(i), f); err != nil {
 				return err
 			}
@@ -56,6 +56,7 @@ type Client interface {
 	GetMachineType(project, zone, machineType string) (*compute.MachineType, error)
 	GetProject(project string) (*compute.Project, error)
 	GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
+	GetRegion(project, region string) (*compute.Region, error)
 	GetZone(project, zone string) (*compute.Zone, error)
 	GetInstance(project, zone, name string) (*compute.Instance, error)
 	GetDisk(project, zone, name string) (*compute.Disk, error)
@@ -89,6 +90,12 @@ type Client interface {
 
 	Retry(f func(opts ...googleapi.CallOption) (*compute.Operation, error), opts ...googleapi.CallOption) (op *compute.Operation, err error)
 	BasePath() string
+	// WithContext returns a copy of the client that sends its API calls, and
+	// waits for the operations they start, with ctx. The copy stops waiting
+	// and returns ctx.Err() once ctx is done. Wrappers that embed a Client
+	// wrap the copy of the embedded Client, which doesn't have their
+	// overrides.
+	WithContext(ctx context.Context) Client
 }
 
 // A ListCallOption is an option for a Google Compute API *ListCall.
@@ -159,6 +166,7 @@ type clientImpl interface {
 
 type client struct {
 	i       clientImpl
+	ctx     context.Context
 	hc      *http.Client
 	raw     *compute.Service
 	rawBeta *computeBeta.Service
@@ -224,7 +232,7 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (Client, error)
 	if ep != "" {
 		rawBetaService.BasePath = ep
 	}
-	c := &client{hc: hc, raw: rawService, rawBeta: rawBetaService}
+	c := &client{ctx: context.Background(), hc: hc, raw: rawService, rawBeta: rawBetaService}
 	c.i = c
 
 	return c, nil
@@ -235,11 +243,32 @@ func (c *client) BasePath() string {
 	return c.raw.BasePath
 }
 
+// WithContext returns a copy of the client that uses ctx.
+func (c *client) WithContext(ctx context.Context) Client {
+	nc := *c
+	nc.ctx = ctx
+	nc.i = &nc
+	return &nc
+}
+
 type operationGetterFunc func() (*compute.Operation, error)
 
+// OperationWaitHook is called with the context of a client when the client
+// starts to wait for an operation. The func it returns is called with the last
+// state of the operation, if any, and the result when the wait ends.
+type OperationWaitHook func(ctx context.Context, project, name string) func(op *compute.Operation, err error)
+
+type operationWaitHookKey struct{}
+
+// WithOperationWaitHook returns a copy of ctx that makes the clients using
+// it, see Client.WithContext, call hook for each operation they wait for.
+func WithOperationWaitHook(ctx context.Context, hook OperationWaitHook) context.Context {
+	return context.WithValue(ctx, operationWaitHookKey{}, hook)
+}
+
 func (c *client) zoneOperationsWait(project, zone, name string) error {
 	return c.operationsWaitHelper(project, name, func() (op *compute.Operation, err error) {
-		op, err = c.Retry(c.raw.ZoneOperations.Get(project, zone, name).Do)
+		op, err = c.Retry(c.raw.ZoneOperations.Get(project, zone, name).Context(c.ctx).Do)
 		if err != nil {
 			err = fmt.Errorf("failed to get zone operation %s: %v", name, err)
 		}
@@ -249,7 +278,7 @@ func (c *client) zoneOperationsWait(project, zone, name string) error {
 
 func (c *client) regionOperationsWait(project, region, name string) error {
 	return c.operationsWaitHelper(project, name, func() (op *compute.Operation, err error) {
-		op, err = c.Retry(c.raw.RegionOperations.Get(project, region, name).Do)
+		op, err = c.Retry(c.raw.RegionOperations.Get(project, region, name).Context(c.ctx).Do)
 		if err != nil {
 			err = fmt.Errorf("failed to get region operation %s: %v", name, err)
 		}
@@ -259,7 +288,7 @@ func (c *client) regionOperationsWait(project, region, name string) error {
 
 func (c *client) globalOperationsWait(project, name string) error {
 	return c.operationsWaitHelper(project, name, func() (op *compute.Operation, err error) {
-		op, err = c.Retry(c.raw.GlobalOperations.Get(project, name).Do)
+		op, err = c.Retry(c.raw.GlobalOperations.Get(project, name).Context(c.ctx).Do)
 		if err != nil {
 			err = fmt.Errorf("failed to get global operation %s: %v", name, err)
 		}
@@ -267,7 +296,20 @@ func (c *client) globalOperationsWait(project, name string) error {
 	})
 }
 
-func (c *client) operationsWaitHelper(project, name string, getOperation operationGetterFunc) error {
+func (c *client) operationsWaitHelper(project, name string, getOperation operationGetterFunc) (err error) {
+	if hook, ok := c.ctx.Value(operationWaitHookKey{}).(OperationWaitHook); ok && hook != nil {
+		var last *compute.Operation
+		get := getOperation
+		getOperation = func() (*compute.Operation, error) {
+			op, err := get()
+			if op != nil {
+				last = op
+			}
+			return op, err
+		}
+		done := hook(c.ctx, project, name)
+		defer func() { done(last, err) }()
+	}
 	for {
 		op, err := getOperation()
 		if err != nil {
@@ -276,7 +318,11 @@ func (c *client) operationsWaitHelper(project, name string, getOperation operati
 
 		switch op.Status {
 		case "PENDING", "RUNNING":
-			time.Sleep(1 * time.Second)
+			select {
+			case <-c.ctx.Done():
+				return c.ctx.Err()
+			case <-time.After(1 * time.Second):
+			}
 			continue
 		case "DONE":
 			if op.Error != nil {
@@ -311,7 +357,7 @@ func (c *client) Retry(f func(opts ...googleapi.CallOption) (*compute.Operation,
 
 // AttachDisk attaches a GCE persistent disk to an instance.
 func (c *client) AttachDisk(project, zone, instance string, d *compute.AttachedDisk) error {
-	op, err := c.Retry(c.raw.Instances.AttachDisk(project, zone, instance, d).Do)
+	op, err := c.Retry(c.raw.Instances.AttachDisk(project, zone, instance, d).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -321,7 +367,7 @@ func (c *client) AttachDisk(project, zone, instance string, d *compute.AttachedD
 
 // DetachDisk detaches a GCE persistent disk to an instance.
 func (c *client) DetachDisk(project, zone, instance, disk string) error {
-	op, err := c.Retry(c.raw.Instances.DetachDisk(project, zone, instance, disk).Do)
+	op, err := c.Retry(c.raw.Instances.DetachDisk(project, zone, instance, disk).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -331,7 +377,7 @@ func (c *client) DetachDisk(project, zone, instance, disk string) error {
 
 // CreateDisk creates a GCE persistent disk.
 func (c *client) CreateDisk(project, zone string, d *compute.Disk) error {
-	op, err := c.Retry(c.raw.Disks.Insert(project, zone, d).Do)
+	op, err := c.Retry(c.raw.Disks.Insert(project, zone, d).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -350,7 +396,7 @@ func (c *client) CreateDisk(project, zone string, d *compute.Disk) error {
 
 // CreateForwardingRule creates a GCE forwarding rule.
 func (c *client) CreateForwardingRule(project, region string, fr *compute.ForwardingRule) error {
-	op, err := c.Retry(c.raw.ForwardingRules.Insert(project, region, fr).Do)
+	op, err := c.Retry(c.raw.ForwardingRules.Insert(project, region, fr).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -368,7 +414,7 @@ func (c *client) CreateForwardingRule(project, region string, fr *compute.Forwar
 }
 
 func (c *client) CreateFirewallRule(project string, i *compute.Firewall) error {
-	op, err := c.Retry(c.raw.Firewalls.Insert(project, i).Do)
+	op, err := c.Retry(c.raw.Firewalls.Insert(project, i).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -390,7 +436,7 @@ func (c *client) CreateFirewallRule(project string, i *compute.Firewall) error {
 // url (full or partial) to the source disk, sourceFile is the full Google
 // Cloud Storage URL where the disk image is stored.
 func (c *client) CreateImage(project string, i *compute.Image) error {
-	op, err := c.Retry(c.raw.Images.Insert(project, i).Do)
+	op, err := c.Retry(c.raw.Images.Insert(project, i).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -408,7 +454,7 @@ func (c *client) CreateImage(project string, i *compute.Image) error {
 }
 
 func (c *client) CreateInstance(project, zone string, i *compute.Instance) error {
-	op, err := c.Retry(c.raw.Instances.Insert(project, zone, i).Do)
+	op, err := c.Retry(c.raw.Instances.Insert(project, zone, i).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -426,7 +472,7 @@ func (c *client) CreateInstance(project, zone string, i *compute.Instance) error
 }
 
 func (c *client) CreateNetwork(project string, n *compute.Network) error {
-	op, err := c.Retry(c.raw.Networks.Insert(project, n).Do)
+	op, err := c.Retry(c.raw.Networks.Insert(project, n).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -444,7 +490,7 @@ func (c *client) CreateNetwork(project string, n *compute.Network) error {
 }
 
 func (c *client) CreateSubnetwork(project, region string, n *compute.Subnetwork) error {
-	op, err := c.Retry(c.raw.Subnetworks.Insert(project, region, n).Do)
+	op, err := c.Retry(c.raw.Subnetworks.Insert(project, region, n).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -464,7 +510,7 @@ func (c *client) CreateSubnetwork(project, region string, n *compute.Subnetwork)
 // CreateTargetInstance creates a GCE Target Instance, which can be used as
 // target on ForwardingRule
 func (c *client) CreateTargetInstance(project, zone string, ti *compute.TargetInstance) error {
-	op, err := c.Retry(c.raw.TargetInstances.Insert(project, zone, ti).Do)
+	op, err := c.Retry(c.raw.TargetInstances.Insert(project, zone, ti).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -483,7 +529,7 @@ func (c *client) CreateTargetInstance(project, zone string, ti *compute.TargetIn
 
 // DeleteFirewallRule deletes a GCE FirewallRule.
 func (c *client) DeleteFirewallRule(project, name string) error {
-	op, err := c.Retry(c.raw.Firewalls.Delete(project, name).Do)
+	op, err := c.Retry(c.raw.Firewalls.Delete(project, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -493,7 +539,7 @@ func (c *client) DeleteFirewallRule(project, name string) error {
 
 // DeleteImage deletes a GCE image.
 func (c *client) DeleteImage(project, name string) error {
-	op, err := c.Retry(c.raw.Images.Delete(project, name).Do)
+	op, err := c.Retry(c.raw.Images.Delete(project, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -503,7 +549,7 @@ func (c *client) DeleteImage(project, name string) error {
 
 // DeleteDisk deletes a GCE persistent disk.
 func (c *client) DeleteDisk(project, zone, name string) error {
-	op, err := c.Retry(c.raw.Disks.Delete(project, zone, name).Do)
+	op, err := c.Retry(c.raw.Disks.Delete(project, zone, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -513,7 +559,7 @@ func (c *client) DeleteDisk(project, zone, name string) error {
 
 // DeleteForwardingRule deletes a GCE ForwardingRule.
 func (c *client) DeleteForwardingRule(project, region, name string) error {
-	op, err := c.Retry(c.raw.ForwardingRules.Delete(project, region, name).Do)
+	op, err := c.Retry(c.raw.ForwardingRules.Delete(project, region, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -523,7 +569,7 @@ func (c *client) DeleteForwardingRule(project, region, name string) error {
 
 // DeleteInstance deletes a GCE instance.
 func (c *client) DeleteInstance(project, zone, name string) error {
-	op, err := c.Retry(c.raw.Instances.Delete(project, zone, name).Do)
+	op, err := c.Retry(c.raw.Instances.Delete(project, zone, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -533,7 +579,7 @@ func (c *client) DeleteInstance(project, zone, name string) error {
 
 // StartInstance starts a GCE instance.
 func (c *client) StartInstance(project, zone, name string) error {
-	op, err := c.Retry(c.raw.Instances.Start(project, zone, name).Do)
+	op, err := c.Retry(c.raw.Instances.Start(project, zone, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -543,7 +589,7 @@ func (c *client) StartInstance(project, zone, name string) error {
 
 // StopInstance stops a GCE instance.
 func (c *client) StopInstance(project, zone, name string) error {
-	op, err := c.Retry(c.raw.Instances.Stop(project, zone, name).Do)
+	op, err := c.Retry(c.raw.Instances.Stop(project, zone, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -553,7 +599,7 @@ func (c *client) StopInstance(project, zone, name string) error {
 
 // DeleteNetwork deletes a GCE network.
 func (c *client) DeleteNetwork(project, name string) error {
-	op, err := c.Retry(c.raw.Networks.Delete(project, name).Do)
+	op, err := c.Retry(c.raw.Networks.Delete(project, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -563,7 +609,7 @@ func (c *client) DeleteNetwork(project, name string) error {
 
 // DeleteSubnetwork deletes a GCE subnetwork.
 func (c *client) DeleteSubnetwork(project, region, name string) error {
-	op, err := c.Retry(c.raw.Subnetworks.Delete(project, region, name).Do)
+	op, err := c.Retry(c.raw.Subnetworks.Delete(project, region, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -573,7 +619,7 @@ func (c *client) DeleteSubnetwork(project, region, name string) error {
 
 // DeleteTargetInstance deletes a GCE TargetInstance.
 func (c *client) DeleteTargetInstance(project, zone, name string) error {
-	op, err := c.Retry(c.raw.TargetInstances.Delete(project, zone, name).Do)
+	op, err := c.Retry(c.raw.TargetInstances.Delete(project, zone, name).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -583,7 +629,7 @@ func (c *client) DeleteTargetInstance(project, zone, name string) error {
 
 // DeprecateImage sets deprecation status on a GCE image.
 func (c *client) DeprecateImage(project, name string, deprecationstatus *compute.DeprecationStatus) error {
-	op, err := c.Retry(c.raw.Images.Deprecate(project, name, deprecationstatus).Do)
+	op, err := c.Retry(c.raw.Images.Deprecate(project, name, deprecationstatus).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -593,9 +639,9 @@ func (c *client) DeprecateImage(project, name string, deprecationstatus *compute
 
 // GetMachineType gets a GCE MachineType.
 func (c *client) GetMachineType(project, zone, machineType string) (*compute.MachineType, error) {
-	mt, err := c.raw.MachineTypes.Get(project, zone, machineType).Do()
+	mt, err := c.raw.MachineTypes.Get(project, zone, machineType).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.MachineTypes.Get(project, zone, machineType).Do()
+		return c.raw.MachineTypes.Get(project, zone, machineType).Context(c.ctx).Do()
 	}
 	return mt, err
 }
@@ -608,9 +654,9 @@ func (c *client) ListMachineTypes(project, zone string, opts ...ListCallOption)
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.MachineTypesListCall)
 	}
-	for mtl, err := call.PageToken(pt).Do(); ; mtl, err = call.PageToken(pt).Do() {
+	for mtl, err := call.PageToken(pt).Context(c.ctx).Do(); ; mtl, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			mtl, err = call.PageToken(pt).Do()
+			mtl, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -626,27 +672,36 @@ func (c *client) ListMachineTypes(project, zone string, opts ...ListCallOption)
 
 // GetProject gets a GCE Project.
 func (c *client) GetProject(project string) (*compute.Project, error) {
-	p, err := c.raw.Projects.Get(project).Do()
+	p, err := c.raw.Projects.Get(project).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Projects.Get(project).Do()
+		return c.raw.Projects.Get(project).Context(c.ctx).Do()
 	}
 	return p, err
 }
 
 // GetSerialPortOutput gets the serial port output of a GCE instance.
 func (c *client) GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error) {
-	sp, err := c.raw.Instances.GetSerialPortOutput(project, zone, name).Start(start).Port(port).Do()
+	sp, err := c.raw.Instances.GetSerialPortOutput(project, zone, name).Start(start).Port(port).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Instances.GetSerialPortOutput(project, zone, name).Start(start).Port(port).Do()
+		return c.raw.Instances.GetSerialPortOutput(project, zone, name).Start(start).Port(port).Context(c.ctx).Do()
 	}
 	return sp, err
 }
 
+// GetRegion gets a GCE Region.
+func (c *client) GetRegion(project, region string) (*compute.Region, error) {
+	r, err := c.raw.Regions.Get(project, region).Context(c.ctx).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.Regions.Get(project, region).Context(c.ctx).Do()
+	}
+	return r, err
+}
+
 // GetZone gets a GCE Zone.
 func (c *client) GetZone(project, zone string) (*compute.Zone, error) {
-	z, err := c.raw.Zones.Get(project, zone).Do()
+	z, err := c.raw.Zones.Get(project, zone).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Zones.Get(project, zone).Do()
+		return c.raw.Zones.Get(project, zone).Context(c.ctx).Do()
 	}
 	return z, err
 }
@@ -659,9 +714,9 @@ func (c *client) ListZones(project string, opts ...ListCallOption) ([]*compute.Z
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.ZonesListCall)
 	}
-	for zl, err := call.PageToken(pt).Do(); ; zl, err = call.PageToken(pt).Do() {
+	for zl, err := call.PageToken(pt).Context(c.ctx).Do(); ; zl, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			zl, err = call.PageToken(pt).Do()
+			zl, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -683,9 +738,9 @@ func (c *client) ListRegions(project string, opts ...ListCallOption) ([]*compute
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.RegionsListCall)
 	}
-	for rl, err := call.PageToken(pt).Do(); ; rl, err = call.PageToken(pt).Do() {
+	for rl, err := call.PageToken(pt).Context(c.ctx).Do(); ; rl, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			rl, err = call.PageToken(pt).Do()
+			rl, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -701,9 +756,9 @@ func (c *client) ListRegions(project string, opts ...ListCallOption) ([]*compute
 
 // GetInstance gets a GCE Instance.
 func (c *client) GetInstance(project, zone, name string) (*compute.Instance, error) {
-	i, err := c.raw.Instances.Get(project, zone, name).Do()
+	i, err := c.raw.Instances.Get(project, zone, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Instances.Get(project, zone, name).Do()
+		return c.raw.Instances.Get(project, zone, name).Context(c.ctx).Do()
 	}
 	return i, err
 }
@@ -716,9 +771,9 @@ func (c *client) ListInstances(project, zone string, opts ...ListCallOption) ([]
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.InstancesListCall)
 	}
-	for il, err := call.PageToken(pt).Do(); ; il, err = call.PageToken(pt).Do() {
+	for il, err := call.PageToken(pt).Context(c.ctx).Do(); ; il, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			il, err = call.PageToken(pt).Do()
+			il, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -734,9 +789,9 @@ func (c *client) ListInstances(project, zone string, opts ...ListCallOption) ([]
 
 // GetDisk gets a GCE Disk.
 func (c *client) GetDisk(project, zone, name string) (*compute.Disk, error) {
-	d, err := c.raw.Disks.Get(project, zone, name).Do()
+	d, err := c.raw.Disks.Get(project, zone, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Disks.Get(project, zone, name).Do()
+		return c.raw.Disks.Get(project, zone, name).Context(c.ctx).Do()
 	}
 	return d, err
 }
@@ -749,9 +804,9 @@ func (c *client) ListDisks(project, zone string, opts ...ListCallOption) ([]*com
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.DisksListCall)
 	}
-	for dl, err := call.PageToken(pt).Do(); ; dl, err = call.PageToken(pt).Do() {
+	for dl, err := call.PageToken(pt).Context(c.ctx).Do(); ; dl, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			dl, err = call.PageToken(pt).Do()
+			dl, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -767,9 +822,9 @@ func (c *client) ListDisks(project, zone string, opts ...ListCallOption) ([]*com
 
 // GetForwardingRule gets a GCE ForwardingRule.
 func (c *client) GetForwardingRule(project, region, name string) (*compute.ForwardingRule, error) {
-	n, err := c.raw.ForwardingRules.Get(project, region, name).Do()
+	n, err := c.raw.ForwardingRules.Get(project, region, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.ForwardingRules.Get(project, region, name).Do()
+		return c.raw.ForwardingRules.Get(project, region, name).Context(c.ctx).Do()
 	}
 	return n, err
 }
@@ -782,9 +837,9 @@ func (c *client) ListForwardingRules(project, region string, opts ...ListCallOpt
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.ForwardingRulesListCall)
 	}
-	for frl, err := call.PageToken(pt).Do(); ; frl, err = call.PageToken(pt).Do() {
+	for frl, err := call.PageToken(pt).Context(c.ctx).Do(); ; frl, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			frl, err = call.PageToken(pt).Do()
+			frl, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -800,9 +855,9 @@ func (c *client) ListForwardingRules(project, region string, opts ...ListCallOpt
 
 // GetFirewallRule gets a GCE FirewallRule.
 func (c *client) GetFirewallRule(project, name string) (*compute.Firewall, error) {
-	i, err := c.raw.Firewalls.Get(project, name).Do()
+	i, err := c.raw.Firewalls.Get(project, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Firewalls.Get(project, name).Do()
+		return c.raw.Firewalls.Get(project, name).Context(c.ctx).Do()
 	}
 	return i, err
 }
@@ -815,9 +870,9 @@ func (c *client) ListFirewallRules(project string, opts ...ListCallOption) ([]*c
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.FirewallsListCall)
 	}
-	for il, err := call.PageToken(pt).Do(); ; il, err = call.PageToken(pt).Do() {
+	for il, err := call.PageToken(pt).Context(c.ctx).Do(); ; il, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			il, err = call.PageToken(pt).Do()
+			il, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -833,18 +888,18 @@ func (c *client) ListFirewallRules(project string, opts ...ListCallOption) ([]*c
 
 // GetImage gets a GCE Image.
 func (c *client) GetImage(project, name string) (*compute.Image, error) {
-	i, err := c.raw.Images.Get(project, name).Do()
+	i, err := c.raw.Images.Get(project, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Images.Get(project, name).Do()
+		return c.raw.Images.Get(project, name).Context(c.ctx).Do()
 	}
 	return i, err
 }
 
 // GetImageFromFamily gets a GCE Image from an image family.
 func (c *client) GetImageFromFamily(project, family string) (*compute.Image, error) {
-	i, err := c.raw.Images.GetFromFamily(project, family).Do()
+	i, err := c.raw.Images.GetFromFamily(project, family).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Images.GetFromFamily(project, family).Do()
+		return c.raw.Images.GetFromFamily(project, family).Context(c.ctx).Do()
 	}
 	return i, err
 }
@@ -857,9 +912,9 @@ func (c *client) ListImages(project string, opts ...ListCallOption) ([]*compute.
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.ImagesListCall)
 	}
-	for il, err := call.PageToken(pt).Do(); ; il, err = call.PageToken(pt).Do() {
+	for il, err := call.PageToken(pt).Context(c.ctx).Do(); ; il, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			il, err = call.PageToken(pt).Do()
+			il, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -875,9 +930,9 @@ func (c *client) ListImages(project string, opts ...ListCallOption) ([]*compute.
 
 // GetNetwork gets a GCE Network.
 func (c *client) GetNetwork(project, name string) (*compute.Network, error) {
-	n, err := c.raw.Networks.Get(project, name).Do()
+	n, err := c.raw.Networks.Get(project, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Networks.Get(project, name).Do()
+		return c.raw.Networks.Get(project, name).Context(c.ctx).Do()
 	}
 	return n, err
 }
@@ -890,9 +945,9 @@ func (c *client) ListNetworks(project string, opts ...ListCallOption) ([]*comput
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.NetworksListCall)
 	}
-	for nl, err := call.PageToken(pt).Do(); ; nl, err = call.PageToken(pt).Do() {
+	for nl, err := call.PageToken(pt).Context(c.ctx).Do(); ; nl, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			nl, err = call.PageToken(pt).Do()
+			nl, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -908,9 +963,9 @@ func (c *client) ListNetworks(project string, opts ...ListCallOption) ([]*comput
 
 // GetSubnetwork gets a GCE subnetwork.
 func (c *client) GetSubnetwork(project, region, name string) (*compute.Subnetwork, error) {
-	n, err := c.raw.Subnetworks.Get(project, region, name).Do()
+	n, err := c.raw.Subnetworks.Get(project, region, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Subnetworks.Get(project, region, name).Do()
+		return c.raw.Subnetworks.Get(project, region, name).Context(c.ctx).Do()
 	}
 	return n, err
 }
@@ -923,9 +978,9 @@ func (c *client) ListSubnetworks(project, region string, opts ...ListCallOption)
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.SubnetworksListCall)
 	}
-	for nl, err := call.PageToken(pt).Do(); ; nl, err = call.PageToken(pt).Do() {
+	for nl, err := call.PageToken(pt).Context(c.ctx).Do(); ; nl, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			nl, err = call.PageToken(pt).Do()
+			nl, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -941,9 +996,9 @@ func (c *client) ListSubnetworks(project, region string, opts ...ListCallOption)
 
 // GetTargetInstance gets a GCE TargetInstance.
 func (c *client) GetTargetInstance(project, zone, name string) (*compute.TargetInstance, error) {
-	n, err := c.raw.TargetInstances.Get(project, zone, name).Do()
+	n, err := c.raw.TargetInstances.Get(project, zone, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.TargetInstances.Get(project, zone, name).Do()
+		return c.raw.TargetInstances.Get(project, zone, name).Context(c.ctx).Do()
 	}
 	return n, err
 }
@@ -956,9 +1011,9 @@ func (c *client) ListTargetInstances(project, zone string, opts ...ListCallOptio
 	for _, opt := range opts {
 		call = opt.listCallOptionApply(call).(*compute.TargetInstancesListCall)
 	}
-	for til, err := call.PageToken(pt).Do(); ; til, err = call.PageToken(pt).Do() {
+	for til, err := call.PageToken(pt).Context(c.ctx).Do(); ; til, err = call.PageToken(pt).Context(c.ctx).Do() {
 		if shouldRetryWithWait(c.hc.Transport, err, 2) {
-			til, err = call.PageToken(pt).Do()
+			til, err = call.PageToken(pt).Context(c.ctx).Do()
 		}
 		if err != nil {
 			return nil, err
@@ -974,18 +1029,18 @@ func (c *client) ListTargetInstances(project, zone string, opts ...ListCallOptio
 
 // GetLicense gets a GCE License.
 func (c *client) GetLicense(project, name string) (*compute.License, error) {
-	l, err := c.raw.Licenses.Get(project, name).Do()
+	l, err := c.raw.Licenses.Get(project, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return c.raw.Licenses.Get(project, name).Do()
+		return c.raw.Licenses.Get(project, name).Context(c.ctx).Do()
 	}
 	return l, err
 }
 
 // InstanceStatus returns an instances Status.
 func (c *client) InstanceStatus(project, zone, name string) (string, error) {
-	is, err := c.raw.Instances.Get(project, zone, name).Do()
+	is, err := c.raw.Instances.Get(project, zone, name).Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		is, err = c.raw.Instances.Get(project, zone, name).Do()
+		is, err = c.raw.Instances.Get(project, zone, name).Context(c.ctx).Do()
 	}
 
 	if err != nil {
@@ -1012,7 +1067,7 @@ func (c *client) InstanceStopped(project, zone, name string) (bool, error) {
 
 // ResizeDisk resizes a GCE persistent disk. You can only increase the size of the disk.
 func (c *client) ResizeDisk(project, zone, disk string, drr *compute.DisksResizeRequest) error {
-	op, err := c.Retry(c.raw.Disks.Resize(project, zone, disk, drr).Do)
+	op, err := c.Retry(c.raw.Disks.Resize(project, zone, disk, drr).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -1022,7 +1077,7 @@ func (c *client) ResizeDisk(project, zone, disk string, drr *compute.DisksResize
 
 // SetInstanceMetadata sets an instances metadata.
 func (c *client) SetInstanceMetadata(project, zone, name string, md *compute.Metadata) error {
-	op, err := c.Retry(c.raw.Instances.SetMetadata(project, zone, name, md).Do)
+	op, err := c.Retry(c.raw.Instances.SetMetadata(project, zone, name, md).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -1031,7 +1086,7 @@ func (c *client) SetInstanceMetadata(project, zone, name string, md *compute.Met
 
 // SetCommonInstanceMetadata sets an instances metadata.
 func (c *client) SetCommonInstanceMetadata(project string, md *compute.Metadata) error {
-	op, err := c.Retry(c.raw.Projects.SetCommonInstanceMetadata(project, md).Do)
+	op, err := c.Retry(c.raw.Projects.SetCommonInstanceMetadata(project, md).Context(c.ctx).Do)
 	if err != nil {
 		return err
 	}
@@ -1048,9 +1103,9 @@ func (c *client) GetGuestAttributes(project, zone, name, queryPath, variableKey
 	if variableKey != "" {
 		call = call.VariableKey(variableKey)
 	}
-	a, err := call.Do()
+	a, err := call.Context(c.ctx).Do()
 	if shouldRetryWithWait(c.hc.Transport, err, 2) {
-		return call.Do()
+		return call.Context(c.ctx).Do()
 	}
 	return a, err
 }
@@ -16,12 +16,15 @@ package compute
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/kylelemons/godebug/pretty"
 	"google.golang.org/api/compute/v1"
@@ -405,3 +408,77 @@ func TestDetachDisk(t *testing.T) {
 		t.Fatalf("error running DetachDisk: %v", err)
 	}
 }
+
+func TestOperationsWaitContext(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/operations/op?alt=json&prettyPrint=false", testProject, testZone) {
+			fmt.Fprint(w, `{"Status":"RUNNING"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.StartInstanceFn = func(_, _, _ string) error { return errors.New("override should be kept") }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cc := c.WithContext(ctx).(*TestClient)
+	if err := cc.StartInstance(testProject, testZone, testInstance); err == nil || err.Error() != "override should be kept" {
+		t.Errorf("WithContext should keep overrides, got: %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- cc.zoneOperationsWait(testProject, testZone, "op") }()
+	cancel()
+	select {
+	case err := <-done:
+		// The context is canceled either while waiting or during a request.
+		if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+			t.Errorf("want %v, got: %v", context.Canceled, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("operation wait did not stop when its context was canceled")
+	}
+}
+
+func TestOperationWaitHook(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/operations/op?alt=json&prettyPrint=false", testProject, testZone) {
+			fmt.Fprint(w, `{"Name":"op","OperationType":"insert","Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	type key struct{}
+	var calls []string
+	hook := func(ctx context.Context, project, name string) func(*compute.Operation, error) {
+		calls = append(calls, fmt.Sprintf("start %s %s %v", project, name, ctx.Value(key{})))
+		return func(op *compute.Operation, err error) {
+			calls = append(calls, fmt.Sprintf("end %s %v", op.OperationType, err))
+		}
+	}
+	ctx := WithOperationWaitHook(context.WithValue(context.Background(), key{}, "value"), hook)
+	cc := c.WithContext(ctx).(*TestClient)
+	if err := cc.zoneOperationsWait(testProject, testZone, "op"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start test-project op value", "end insert <nil>"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got hook calls %q, want %q", calls, want)
+	}
+
+	// Clients without the hook don't call it.
+	calls = nil
+	if err := c.zoneOperationsWait(testProject, testZone, "op"); err != nil || calls != nil {
+		t.Errorf("got hook calls %q, error: %v", calls, err)
+	}
+}
@@ -0,0 +1,625 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package emulator emulates the subset of the Compute Engine v1 REST API
+// that the daisy compute client uses, backed by in-memory state, so that
+// workflows can run end to end without a GCP project.
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// BasePath is the path the emulator serves the API under. Clients use the
+// URL of the emulator followed by BasePath as endpoint, e.g.
+// http://localhost:8080/compute/v1/projects/.
+const BasePath = "/compute/v1/projects/"
+
+// apiBase prefixes the self links of the resources, like in GCE.
+const apiBase = "https://www.googleapis.com/compute/v1/projects/"
+
+const timeFormat = "2006-01-02T15:04:05.000-07:00"
+
+var (
+	defaultZones = []string{
+		"asia-east1-a", "asia-east1-b", "europe-west1-b", "europe-west1-c", "europe-west1-d",
+		"us-central1-a", "us-central1-b", "us-central1-c", "us-central1-f",
+		"us-east1-b", "us-east1-c", "us-east1-d", "us-west1-a", "us-west1-b",
+	}
+	nameRgx    = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	projectRgx = regexp.MustCompile(`^[a-z]([-.:a-z0-9]*[a-z0-9])?$`)
+)
+
+// Config configures an Emulator.
+type Config struct {
+	// Zones of every project, the regions are derived from them. Defaults to
+	// a few zones in asia-east1, europe-west1, us-central1, us-east1 and
+	// us-west1.
+	Zones []string
+	// OperationDuration is how long operations take. They are PENDING for
+	// the first half and RUNNING for the second half of it, then DONE.
+	OperationDuration time.Duration `json:"-"`
+	// Images exist when the emulator starts, e.g. the public images the
+	// workflows use.
+	Images []*Image
+	// Instances script the guests of instances.
+	Instances []*InstanceScript
+}
+
+// Image is an image that exists when the emulator starts.
+type Image struct {
+	Project    string
+	Name       string
+	Family     string
+	DiskSizeGb int64
+	Licenses   []string
+}
+
+// Emulator is an http.Handler serving the emulated API. Every project
+// exists and starts with a "default" network. The effects of operations
+// and guest scripts are applied when a request arrives after they are due.
+type Emulator struct {
+	zones      map[string]string
+	regions    []string
+	opDuration time.Duration
+	scripts    []*script
+	now        func() time.Time
+
+	mx        sync.Mutex
+	lastID    uint64
+	projects  map[string]*compute.Project
+	resources map[string]*resource
+	ops       map[string]*operation
+}
+
+// resource is an emulated resource, stored by key, its path below BasePath,
+// e.g. "my-project/zones/us-central1-a/disks/my-disk".
+type resource struct {
+	kind    *kind
+	key     string
+	obj     interface{}
+	created time.Time
+	// refs are the keys of the resources this resource uses, which can't be
+	// deleted while it exists.
+	refs []string
+	// guest is the scripted guest of instances.
+	guest *guest
+}
+
+type operation struct {
+	op     *compute.Operation
+	start  time.Time
+	finish func(time.Time)
+	done   bool
+}
+
+// call holds the scope of a request.
+type call struct {
+	project string
+	// scope is "zones/<zone>", "regions/<region>" or "global".
+	scope string
+	query url.Values
+}
+
+// resolve returns the key of the resource link refers to. Links are URLs,
+// partial URLs or, for resources in the scope of c, names.
+func (c *call) resolve(link, collection string) string {
+	if i := strings.Index(link, "projects/"); i >= 0 {
+		return link[i+len("projects/"):]
+	}
+	if strings.Contains(link, "/") {
+		return path.Join(c.project, link)
+	}
+	return path.Join(c.project, c.scope, collection, link)
+}
+
+func link(key string) string {
+	return apiBase + key
+}
+
+// New creates an Emulator.
+func New(cfg Config) (*Emulator, error) {
+	e := &Emulator{
+		zones:      map[string]string{},
+		opDuration: cfg.OperationDuration,
+		now:        time.Now,
+		projects:   map[string]*compute.Project{},
+		resources:  map[string]*resource{},
+		ops:        map[string]*operation{},
+	}
+	zones := cfg.Zones
+	if len(zones) == 0 {
+		zones = defaultZones
+	}
+	for _, z := range zones {
+		i := strings.LastIndex(z, "-")
+		if i <= 0 || !nameRgx.MatchString(z) {
+			return nil, fmt.Errorf("invalid zone %q", z)
+		}
+		r := z[:i]
+		if !strIn(r, e.regions) {
+			e.regions = append(e.regions, r)
+		}
+		e.zones[z] = r
+	}
+	sort.Strings(e.regions)
+
+	for _, is := range cfg.Instances {
+		s, err := compileScript(is)
+		if err != nil {
+			return nil, err
+		}
+		e.scripts = append(e.scripts, s)
+	}
+
+	for _, img := range cfg.Images {
+		if img.Project == "" || !nameRgx.MatchString(img.Name) {
+			return nil, fmt.Errorf("invalid image %q in project %q", img.Name, img.Project)
+		}
+		key := path.Join(img.Project, "global/images", img.Name)
+		i := &compute.Image{
+			Name:       img.Name,
+			Family:     img.Family,
+			DiskSizeGb: img.DiskSizeGb,
+			Licenses:   img.Licenses,
+			Status:     "READY",
+		}
+		if i.DiskSizeGb == 0 {
+			i.DiskSizeGb = 10
+		}
+		e.add(imageKind, key, i)
+	}
+	return e, nil
+}
+
+// add stores obj as a new resource of kind k.
+func (e *Emulator) add(k *kind, key string, obj interface{}) *resource {
+	r := e.newResource(k, key, obj)
+	e.resources[key] = r
+	return r
+}
+
+// newResource returns obj as a resource of kind k, with its common fields
+// filled in.
+func (e *Emulator) newResource(k *kind, key string, obj interface{}) *resource {
+	now := e.now()
+	r := &resource{kind: k, key: key, obj: obj, created: now}
+	setField(obj, "Kind", k.typ)
+	setField(obj, "Id", e.newID())
+	setField(obj, "CreationTimestamp", now.Format(timeFormat))
+	setField(obj, "SelfLink", link(key))
+	if k.scope != "global" {
+		// The key is "<project>/<scope>/<location>/<collection>/<name>".
+		parts := strings.Split(key, "/")
+		setField(obj, map[string]string{"zones": "Zone", "regions": "Region"}[k.scope], link(strings.Join(parts[:3], "/")))
+	}
+	return r
+}
+
+func (e *Emulator) newID() uint64 {
+	e.lastID++
+	return e.lastID
+}
+
+func setField(obj interface{}, name string, v interface{}) {
+	if f := reflect.ValueOf(obj).Elem().FieldByName(name); f.IsValid() {
+		f.Set(reflect.ValueOf(v))
+	}
+}
+
+func fieldString(obj interface{}, name string) string {
+	if f := reflect.ValueOf(obj).Elem().FieldByName(name); f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+// apiError is an error of the API, sent as HTTP status code with a reason,
+// like GCE does.
+type apiError struct {
+	code   int
+	reason string
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+func notFound(key string) error {
+	return &apiError{http.StatusNotFound, "notFound", fmt.Sprintf("The resource 'projects/%s' was not found", key)}
+}
+
+func alreadyExists(key string) error {
+	return &apiError{http.StatusConflict, "alreadyExists", fmt.Sprintf("The resource 'projects/%s' already exists", key)}
+}
+
+func inUse(key, user string) error {
+	return &apiError{http.StatusBadRequest, "resourceInUseByAnotherResource", fmt.Sprintf("The resource 'projects/%s' is already being used by 'projects/%s'", key, user)}
+}
+
+func notReady(key string) error {
+	return &apiError{http.StatusBadRequest, "resourceNotReady", fmt.Sprintf("The resource 'projects/%s' is not ready", key)}
+}
+
+func invalid(format string, a ...interface{}) error {
+	return &apiError{http.StatusBadRequest, "invalid", fmt.Sprintf(format, a...)}
+}
+
+func unsupported(r *http.Request) error {
+	return &apiError{http.StatusBadRequest, "unsupported", fmt.Sprintf("The emulator doesn't support %s %s", r.Method, r.URL.Path)}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	ae, ok := err.(*apiError)
+	if !ok {
+		ae = &apiError{http.StatusInternalServerError, "backendError", err.Error()}
+	}
+	body := map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    ae.code,
+			"message": ae.msg,
+			"errors":  []map[string]string{{"domain": "global", "reason": ae.reason, "message": ae.msg}},
+		},
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(ae.code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// ServeHTTP serves a request to the emulated API.
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, BasePath) {
+		writeError(w, &apiError{http.StatusNotFound, "notFound", fmt.Sprintf("The emulator serves the API under %s, not %s", BasePath, r.URL.Path)})
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, BasePath), "/"), "/")
+	if !projectRgx.MatchString(parts[0]) {
+		writeError(w, invalid("Invalid project %q", parts[0]))
+		return
+	}
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	e.advance()
+	resp, err := e.route(&call{project: parts[0], query: r.URL.Query()}, r, parts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (e *Emulator) route(c *call, r *http.Request, parts []string) (interface{}, error) {
+	p := e.project(c.project)
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		p.Quotas = e.quotas(c.project, "")
+		return p, nil
+	case len(parts) == 2 && parts[1] == "setCommonInstanceMetadata" && r.Method == http.MethodPost:
+		md := &compute.Metadata{}
+		if err := json.NewDecoder(r.Body).Decode(md); err != nil {
+			return nil, invalid("Invalid metadata: %v", err)
+		}
+		c.scope = "global"
+		return e.newOperation(c, "setCommonInstanceMetadata", c.project, func(time.Time) {
+			md.Kind = "compute#metadata"
+			p.CommonInstanceMetadata = md
+		}), nil
+	case len(parts) >= 2 && parts[1] == "zones":
+		return e.routeZone(c, r, parts[2:])
+	case len(parts) >= 2 && parts[1] == "regions":
+		return e.routeRegion(c, r, parts[2:])
+	case len(parts) >= 3 && parts[1] == "global":
+		c.scope = "global"
+		return e.routeScope(c, r, parts[2:])
+	}
+	return nil, unsupported(r)
+}
+
+func (e *Emulator) routeZone(c *call, r *http.Request, parts []string) (interface{}, error) {
+	if r.Method != http.MethodGet && len(parts) < 3 {
+		return nil, unsupported(r)
+	}
+	if len(parts) == 0 {
+		zl := &compute.ZoneList{Kind: "compute#zoneList", SelfLink: link(c.project + "/zones")}
+		var zones []string
+		for z := range e.zones {
+			zones = append(zones, z)
+		}
+		sort.Strings(zones)
+		for _, z := range zones {
+			zl.Items = append(zl.Items, e.zone(c.project, z))
+		}
+		return zl, nil
+	}
+	if _, ok := e.zones[parts[0]]; !ok {
+		return nil, notFound(path.Join(c.project, "zones", parts[0]))
+	}
+	if len(parts) == 1 {
+		return e.zone(c.project, parts[0]), nil
+	}
+	c.scope = "zones/" + parts[0]
+	if parts[1] == "machineTypes" {
+		return e.routeMachineTypes(c, r, parts[2:])
+	}
+	return e.routeScope(c, r, parts[1:])
+}
+
+func (e *Emulator) routeRegion(c *call, r *http.Request, parts []string) (interface{}, error) {
+	if r.Method != http.MethodGet && len(parts) < 3 {
+		return nil, unsupported(r)
+	}
+	if len(parts) == 0 {
+		rl := &compute.RegionList{Kind: "compute#regionList", SelfLink: link(c.project + "/regions")}
+		for _, region := range e.regions {
+			rl.Items = append(rl.Items, e.region(c.project, region))
+		}
+		return rl, nil
+	}
+	if !strIn(parts[0], e.regions) {
+		return nil, notFound(path.Join(c.project, "regions", parts[0]))
+	}
+	if len(parts) == 1 {
+		return e.region(c.project, parts[0]), nil
+	}
+	c.scope = "regions/" + parts[0]
+	return e.routeScope(c, r, parts[1:])
+}
+
+// routeScope routes requests to the collections of a zone, a region or the
+// global scope.
+func (e *Emulator) routeScope(c *call, r *http.Request, parts []string) (interface{}, error) {
+	switch {
+	case parts[0] == "operations" && len(parts) == 2 && r.Method == http.MethodGet:
+		key := path.Join(c.project, c.scope, "operations", parts[1])
+		o, ok := e.ops[key]
+		if !ok {
+			return nil, notFound(key)
+		}
+		return o.op, nil
+	case parts[0] == "licenses" && c.scope == "global" && len(parts) == 2 && r.Method == http.MethodGet:
+		return e.license(c.project, parts[1])
+	}
+	scope := strings.SplitN(c.scope, "/", 2)[0]
+	for _, k := range kinds {
+		if k.collection == parts[0] && k.scope == scope {
+			return e.routeCollection(k, c, r, parts[1:])
+		}
+	}
+	return nil, unsupported(r)
+}
+
+func (e *Emulator) routeCollection(k *kind, c *call, r *http.Request, parts []string) (interface{}, error) {
+	col := path.Join(c.project, c.scope, k.collection)
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		return e.list(k, col, c.query.Get("orderBy"))
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		return e.insert(k, c, col, r)
+	case len(parts) == 2 && k == imageKind && parts[0] == "family" && r.Method == http.MethodGet:
+		return e.imageFromFamily(c.project, parts[1])
+	case len(parts) == 0:
+		return nil, unsupported(r)
+	}
+
+	key := path.Join(col, parts[0])
+	res, ok := e.resources[key]
+	if !ok {
+		return nil, notFound(key)
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		return res.obj, nil
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		return e.delete(c, res)
+	case len(parts) == 2:
+		if a, ok := k.actions[parts[1]]; ok && a.method == r.Method {
+			return a.do(e, c, res, r)
+		}
+	}
+	return nil, unsupported(r)
+}
+
+func (e *Emulator) list(k *kind, col, orderBy string) (interface{}, error) {
+	var rs []*resource
+	for key, r := range e.resources {
+		if path.Dir(key) == col {
+			rs = append(rs, r)
+		}
+	}
+	switch orderBy {
+	case "", "name":
+		sort.Slice(rs, func(i, j int) bool { return rs[i].key < rs[j].key })
+	case "creationTimestamp":
+		sort.SliceStable(rs, func(i, j int) bool { return rs[i].created.Before(rs[j].created) })
+	case "creationTimestamp desc":
+		sort.SliceStable(rs, func(i, j int) bool { return rs[i].created.After(rs[j].created) })
+	default:
+		return nil, invalid("Unsupported orderBy %q", orderBy)
+	}
+
+	// The list types of the API all have the same fields, so the items are
+	// put in the list type of k with reflection.
+	obj := k.newList()
+	items := reflect.ValueOf(obj).Elem().FieldByName("Items")
+	for _, r := range rs {
+		items.Set(reflect.Append(items, reflect.ValueOf(r.obj)))
+	}
+	setField(obj, "Kind", k.typ+"List")
+	setField(obj, "SelfLink", link(col))
+	return obj, nil
+}
+
+func (e *Emulator) insert(k *kind, c *call, col string, r *http.Request) (interface{}, error) {
+	obj := k.newObj()
+	if err := json.NewDecoder(r.Body).Decode(obj); err != nil {
+		return nil, invalid("Invalid %s: %v", k.typ, err)
+	}
+	name := fieldString(obj, "Name")
+	if !nameRgx.MatchString(name) {
+		return nil, invalid("Invalid value for field 'resource.name': %q. Must be a match of regex '%s'", name, nameRgx)
+	}
+	key := path.Join(col, name)
+	if _, ok := e.resources[key]; ok {
+		return nil, alreadyExists(key)
+	}
+	res := e.newResource(k, key, obj)
+	finish, err := k.insert(e, c, res)
+	if err != nil {
+		return nil, err
+	}
+	e.resources[key] = res
+	return e.newOperation(c, "insert", key, finish), nil
+}
+
+func (e *Emulator) delete(c *call, res *resource) (interface{}, error) {
+	var users []string
+	for _, other := range e.resources {
+		if strIn(res.key, other.refs) {
+			users = append(users, other.key)
+		}
+	}
+	if len(users) > 0 {
+		sort.Strings(users)
+		return nil, inUse(res.key, users[0])
+	}
+	var finish func(time.Time)
+	if res.kind.delete != nil {
+		finish = res.kind.delete(e, res)
+	}
+	return e.newOperation(c, "delete", res.key, func(t time.Time) {
+		if e.resources[res.key] != res {
+			// Deleted by an earlier operation.
+			return
+		}
+		delete(e.resources, res.key)
+		if finish != nil {
+			finish(t)
+		}
+	}), nil
+}
+
+// newOperation starts an operation of type typ on target, finish applies
+// its effects once it's done.
+func (e *Emulator) newOperation(c *call, typ, target string, finish func(time.Time)) *compute.Operation {
+	now := e.now()
+	id := e.newID()
+	name := fmt.Sprintf("operation-%d-%08x", now.UnixNano()/int64(time.Millisecond), id)
+	key := path.Join(c.project, c.scope, "operations", name)
+	op := &compute.Operation{
+		Kind:          "compute#operation",
+		Id:            id,
+		Name:          name,
+		OperationType: typ,
+		TargetLink:    link(target),
+		Status:        "PENDING",
+		InsertTime:    now.Format(timeFormat),
+		User:          "emulator",
+		SelfLink:      link(key),
+	}
+	switch {
+	case strings.HasPrefix(c.scope, "zones/"):
+		op.Zone = link(path.Join(c.project, c.scope))
+	case strings.HasPrefix(c.scope, "regions/"):
+		op.Region = link(path.Join(c.project, c.scope))
+	}
+	e.ops[key] = &operation{op: op, start: now, finish: finish}
+	return op
+}
+
+// event is something due at a point in time: an operation that completes or
+// a guest event.
+type event struct {
+	at    time.Time
+	id    uint64
+	apply func()
+}
+
+// advance applies the operations and guest events that are due, in the
+// order they are due, and updates the status of the other operations.
+func (e *Emulator) advance() {
+	now := e.now()
+	for {
+		var next *event
+		consider := func(ev *event) {
+			if ev.at.After(now) {
+				return
+			}
+			if next == nil || ev.at.Before(next.at) || (ev.at.Equal(next.at) && ev.id < next.id) {
+				next = ev
+			}
+		}
+		for _, o := range e.ops {
+			if !o.done {
+				consider(e.completion(o))
+			}
+		}
+		for _, r := range e.resources {
+			if ev := r.guestEvent(); ev != nil {
+				consider(ev)
+			}
+		}
+		if next == nil {
+			break
+		}
+		next.apply()
+	}
+
+	for _, o := range e.ops {
+		if !o.done && !now.Before(o.start.Add(e.opDuration/2)) && o.op.Status == "PENDING" {
+			o.op.Status = "RUNNING"
+			o.op.Progress = 50
+			o.op.StartTime = now.Format(timeFormat)
+		}
+	}
+}
+
+func (e *Emulator) completion(o *operation) *event {
+	at := o.start.Add(e.opDuration)
+	return &event{at: at, id: o.op.Id, apply: func() {
+		o.done = true
+		if o.op.StartTime == "" {
+			o.op.StartTime = at.Format(timeFormat)
+		}
+		o.op.Status = "DONE"
+		o.op.Progress = 100
+		o.op.EndTime = at.Format(timeFormat)
+		if o.finish != nil {
+			o.finish(at)
+		}
+	}}
+}
+
+func strIn(s string, ss []string) bool {
+	for _, x := range ss {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
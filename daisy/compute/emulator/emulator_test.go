@@ -0,0 +1,217 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+type testClock struct {
+	mx sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.t
+}
+
+func (c *testClock) add(d time.Duration) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestEmulator(t *testing.T, cfg Config) (*Emulator, *testClock) {
+	e, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{t: time.Now()}
+	e.now = clock.now
+	return e, clock
+}
+
+// newTestClient returns a daisy compute client of e, and the function that
+// stops its server.
+func newTestClient(t *testing.T, e *Emulator) (daisyCompute.Client, func()) {
+	ts := httptest.NewServer(e)
+	c, err := daisyCompute.NewClient(context.Background(), option.WithEndpoint(ts.URL+BasePath), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return c, ts.Close
+}
+
+func errCode(err error) int {
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// serve sends a request for path p below BasePath to e, decodes the
+// response into resp and returns the status code.
+func serve(e *Emulator, method, p string, body, resp interface{}) int {
+	var b io.Reader
+	if body != nil {
+		j, _ := json.Marshal(body)
+		b = bytes.NewReader(j)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(method, BasePath+p, b))
+	if resp != nil {
+		json.Unmarshal(rec.Body.Bytes(), resp)
+	}
+	return rec.Code
+}
+
+func TestOperationLifecycle(t *testing.T) {
+	e, clock := newTestEmulator(t, Config{OperationDuration: 10 * time.Second})
+	op := &compute.Operation{}
+	if code := serve(e, http.MethodPost, "p/zones/us-central1-a/disks", &compute.Disk{Name: "d", SizeGb: 10}, op); code != http.StatusOK {
+		t.Fatalf("got status code %d inserting disk", code)
+	}
+	if op.Zone != apiBase+"p/zones/us-central1-a" || op.TargetLink != apiBase+"p/zones/us-central1-a/disks/d" {
+		t.Errorf("unexpected operation: %+v", op)
+	}
+
+	tests := []struct {
+		after              time.Duration
+		wantOp, wantDisk   string
+		wantOpEnd, wantGet bool
+	}{
+		{0, "PENDING", "CREATING", false, true},
+		{5 * time.Second, "RUNNING", "CREATING", false, true},
+		{5 * time.Second, "DONE", "READY", true, true},
+	}
+	for _, tt := range tests {
+		clock.add(tt.after)
+		d := &compute.Disk{}
+		serve(e, http.MethodGet, "p/zones/us-central1-a/operations/"+op.Name, nil, op)
+		serve(e, http.MethodGet, "p/zones/us-central1-a/disks/d", nil, d)
+		if op.Status != tt.wantOp || d.Status != tt.wantDisk {
+			t.Errorf("after %v: got operation %q and disk %q, want %q and %q", tt.after, op.Status, d.Status, tt.wantOp, tt.wantDisk)
+		}
+		if (op.EndTime != "") != tt.wantOpEnd {
+			t.Errorf("after %v: unexpected operation end time %q", tt.after, op.EndTime)
+		}
+	}
+
+	// Deleted resources exist until the operation is done.
+	serve(e, http.MethodDelete, "p/zones/us-central1-a/disks/d", nil, op)
+	if code := serve(e, http.MethodGet, "p/zones/us-central1-a/disks/d", nil, nil); code != http.StatusOK {
+		t.Errorf("got status code %d getting disk being deleted", code)
+	}
+	clock.add(10 * time.Second)
+	if code := serve(e, http.MethodGet, "p/zones/us-central1-a/disks/d", nil, nil); code != http.StatusNotFound {
+		t.Errorf("got status code %d getting deleted disk", code)
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	e, _ := newTestEmulator(t, Config{})
+	tests := []struct {
+		desc, method, path string
+		body               interface{}
+		wantCode           int
+		wantReason         string
+	}{
+		{"unknown zone", http.MethodGet, "p/zones/nowhere-a", nil, http.StatusNotFound, "notFound"},
+		{"unknown region", http.MethodGet, "p/regions/nowhere/subnetworks", nil, http.StatusNotFound, "notFound"},
+		{"unknown resource", http.MethodGet, "p/zones/us-central1-a/disks/d", nil, http.StatusNotFound, "notFound"},
+		{"unknown collection", http.MethodGet, "p/zones/us-central1-a/foos", nil, http.StatusBadRequest, "unsupported"},
+		{"unknown method", http.MethodPut, "p/global/images", nil, http.StatusBadRequest, "unsupported"},
+		{"invalid name", http.MethodPost, "p/global/networks", &compute.Network{Name: "Bad_Name"}, http.StatusBadRequest, "invalid"},
+		{"existing name", http.MethodPost, "p/global/networks", &compute.Network{Name: "default"}, http.StatusConflict, "alreadyExists"},
+		{"missing reference", http.MethodPost, "p/global/firewalls", &compute.Firewall{Name: "fw", Network: "global/networks/foo"}, http.StatusNotFound, "notFound"},
+		{"invalid project", http.MethodGet, "Bad_Project", nil, http.StatusBadRequest, "invalid"},
+	}
+	for _, tt := range tests {
+		var resp struct {
+			Error struct {
+				Code   int
+				Errors []struct{ Reason string }
+			}
+		}
+		code := serve(e, tt.method, tt.path, tt.body, &resp)
+		if code != tt.wantCode || resp.Error.Code != tt.wantCode || len(resp.Error.Errors) != 1 || resp.Error.Errors[0].Reason != tt.wantReason {
+			t.Errorf("%s: got status code %d and error %+v, want %d %q", tt.desc, code, resp.Error, tt.wantCode, tt.wantReason)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/p/zones", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status code %d for a path outside of BasePath", rec.Code)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c := &call{project: "p", scope: "zones/z"}
+	tests := []struct {
+		link, collection, want string
+	}{
+		{"d", "disks", "p/zones/z/disks/d"},
+		{"zones/y/disks/d", "disks", "p/zones/y/disks/d"},
+		{"global/images/family/f", "images", "p/global/images/family/f"},
+		{"projects/o/zones/y/disks/d", "disks", "o/zones/y/disks/d"},
+		{"https://www.googleapis.com/compute/v1/projects/o/global/images/i", "images", "o/global/images/i"},
+	}
+	for _, tt := range tests {
+		if got := c.resolve(tt.link, tt.collection); got != tt.want {
+			t.Errorf("resolve(%q, %q) = %q, want %q", tt.link, tt.collection, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		desc string
+		cfg  Config
+	}{
+		{"bad zone", Config{Zones: []string{"zone"}}},
+		{"bad image", Config{Images: []*Image{{Name: "i"}}}},
+		{"bad script", Config{Instances: []*InstanceScript{{Name: "("}}}},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.cfg); err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+	}
+
+	e, err := New(Config{Zones: []string{"us-central1-a", "us-central1-b", "europe-west1-b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"europe-west1", "us-central1"}; len(e.regions) != 2 || e.regions[0] != want[0] || e.regions[1] != want[1] {
+		t.Errorf("got regions %v, want %v", e.regions, want)
+	}
+}
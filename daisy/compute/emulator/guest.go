@@ -0,0 +1,214 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package emulator
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	computeBeta "google.golang.org/api/compute/v0.beta"
+	"google.golang.org/api/compute/v1"
+)
+
+// InstanceScript scripts the guest of the instances whose names match Name:
+// each time such an instance starts, its Events happen in order. The guests
+// of other instances do nothing.
+type InstanceScript struct {
+	// Name is a regular expression that matches the whole name of the
+	// instances.
+	Name   string
+	Events []*GuestEvent
+}
+
+// GuestEvent is something the guest of an instance does.
+type GuestEvent struct {
+	// After is how long after the previous event, or after the instance
+	// started, the event happens, e.g. "10s".
+	After string
+	// SerialOutput is written to the serial port Port, 1 by default, followed
+	// by a newline if it doesn't end with one.
+	SerialOutput string
+	Port         int64
+	// GuestAttributes are set, keyed by "<namespace>/<key>".
+	GuestAttributes map[string]string
+	// Stop shuts the instance down.
+	Stop bool
+}
+
+type script struct {
+	rgx    *regexp.Regexp
+	events []*GuestEvent
+	// offsets are the times of the events after the instance started.
+	offsets []time.Duration
+}
+
+func compileScript(is *InstanceScript) (*script, error) {
+	rgx, err := regexp.Compile("^(?:" + is.Name + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance script name %q: %v", is.Name, err)
+	}
+	s := &script{rgx: rgx, events: is.Events}
+	var offset time.Duration
+	for i, ev := range is.Events {
+		if ev.After != "" {
+			d, err := time.ParseDuration(ev.After)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("instance script %q: event %d: invalid After %q", is.Name, i, ev.After)
+			}
+			offset += d
+		}
+		if ev.Port == 0 {
+			ev.Port = 1
+		}
+		if ev.Port < 1 || ev.Port > 4 {
+			return nil, fmt.Errorf("instance script %q: event %d: invalid serial port %d", is.Name, i, ev.Port)
+		}
+		for k := range ev.GuestAttributes {
+			if parts := strings.Split(k, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("instance script %q: event %d: guest attribute %q is not of the form <namespace>/<key>", is.Name, i, k)
+			}
+		}
+		s.offsets = append(s.offsets, offset)
+	}
+	return s, nil
+}
+
+// guest is the guest of an instance.
+type guest struct {
+	script *script
+	// boot is when the instance last started.
+	boot time.Time
+	// next is the index of the next event of the script.
+	next   int
+	serial map[int64]string
+	attrs  map[string]string
+}
+
+func (e *Emulator) newGuest(name string) *guest {
+	g := &guest{serial: map[int64]string{}, attrs: map[string]string{}}
+	for _, s := range e.scripts {
+		if s.rgx.MatchString(name) {
+			g.script = s
+			break
+		}
+	}
+	return g
+}
+
+// start boots the guest at t, its script starts over.
+func (g *guest) start(t time.Time) {
+	g.boot = t
+	g.next = 0
+}
+
+// guestEvent returns the next event of the guest of r, or nil if there is
+// none: r is not an instance, is not running or its script has ended.
+func (r *resource) guestEvent() *event {
+	g := r.guest
+	if g == nil || g.script == nil || g.next >= len(g.script.events) {
+		return nil
+	}
+	inst := r.obj.(*compute.Instance)
+	if inst.Status != "RUNNING" {
+		return nil
+	}
+	ev := g.script.events[g.next]
+	return &event{at: g.boot.Add(g.script.offsets[g.next]), id: inst.Id, apply: func() {
+		g.next++
+		if ev.SerialOutput != "" {
+			out := ev.SerialOutput
+			if !strings.HasSuffix(out, "\n") {
+				out += "\n"
+			}
+			g.serial[ev.Port] += out
+		}
+		for k, v := range ev.GuestAttributes {
+			g.attrs[k] = v
+		}
+		if ev.Stop {
+			inst.Status = "TERMINATED"
+		}
+	}}
+}
+
+func serialPortOutput(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error) {
+	port := int64(1)
+	if p := c.query.Get("port"); p != "" {
+		var err error
+		if port, err = strconv.ParseInt(p, 10, 64); err != nil || port < 1 || port > 4 {
+			return nil, invalid("Invalid value for field 'port': %q. Must be between 1 and 4", p)
+		}
+	}
+	var start int64
+	if s := c.query.Get("start"); s != "" {
+		var err error
+		if start, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, invalid("Invalid value for field 'start': %q", s)
+		}
+	}
+	// Like in GCE, the output of instances that aren't running can't be read.
+	if r.obj.(*compute.Instance).Status != "RUNNING" {
+		return nil, notReady(r.key)
+	}
+
+	out := r.guest.serial[port]
+	if start < 0 || start > int64(len(out)) {
+		start = int64(len(out))
+	}
+	return &compute.SerialPortOutput{
+		Kind:     "compute#serialPortOutput",
+		Contents: out[start:],
+		Start:    start,
+		Next:     int64(len(out)),
+		SelfLink: link(r.key + "/serialPort"),
+	}, nil
+}
+
+func guestAttributes(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error) {
+	ga := &computeBeta.GuestAttributes{Kind: "compute#guestAttributes", SelfLink: link(r.key + "/guestAttributes")}
+	if k := c.query.Get("variableKey"); k != "" {
+		v, ok := r.guest.attrs[k]
+		if !ok {
+			return nil, notFound(r.key + "/guestAttributes/" + k)
+		}
+		ga.VariableKey = k
+		ga.VariableValue = v
+		return ga, nil
+	}
+
+	qp := c.query.Get("queryPath")
+	ga.QueryPath = qp
+	ga.QueryValue = &computeBeta.GuestAttributesValue{}
+	var keys []string
+	for k := range r.guest.attrs {
+		if strings.HasPrefix(k, qp) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, notFound(r.key + "/guestAttributes/" + qp)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts := strings.SplitN(k, "/", 2)
+		ga.QueryValue.Items = append(ga.QueryValue.Items, &computeBeta.GuestAttributesEntry{Namespace: parts[0], Key: parts[1], Value: r.guest.attrs[k]})
+	}
+	return ga, nil
+}
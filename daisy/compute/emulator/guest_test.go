@@ -0,0 +1,120 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package emulator
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestInstanceScript(t *testing.T) {
+	e, clock := newTestEmulator(t, Config{Instances: []*InstanceScript{{
+		Name: "build-.*",
+		Events: []*GuestEvent{
+			{After: "10s", SerialOutput: "BuildStatus: started"},
+			{After: "10s", SerialOutput: "BuildSuccess: done\n", GuestAttributes: map[string]string{"daisy/result": "ok"}},
+			{After: "10s", Stop: true},
+		},
+	}}})
+	c, closeFn := newTestClient(t, e)
+	defer closeFn()
+	p, z := "p", "us-central1-a"
+	for _, name := range []string{"build-1", "other"} {
+		i := &compute.Instance{Name: name, MachineType: "n1-standard-1", Disks: []*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskSizeGb: 10}}}}
+		if err := c.CreateInstance(p, z, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var next int64
+	tests := []struct {
+		after time.Duration
+		want  string
+	}{
+		{0, ""},
+		{10 * time.Second, "BuildStatus: started\n"},
+		{10 * time.Second, "BuildSuccess: done\n"},
+	}
+	for _, tt := range tests {
+		clock.add(tt.after)
+		out, err := c.GetSerialPortOutput(p, z, "build-1", 1, next)
+		if err != nil || out.Contents != tt.want {
+			t.Fatalf("got serial output %+v, error: %v, want %q", out, err, tt.want)
+		}
+		next = out.Next
+	}
+	if out, err := c.GetSerialPortOutput(p, z, "other", 1, 0); err != nil || out.Contents != "" {
+		t.Errorf("instance without script: got serial output %+v, error: %v", out, err)
+	}
+
+	if ga, err := c.GetGuestAttributes(p, z, "build-1", "", "daisy/result"); err != nil || ga.VariableValue != "ok" {
+		t.Errorf("got guest attribute %+v, error: %v", ga, err)
+	}
+	if ga, err := c.GetGuestAttributes(p, z, "build-1", "daisy/", ""); err != nil || len(ga.QueryValue.Items) != 1 || ga.QueryValue.Items[0].Key != "result" {
+		t.Errorf("got guest attributes %+v, error: %v", ga, err)
+	}
+	if _, err := c.GetGuestAttributes(p, z, "build-1", "", "daisy/missing"); errCode(err) != http.StatusNotFound {
+		t.Errorf("got error %v for missing guest attribute", err)
+	}
+
+	clock.add(10 * time.Second)
+	if stopped, err := c.InstanceStopped(p, z, "build-1"); err != nil || !stopped {
+		t.Errorf("instance not stopped by its script, error: %v", err)
+	}
+	if _, err := c.GetSerialPortOutput(p, z, "build-1", 1, 0); errCode(err) != http.StatusBadRequest {
+		t.Errorf("got error %v for serial output of a stopped instance", err)
+	}
+
+	// The script starts over when the instance starts again.
+	if err := c.StartInstance(p, z, "build-1"); err != nil {
+		t.Fatal(err)
+	}
+	clock.add(10 * time.Second)
+	if out, err := c.GetSerialPortOutput(p, z, "build-1", 1, next); err != nil || out.Contents != "BuildStatus: started\n" {
+		t.Errorf("got serial output %+v after restart, error: %v", out, err)
+	}
+}
+
+func TestCompileScript(t *testing.T) {
+	tests := []struct {
+		desc string
+		is   *InstanceScript
+	}{
+		{"bad name", &InstanceScript{Name: "("}},
+		{"bad after", &InstanceScript{Name: "i", Events: []*GuestEvent{{After: "soon"}}}},
+		{"negative after", &InstanceScript{Name: "i", Events: []*GuestEvent{{After: "-1s"}}}},
+		{"bad port", &InstanceScript{Name: "i", Events: []*GuestEvent{{Port: 5}}}},
+		{"bad guest attribute", &InstanceScript{Name: "i", Events: []*GuestEvent{{GuestAttributes: map[string]string{"key": "v"}}}}},
+	}
+	for _, tt := range tests {
+		if _, err := compileScript(tt.is); err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+	}
+
+	s, err := compileScript(&InstanceScript{Name: "inst-.*", Events: []*GuestEvent{{After: "1s"}, {}, {After: "2s"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{time.Second, time.Second, 3 * time.Second}; len(s.offsets) != 3 || s.offsets[0] != want[0] || s.offsets[1] != want[1] || s.offsets[2] != want[2] {
+		t.Errorf("got offsets %v, want %v", s.offsets, want)
+	}
+	if s.rgx.MatchString("my-inst-1") || !s.rgx.MatchString("inst-1") {
+		t.Error("instance name regexp doesn't match whole names")
+	}
+}
@@ -0,0 +1,920 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// kind is a kind of resource the emulator can create and delete.
+type kind struct {
+	// collection is the path segment of the resources, e.g. "disks".
+	collection string
+	// scope is "zones", "regions" or "global".
+	scope   string
+	typ     string
+	newObj  func() interface{}
+	newList func() interface{}
+	// insert validates and completes a new resource, before it's stored. It
+	// returns the function that applies the effects of the insert operation
+	// once it's done.
+	insert func(e *Emulator, c *call, r *resource) (func(time.Time), error)
+	// delete, if set, returns the function that applies the effects of the
+	// delete operation, other than removing the resource, once it's done.
+	delete  func(e *Emulator, r *resource) func(time.Time)
+	actions map[string]*action
+}
+
+// action is a custom method of a resource, e.g. instances.stop.
+type action struct {
+	method string
+	do     func(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error)
+}
+
+var (
+	diskKind = &kind{
+		collection: "disks",
+		scope:      "zones",
+		typ:        "compute#disk",
+		newObj:     func() interface{} { return &compute.Disk{} },
+		newList:    func() interface{} { return &compute.DiskList{} },
+		insert:     insertDisk,
+		delete: func(e *Emulator, r *resource) func(time.Time) {
+			r.obj.(*compute.Disk).Status = "DELETING"
+			return nil
+		},
+		actions: map[string]*action{"resize": {http.MethodPost, resizeDisk}},
+	}
+	firewallKind = &kind{
+		collection: "firewalls",
+		scope:      "global",
+		typ:        "compute#firewall",
+		newObj:     func() interface{} { return &compute.Firewall{} },
+		newList:    func() interface{} { return &compute.FirewallList{} },
+		insert:     insertFirewall,
+	}
+	forwardingRuleKind = &kind{
+		collection: "forwardingRules",
+		scope:      "regions",
+		typ:        "compute#forwardingRule",
+		newObj:     func() interface{} { return &compute.ForwardingRule{} },
+		newList:    func() interface{} { return &compute.ForwardingRuleList{} },
+		insert:     insertForwardingRule,
+	}
+	imageKind = &kind{
+		collection: "images",
+		scope:      "global",
+		typ:        "compute#image",
+		newObj:     func() interface{} { return &compute.Image{} },
+		newList:    func() interface{} { return &compute.ImageList{} },
+		insert:     insertImage,
+		actions:    map[string]*action{"deprecate": {http.MethodPost, deprecateImage}},
+	}
+	instanceKind = &kind{
+		collection: "instances",
+		scope:      "zones",
+		typ:        "compute#instance",
+		newObj:     func() interface{} { return &compute.Instance{} },
+		newList:    func() interface{} { return &compute.InstanceList{} },
+		insert:     insertInstance,
+		delete:     deleteInstance,
+		actions: map[string]*action{
+			"attachDisk":         {http.MethodPost, attachDisk},
+			"detachDisk":         {http.MethodPost, detachDisk},
+			"getGuestAttributes": {http.MethodGet, guestAttributes},
+			"serialPort":         {http.MethodGet, serialPortOutput},
+			"setMetadata":        {http.MethodPost, setMetadata},
+			"start":              {http.MethodPost, startInstance},
+			"stop":               {http.MethodPost, stopInstance},
+		},
+	}
+	networkKind = &kind{
+		collection: "networks",
+		scope:      "global",
+		typ:        "compute#network",
+		newObj:     func() interface{} { return &compute.Network{} },
+		newList:    func() interface{} { return &compute.NetworkList{} },
+		insert:     insertNetwork,
+	}
+	subnetworkKind = &kind{
+		collection: "subnetworks",
+		scope:      "regions",
+		typ:        "compute#subnetwork",
+		newObj:     func() interface{} { return &compute.Subnetwork{} },
+		newList:    func() interface{} { return &compute.SubnetworkList{} },
+		insert:     insertSubnetwork,
+	}
+	targetInstanceKind = &kind{
+		collection: "targetInstances",
+		scope:      "zones",
+		typ:        "compute#targetInstance",
+		newObj:     func() interface{} { return &compute.TargetInstance{} },
+		newList:    func() interface{} { return &compute.TargetInstanceList{} },
+		insert:     insertTargetInstance,
+	}
+
+	kinds = []*kind{diskKind, firewallKind, forwardingRuleKind, imageKind, instanceKind, networkKind, subnetworkKind, targetInstanceKind}
+)
+
+// lookup returns the resource of collection stored by key. Kinds are looked
+// up by collection, the functions of the kinds would otherwise refer to
+// their own kind.
+func (e *Emulator) lookup(collection, key string) (*resource, error) {
+	r, ok := e.resources[key]
+	if !ok || r.kind.collection != collection {
+		return nil, notFound(key)
+	}
+	return r, nil
+}
+
+// keyOf returns the key of the resource of the self link l.
+func keyOf(l string) string {
+	return strings.TrimPrefix(l, apiBase)
+}
+
+func (c *call) global() *call {
+	return &call{project: c.project, scope: "global"}
+}
+
+// Projects, zones, regions, machine types and licenses.
+
+type quotaLimit struct {
+	metric string
+	limit  float64
+}
+
+var (
+	projectQuotas = []quotaLimit{
+		{"CPUS_ALL_REGIONS", 1000}, {"FIREWALLS", 500}, {"FORWARDING_RULES", 500}, {"IMAGES", 2000},
+		{"NETWORKS", 50}, {"SUBNETWORKS", 500}, {"TARGET_INSTANCES", 500},
+	}
+	regionQuotas = []quotaLimit{
+		{"CPUS", 1000}, {"DISKS_TOTAL_GB", 100000}, {"INSTANCES", 1000}, {"LOCAL_SSD_TOTAL_GB", 10000}, {"SSD_TOTAL_GB", 50000},
+	}
+	diskTypes = []string{"local-ssd", "pd-balanced", "pd-ssd", "pd-standard"}
+)
+
+// project returns the project name, which is created on first use.
+func (e *Emulator) project(name string) *compute.Project {
+	if p, ok := e.projects[name]; ok {
+		return p
+	}
+	id := e.newID()
+	p := &compute.Project{
+		Kind:                   "compute#project",
+		Id:                     id,
+		Name:                   name,
+		CreationTimestamp:      e.now().Format(timeFormat),
+		SelfLink:               link(name),
+		CommonInstanceMetadata: &compute.Metadata{Kind: "compute#metadata"},
+		DefaultServiceAccount:  fmt.Sprintf("%d-compute@developer.gserviceaccount.com", id),
+	}
+	e.projects[name] = p
+	e.add(networkKind, path.Join(name, "global/networks/default"), &compute.Network{
+		Name:                  "default",
+		Description:           "Default network for the project",
+		AutoCreateSubnetworks: true,
+		RoutingConfig:         &compute.NetworkRoutingConfig{RoutingMode: "REGIONAL"},
+	})
+	return p
+}
+
+// quotas returns the quotas of project, or of region of project, with their
+// usage by the existing resources.
+func (e *Emulator) quotas(project, region string) []*compute.Quota {
+	usage := map[string]float64{}
+	count := func(metric, location string, v float64) {
+		if location == region {
+			usage[metric] += v
+		}
+	}
+	for key, r := range e.resources {
+		parts := strings.Split(key, "/")
+		if parts[0] != project {
+			continue
+		}
+		var location string
+		switch parts[1] {
+		case "zones":
+			location = e.zones[parts[2]]
+		case "regions":
+			location = parts[2]
+		}
+		switch obj := r.obj.(type) {
+		case *compute.Disk:
+			metric := "DISKS_TOTAL_GB"
+			switch path.Base(obj.Type) {
+			case "pd-ssd", "pd-balanced":
+				metric = "SSD_TOTAL_GB"
+			case "local-ssd":
+				metric = "LOCAL_SSD_TOTAL_GB"
+			}
+			count(metric, location, float64(obj.SizeGb))
+		case *compute.Firewall:
+			count("FIREWALLS", "", 1)
+		case *compute.ForwardingRule:
+			count("FORWARDING_RULES", "", 1)
+		case *compute.Image:
+			count("IMAGES", "", 1)
+		case *compute.Instance:
+			count("INSTANCES", location, 1)
+			if obj.Status == "TERMINATED" {
+				continue
+			}
+			if mt, err := e.machineType(keyOf(obj.MachineType)); err == nil {
+				count("CPUS", location, float64(mt.GuestCpus))
+				count("CPUS_ALL_REGIONS", "", float64(mt.GuestCpus))
+			}
+		case *compute.Network:
+			count("NETWORKS", "", 1)
+		case *compute.Subnetwork:
+			count("SUBNETWORKS", "", 1)
+		case *compute.TargetInstance:
+			count("TARGET_INSTANCES", "", 1)
+		}
+	}
+
+	limits := projectQuotas
+	if region != "" {
+		limits = regionQuotas
+	}
+	var qs []*compute.Quota
+	for _, l := range limits {
+		qs = append(qs, &compute.Quota{Metric: l.metric, Limit: l.limit, Usage: usage[l.metric]})
+	}
+	return qs
+}
+
+func (e *Emulator) zone(project, zone string) *compute.Zone {
+	return &compute.Zone{
+		Kind:        "compute#zone",
+		Name:        zone,
+		Description: zone,
+		Region:      link(path.Join(project, "regions", e.zones[zone])),
+		Status:      "UP",
+		SelfLink:    link(path.Join(project, "zones", zone)),
+	}
+}
+
+func (e *Emulator) region(project, region string) *compute.Region {
+	r := &compute.Region{
+		Kind:        "compute#region",
+		Name:        region,
+		Description: region,
+		Quotas:      e.quotas(project, region),
+		Status:      "UP",
+		SelfLink:    link(path.Join(project, "regions", region)),
+	}
+	for z, zr := range e.zones {
+		if zr == region {
+			r.Zones = append(r.Zones, link(path.Join(project, "zones", z)))
+		}
+	}
+	sort.Strings(r.Zones)
+	return r
+}
+
+var (
+	machineTypeRgx = regexp.MustCompile(`^(f1-micro|g1-small|n1-(standard|highmem|highcpu)-([0-9]+)|custom-([0-9]+)-([0-9]+))$`)
+	n1CPUs         = []int64{1, 2, 4, 8, 16, 32, 64, 96}
+	n1Memory       = map[string]int64{"standard": 3840, "highmem": 6656, "highcpu": 922}
+)
+
+// machineType returns the machine type of key, which is
+// "<project>/zones/<zone>/machineTypes/<name>". Besides the predefined
+// machine types, custom machine types exist.
+func (e *Emulator) machineType(key string) (*compute.MachineType, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 5 || parts[1] != "zones" || parts[3] != "machineTypes" {
+		return nil, invalid("Invalid machine type %q", key)
+	}
+	if _, ok := e.zones[parts[2]]; !ok {
+		return nil, notFound(key)
+	}
+	m := machineTypeRgx.FindStringSubmatch(parts[4])
+	if m == nil {
+		return nil, notFound(key)
+	}
+	mt := &compute.MachineType{
+		Kind:                         "compute#machineType",
+		Name:                         parts[4],
+		Zone:                         parts[2],
+		MaximumPersistentDisks:       128,
+		MaximumPersistentDisksSizeGb: 65536,
+		SelfLink:                     link(key),
+	}
+	switch {
+	case m[1] == "f1-micro":
+		mt.GuestCpus, mt.MemoryMb, mt.IsSharedCpu = 1, 614, true
+	case m[1] == "g1-small":
+		mt.GuestCpus, mt.MemoryMb, mt.IsSharedCpu = 1, 1740, true
+	case m[2] != "":
+		cpus, _ := strconv.ParseInt(m[3], 10, 64)
+		if !int64In(cpus, n1CPUs) || (cpus == 1 && m[2] != "standard") {
+			return nil, notFound(key)
+		}
+		mt.GuestCpus, mt.MemoryMb = cpus, cpus*n1Memory[m[2]]
+	default:
+		cpus, _ := strconv.ParseInt(m[4], 10, 64)
+		mem, _ := strconv.ParseInt(m[5], 10, 64)
+		// Like in GCE: 1 or an even number of CPUs, between 0.9 and 6.5 GB of
+		// memory per CPU, in multiples of 256 MB.
+		if cpus < 1 || cpus > 96 || (cpus > 1 && cpus%2 != 0) || mem%256 != 0 || mem*10 < cpus*9*1024 || mem*10 > cpus*65*1024 {
+			return nil, invalid("Invalid custom machine type %q", parts[4])
+		}
+		mt.GuestCpus, mt.MemoryMb = cpus, mem
+	}
+	mt.Description = fmt.Sprintf("%d vCPU, %d MB RAM", mt.GuestCpus, mt.MemoryMb)
+	return mt, nil
+}
+
+func (e *Emulator) routeMachineTypes(c *call, r *http.Request, parts []string) (interface{}, error) {
+	col := path.Join(c.project, c.scope, "machineTypes")
+	switch {
+	case r.Method != http.MethodGet || len(parts) > 1:
+		return nil, unsupported(r)
+	case len(parts) == 1:
+		return e.machineType(path.Join(col, parts[0]))
+	}
+	names := []string{"f1-micro", "g1-small"}
+	for _, family := range []string{"highcpu", "highmem", "standard"} {
+		for _, cpus := range n1CPUs {
+			if cpus > 1 || family == "standard" {
+				names = append(names, fmt.Sprintf("n1-%s-%d", family, cpus))
+			}
+		}
+	}
+	l := &compute.MachineTypeList{Kind: "compute#machineTypeList", SelfLink: link(col)}
+	for _, n := range names {
+		mt, err := e.machineType(path.Join(col, n))
+		if err != nil {
+			return nil, err
+		}
+		l.Items = append(l.Items, mt)
+	}
+	return l, nil
+}
+
+// license returns the license name of project. Every license exists, the
+// emulator can't know the licenses of the public image projects.
+func (e *Emulator) license(project, name string) (interface{}, error) {
+	if !nameRgx.MatchString(name) {
+		return nil, notFound(path.Join(project, "global/licenses", name))
+	}
+	return &compute.License{
+		Kind:         "compute#license",
+		Name:         name,
+		Transferable: true,
+		SelfLink:     link(path.Join(project, "global/licenses", name)),
+	}, nil
+}
+
+// Disks.
+
+// prepareDisk validates and completes d, a new disk of the zone of c.
+func (e *Emulator) prepareDisk(c *call, d *compute.Disk) error {
+	if d.SourceImage != "" {
+		img, key, err := e.image(c.global().resolve(d.SourceImage, "images"))
+		if err != nil {
+			return err
+		}
+		if img.Status != "READY" {
+			return notReady(key)
+		}
+		if d.SizeGb == 0 {
+			d.SizeGb = img.DiskSizeGb
+		}
+		if d.SizeGb < img.DiskSizeGb {
+			return invalid("Requested disk size cannot be smaller than the image size (%d GB)", img.DiskSizeGb)
+		}
+		d.SourceImage = link(key)
+		d.SourceImageId = strconv.FormatUint(img.Id, 10)
+		d.Licenses = img.Licenses
+	}
+	if d.SizeGb == 0 {
+		d.SizeGb = 500
+	}
+	t := "pd-standard"
+	if d.Type != "" {
+		t = path.Base(d.Type)
+	}
+	key := path.Join(c.project, c.scope, "diskTypes", t)
+	if !strIn(t, diskTypes) {
+		return notFound(key)
+	}
+	d.Type = link(key)
+	d.Status = "CREATING"
+	return nil
+}
+
+func insertDisk(e *Emulator, c *call, r *resource) (func(time.Time), error) {
+	d := r.obj.(*compute.Disk)
+	if err := e.prepareDisk(c, d); err != nil {
+		return nil, err
+	}
+	return func(time.Time) { d.Status = "READY" }, nil
+}
+
+func resizeDisk(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error) {
+	drr := &compute.DisksResizeRequest{}
+	if err := json.NewDecoder(req.Body).Decode(drr); err != nil {
+		return nil, invalid("Invalid resize request: %v", err)
+	}
+	d := r.obj.(*compute.Disk)
+	if drr.SizeGb <= d.SizeGb {
+		return nil, invalid("Requested disk size cannot be smaller than the current size (%d GB)", d.SizeGb)
+	}
+	return e.newOperation(c, "resizeDisk", r.key, func(time.Time) { d.SizeGb = drr.SizeGb }), nil
+}
+
+// Images.
+
+// image returns the image of key, which is either
+// "<project>/global/images/<name>" or
+// "<project>/global/images/family/<family>", and the key of the image.
+func (e *Emulator) image(key string) (*compute.Image, string, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) == 5 && parts[3] == "family" {
+		img, err := e.imageFromFamily(parts[0], parts[4])
+		if err != nil {
+			return nil, "", err
+		}
+		return img, path.Join(parts[0], "global/images", img.Name), nil
+	}
+	r, err := e.lookup("images", key)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.obj.(*compute.Image), key, nil
+}
+
+// imageFromFamily returns the newest image of family that isn't deprecated.
+func (e *Emulator) imageFromFamily(project, family string) (*compute.Image, error) {
+	var latest *resource
+	for key, r := range e.resources {
+		img, ok := r.obj.(*compute.Image)
+		if !ok || path.Dir(key) != path.Join(project, "global/images") || img.Family != family {
+			continue
+		}
+		if img.Deprecated != nil && img.Deprecated.State != "" && img.Deprecated.State != "ACTIVE" {
+			continue
+		}
+		if latest == nil || r.created.After(latest.created) {
+			latest = r
+		}
+	}
+	if latest == nil {
+		return nil, notFound(path.Join(project, "global/images/family", family))
+	}
+	return latest.obj.(*compute.Image), nil
+}
+
+func insertImage(e *Emulator, c *call, r *resource) (func(time.Time), error) {
+	img := r.obj.(*compute.Image)
+	var sources int
+	for _, s := range []string{img.SourceDisk, img.SourceImage} {
+		if s != "" {
+			sources++
+		}
+	}
+	if img.RawDisk != nil && img.RawDisk.Source != "" {
+		sources++
+	}
+	if sources != 1 {
+		return nil, invalid("Exactly one of sourceDisk, sourceImage or rawDisk.source must be set")
+	}
+
+	switch {
+	case img.SourceDisk != "":
+		key := c.resolve(img.SourceDisk, "disks")
+		dr, err := e.lookup("disks", key)
+		if err != nil {
+			return nil, err
+		}
+		d := dr.obj.(*compute.Disk)
+		// GCE refuses to create images of disks attached to running
+		// instances unless forced.
+		for _, u := range d.Users {
+			if ir, ok := e.resources[keyOf(u)]; ok && ir.obj.(*compute.Instance).Status == "RUNNING" && c.query.Get("forceCreate") != "true" {
+				return nil, inUse(key, ir.key)
+			}
+		}
+		img.SourceDisk = link(key)
+		img.SourceDiskId = strconv.FormatUint(d.Id, 10)
+		img.DiskSizeGb = d.SizeGb
+		img.Licenses = append(img.Licenses, d.Licenses...)
+	case img.SourceImage != "":
+		src, key, err := e.image(c.resolve(img.SourceImage, "images"))
+		if err != nil {
+			return nil, err
+		}
+		img.SourceImage = link(key)
+		img.SourceImageId = strconv.FormatUint(src.Id, 10)
+		img.DiskSizeGb = src.DiskSizeGb
+		img.Licenses = append(img.Licenses, src.Licenses...)
+	default:
+		// The emulator doesn't read the file, its size is unknown.
+		img.DiskSizeGb = 10
+	}
+	img.SourceType = "RAW"
+	img.Status = "PENDING"
+	return func(time.Time) { img.Status = "READY" }, nil
+}
+
+func deprecateImage(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error) {
+	ds := &compute.DeprecationStatus{}
+	if err := json.NewDecoder(req.Body).Decode(ds); err != nil {
+		return nil, invalid("Invalid deprecation status: %v", err)
+	}
+	if !strIn(ds.State, []string{"", "ACTIVE", "DEPRECATED", "OBSOLETE", "DELETED"}) {
+		return nil, invalid("Invalid deprecation state %q", ds.State)
+	}
+	img := r.obj.(*compute.Image)
+	return e.newOperation(c, "deprecate", r.key, func(time.Time) {
+		if ds.State == "" || ds.State == "ACTIVE" {
+			img.Deprecated = nil
+			return
+		}
+		img.Deprecated = ds
+	}), nil
+}
+
+// Instances.
+
+func insertInstance(e *Emulator, c *call, r *resource) (func(time.Time), error) {
+	inst := r.obj.(*compute.Instance)
+	mtKey := c.resolve(inst.MachineType, "machineTypes")
+	if _, err := e.machineType(mtKey); err != nil {
+		return nil, err
+	}
+	inst.MachineType = link(mtKey)
+	if len(inst.Disks) == 0 {
+		return nil, invalid("Invalid value for field 'resource.disks': ''. No disks are specified.")
+	}
+
+	// Everything is validated before anything is changed: the disks of
+	// InitializeParams are only stored once the instance is valid.
+	var refs []string
+	var newDisks []*resource
+	for i, ad := range inst.Disks {
+		var key string
+		switch {
+		case ad.Source != "":
+			key = c.resolve(ad.Source, "disks")
+			dr, err := e.lookup("disks", key)
+			if err != nil {
+				return nil, err
+			}
+			if path.Dir(path.Dir(key)) != path.Join(c.project, c.scope) {
+				return nil, invalid("Disk %q is not in the zone of the instance", key)
+			}
+			if err := checkAttachable(dr, ad.Mode); err != nil {
+				return nil, err
+			}
+		case ad.InitializeParams != nil:
+			p := ad.InitializeParams
+			name := p.DiskName
+			if name == "" {
+				name = inst.Name
+				if i > 0 {
+					name = fmt.Sprintf("%s-%d", inst.Name, i)
+				}
+			}
+			key = path.Join(c.project, c.scope, "disks", name)
+			if _, ok := e.resources[key]; ok {
+				return nil, alreadyExists(key)
+			}
+			d := &compute.Disk{Name: name, SourceImage: p.SourceImage, SizeGb: p.DiskSizeGb, Type: p.DiskType, Labels: p.Labels}
+			if err := e.prepareDisk(c, d); err != nil {
+				return nil, err
+			}
+			newDisks = append(newDisks, e.newResource(diskKind, key, d))
+		default:
+			return nil, invalid("Disk %d has neither a source nor initializeParams", i)
+		}
+		refs = append(refs, key)
+	}
+
+	for i, ni := range inst.NetworkInterfaces {
+		if ni.Subnetwork != "" {
+			key := (&call{project: c.project, scope: "regions/" + e.zones[path.Base(c.scope)]}).resolve(ni.Subnetwork, "subnetworks")
+			sr, err := e.lookup("subnetworks", key)
+			if err != nil {
+				return nil, err
+			}
+			ni.Subnetwork = link(key)
+			refs = append(refs, key)
+			if ni.Network == "" {
+				ni.Network = sr.obj.(*compute.Subnetwork).Network
+			}
+		}
+		if ni.Network == "" {
+			ni.Network = "global/networks/default"
+		}
+		key := c.global().resolve(ni.Network, "networks")
+		if _, err := e.lookup("networks", key); err != nil {
+			return nil, err
+		}
+		ni.Network = link(key)
+		refs = append(refs, key)
+		ni.Kind = "compute#networkInterface"
+		ni.Name = fmt.Sprintf("nic%d", i)
+		ni.NetworkIP = fmt.Sprintf("10.%d.%d.%d", 128+i, inst.Id/250%250, inst.Id%250+2)
+	}
+
+	for _, d := range newDisks {
+		e.resources[d.key] = d
+	}
+	for i, ad := range inst.Disks {
+		d := e.resources[refs[i]].obj.(*compute.Disk)
+		d.Users = append(d.Users, link(r.key))
+		ad.Kind = "compute#attachedDisk"
+		ad.Source = link(refs[i])
+		ad.Index = int64(i)
+		ad.Type = "PERSISTENT"
+		if ad.Mode == "" {
+			ad.Mode = "READ_WRITE"
+		}
+		if ad.DeviceName == "" {
+			ad.DeviceName = d.Name
+		}
+		ad.InitializeParams = nil
+	}
+	if inst.Metadata == nil {
+		inst.Metadata = &compute.Metadata{}
+	}
+	inst.Metadata.Kind = "compute#metadata"
+	r.refs = refs
+	r.guest = e.newGuest(inst.Name)
+	inst.Status = "PROVISIONING"
+	return func(t time.Time) {
+		for _, d := range newDisks {
+			d.obj.(*compute.Disk).Status = "READY"
+		}
+		inst.Status = "RUNNING"
+		r.guest.start(t)
+	}, nil
+}
+
+// checkAttachable returns an error if the disk of dr can't be attached in
+// mode: disks attached in read-write mode can't be attached elsewhere.
+func checkAttachable(dr *resource, mode string) error {
+	d := dr.obj.(*compute.Disk)
+	if len(d.Users) > 0 && mode != "READ_ONLY" {
+		return inUse(dr.key, keyOf(d.Users[0]))
+	}
+	return nil
+}
+
+func removeUser(d *compute.Disk, user string) {
+	var users []string
+	for _, u := range d.Users {
+		if u != user {
+			users = append(users, u)
+		}
+	}
+	d.Users = users
+}
+
+func removeRef(r *resource, key string) {
+	for i, ref := range r.refs {
+		if ref == key {
+			r.refs = append(r.refs[:i:i], r.refs[i+1:]...)
+			return
+		}
+	}
+}
+
+func deleteInstance(e *Emulator, r *resource) func(time.Time) {
+	inst := r.obj.(*compute.Instance)
+	inst.Status = "STOPPING"
+	return func(time.Time) {
+		for _, ad := range inst.Disks {
+			key := keyOf(ad.Source)
+			dr, ok := e.resources[key]
+			if !ok {
+				continue
+			}
+			d := dr.obj.(*compute.Disk)
+			removeUser(d, link(r.key))
+			if ad.AutoDelete && len(d.Users) == 0 {
+				delete(e.resources, key)
+			}
+		}
+	}
+}
+
+func attachDisk(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error) {
+	ad := &compute.AttachedDisk{}
+	if err := json.NewDecoder(req.Body).Decode(ad); err != nil {
+		return nil, invalid("Invalid attached disk: %v", err)
+	}
+	key := c.resolve(ad.Source, "disks")
+	dr, err := e.lookup("disks", key)
+	if err != nil {
+		return nil, err
+	}
+	if path.Dir(path.Dir(key)) != path.Join(c.project, c.scope) {
+		return nil, invalid("Disk %q is not in the zone of the instance", key)
+	}
+	if err := checkAttachable(dr, ad.Mode); err != nil {
+		return nil, err
+	}
+	inst := r.obj.(*compute.Instance)
+	d := dr.obj.(*compute.Disk)
+	if ad.DeviceName == "" {
+		ad.DeviceName = d.Name
+	}
+	for _, other := range inst.Disks {
+		if other.DeviceName == ad.DeviceName {
+			return nil, invalid("Device name %q is already in use by instance %q", ad.DeviceName, inst.Name)
+		}
+	}
+	return e.newOperation(c, "attachDisk", r.key, func(time.Time) {
+		ad.Kind = "compute#attachedDisk"
+		ad.Source = link(key)
+		ad.Index = int64(len(inst.Disks))
+		ad.Type = "PERSISTENT"
+		if ad.Mode == "" {
+			ad.Mode = "READ_WRITE"
+		}
+		inst.Disks = append(inst.Disks, ad)
+		d.Users = append(d.Users, link(r.key))
+		r.refs = append(r.refs, key)
+	}), nil
+}
+
+func detachDisk(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error) {
+	name := c.query.Get("deviceName")
+	inst := r.obj.(*compute.Instance)
+	for i, ad := range inst.Disks {
+		if ad.DeviceName != name {
+			continue
+		}
+		return e.newOperation(c, "detachDisk", r.key, func(time.Time) {
+			inst.Disks = append(inst.Disks[:i:i], inst.Disks[i+1:]...)
+			key := keyOf(ad.Source)
+			if dr, ok := e.resources[key]; ok {
+				removeUser(dr.obj.(*compute.Disk), link(r.key))
+			}
+			removeRef(r, key)
+		}), nil
+	}
+	return nil, invalid("No attached disk found with device name %q", name)
+}
+
+func startInstance(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error) {
+	inst := r.obj.(*compute.Instance)
+	if inst.Status != "TERMINATED" {
+		return e.newOperation(c, "start", r.key, nil), nil
+	}
+	inst.Status = "STAGING"
+	return e.newOperation(c, "start", r.key, func(t time.Time) {
+		inst.Status = "RUNNING"
+		r.guest.start(t)
+	}), nil
+}
+
+func stopInstance(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error) {
+	inst := r.obj.(*compute.Instance)
+	if inst.Status == "TERMINATED" {
+		return e.newOperation(c, "stop", r.key, nil), nil
+	}
+	inst.Status = "STOPPING"
+	return e.newOperation(c, "stop", r.key, func(time.Time) { inst.Status = "TERMINATED" }), nil
+}
+
+func setMetadata(e *Emulator, c *call, r *resource, req *http.Request) (interface{}, error) {
+	md := &compute.Metadata{}
+	if err := json.NewDecoder(req.Body).Decode(md); err != nil {
+		return nil, invalid("Invalid metadata: %v", err)
+	}
+	inst := r.obj.(*compute.Instance)
+	return e.newOperation(c, "setMetadata", r.key, func(time.Time) {
+		md.Kind = "compute#metadata"
+		inst.Metadata = md
+	}), nil
+}
+
+// Networks, subnetworks, firewall rules, target instances and forwarding
+// rules.
+
+func insertNetwork(e *Emulator, c *call, r *resource) (func(time.Time), error) {
+	n := r.obj.(*compute.Network)
+	if n.IPv4Range != "" {
+		if _, _, err := net.ParseCIDR(n.IPv4Range); err != nil {
+			return nil, invalid("Invalid IPv4Range %q", n.IPv4Range)
+		}
+	}
+	if n.RoutingConfig == nil {
+		n.RoutingConfig = &compute.NetworkRoutingConfig{RoutingMode: "REGIONAL"}
+	}
+	return nil, nil
+}
+
+func insertSubnetwork(e *Emulator, c *call, r *resource) (func(time.Time), error) {
+	sn := r.obj.(*compute.Subnetwork)
+	key := c.global().resolve(sn.Network, "networks")
+	if _, err := e.lookup("networks", key); err != nil {
+		return nil, err
+	}
+	ip, ipNet, err := net.ParseCIDR(sn.IpCidrRange)
+	if err != nil || ip.To4() == nil {
+		return nil, invalid("Invalid IPv4 CIDR range %q", sn.IpCidrRange)
+	}
+	gw := ipNet.IP.To4()
+	gw[3]++
+	sn.GatewayAddress = gw.String()
+	sn.Network = link(key)
+	r.refs = []string{key}
+	return nil, nil
+}
+
+func insertFirewall(e *Emulator, c *call, r *resource) (func(time.Time), error) {
+	fw := r.obj.(*compute.Firewall)
+	if fw.Network == "" {
+		fw.Network = "global/networks/default"
+	}
+	key := c.global().resolve(fw.Network, "networks")
+	if _, err := e.lookup("networks", key); err != nil {
+		return nil, err
+	}
+	if len(fw.Allowed) == 0 && len(fw.Denied) == 0 {
+		return nil, invalid("Exactly one of allowed or denied must be set")
+	}
+	fw.Network = link(key)
+	if fw.Direction == "" {
+		fw.Direction = "INGRESS"
+	}
+	if fw.Priority == 0 {
+		fw.Priority = 1000
+	}
+	r.refs = []string{key}
+	return nil, nil
+}
+
+func insertTargetInstance(e *Emulator, c *call, r *resource) (func(time.Time), error) {
+	ti := r.obj.(*compute.TargetInstance)
+	key := c.resolve(ti.Instance, "instances")
+	if _, err := e.lookup("instances", key); err != nil {
+		return nil, err
+	}
+	ti.Instance = link(key)
+	if ti.NatPolicy == "" {
+		ti.NatPolicy = "NO_NAT"
+	}
+	r.refs = []string{key}
+	return nil, nil
+}
+
+func insertForwardingRule(e *Emulator, c *call, r *resource) (func(time.Time), error) {
+	fr := r.obj.(*compute.ForwardingRule)
+	key := c.resolve(fr.Target, "targetInstances")
+	if _, err := e.lookup("targetInstances", key); err != nil {
+		return nil, err
+	}
+	fr.Target = link(key)
+	if fr.IPAddress == "" {
+		fr.IPAddress = fmt.Sprintf("203.0.%d.%d", fr.Id/250%250, fr.Id%250+2)
+	}
+	if fr.IPProtocol == "" {
+		fr.IPProtocol = "TCP"
+	}
+	if fr.LoadBalancingScheme == "" {
+		fr.LoadBalancingScheme = "EXTERNAL"
+	}
+	r.refs = []string{key}
+	return nil, nil
+}
+
+func int64In(i int64, is []int64) bool {
+	for _, x := range is {
+		if i == x {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package emulator
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestClientResources(t *testing.T) {
+	e, _ := newTestEmulator(t, Config{Images: []*Image{{Project: "debian-cloud", Name: "debian-9-v1", Family: "debian-9", DiskSizeGb: 10}}})
+	c, closeFn := newTestClient(t, e)
+	defer closeFn()
+	p, z, r := "p", "us-central1-a", "us-central1"
+	must := func(desc string, err error) {
+		if err != nil {
+			t.Fatalf("%s: %v", desc, err)
+		}
+	}
+
+	n := &compute.Network{Name: "net"}
+	must("create network", c.CreateNetwork(p, n))
+	if want := apiBase + "p/global/networks/net"; n.SelfLink != want {
+		t.Errorf("got network self link %q, want %q", n.SelfLink, want)
+	}
+	sn := &compute.Subnetwork{Name: "subnet", Network: "global/networks/net", IpCidrRange: "10.0.0.0/24"}
+	must("create subnetwork", c.CreateSubnetwork(p, r, sn))
+	if sn.GatewayAddress != "10.0.0.1" || sn.Network != n.SelfLink {
+		t.Errorf("unexpected subnetwork: %+v", sn)
+	}
+	must("create firewall rule", c.CreateFirewallRule(p, &compute.Firewall{Name: "fw", Network: n.SelfLink, Allowed: []*compute.FirewallAllowed{{IPProtocol: "tcp"}}}))
+
+	d := &compute.Disk{Name: "boot", SourceImage: "projects/debian-cloud/global/images/family/debian-9"}
+	must("create disk", c.CreateDisk(p, z, d))
+	if d.Status != "READY" || d.SizeGb != 10 || d.SourceImage != apiBase+"debian-cloud/global/images/debian-9-v1" || d.Type != apiBase+"p/zones/us-central1-a/diskTypes/pd-standard" {
+		t.Errorf("unexpected disk: %+v", d)
+	}
+
+	i := &compute.Instance{
+		Name:        "inst",
+		MachineType: "zones/us-central1-a/machineTypes/n1-standard-2",
+		Disks: []*compute.AttachedDisk{
+			{Source: "zones/us-central1-a/disks/boot", Boot: true},
+			{InitializeParams: &compute.AttachedDiskInitializeParams{DiskSizeGb: 20}, AutoDelete: true},
+		},
+		NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: "regions/us-central1/subnetworks/subnet"}},
+	}
+	must("create instance", c.CreateInstance(p, z, i))
+	if i.Status != "RUNNING" || i.NetworkInterfaces[0].Network != n.SelfLink || i.Disks[1].DeviceName != "inst-1" {
+		t.Errorf("unexpected instance: %+v", i)
+	}
+	if d, err := c.GetDisk(p, z, "inst-1"); err != nil || d.SizeGb != 20 || len(d.Users) != 1 {
+		t.Errorf("unexpected disk created with the instance: %+v, error: %v", d, err)
+	}
+
+	// Resources in use can't be deleted.
+	if err := c.DeleteDisk(p, z, "boot"); errCode(err) != http.StatusBadRequest {
+		t.Errorf("got error %v deleting disk in use", err)
+	}
+	if err := c.DeleteNetwork(p, "net"); errCode(err) != http.StatusBadRequest {
+		t.Errorf("got error %v deleting network in use", err)
+	}
+
+	reg, err := c.GetRegion(p, r)
+	must("get region", err)
+	usage := map[string]float64{}
+	for _, q := range reg.Quotas {
+		usage[q.Metric] = q.Usage
+	}
+	if usage["CPUS"] != 2 || usage["INSTANCES"] != 1 || usage["DISKS_TOTAL_GB"] != 30 {
+		t.Errorf("unexpected quota usage: %v", usage)
+	}
+
+	// Images of disks of running instances must be forced.
+	img := &compute.Image{Name: "img", SourceDisk: "zones/us-central1-a/disks/boot"}
+	if err := c.CreateImage(p, img); errCode(err) != http.StatusBadRequest {
+		t.Errorf("got error %v creating image of disk of a running instance", err)
+	}
+	must("stop instance", c.StopInstance(p, z, "inst"))
+	if stopped, err := c.InstanceStopped(p, z, "inst"); err != nil || !stopped {
+		t.Errorf("instance not stopped, error: %v", err)
+	}
+	must("create image", c.CreateImage(p, img))
+	if img.Status != "READY" || img.DiskSizeGb != 10 {
+		t.Errorf("unexpected image: %+v", img)
+	}
+
+	must("detach disk", c.DetachDisk(p, z, "inst", "boot"))
+	if d, err := c.GetDisk(p, z, "boot"); err != nil || len(d.Users) != 0 {
+		t.Errorf("disk still in use after detaching: %+v, error: %v", d, err)
+	}
+	must("attach disk", c.AttachDisk(p, z, "inst", &compute.AttachedDisk{Source: d.SelfLink}))
+	if i, err := c.GetInstance(p, z, "inst"); err != nil || len(i.Disks) != 2 || i.Disks[1].DeviceName != "boot" {
+		t.Errorf("unexpected disks after attaching: %+v, error: %v", i, err)
+	}
+
+	ti := &compute.TargetInstance{Name: "ti", Instance: "zones/us-central1-a/instances/inst"}
+	must("create target instance", c.CreateTargetInstance(p, z, ti))
+	must("create forwarding rule", c.CreateForwardingRule(p, r, &compute.ForwardingRule{Name: "fr", Target: ti.SelfLink}))
+
+	must("delete forwarding rule", c.DeleteForwardingRule(p, r, "fr"))
+	must("delete target instance", c.DeleteTargetInstance(p, z, "ti"))
+	must("delete instance", c.DeleteInstance(p, z, "inst"))
+	ds, err := c.ListDisks(p, z)
+	must("list disks", err)
+	if len(ds) != 1 || ds[0].Name != "boot" || len(ds[0].Users) != 0 {
+		t.Errorf("only the disk that isn't auto deleted should be left, got %+v", ds)
+	}
+	must("delete firewall rule", c.DeleteFirewallRule(p, "fw"))
+	must("delete subnetwork", c.DeleteSubnetwork(p, r, "subnet"))
+	must("delete network", c.DeleteNetwork(p, "net"))
+}
+
+func TestClientImages(t *testing.T) {
+	e, clock := newTestEmulator(t, Config{Images: []*Image{{Project: "debian-cloud", Name: "debian-9-v1", Family: "debian-9"}}})
+	c, closeFn := newTestClient(t, e)
+	defer closeFn()
+
+	clock.add(time.Hour)
+	if err := c.CreateImage("debian-cloud", &compute.Image{Name: "debian-9-v2", Family: "debian-9", SourceImage: "global/images/debian-9-v1"}); err != nil {
+		t.Fatal(err)
+	}
+	names := func(is []*compute.Image) []string {
+		var ns []string
+		for _, i := range is {
+			ns = append(ns, i.Name)
+		}
+		return ns
+	}
+	if is, err := c.ListImages("debian-cloud"); err != nil || len(is) != 2 || is[0].Name != "debian-9-v1" {
+		t.Errorf("images not ordered by name: %v, error: %v", names(is), err)
+	}
+	if is, err := c.ListImages("debian-cloud", daisyCompute.OrderBy("creationTimestamp desc")); err != nil || len(is) != 2 || is[0].Name != "debian-9-v2" {
+		t.Errorf("images not ordered by creation: %v, error: %v", names(is), err)
+	}
+
+	if img, err := c.GetImageFromFamily("debian-cloud", "debian-9"); err != nil || img.Name != "debian-9-v2" {
+		t.Errorf("got image %+v, error: %v, want debian-9-v2", img, err)
+	}
+	if err := c.DeprecateImage("debian-cloud", "debian-9-v2", &compute.DeprecationStatus{State: "DEPRECATED"}); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := c.GetImageFromFamily("debian-cloud", "debian-9"); err != nil || img.Name != "debian-9-v1" {
+		t.Errorf("got image %+v, error: %v, want debian-9-v1", img, err)
+	}
+	if _, err := c.GetImageFromFamily("debian-cloud", "debian-8"); errCode(err) != http.StatusNotFound {
+		t.Errorf("got error %v for unknown family", err)
+	}
+}
+
+func TestMachineType(t *testing.T) {
+	e, _ := newTestEmulator(t, Config{})
+	tests := []struct {
+		name      string
+		wantCPUs  int64
+		wantMemMb int64
+		wantErr   bool
+	}{
+		{"f1-micro", 1, 614, false},
+		{"n1-standard-2", 2, 7680, false},
+		{"n1-highmem-96", 96, 638976, false},
+		{"n1-highcpu-1", 0, 0, true},
+		{"n1-standard-3", 0, 0, true},
+		{"custom-2-4096", 2, 4096, false},
+		{"custom-3-4096", 0, 0, true},
+		{"custom-2-1024", 0, 0, true},
+		{"custom-2-4000", 0, 0, true},
+		{"m1-ultramem-40", 0, 0, true},
+	}
+	for _, tt := range tests {
+		mt, err := e.machineType("p/zones/us-central1-a/machineTypes/" + tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (mt.GuestCpus != tt.wantCPUs || mt.MemoryMb != tt.wantMemMb) {
+			t.Errorf("%s: got %d CPUs and %d MB, want %d and %d", tt.name, mt.GuestCpus, mt.MemoryMb, tt.wantCPUs, tt.wantMemMb)
+		}
+	}
+	if _, err := e.machineType("p/zones/nowhere-a/machineTypes/f1-micro"); err == nil {
+		t.Error("expected error for unknown zone")
+	}
+}
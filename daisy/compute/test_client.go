@@ -73,8 +73,10 @@ type TestClient struct {
 	ListMachineTypesFn          func(project, zone string, opts ...ListCallOption) ([]*compute.MachineType, error)
 	GetProjectFn                func(project string) (*compute.Project, error)
 	GetSerialPortOutputFn       func(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
+	GetRegionFn                 func(project, region string) (*compute.Region, error)
 	GetZoneFn                   func(project, zone string) (*compute.Zone, error)
 	ListZonesFn                 func(project string, opts ...ListCallOption) ([]*compute.Zone, error)
+	ListRegionsFn               func(project string, opts ...ListCallOption) ([]*compute.Region, error)
 	GetInstanceFn               func(project, zone, name string) (*compute.Instance, error)
 	ListInstancesFn             func(project, zone string, opts ...ListCallOption) ([]*compute.Instance, error)
 	GetDiskFn                   func(project, zone, name string) (*compute.Disk, error)
@@ -108,6 +110,15 @@ type TestClient struct {
 	globalOperationsWaitFn func(project, name string) error
 }
 
+// WithContext returns a copy of the TestClient, with the same overrides, that
+// uses ctx.
+func (c *TestClient) WithContext(ctx context.Context) Client {
+	nc := *c
+	nc.client.ctx = ctx
+	nc.client.i = &nc
+	return &nc
+}
+
 // Retry uses the override method RetryFn or the real implementation.
 func (c *TestClient) Retry(f func(opts ...googleapi.CallOption) (*compute.Operation, error), opts ...googleapi.CallOption) (op *compute.Operation, err error) {
 	if c.RetryFn != nil {
@@ -308,6 +319,14 @@ func (c *TestClient) ListMachineTypes(project, zone string, opts ...ListCallOpti
 	return c.client.ListMachineTypes(project, zone, opts...)
 }
 
+// GetRegion uses the override method GetRegionFn or the real implementation.
+func (c *TestClient) GetRegion(project, region string) (*compute.Region, error) {
+	if c.GetRegionFn != nil {
+		return c.GetRegionFn(project, region)
+	}
+	return c.client.GetRegion(project, region)
+}
+
 // GetZone uses the override method GetZoneFn or the real implementation.
 func (c *TestClient) GetZone(project, zone string) (*compute.Zone, error) {
 	if c.GetZoneFn != nil {
@@ -324,6 +343,14 @@ func (c *TestClient) ListZones(project string, opts ...ListCallOption) ([]*compu
 	return c.client.ListZones(project, opts...)
 }
 
+// ListRegions uses the override method ListRegionsFn or the real implementation.
+func (c *TestClient) ListRegions(project string, opts ...ListCallOption) ([]*compute.Region, error) {
+	if c.ListRegionsFn != nil {
+		return c.ListRegionsFn(project, opts...)
+	}
+	return c.client.ListRegions(project, opts...)
+}
+
 // GetInstance uses the override method GetZoneFn or the real implementation.
 func (c *TestClient) GetInstance(project, zone, name string) (*compute.Instance, error) {
 	if c.GetInstanceFn != nil {
@@ -68,8 +68,10 @@ func TestTestClient(t *testing.T) {
 		{"list machine types", func() { c.ListMachineTypes("a", "b", listOpts...) }, "/a/zones/b/machineTypes?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
 		{"get firewall rule", func() { c.GetFirewallRule("a", "b") }, "/a/global/firewalls/b?alt=json&prettyPrint=false"},
 		{"list firewall rules", func() { c.ListFirewallRules("a", listOpts...) }, "/a/global/firewalls?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
+		{"get region", func() { c.GetRegion("a", "b") }, "/a/regions/b?alt=json&prettyPrint=false"},
 		{"get zone", func() { c.GetZone("a", "b") }, "/a/zones/b?alt=json&prettyPrint=false"},
 		{"list zones", func() { c.ListZones("a", listOpts...) }, "/a/zones?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
+		{"list regions", func() { c.ListRegions("a") }, "/a/regions?alt=json&pageToken=&prettyPrint=false"},
 		{"get instance", func() { c.GetInstance("a", "b", "c") }, "/a/zones/b/instances/c?alt=json&prettyPrint=false"},
 		{"list instances", func() { c.ListInstances("a", "b", listOpts...) }, "/a/zones/b/instances?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
 		{"get image from family", func() { c.GetImageFromFamily("a", "b") }, "/a/global/images/family/b?alt=json&prettyPrint=false"},
@@ -142,11 +144,16 @@ func TestTestClient(t *testing.T) {
 		return nil, nil
 	}
 	c.GetProjectFn = func(_ string) (*compute.Project, error) { fakeCalled = true; return nil, nil }
+	c.GetRegionFn = func(_, _ string) (*compute.Region, error) { fakeCalled = true; return nil, nil }
 	c.GetZoneFn = func(_, _ string) (*compute.Zone, error) { fakeCalled = true; return nil, nil }
 	c.ListZonesFn = func(_ string, _ ...ListCallOption) ([]*compute.Zone, error) {
 		fakeCalled = true
 		return nil, nil
 	}
+	c.ListRegionsFn = func(_ string, _ ...ListCallOption) ([]*compute.Region, error) {
+		fakeCalled = true
+		return nil, nil
+	}
 	c.GetFirewallRuleFn = func(_, _ string) (*compute.Firewall, error) { fakeCalled = true; return nil, nil }
 	c.ListFirewallRulesFn = func(_ string, _ ...ListCallOption) ([]*compute.Firewall, error) {
 		fakeCalled = true
@@ -0,0 +1,177 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// evalCondition evaluates a step If condition. Vars have already been
+// substituted, so operands are literals: booleans (true, false, 1, 0...),
+// words or quoted strings. Operators are, by precedence: == and !=, !, &&
+// and ||. Parentheses group sub expressions.
+//
+// Examples:
+//
+//	${install_gce_packages}
+//	'${os}' == 'windows' && !${skip_drivers}
+func evalCondition(expr string) (bool, dErr) {
+	toks, err := tokenizeCondition(expr)
+	if err != nil {
+		return false, errf("invalid If condition %q: %v", expr, err)
+	}
+	p := &condParser{toks: toks}
+	res, err := p.or()
+	if err == nil && p.pos < len(p.toks) {
+		err = errf("unexpected %q", p.toks[p.pos].val)
+	}
+	if err != nil {
+		return false, errf("invalid If condition %q: %v", expr, err)
+	}
+	return res, nil
+}
+
+type condTokenKind int
+
+const (
+	condOperand condTokenKind = iota
+	condOperator
+)
+
+type condToken struct {
+	kind condTokenKind
+	val  string
+}
+
+func tokenizeCondition(expr string) ([]condToken, dErr) {
+	var toks []condToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, condToken{condOperator, string(c)})
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"), strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="):
+			toks = append(toks, condToken{condOperator, expr[i : i+2]})
+			i += 2
+		case c == '!':
+			toks = append(toks, condToken{condOperator, "!"})
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end == -1 {
+				return nil, errf("unterminated string starting at %q", expr[i:])
+			}
+			toks = append(toks, condToken{condOperand, expr[i+1 : i+1+end]})
+			i += end + 2
+		default:
+			j := i
+			for j < len(expr) && !unicode.IsSpace(rune(expr[j])) && !strings.ContainsRune("()!&|='\"", rune(expr[j])) {
+				j++
+			}
+			if j == i {
+				return nil, errf("unexpected %q", expr[i:])
+			}
+			toks = append(toks, condToken{condOperand, expr[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type condParser struct {
+	toks []condToken
+	pos  int
+}
+
+func (p *condParser) accept(op string) bool {
+	if p.pos < len(p.toks) && p.toks[p.pos].kind == condOperator && p.toks[p.pos].val == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *condParser) or() (bool, dErr) {
+	res, err := p.and()
+	for err == nil && p.accept("||") {
+		var r bool
+		r, err = p.and()
+		res = res || r
+	}
+	return res, err
+}
+
+func (p *condParser) and() (bool, dErr) {
+	res, err := p.not()
+	for err == nil && p.accept("&&") {
+		var r bool
+		r, err = p.not()
+		res = res && r
+	}
+	return res, err
+}
+
+func (p *condParser) not() (bool, dErr) {
+	if p.accept("!") {
+		res, err := p.not()
+		return !res, err
+	}
+	return p.comparison()
+}
+
+func (p *condParser) comparison() (bool, dErr) {
+	if p.accept("(") {
+		res, err := p.or()
+		if err == nil && !p.accept(")") {
+			err = errf("missing )")
+		}
+		return res, err
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case p.accept("=="):
+		right, err := p.operand()
+		return left == right, err
+	case p.accept("!="):
+		right, err := p.operand()
+		return left != right, err
+	}
+	res, pErr := strconv.ParseBool(left)
+	if pErr != nil {
+		return false, errf("%q is not a boolean", left)
+	}
+	return res, nil
+}
+
+func (p *condParser) operand() (string, dErr) {
+	if p.pos >= len(p.toks) {
+		return "", errf("unexpected end of condition")
+	}
+	t := p.toks[p.pos]
+	if t.kind != condOperand {
+		return "", errf("unexpected %q", t.val)
+	}
+	p.pos++
+	return t.val, nil
+}
@@ -0,0 +1,137 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestEvalCondition(t *testing.T) {
+	tests := []struct {
+		desc, expr string
+		want, err  bool
+	}{
+		{"true case", "true", true, false},
+		{"false case", "false", false, false},
+		{"bool number case", "1", true, false},
+		{"equal case", "foo == foo", true, false},
+		{"not equal case", "foo != foo", false, false},
+		{"quoted case", `'foo bar' == "foo bar"`, true, false},
+		{"empty string case", "'' == ''", true, false},
+		{"not case", "!false", true, false},
+		{"not comparison case", "!a == b", true, false},
+		{"and case", "true && false", false, false},
+		{"or case", "true || false", true, false},
+		{"precedence case", "true || false && false", true, false},
+		{"parentheses case", "(true || false) && false", false, false},
+		{"complex case", "'windows' == 'windows' && !(false || 0)", true, false},
+		{"not a boolean case", "foo", false, true},
+		{"missing operand case", "== foo", false, true},
+		{"missing right operand case", "foo ==", false, true},
+		{"missing parenthesis case", "(true", false, true},
+		{"trailing token case", "true false", false, true},
+		{"unterminated string case", "'foo == foo", false, true},
+		{"empty case", "", false, true},
+	}
+
+	for _, tt := range tests {
+		got, err := evalCondition(tt.expr)
+		if tt.err && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.err && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got: %t, want: %t", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestSkippedStep(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.AddVar("install", "false")
+	var validated, ran bool
+	w.Steps = map[string]*Step{
+		"skipped": {
+			If: "${install}",
+			testType: &mockStep{
+				validateImpl: func(ctx context.Context, s *Step) dErr {
+					validated = true
+					return nil
+				},
+				runImpl: func(ctx context.Context, s *Step) dErr {
+					ran = true
+					return nil
+				},
+			},
+		},
+		"dependent": {testType: &mockStep{}},
+	}
+	w.Dependencies = map[string][]string{"dependent": {"skipped"}}
+
+	if err := w.populate(ctx); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	if !w.Steps["skipped"].skipped {
+		t.Fatal("step with a false condition should be skipped")
+	}
+	if w.Steps["dependent"].skipped {
+		t.Fatal("step without a condition should not be skipped")
+	}
+	if err := w.validate(ctx); err != nil {
+		t.Fatalf("error validating workflow: %v", err)
+	}
+	if err := w.run(ctx); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+	if validated || ran {
+		t.Errorf("skipped step should not be validated or run, validated: %t, ran: %t", validated, ran)
+	}
+}
+
+func TestSkippedStepResources(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.Steps = map[string]*Step{
+		"create": {
+			If:          "foo == bar",
+			CreateDisks: &CreateDisks{{Disk: compute.Disk{Name: "d"}, SizeGb: "1"}},
+		},
+		"delete": {
+			DeleteResources: &DeleteResources{Disks: []string{"d"}},
+		},
+	}
+	w.Dependencies = map[string][]string{"delete": {"create"}}
+
+	if err := w.populate(ctx); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	want := `missing reference for disk "d"`
+	if err := w.validate(ctx); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("deleting a disk created by a skipped step should fail validation with %q, got: %v", want, err)
+	}
+}
+
+func TestInvalidCondition(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{"s": {If: "foo", testType: &mockStep{}}}
+	if err := w.populate(context.Background()); err == nil {
+		t.Error("invalid condition should fail populate")
+	}
+}
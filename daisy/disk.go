@@ -80,6 +80,7 @@ func (d *Disk) populate(ctx context.Context, s *Step) dErr {
 	d.Name, d.Zone, errs = d.Resource.populateWithZone(ctx, s, d.Name, d.Zone)
 
 	d.Description = strOr(d.Description, fmt.Sprintf("Disk created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+	d.Labels = s.w.addWorkflowLabels(&d.Resource, d.Labels)
 	if d.SizeGb != "" {
 		size, err := strconv.ParseInt(d.SizeGb, 10, 64)
 		if err != nil {
@@ -145,9 +146,9 @@ func (dr *diskRegistry) init() {
 	dr.attachments = map[string]map[string]*diskAttachment{}
 }
 
-func (dr *diskRegistry) deleteFn(res *Resource) dErr {
+func (dr *diskRegistry) deleteFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(diskURLRgx, res.link)
-	err := dr.w.ComputeClient.DeleteDisk(m["project"], m["zone"], m["disk"])
+	err := dr.w.ComputeClient.WithContext(ctx).DeleteDisk(m["project"], m["zone"], m["disk"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
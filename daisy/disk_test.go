@@ -68,6 +68,7 @@ func TestDiskPopulate(t *testing.T) {
 		// Test sanitation -- clean/set irrelevant fields.
 		if tt.want != nil {
 			tt.want.Description = tt.input.Description
+			tt.want.Labels = map[string]string{"daisy-workflow-id": "abcdef", "daisy-workflow-name": "test-wf"}
 		}
 		tt.input.Resource = Resource{} // These fields are tested in resource_test.
 
@@ -0,0 +1,90 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute/emulator"
+	"google.golang.org/api/compute/v1"
+)
+
+// TestEmulatorWorkflow runs a workflow against the compute emulator with a
+// local GCSPath, the way it runs without any access to GCP.
+func TestEmulatorWorkflow(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	e, err := emulator.New(emulator.Config{
+		Images: []*emulator.Image{{Project: "debian-cloud", Name: "debian-9-v20180101", Family: "debian-9"}},
+		Instances: []*emulator.InstanceScript{{
+			Name:   "build-.*",
+			Events: []*emulator.GuestEvent{{After: "10ms", SerialOutput: "BuildSuccess: done"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	w := New()
+	w.Name = "emulator-wf"
+	w.Project = "test-project"
+	w.Zone = "us-central1-a"
+	w.GCSPath = "file://" + filepath.ToSlash(td)
+	w.ComputeEndpoint = ts.URL + emulator.BasePath
+	w.DisableCloudLogging()
+	w.DisableStdoutLogging()
+
+	cd, _ := w.NewStep("create-disks")
+	cd.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "boot", SourceImage: "projects/debian-cloud/global/images/family/debian-9"}}}
+	ci, _ := w.NewStep("create-instances")
+	ci.CreateInstances = &CreateInstances{{Instance: compute.Instance{Name: "build", Disks: []*compute.AttachedDisk{{Source: "boot"}}}}}
+	wait, _ := w.NewStep("wait")
+	wait.WaitForInstancesSignal = &WaitForInstancesSignal{{Name: "build", Interval: "100ms", SerialOutput: &SerialOutput{Port: 1, SuccessMatch: "BuildSuccess"}}}
+	if err := w.AddDependency(ci, cd); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddDependency(wait, ci); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Run(ctx); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	// The resources of the workflow are cleaned up in the emulator.
+	if is, err := w.ComputeClient.ListInstances(w.Project, w.Zone); err != nil || len(is) != 0 {
+		t.Errorf("got %d instances after cleanup, error: %v", len(is), err)
+	}
+	if ds, err := w.ComputeClient.ListDisks(w.Project, w.Zone); err != nil || len(ds) != 0 {
+		t.Errorf("got %d disks after cleanup, error: %v", len(ds), err)
+	}
+
+	if _, err := os.Stat((&fileStorage{}).file(w.bucket, path.Join(w.outsPath, summaryFile))); err != nil {
+		t.Errorf("run summary not written: %v", err)
+	}
+}
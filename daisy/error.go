@@ -30,6 +30,9 @@ const (
 	apiError404 = "APIError404"
 )
 
+// errorTypes are the types of typed errors, e.g. for Retry ErrorTypes.
+var errorTypes = []string{apiError, apiError404, fileIOError, imageObsoleteDeletedError, multiError, resourceDNEError}
+
 // dErr is a Daisy internal error type.
 // It has:
 // - optional error typing
@@ -0,0 +1,467 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the type of a workflow lifecycle Event.
+type EventType string
+
+// Workflow lifecycle event types.
+const (
+	EventWorkflowStarted  EventType = "WorkflowStarted"
+	EventStepStarted      EventType = "StepStarted"
+	EventStepFinished     EventType = "StepFinished"
+	EventStepFailed       EventType = "StepFailed"
+	EventResourceCreated  EventType = "ResourceCreated"
+	EventResourceDeleted  EventType = "ResourceDeleted"
+	EventWorkflowFinished EventType = "WorkflowFinished"
+)
+
+var eventTypes = []EventType{
+	EventWorkflowStarted,
+	EventStepStarted,
+	EventStepFinished,
+	EventStepFailed,
+	EventResourceCreated,
+	EventResourceDeleted,
+	EventWorkflowFinished,
+}
+
+// Event is a workflow lifecycle event. The events of a run have the name and
+// ID of the top-level workflow, steps of included and sub workflows are
+// identified by their step path.
+type Event struct {
+	// Unique ID of the event, the same for all delivery attempts.
+	ID         string    `json:"id"`
+	Type       EventType `json:"type"`
+	Time       time.Time `json:"time"`
+	Workflow   string    `json:"workflow"`
+	WorkflowID string    `json:"workflowId"`
+	// Path of the step, qualified by the names of the IncludeWorkflow,
+	// SubWorkflow and ForEach steps that lead to it, e.g. "run-sub.create-disks".
+	StepPath string `json:"stepPath,omitempty"`
+	StepType string `json:"stepType,omitempty"`
+	// Kind and partial URL of the resource, e.g. "disk" and
+	// "projects/p/zones/z/disks/d".
+	ResourceType string `json:"resourceType,omitempty"`
+	Resource     string `json:"resource,omitempty"`
+	// Error of a failed step or workflow.
+	Error string `json:"error,omitempty"`
+}
+
+// EventSink receives the lifecycle events of workflow runs. The events of a
+// run are sent in order, one at a time, without holding up the run. An error
+// returned by Send is logged and the event is dropped. Send must return
+// once ctx is done: the delivery is canceled if it takes too long after the
+// run ended.
+type EventSink interface {
+	Send(ctx context.Context, e *Event) error
+}
+
+// AddEventSink makes the workflow send its lifecycle events to sink. Only used
+// on the top-level workflow.
+func (w *Workflow) AddEventSink(sink EventSink) {
+	w.eventSinks = append(w.eventSinks, sink)
+}
+
+// eventQueue delivers the events of a run to one sink. The queue is not
+// bounded so that a slow sink never holds up the run, runs have few events.
+type eventQueue struct {
+	sink   EventSink
+	mx     sync.Mutex
+	events []*Event
+	closed bool
+	// ready is signaled when events are pushed or the queue is closed.
+	ready chan struct{}
+	// Number of events not delivered before the delivery was stopped.
+	dropped int
+}
+
+func newEventQueue(sink EventSink) *eventQueue {
+	return &eventQueue{sink: sink, ready: make(chan struct{}, 1)}
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// push queues e without blocking.
+func (q *eventQueue) push(e *Event) {
+	q.mx.Lock()
+	q.events = append(q.events, e)
+	q.mx.Unlock()
+	q.signal()
+}
+
+// close stops the delivery once the queued events are delivered.
+func (q *eventQueue) close() {
+	q.mx.Lock()
+	q.closed = true
+	q.mx.Unlock()
+	q.signal()
+}
+
+// pop waits for events and returns them in order, or returns false once the
+// queue is closed and empty.
+func (q *eventQueue) pop() ([]*Event, bool) {
+	for {
+		q.mx.Lock()
+		es, closed := q.events, q.closed
+		q.events = nil
+		q.mx.Unlock()
+		if len(es) > 0 {
+			return es, true
+		}
+		if closed {
+			return nil, false
+		}
+		<-q.ready
+	}
+}
+
+// startEvents starts the delivery of the events of the run to the event sinks
+// of w and its Webhooks.
+func (w *Workflow) startEvents() {
+	sinks := w.eventSinks
+	for _, h := range w.Webhooks {
+		sinks = append(sinks, h)
+	}
+	w.eventsMx.Lock()
+	defer w.eventsMx.Unlock()
+	// Events are still delivered when the run is canceled, until stopEvents
+	// gives up on them.
+	ctx, cancel := context.WithCancel(context.Background())
+	w.eventsCancel = cancel
+	for _, sink := range sinks {
+		q := newEventQueue(sink)
+		w.eventQueues = append(w.eventQueues, q)
+		w.eventsWait.Add(1)
+		go func() {
+			defer w.eventsWait.Done()
+			for {
+				es, ok := q.pop()
+				if !ok {
+					return
+				}
+				for _, e := range es {
+					if ctx.Err() != nil {
+						q.dropped++
+						continue
+					}
+					if err := q.sink.Send(ctx, e); err != nil {
+						if ctx.Err() != nil {
+							q.dropped++
+							continue
+						}
+						w.logWorkflow(SeverityWarn, "Error sending %s event: %v", e.Type, err)
+					}
+				}
+			}
+		}()
+	}
+}
+
+// stopEvents waits for the events of the run to be delivered. When ctx is
+// done first, the delivery is canceled and the remaining events are dropped.
+func (w *Workflow) stopEvents(ctx context.Context) {
+	w.eventsMx.Lock()
+	qs, cancel := w.eventQueues, w.eventsCancel
+	for _, q := range qs {
+		q.close()
+	}
+	w.eventQueues = nil
+	w.eventsCancel = nil
+	w.eventsMx.Unlock()
+	if cancel == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		w.eventsWait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		cancel()
+		<-done
+	}
+	cancel()
+
+	var dropped int
+	for _, q := range qs {
+		dropped += q.dropped
+	}
+	if dropped > 0 {
+		w.logWorkflow(SeverityWarn, "Stopped sending events: %d events were not delivered.", dropped)
+	}
+}
+
+// emit sends a lifecycle event of the run of the workflow tree of w to the
+// event sinks.
+func (w *Workflow) emit(e Event) {
+	root := w.root()
+	root.eventsMx.Lock()
+	defer root.eventsMx.Unlock()
+	if len(root.eventQueues) == 0 {
+		return
+	}
+	root.eventSeq++
+	e.ID = fmt.Sprintf("%s-%d", root.id, root.eventSeq)
+	e.Time = time.Now()
+	e.Workflow = root.Name
+	e.WorkflowID = root.id
+	e.Error = root.Redact(e.Error)
+	for _, q := range root.eventQueues {
+		q.push(&e)
+	}
+}
+
+// emit sends a step event of s.
+func (s *Step) emit(t EventType, err error) {
+	e := Event{Type: t, StepPath: s.fullName(), StepType: s.typeName()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.w.emit(e)
+}
+
+// emitEnd sends the StepFinished or StepFailed event of s, which ran with
+// ctx. Like runStep, a step whose context is done failed.
+func (s *Step) emitEnd(ctx context.Context, err dErr) {
+	switch {
+	case err != nil:
+		s.emit(EventStepFailed, err)
+	case ctx.Err() == context.DeadlineExceeded:
+		s.emit(EventStepFailed, errf("step %q did not complete within its timeout", s.name))
+	case ctx.Err() != nil:
+		s.emit(EventStepFailed, errf("step %q was canceled", s.name))
+	default:
+		for _, r := range s.w.resourceRegistries() {
+			var created []*Resource
+			r.mx.Lock()
+			for _, res := range r.m {
+				if res.creator == s && !res.deleted {
+					created = append(created, res)
+				}
+			}
+			r.mx.Unlock()
+			for _, res := range created {
+				s.w.emit(Event{Type: EventResourceCreated, StepPath: s.fullName(), StepType: s.typeName(), ResourceType: r.typeName, Resource: res.link})
+			}
+		}
+		s.emit(EventStepFinished, nil)
+	}
+}
+
+const (
+	defaultWebhookMaxAttempts    = 3
+	defaultWebhookInitialBackoff = "1s"
+	defaultWebhookTimeout        = "30s"
+)
+
+// Webhook is an EventSink that POSTs the events, as JSON, to an HTTP
+// endpoint. The X-Daisy-Event and X-Daisy-Delivery request headers are the
+// event type and ID. Failed requests are retried with a doubling backoff,
+// unless the endpoint rejected the event with a 4xx status.
+type Webhook struct {
+	// URL to POST the events to.
+	URL string
+	// Key to sign the requests with. The X-Daisy-Signature header of a signed
+	// request is "sha256=" followed by the hex HMAC-SHA256 of the body, see
+	// VerifyWebhookSignature.
+	Secret string `json:",omitempty"`
+	// Types of the events to send (default is all).
+	Events []EventType `json:",omitempty"`
+	// Number of attempts to send an event, including the first one
+	// (default 3).
+	MaxAttempts int `json:",omitempty"`
+	// Time to wait before the first retry (default 1s), the wait doubles
+	// after each retry. Time to wait for the endpoint to respond (default
+	// 30s).
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	InitialBackoff string `json:",omitempty"`
+	Timeout        string `json:",omitempty"`
+
+	initialBackoff time.Duration
+	timeout        time.Duration
+}
+
+// NewWebhook returns a Webhook that sends all events to endpoint, signed with
+// secret if it is not empty.
+func NewWebhook(endpoint, secret string) (*Webhook, error) {
+	h := &Webhook{URL: endpoint, Secret: secret}
+	if err := h.populate(); err != nil {
+		return nil, err
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Webhook) populate() dErr {
+	if h.MaxAttempts == 0 {
+		h.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if h.InitialBackoff == "" {
+		h.InitialBackoff = defaultWebhookInitialBackoff
+	}
+	if h.Timeout == "" {
+		h.Timeout = defaultWebhookTimeout
+	}
+
+	var err error
+	if h.initialBackoff, err = time.ParseDuration(h.InitialBackoff); err != nil {
+		return newErr(err)
+	}
+	if h.timeout, err = time.ParseDuration(h.Timeout); err != nil {
+		return newErr(err)
+	}
+	return nil
+}
+
+func (h *Webhook) validate() dErr {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return newErr(err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return errf("Webhook URL %q is not an http or https URL", h.URL)
+	}
+	if h.MaxAttempts < 1 {
+		return errf("Webhook MaxAttempts must be at least 1, got %d", h.MaxAttempts)
+	}
+	if h.initialBackoff < 0 || h.timeout <= 0 {
+		return errf("Webhook InitialBackoff must not be negative and Timeout must be positive")
+	}
+	for _, t := range h.Events {
+		if !eventTypeIn(t, eventTypes) {
+			return errf("unknown Webhook event type %q, must be one of %q", t, eventTypes)
+		}
+	}
+	return nil
+}
+
+func eventTypeIn(t EventType, ts []EventType) bool {
+	for _, et := range ts {
+		if t == et {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookStatusError is the error of a request the endpoint responded to
+// with an error status.
+type webhookStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *webhookStatusError) Error() string {
+	return e.msg
+}
+
+// retryable reports whether a request that failed with err is retried.
+func (e *webhookStatusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusRequestTimeout || e.code == http.StatusTooManyRequests
+}
+
+// Send sends e to the endpoint of h, retrying failed requests.
+func (h *Webhook) Send(ctx context.Context, e *Event) error {
+	if len(h.Events) > 0 && !eventTypeIn(e.Type, h.Events) {
+		return nil
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	backoff := h.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err = h.post(ctx, e, body)
+		if err == nil {
+			return nil
+		}
+		if sErr, ok := err.(*webhookStatusError); ok && !sErr.retryable() {
+			return err
+		}
+		if attempt >= h.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %v", attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (h *Webhook) post(ctx context.Context, e *Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Daisy-Event", string(e.Type))
+	req.Header.Set("X-Daisy-Delivery", e.ID)
+	if h.Secret != "" {
+		req.Header.Set("X-Daisy-Signature", webhookSignature(h.Secret, body))
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return &webhookStatusError{code: resp.StatusCode, msg: fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))}
+	}
+	return nil
+}
+
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature reports whether signature, the X-Daisy-Signature
+// header of a Webhook request, is the signature of body with secret.
+func VerifyWebhookSignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(webhookSignature(secret, body)), []byte(signature))
+}
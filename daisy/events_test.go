@@ -0,0 +1,402 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testEventSink struct {
+	events []*Event
+	mx     sync.Mutex
+}
+
+func (s *testEventSink) Send(ctx context.Context, e *Event) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+// types returns the types of the events, with the step path or resource.
+func (s *testEventSink) types() []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var ts []string
+	for _, e := range s.events {
+		t := string(e.Type)
+		if e.StepPath != "" {
+			t += " " + e.StepPath
+		}
+		if e.Resource != "" {
+			t += " " + e.Resource
+		}
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+func TestEmit(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	// Events are dropped while no events are delivered.
+	w.emit(Event{Type: EventWorkflowStarted})
+
+	sink := &testEventSink{}
+	w.AddEventSink(sink)
+	w.startEvents()
+	sw := testWorkflow()
+	sw.parent = w
+	s, _ := sw.NewStep("create")
+	s.CreateDisks = &CreateDisks{}
+	other, _ := sw.NewStep("other")
+	sw.disks.m["d"] = &Resource{link: "projects/p/zones/z/disks/d", creator: s}
+	sw.disks.m["o"] = &Resource{link: "projects/p/zones/z/disks/o", creator: other}
+
+	s.emit(EventStepStarted, nil)
+	s.emitEnd(ctx, nil)
+	if err := sw.disks.delete(ctx, "d"); err != nil {
+		t.Fatal(err)
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	other.emitEnd(cctx, nil)
+	other.emitEnd(ctx, errf("failed"))
+	w.stopEvents(context.Background())
+
+	want := []string{
+		"StepStarted create",
+		"ResourceCreated create projects/p/zones/z/disks/d",
+		"StepFinished create",
+		"ResourceDeleted projects/p/zones/z/disks/d",
+		"StepFailed other",
+		"StepFailed other",
+	}
+	if diffRes := diff(sink.types(), want, 0); diffRes != "" {
+		t.Errorf("events do not match expectation: (-got +want)\n%s", diffRes)
+	}
+	for i, e := range sink.events {
+		if e.Workflow != w.Name || e.WorkflowID != w.id || e.ID == "" || e.Time.IsZero() {
+			t.Errorf("event %d does not identify the run: %+v", i, e)
+		}
+	}
+	if e := sink.events[1]; e.StepType != "CreateDisks" || e.ResourceType != "disk" {
+		t.Errorf("unexpected ResourceCreated event: %+v", e)
+	}
+	if sink.events[4].Error == "" || sink.events[5].Error != "failed" {
+		t.Errorf("StepFailed events do not have the error: %+v, %+v", sink.events[4], sink.events[5])
+	}
+}
+
+func TestWebhookPopulate(t *testing.T) {
+	h := &Webhook{URL: "http://example.com"}
+	if err := h.populate(); err != nil {
+		t.Fatal(err)
+	}
+	want := &Webhook{URL: "http://example.com", MaxAttempts: 3, InitialBackoff: "1s", Timeout: "30s", initialBackoff: 1e9, timeout: 30e9}
+	if diffRes := diff(h, want, 0); diffRes != "" {
+		t.Errorf("Webhook not populated as expected: (-got +want)\n%s", diffRes)
+	}
+	if err := (&Webhook{URL: "http://example.com", Timeout: "30 seconds"}).populate(); err == nil {
+		t.Error("expected error for bad Timeout")
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		desc      string
+		h         *Webhook
+		shouldErr bool
+	}{
+		{"normal case", &Webhook{URL: "https://example.com/hook"}, false},
+		{"events case", &Webhook{URL: "https://example.com/hook", Events: []EventType{EventStepFailed, EventWorkflowFinished}}, false},
+		{"bad scheme case", &Webhook{URL: "ftp://example.com/hook"}, true},
+		{"no host case", &Webhook{URL: "http:///hook"}, true},
+		{"bad attempts case", &Webhook{URL: "https://example.com/hook", MaxAttempts: -1}, true},
+		{"bad event case", &Webhook{URL: "https://example.com/hook", Events: []EventType{"StepDone"}}, true},
+	}
+	for _, tt := range tests {
+		err := tt.h.populate()
+		if err == nil {
+			err = tt.h.validate()
+		}
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	var mx sync.Mutex
+	var statuses []int
+	var reqs int
+	var got Event
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		reqs++
+		body, _ := ioutil.ReadAll(r.Body)
+		if !VerifyWebhookSignature("key", body, r.Header.Get("X-Daisy-Signature")) {
+			http.Error(rw, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Daisy-Event") != "StepFailed" || r.Header.Get("X-Daisy-Delivery") != "abcdef-1" {
+			http.Error(rw, "bad headers", http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(statuses) > 0 {
+			rw.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	defer ts.Close()
+
+	e := &Event{ID: "abcdef-1", Type: EventStepFailed, WorkflowID: "abcdef", StepPath: "sub.step", Error: "failed"}
+	tests := []struct {
+		desc      string
+		secret    string
+		events    []EventType
+		statuses  []int
+		wantReqs  int
+		shouldErr bool
+	}{
+		{"normal case", "key", nil, nil, 1, false},
+		{"retry case", "key", nil, []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, 3, false},
+		{"give up case", "key", nil, []int{500, 500, 500}, 3, true},
+		{"rejected case", "key", nil, []int{http.StatusNotFound}, 1, true},
+		{"bad signature case", "other", nil, nil, 1, true},
+		{"filtered case", "key", []EventType{EventWorkflowFinished}, nil, 0, false},
+	}
+	for _, tt := range tests {
+		h, err := NewWebhook(ts.URL, tt.secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.InitialBackoff = "1ms"
+		h.Events = tt.events
+		if err := h.populate(); err != nil {
+			t.Fatal(err)
+		}
+		mx.Lock()
+		statuses, reqs, got = tt.statuses, 0, Event{}
+		mx.Unlock()
+
+		err = h.Send(context.Background(), e)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		mx.Lock()
+		if reqs != tt.wantReqs {
+			t.Errorf("%s: got %d requests, want %d", tt.desc, reqs, tt.wantReqs)
+		}
+		if !tt.shouldErr && tt.wantReqs > 0 && (got.StepPath != e.StepPath || got.Error != e.Error) {
+			t.Errorf("%s: unexpected event: %+v", tt.desc, got)
+		}
+		mx.Unlock()
+	}
+}
+
+func TestRunEvents(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	gcsPath := "file://" + filepath.ToSlash(td)
+
+	var mx sync.Mutex
+	var hooked []string
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		hooked = append(hooked, r.Header.Get("X-Daisy-Event"))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		desc, source string
+		want         []string
+		wantErr      bool
+	}{
+		{
+			"normal case", "${SOURCESPATH}/test.txt",
+			[]string{"WorkflowStarted", "StepStarted copy", "StepFinished copy", "WorkflowFinished"},
+			false,
+		},
+		{
+			"failure case", "${SOURCESPATH}/dne.txt",
+			[]string{"WorkflowStarted", "StepStarted copy", "StepFailed copy", "WorkflowFinished"},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.GCSPath = gcsPath
+		w.StorageClient = nil
+		w.Sources = map[string]string{"test.txt": "./test_data/test.txt"}
+		w.Webhooks = []*Webhook{{URL: ts.URL, Events: []EventType{EventWorkflowFinished}}}
+		s, _ := w.NewStep("copy")
+		s.CopyGCSObjects = &CopyGCSObjects{{Source: tt.source, Destination: gcsPath + "/out/test.txt"}}
+		sink := &testEventSink{}
+		w.AddEventSink(sink)
+		mx.Lock()
+		hooked = nil
+		mx.Unlock()
+
+		err := w.Run(ctx)
+		if tt.wantErr != (err != nil) {
+			t.Errorf("%s: unexpected run error: %v", tt.desc, err)
+		}
+		if diffRes := diff(sink.types(), tt.want, 0); diffRes != "" {
+			t.Errorf("%s: events do not match expectation: (-got +want)\n%s", tt.desc, diffRes)
+		}
+		last := sink.events[len(sink.events)-1]
+		if tt.wantErr != (last.Error != "") {
+			t.Errorf("%s: unexpected WorkflowFinished error: %q", tt.desc, last.Error)
+		}
+		mx.Lock()
+		if len(hooked) != 1 || hooked[0] != "WorkflowFinished" {
+			t.Errorf("%s: webhook got %q, want the WorkflowFinished event", tt.desc, hooked)
+		}
+		mx.Unlock()
+	}
+}
+
+func TestEventSinkError(t *testing.T) {
+	w := testWorkflow()
+	w.AddEventSink(errEventSink{})
+	w.startEvents()
+	w.emit(Event{Type: EventWorkflowStarted})
+	w.stopEvents(context.Background())
+	var found bool
+	for _, e := range w.Logger.(*MockLogger).getEntries() {
+		if e.Severity == SeverityWarn && e.Message == "Error sending WorkflowStarted event: sink error" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("event sink error not logged")
+	}
+}
+
+// blockingEventSink blocks in Send until release is closed.
+type blockingEventSink struct {
+	testEventSink
+	release chan struct{}
+}
+
+func (s *blockingEventSink) Send(ctx context.Context, e *Event) error {
+	<-s.release
+	return s.testEventSink.Send(ctx, e)
+}
+
+func TestEmitSlowSink(t *testing.T) {
+	w := testWorkflow()
+	sink := &blockingEventSink{release: make(chan struct{})}
+	w.AddEventSink(sink)
+	w.startEvents()
+
+	// A sink that doesn't keep up doesn't hold up the run.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			w.emit(Event{Type: EventStepStarted, StepPath: fmt.Sprint(i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("emit blocked on a slow sink")
+	}
+	close(sink.release)
+	w.stopEvents(context.Background())
+
+	ts := sink.types()
+	if len(ts) != 1000 {
+		t.Fatalf("got %d events, want 1000", len(ts))
+	}
+	for i, typ := range ts {
+		if want := fmt.Sprintf("StepStarted %d", i); typ != want {
+			t.Fatalf("event %d: got %q, want %q", i, typ, want)
+		}
+	}
+}
+
+type errEventSink struct{}
+
+func (errEventSink) Send(ctx context.Context, e *Event) error {
+	return errors.New("sink error")
+}
+
+// stuckEventSink blocks in Send until ctx is done.
+type stuckEventSink struct{}
+
+func (stuckEventSink) Send(ctx context.Context, e *Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStopEventsDeadline(t *testing.T) {
+	w := testWorkflow()
+	w.AddEventSink(stuckEventSink{})
+	w.startEvents()
+	for i := 0; i < 3; i++ {
+		w.emit(Event{Type: EventStepStarted, StepPath: fmt.Sprint(i)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		w.stopEvents(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopEvents did not return at its deadline")
+	}
+
+	var found bool
+	for _, e := range w.Logger.(*MockLogger).getEntries() {
+		if e.Severity == SeverityWarn && e.Message == "Stopped sending events: 3 events were not delivered." {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("dropped events not logged, got: %v", w.Logger.(*MockLogger).getEntries())
+	}
+}
@@ -0,0 +1,209 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// fileStorage is a Storage in the local file system, for running workflows
+// without GCS. Object obj of bucket bkt is the file /bkt/obj and its URL is
+// file:///bkt/obj. ACLs are not supported and are ignored.
+type fileStorage struct{}
+
+func (s *fileStorage) file(bkt, obj string) string {
+	return filepath.Join(string(filepath.Separator), bkt, filepath.FromSlash(obj))
+}
+
+// fileErr converts errors for files that don't exist to the 404 errors of
+// the GCS API.
+func fileErr(err error) error {
+	if os.IsNotExist(err) {
+		return &googleapi.Error{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
+
+// NewReader reads the content of an object.
+func (s *fileStorage) NewReader(ctx context.Context, bkt, obj string) (io.ReadCloser, error) {
+	f, err := os.Open(s.file(bkt, obj))
+	if err != nil {
+		return nil, fileErr(err)
+	}
+	return f, nil
+}
+
+// fileWriter writes to a temporary file that is moved to the file of the
+// object when it is closed, so that readers never see partial objects.
+type fileWriter struct {
+	name string
+	f    *os.File
+	err  error
+}
+
+func (w *fileWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.f.Write(b)
+}
+
+func (w *fileWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.f.Chmod(0644); err != nil {
+		w.f.Close()
+		os.Remove(w.f.Name())
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	if err := os.Rename(w.f.Name(), w.name); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	return nil
+}
+
+// abort removes the temporary file without writing the object, which keeps
+// its previous content, if any.
+func (w *fileWriter) abort() {
+	if w.f == nil {
+		return
+	}
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// NewWriter replaces the content of an object. The object is written when
+// the writer is closed.
+func (s *fileStorage) NewWriter(ctx context.Context, bkt, obj, contentType string) io.WriteCloser {
+	w := &fileWriter{name: s.file(bkt, obj)}
+	if _, err := os.Stat(s.file(bkt, "")); err != nil {
+		w.err = fileErr(err)
+		return w
+	}
+	if w.err = os.MkdirAll(filepath.Dir(w.name), 0755); w.err != nil {
+		return w
+	}
+	w.f, w.err = ioutil.TempFile(filepath.Dir(w.name), ".daisy-")
+	return w
+}
+
+// Attrs returns the attributes of an object.
+func (s *fileStorage) Attrs(ctx context.Context, bkt, obj string) (*storage.ObjectAttrs, error) {
+	fi, err := os.Stat(s.file(bkt, obj))
+	if err != nil {
+		return nil, fileErr(err)
+	}
+	if fi.IsDir() {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: s.file(bkt, obj) + " is a directory"}
+	}
+	return &storage.ObjectAttrs{Bucket: bkt, Name: obj, Size: fi.Size(), Updated: fi.ModTime()}, nil
+}
+
+// List returns the attributes of the objects of a bucket whose names start
+// with prefix.
+func (s *fileStorage) List(ctx context.Context, bkt, prefix string) ([]*storage.ObjectAttrs, error) {
+	// Only walk the directory the prefix is in.
+	dir := prefix
+	if !strings.HasSuffix(prefix, "/") {
+		dir = path.Dir(prefix)
+	}
+	root := s.file(bkt, "")
+	var objs []*storage.ObjectAttrs
+	err := filepath.Walk(s.file(bkt, dir), func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) && !strings.HasPrefix(fi.Name(), ".daisy-") {
+			objs = append(objs, &storage.ObjectAttrs{Bucket: bkt, Name: name, Size: fi.Size(), Updated: fi.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// Copy copies an object.
+func (s *fileStorage) Copy(ctx context.Context, sBkt, sObj, dBkt, dObj string) error {
+	r, err := s.NewReader(ctx, sBkt, sObj)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w := s.NewWriter(ctx, dBkt, dObj, "").(*fileWriter)
+	if _, err := io.Copy(w, r); err != nil {
+		w.abort()
+		return err
+	}
+	return w.Close()
+}
+
+// Delete deletes an object.
+func (s *fileStorage) Delete(ctx context.Context, bkt, obj string) error {
+	if _, err := s.Attrs(ctx, bkt, obj); err != nil {
+		return err
+	}
+	return fileErr(os.Remove(s.file(bkt, obj)))
+}
+
+// SetACL does nothing, files have no ACLs.
+func (s *fileStorage) SetACL(ctx context.Context, bkt, obj string, entity storage.ACLEntity, role storage.ACLRole) error {
+	return nil
+}
+
+// CheckBucket returns an error if the directory of a bucket doesn't exist.
+func (s *fileStorage) CheckBucket(ctx context.Context, bkt string) error {
+	fi, err := os.Stat(s.file(bkt, ""))
+	if err != nil {
+		return fileErr(err)
+	}
+	if !fi.IsDir() {
+		return errf("%s is not a directory", s.file(bkt, ""))
+	}
+	return nil
+}
+
+// URL returns the file:// URL of an object.
+func (s *fileStorage) URL(bkt, obj string) string {
+	return "file:///" + path.Join(bkt, obj)
+}
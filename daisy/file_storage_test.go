@@ -0,0 +1,170 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func isNotFound(err error) bool {
+	gErr, ok := err.(*googleapi.Error)
+	return ok && gErr.Code == http.StatusNotFound
+}
+
+func TestFileStorage(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	bkt, p, err := splitGCSPath("file://" + filepath.ToSlash(td))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fileStorage{}
+
+	write := func(obj, content string) {
+		w := s.NewWriter(ctx, bkt, obj, "text/plain")
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("error writing %s: %v", obj, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("error closing %s: %v", obj, err)
+		}
+	}
+	write(path.Join(p, "a/obj1"), "content")
+	write(path.Join(p, "a/b/obj2"), "")
+	write(path.Join(p, "c"), "c")
+
+	if attrs, err := s.Attrs(ctx, bkt, path.Join(p, "a/obj1")); err != nil || attrs.Size != 7 {
+		t.Errorf("got attrs %+v, error: %v", attrs, err)
+	}
+	r, err := s.NewReader(ctx, bkt, path.Join(p, "a/obj1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "content" {
+		t.Errorf("read %q, error: %v", b, err)
+	}
+
+	objs, err := s.List(ctx, bkt, path.Join(p, "a")+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, o := range objs {
+		names = append(names, o.Name)
+	}
+	sort.Strings(names)
+	if want := []string{path.Join(p, "a/b/obj2"), path.Join(p, "a/obj1")}; len(names) != 2 || names[0] != want[0] || names[1] != want[1] {
+		t.Errorf("listed %q, want %q", names, want)
+	}
+	if objs, err := s.List(ctx, bkt, path.Join(p, "dne")+"/"); err != nil || len(objs) != 0 {
+		t.Errorf("listed %d objects of a missing directory, error: %v", len(objs), err)
+	}
+
+	if err := s.Copy(ctx, bkt, path.Join(p, "c"), bkt, path.Join(p, "d/c")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(td, "d", "c")); err != nil || string(b) != "c" {
+		t.Errorf("copied %q, error: %v", b, err)
+	}
+	if err := s.Copy(ctx, bkt, path.Join(p, "dne"), bkt, path.Join(p, "d/dne")); !isNotFound(err) {
+		t.Errorf("got error %v copying a missing object", err)
+	}
+	// A failed copy leaves the destination and no temporary file behind.
+	if err := s.Copy(ctx, bkt, path.Join(p, "a"), bkt, path.Join(p, "d/c")); err == nil {
+		t.Error("expected error copying a directory")
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(td, "d", "c")); err != nil || string(b) != "c" {
+		t.Errorf("failed copy left %q, error: %v", b, err)
+	}
+	if fis, err := ioutil.ReadDir(filepath.Join(td, "d")); err != nil || len(fis) != 1 {
+		t.Errorf("failed copy left %d files, error: %v", len(fis), err)
+	}
+
+	if err := s.Delete(ctx, bkt, path.Join(p, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Attrs(ctx, bkt, path.Join(p, "c")); !isNotFound(err) {
+		t.Errorf("got error %v for deleted object", err)
+	}
+	if err := s.Delete(ctx, bkt, path.Join(p, "c")); !isNotFound(err) {
+		t.Errorf("got error %v deleting a missing object", err)
+	}
+	if _, err := s.NewReader(ctx, bkt, path.Join(p, "c")); !isNotFound(err) {
+		t.Errorf("got error %v reading a missing object", err)
+	}
+
+	if err := s.CheckBucket(ctx, bkt); err != nil {
+		t.Errorf("unexpected error checking bucket: %v", err)
+	}
+	if err := s.CheckBucket(ctx, "daisy-dne-bucket"); !isNotFound(err) {
+		t.Errorf("got error %v checking a missing bucket", err)
+	}
+	if err := s.NewWriter(ctx, "daisy-dne-bucket", "obj", "").Close(); !isNotFound(err) {
+		t.Errorf("got error %v writing to a missing bucket", err)
+	}
+
+	if got, want := s.URL(bkt, path.Join(p, "c")), "file://"+filepath.ToSlash(filepath.Join(td, "c")); got != want {
+		t.Errorf("got URL %q, want %q", got, want)
+	}
+}
+
+func TestFileStorageWorkflow(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	gcsPath := "file://" + filepath.ToSlash(td)
+
+	w := testWorkflow()
+	w.GCSPath = gcsPath
+	w.StorageClient = nil
+	w.Sources = map[string]string{"test.txt": "./test_data/test.txt"}
+	s, _ := w.NewStep("copy")
+	s.CopyGCSObjects = &CopyGCSObjects{{Source: "${SOURCESPATH}/test.txt", Destination: gcsPath + "/out/test.txt"}}
+	if err := w.Run(ctx); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+	if _, ok := w.Storage.(*fileStorage); !ok || w.StorageClient != nil {
+		t.Errorf("workflow with a local GCSPath uses storage %T and GCS client %v", w.Storage, w.StorageClient)
+	}
+
+	want, err := ioutil.ReadFile("./test_data/test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(td, "out", "test.txt")); err != nil || string(got) != string(want) {
+		t.Errorf("copied %q, error: %v, want %q", got, err, want)
+	}
+	if _, err := os.Stat((&fileStorage{}).file(w.bucket, path.Join(w.outsPath, summaryFile))); err != nil {
+		t.Errorf("run summary not written: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// failureVarRgx matches the vars set for OnFailure and Finally steps.
+var failureVarRgx = regexp.MustCompile(`\$\{(FAILED_STEP|ERROR)}`)
+
+// stepStage is the part of a workflow a step belongs to: the DAG of Steps, or
+// the OnFailure or Finally steps, which run in that order.
+type stepStage int
+
+const (
+	stageDAG stepStage = iota
+	stageOnFailure
+	stageFinally
+)
+
+// finalSteps returns the OnFailure steps of w followed by its Finally steps.
+func (w *Workflow) finalSteps() []*Step {
+	return append(append([]*Step{}, w.OnFailure...), w.Finally...)
+}
+
+// nameFinalSteps names the OnFailure and Finally steps of w after their list
+// and their position in it, e.g. "on-failure-1" and "finally-2".
+func (w *Workflow) nameFinalSteps() {
+	lists := []struct {
+		stage  stepStage
+		prefix string
+		steps  []*Step
+	}{
+		{stageOnFailure, "on-failure", w.OnFailure},
+		{stageFinally, "finally", w.Finally},
+	}
+	for _, l := range lists {
+		for i, s := range l.steps {
+			if s == nil {
+				continue
+			}
+			s.name = fmt.Sprintf("%s-%d", l.prefix, i+1)
+			s.w = w
+			s.stage = l.stage
+			s.index = i
+		}
+	}
+}
+
+func (w *Workflow) populateFinalSteps(ctx context.Context) dErr {
+	w.nameFinalSteps()
+	for _, s := range w.finalSteps() {
+		if s == nil {
+			return errf("OnFailure and Finally steps must not be null")
+		}
+		if err := w.populateStep(ctx, s); err != nil {
+			return errf("error populating step %q: %v", s.name, err)
+		}
+	}
+	return nil
+}
+
+// validateFinalSteps validates the OnFailure and Finally steps of w in the
+// order they run in, after the steps of the DAG are validated.
+func (w *Workflow) validateFinalSteps(ctx context.Context) dErr {
+	for _, s := range w.finalSteps() {
+		if _, ok := w.Steps[s.name]; ok {
+			return errf("step name %q is reserved for OnFailure and Finally steps", s.name)
+		}
+		if err := s.validate(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordFailure records that s failed with err, for w and the workflows that
+// include w, unless a step failed before.
+func (w *Workflow) recordFailure(s *Step, err dErr) {
+	name := s.fullName()
+	for wf := w; wf != nil; wf = wf.parent {
+		wf.failedMx.Lock()
+		if wf.failedStep == "" {
+			wf.failedStep, wf.failedErr = name, err
+		}
+		wf.failedMx.Unlock()
+	}
+}
+
+// runFinalSteps runs the OnFailure steps of w, if err, the error the DAG of w
+// ended with, is set, and then its Finally steps. They run while the
+// resources of the workflow still exist. A failing step doesn't stop the
+// steps after it. Their errors are only returned if the DAG succeeded, so
+// they don't mask the error of the step that failed.
+func (w *Workflow) runFinalSteps(ctx context.Context, err dErr) dErr {
+	steps := w.Finally
+	if err != nil {
+		steps = w.finalSteps()
+	}
+	if len(steps) == 0 {
+		return err
+	}
+	if ctx.Err() != nil {
+		w.LogWorkflowInfo("Workflow was canceled, not running OnFailure and Finally steps.")
+		return err
+	}
+
+	w.failedMx.Lock()
+	failedStep, failedErr := w.failedStep, w.failedErr
+	w.failedMx.Unlock()
+	errMsg := ""
+	if failedErr != nil {
+		errMsg = failedErr.Error()
+	} else if err != nil {
+		errMsg = err.Error()
+	}
+	r := strings.NewReplacer("${FAILED_STEP}", failedStep, "${ERROR}", errMsg)
+
+	var errs dErr
+	for _, s := range steps {
+		substitute(reflect.ValueOf(s).Elem(), r)
+		if sErr := w.runStep(ctx, s); sErr != nil {
+			if err != nil {
+				w.logWorkflow(SeverityError, "Error running step %q after the workflow failed: %v", s.name, sErr)
+			}
+			errs = addErrs(errs, sErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return errs
+}
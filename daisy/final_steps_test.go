@@ -0,0 +1,169 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunFinalSteps(t *testing.T) {
+	tests := []struct {
+		desc      string
+		dagErr    dErr
+		wantErr   string
+		wantOrder []string
+	}{
+		{"dag fails", errf("boom"), `step "a" run error: boom`, []string{"a", "on-failure-1", "finally-1", "finally-2"}},
+		{"dag succeeds", nil, `step "finally-1" run error: finally error`, []string{"a", "finally-1", "finally-2"}},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		var mx sync.Mutex
+		var order []string
+		step := func(err dErr) *Step {
+			return &Step{timeout: time.Minute, testType: &mockStep{runImpl: func(_ context.Context, s *Step) dErr {
+				mx.Lock()
+				order = append(order, s.name)
+				mx.Unlock()
+				return err
+			}}}
+		}
+		a := step(tt.dagErr)
+		a.name, a.w = "a", w
+		w.Steps = map[string]*Step{"a": a}
+		// Any string field of the steps is substituted.
+		onFailure := step(nil)
+		onFailure.If = "${FAILED_STEP}: ${ERROR}"
+		w.OnFailure = []*Step{onFailure}
+		w.Finally = []*Step{step(errf("finally error")), step(nil)}
+		w.nameFinalSteps()
+
+		err := w.run(context.Background())
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("%s: got error %v, want %q", tt.desc, err, tt.wantErr)
+		}
+		if diffRes := diff(order, tt.wantOrder, 0); diffRes != "" {
+			t.Errorf("%s: steps did not run in the expected order: (-got +want)\n%s", tt.desc, diffRes)
+		}
+		if tt.dagErr != nil {
+			if want := `a: step "a" run error: boom`; onFailure.If != want {
+				t.Errorf("%s: got substituted field %q, want %q", tt.desc, onFailure.If, want)
+			}
+		}
+	}
+}
+
+func TestRunFinalStepsCanceled(t *testing.T) {
+	w := testWorkflow()
+	ctx, cancel := context.WithCancel(context.Background())
+	w.Steps = map[string]*Step{"a": {name: "a", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) dErr {
+		cancel()
+		return nil
+	}}}}
+	ran := false
+	w.Finally = []*Step{{timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) dErr {
+		ran = true
+		return nil
+	}}}}
+	w.nameFinalSteps()
+
+	if err := w.run(ctx); err == nil {
+		t.Error("expected error")
+	}
+	if ran {
+		t.Error("Finally step ran after the workflow was canceled")
+	}
+}
+
+func TestRunStopsRunningSteps(t *testing.T) {
+	w := testWorkflow()
+	stopped := make(chan struct{})
+	w.Steps = map[string]*Step{
+		"a": {name: "a", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) dErr {
+			return errf("boom")
+		}}},
+		"b": {name: "b", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(ctx context.Context, _ *Step) dErr {
+			<-ctx.Done()
+			close(stopped)
+			return nil
+		}}},
+	}
+	var ranAfterStop bool
+	w.Finally = []*Step{{timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) dErr {
+		select {
+		case <-stopped:
+			ranAfterStop = true
+		default:
+		}
+		return nil
+	}}}}
+	w.nameFinalSteps()
+
+	want := w.Steps["a"].wrapRunError(errf("boom"))
+	if err := w.run(context.Background()); err == nil || err.Error() != want.Error() {
+		t.Errorf("unexpected error: got %v, want %v", err, want)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("running step was not stopped")
+	}
+	if !ranAfterStop {
+		t.Error("Finally step ran before the running step was stopped")
+	}
+}
+
+func TestFinalStepsDepend(t *testing.T) {
+	w := testWorkflow()
+	a, _ := w.NewStep("a")
+	w.OnFailure = []*Step{{}}
+	w.Finally = []*Step{{}, {}}
+	w.nameFinalSteps()
+	o1, f1, f2 := w.OnFailure[0], w.Finally[0], w.Finally[1]
+
+	tests := []struct {
+		s, other *Step
+		want     bool
+	}{
+		{o1, a, true},
+		{f1, a, true},
+		{f1, o1, true},
+		{f2, f1, true},
+		{a, o1, false},
+		{o1, f1, false},
+		{f1, f2, false},
+		{f1, f1, false},
+	}
+	for _, tt := range tests {
+		if got := tt.s.depends(tt.other); got != tt.want {
+			t.Errorf("%q.depends(%q) = %t, want %t", tt.s.name, tt.other.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateFinalSteps(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{"finally-1": {name: "finally-1", w: w, testType: &mockStep{}}}
+	w.Finally = []*Step{{testType: &mockStep{}}}
+	w.nameFinalSteps()
+
+	want := `step name "finally-1" is reserved for OnFailure and Finally steps`
+	if err := w.validateFinalSteps(context.Background()); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
@@ -74,7 +74,7 @@ func (fir *FirewallRule) populate(ctx context.Context, s *Step) dErr {
 		fir.Network = extendPartialURL(fir.Network, fir.Project)
 	}
 
-	fir.Description = strOr(fir.Description, defaultDescription("FirewallRule", s.w.Name, s.w.username))
+	fir.Description = s.w.addWorkflowMarker(&fir.Resource, strOr(fir.Description, defaultDescription("FirewallRule", s.w.Name, s.w.username)))
 	fir.link = fmt.Sprintf("projects/%s/global/firewalls/%s", fir.Project, fir.Name)
 	return errs
 }
@@ -110,9 +110,9 @@ func newFirewallRuleRegistry(w *Workflow) *firewallRuleRegistry {
 	return frr
 }
 
-func (frr *firewallRuleRegistry) deleteFn(res *Resource) dErr {
+func (frr *firewallRuleRegistry) deleteFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(firewallRuleURLRegex, res.link)
-	err := frr.w.ComputeClient.DeleteFirewallRule(m["project"], m["firewallRule"])
+	err := frr.w.ComputeClient.WithContext(ctx).DeleteFirewallRule(m["project"], m["firewallRule"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
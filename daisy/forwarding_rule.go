@@ -79,7 +79,7 @@ func (fr *ForwardingRule) populate(ctx context.Context, s *Step) dErr {
 		fr.Target = fmt.Sprintf("projects/%s/zones/%s/targetInstances/%s", fr.Project, s.w.Zone, fr.Target)
 	}
 
-	fr.Description = strOr(fr.Description, defaultDescription("ForwardingRule", s.w.Name, s.w.username))
+	fr.Description = s.w.addWorkflowMarker(&fr.Resource, strOr(fr.Description, defaultDescription("ForwardingRule", s.w.Name, s.w.username)))
 	fr.link = fmt.Sprintf("projects/%s/regions/%s/forwardingRules/%s", fr.Project, fr.Region, fr.Name)
 	return errs
 }
@@ -121,9 +121,9 @@ func newForwardingRuleRegistry(w *Workflow) *forwardingRuleRegistry {
 	return tir
 }
 
-func (tir *forwardingRuleRegistry) deleteFn(res *Resource) dErr {
+func (tir *forwardingRuleRegistry) deleteFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(forwardingRuleURLRegex, res.link)
-	err := tir.w.ComputeClient.DeleteForwardingRule(m["project"], m["region"], m["forwardingRule"])
+	err := tir.w.ComputeClient.WithContext(ctx).DeleteForwardingRule(m["project"], m["region"], m["forwardingRule"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
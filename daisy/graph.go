@@ -0,0 +1,313 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Graph formats.
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+)
+
+// Graph populates the workflow and renders its step DAG as a Graphviz DOT or
+// Mermaid flowchart, see GraphDOT and GraphMermaid. Edges point from a
+// dependency to its dependent. The steps of IncludeWorkflow, SubWorkflow and
+// ForEach steps are drawn as clusters.
+// The OnFailure and Finally steps follow the DAG. If resources is set, the
+// workflow is also validated, which needs API access, and dashed edges link
+// the creator, users and deleter of each resource. A workflow without a
+// GCSPath is graphed with the name of the default bucket, which isn't created.
+func (w *Workflow) Graph(ctx context.Context, format string, resources bool) (string, error) {
+	if format != GraphDOT && format != GraphMermaid {
+		return "", errf("unknown graph format %q, want %s or %s", format, GraphDOT, GraphMermaid)
+	}
+	w.noBucketCreation = true
+	if resources {
+		if err := w.Validate(ctx); err != nil {
+			return "", err
+		}
+	} else {
+		if err := w.PopulateClients(ctx); err != nil {
+			return "", errf("error populating workflow: %v", err)
+		}
+		if err := w.populate(ctx); err != nil {
+			return "", errf("error populating workflow: %v", err)
+		}
+	}
+	return w.graph(format, resources), nil
+}
+
+type graphEdge struct {
+	from, to *Step
+	label    string
+}
+
+type graphBuilder struct {
+	format    string
+	b         bytes.Buffer
+	ids       map[*Step]string
+	workflows []*Workflow
+	edges     []graphEdge
+}
+
+func (w *Workflow) graph(format string, resources bool) string {
+	g := &graphBuilder{format: format, ids: map[*Step]string{}}
+	if format == GraphDOT {
+		fmt.Fprintf(&g.b, "digraph %s {\n  compound=true;\n  node [shape=box];\n", dotQuote(w.Name))
+	} else {
+		fmt.Fprintln(&g.b, "flowchart TD")
+	}
+	g.addWorkflow(w, 1)
+	for _, e := range g.edges {
+		g.writeEdge(e, "")
+	}
+	if resources {
+		for _, e := range g.resourceEdges() {
+			g.writeEdge(e, e.label)
+		}
+	}
+	if format == GraphDOT {
+		fmt.Fprintln(&g.b, "}")
+	}
+	return g.b.String()
+}
+
+func (g *graphBuilder) id(s *Step) string {
+	id, ok := g.ids[s]
+	if !ok {
+		id = fmt.Sprintf("n%d", len(g.ids))
+		g.ids[s] = id
+	}
+	return id
+}
+
+// addWorkflow writes the steps of w, followed by its OnFailure and Finally
+// steps, and records its dependencies.
+func (g *graphBuilder) addWorkflow(w *Workflow, depth int) {
+	g.workflows = append(g.workflows, w)
+	steps, final := sortedSteps(w), w.finalSteps()
+	g.addSteps(steps, depth)
+	g.addSteps(final, depth)
+
+	var dependents []string
+	for name := range w.Dependencies {
+		dependents = append(dependents, name)
+	}
+	sort.Strings(dependents)
+	for _, name := range dependents {
+		deps := append([]string{}, w.Dependencies[name]...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			from, to := w.Steps[dep], w.Steps[name]
+			if from == nil || to == nil {
+				continue
+			}
+			g.edges = append(g.edges, graphEdge{from: from, to: to})
+		}
+	}
+
+	// The OnFailure and Finally steps run one after the other once the steps
+	// no other step depends on are done.
+	if len(final) == 0 {
+		return
+	}
+	hasDependents := map[string]bool{}
+	for _, deps := range w.Dependencies {
+		for _, dep := range deps {
+			hasDependents[dep] = true
+		}
+	}
+	for _, s := range steps {
+		if !hasDependents[s.name] {
+			g.edges = append(g.edges, graphEdge{from: s, to: final[0]})
+		}
+	}
+	for i := 1; i < len(final); i++ {
+		g.edges = append(g.edges, graphEdge{from: final[i-1], to: final[i]})
+	}
+}
+
+// addSteps writes a node for each step, or a cluster for steps that run
+// other steps.
+func (g *graphBuilder) addSteps(steps []*Step, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, s := range steps {
+		id := g.id(s)
+		label := graphLabel(s)
+		nw, nested := nestedSteps(s)
+		if len(nested) == 0 {
+			if g.format == GraphDOT {
+				fmt.Fprintf(&g.b, "%s%s [label=%s];\n", indent, id, dotQuote(label))
+			} else {
+				fmt.Fprintf(&g.b, "%s%s[%s]\n", indent, id, mermaidQuote(label))
+			}
+			continue
+		}
+
+		if g.format == GraphDOT {
+			fmt.Fprintf(&g.b, "%ssubgraph cluster_%s {\n%s  label=%s;\n", indent, id, indent, dotQuote(label))
+		} else {
+			fmt.Fprintf(&g.b, "%ssubgraph %s [%s]\n", indent, id, mermaidQuote(label))
+		}
+		if nw != nil {
+			g.addWorkflow(nw, depth+1)
+		} else {
+			g.addSteps(nested, depth+1)
+		}
+		if g.format == GraphDOT {
+			fmt.Fprintf(&g.b, "%s}\n", indent)
+		} else {
+			fmt.Fprintf(&g.b, "%send\n", indent)
+		}
+	}
+}
+
+func (g *graphBuilder) writeEdge(e graphEdge, label string) {
+	if g.format == GraphMermaid {
+		if label == "" {
+			fmt.Fprintf(&g.b, "  %s --> %s\n", g.id(e.from), g.id(e.to))
+		} else {
+			fmt.Fprintf(&g.b, "  %s -.->|%s| %s\n", g.id(e.from), mermaidQuote(label), g.id(e.to))
+		}
+		return
+	}
+
+	// DOT edges connect nodes, edges of clusters connect their first node
+	// and are clipped at the cluster border.
+	var attrs []string
+	from, to := graphAnchor(e.from), graphAnchor(e.to)
+	if from != e.from {
+		attrs = append(attrs, "ltail=cluster_"+g.id(e.from))
+	}
+	if to != e.to {
+		attrs = append(attrs, "lhead=cluster_"+g.id(e.to))
+	}
+	if label != "" {
+		attrs = append(attrs, "style=dashed", "label="+dotQuote(label))
+	}
+	fmt.Fprintf(&g.b, "  %s -> %s", g.id(from), g.id(to))
+	if len(attrs) > 0 {
+		fmt.Fprintf(&g.b, " [%s]", strings.Join(attrs, ", "))
+	}
+	fmt.Fprintln(&g.b, ";")
+}
+
+// resourceEdges returns creator -> user -> deleter edges for the resources
+// of all registries of the graphed workflows.
+func (g *graphBuilder) resourceEdges() []graphEdge {
+	var edges []graphEdge
+	seenRegs := map[*baseResourceRegistry]bool{}
+	seenEdges := map[graphEdge]bool{}
+	add := func(from, to *Step, label string) {
+		e := graphEdge{from: from, to: to, label: label}
+		if from == nil || to == nil || from == to || seenEdges[e] {
+			return
+		}
+		seenEdges[e] = true
+		edges = append(edges, e)
+	}
+	for _, w := range g.workflows {
+		for _, r := range w.resourceRegistries() {
+			if seenRegs[r] {
+				continue
+			}
+			seenRegs[r] = true
+
+			var names []string
+			for name := range r.m {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				res := r.m[name]
+				label := fmt.Sprintf("%s %s", r.typeName, name)
+				for _, u := range res.users {
+					add(res.creator, u, label)
+					add(u, res.deleter, label)
+				}
+				if len(res.users) == 0 {
+					add(res.creator, res.deleter, label)
+				}
+			}
+		}
+	}
+	return edges
+}
+
+// nestedSteps returns the steps s runs, and the workflow they belong to for
+// IncludeWorkflow and SubWorkflow steps.
+func nestedSteps(s *Step) (*Workflow, []*Step) {
+	var nw *Workflow
+	switch {
+	case s.ForEach != nil:
+		return nil, s.ForEach.steps
+	case s.IncludeWorkflow != nil:
+		nw = s.IncludeWorkflow.Workflow
+	case s.SubWorkflow != nil:
+		nw = s.SubWorkflow.Workflow
+	}
+	if nw == nil {
+		return nil, nil
+	}
+	return nw, sortedSteps(nw)
+}
+
+// graphAnchor returns the first node drawn for s, which is s itself unless
+// s is drawn as a cluster.
+func graphAnchor(s *Step) *Step {
+	for {
+		_, nested := nestedSteps(s)
+		if len(nested) == 0 {
+			return s
+		}
+		s = nested[0]
+	}
+}
+
+func graphLabel(s *Step) string {
+	t := s.typeName()
+	if s.skipped {
+		t += ", skipped"
+	}
+	return fmt.Sprintf("%s\n(%s)", s.name, t)
+}
+
+func sortedSteps(w *Workflow) []*Step {
+	var names []string
+	for name := range w.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var steps []*Step
+	for _, name := range names {
+		steps = append(steps, w.Steps[name])
+	}
+	return steps
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "\n", "<br/>").Replace(s) + `"`
+}
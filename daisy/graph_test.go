@@ -0,0 +1,182 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func graphTestWorkflow() *Workflow {
+	w := testWorkflow()
+	a, _ := w.NewStep("a")
+	a.CreateDisks = &CreateDisks{}
+	b, _ := w.NewStep("b")
+	b.CreateInstances = &CreateInstances{}
+	b.skipped = true
+	inc, _ := w.NewStep("inc")
+	iw := w.NewIncludedWorkflow()
+	inc.IncludeWorkflow = &IncludeWorkflow{Workflow: iw}
+	x, _ := iw.NewStep("x")
+	x.CreateImages = &CreateImages{}
+	y, _ := iw.NewStep("y")
+	y.DeleteResources = &DeleteResources{}
+	iw.Dependencies = map[string][]string{"y": {"x"}}
+	w.Dependencies = map[string][]string{"inc": {"a"}, "b": {"inc"}}
+
+	w.disks.m = map[string]*Resource{"d": {creator: a, users: []*Step{x}, deleter: y}}
+	return w
+}
+
+func TestGraphDOT(t *testing.T) {
+	got := graphTestWorkflow().graph(GraphDOT, true)
+	want := `digraph "test-wf" {
+  compound=true;
+  node [shape=box];
+  n0 [label="a\n(CreateDisks)"];
+  n1 [label="b\n(CreateInstances, skipped)"];
+  subgraph cluster_n2 {
+    label="inc\n(IncludeWorkflow)";
+    n3 [label="x\n(CreateImages)"];
+    n4 [label="y\n(DeleteResources)"];
+  }
+  n3 -> n4;
+  n3 -> n1 [ltail=cluster_n2];
+  n0 -> n3 [lhead=cluster_n2];
+  n0 -> n3 [style=dashed, label="disk d"];
+  n3 -> n4 [style=dashed, label="disk d"];
+}
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGraphMermaid(t *testing.T) {
+	got := graphTestWorkflow().graph(GraphMermaid, false)
+	want := `flowchart TD
+  n0["a<br/>(CreateDisks)"]
+  n1["b<br/>(CreateInstances, skipped)"]
+  subgraph n2 ["inc<br/>(IncludeWorkflow)"]
+    n3["x<br/>(CreateImages)"]
+    n4["y<br/>(DeleteResources)"]
+  end
+  n3 --> n4
+  n2 --> n1
+  n0 --> n2
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = graphTestWorkflow().graph(GraphMermaid, true)
+	want += "  n0 -.->|\"disk d\"| n3\n  n3 -.->|\"disk d\"| n4\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGraphFinalSteps(t *testing.T) {
+	w := graphTestWorkflow()
+	w.OnFailure = []*Step{{DeleteResources: &DeleteResources{}}}
+	w.Finally = []*Step{{CreateImages: &CreateImages{}}}
+	w.nameFinalSteps()
+
+	got := w.graph(GraphMermaid, false)
+	want := `flowchart TD
+  n0["a<br/>(CreateDisks)"]
+  n1["b<br/>(CreateInstances, skipped)"]
+  subgraph n2 ["inc<br/>(IncludeWorkflow)"]
+    n3["x<br/>(CreateImages)"]
+    n4["y<br/>(DeleteResources)"]
+  end
+  n5["on-failure-1<br/>(DeleteResources)"]
+  n6["finally-1<br/>(CreateImages)"]
+  n3 --> n4
+  n2 --> n1
+  n0 --> n2
+  n1 --> n5
+  n5 --> n6
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGraphNoBucketCreation(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.GCSPath = ""
+	var reqs []string
+	sc, err := storage.NewClient(ctx, option.WithHTTPClient(&http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		reqs = append(reqs, r.Method+" "+r.URL.Path)
+		return nil, errors.New("unexpected GCS request")
+	})}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.StorageClient = sc
+	s, _ := w.NewStep("s")
+	s.testType = &mockStep{}
+
+	if _, err := w.Graph(ctx, GraphMermaid, false); err != nil {
+		t.Fatalf("error graphing workflow: %v", err)
+	}
+	if want := "gs://" + testProject + "-daisy-bkt"; w.GCSPath != want {
+		t.Errorf("got GCSPath %q, want %q", w.GCSPath, want)
+	}
+	if len(reqs) != 0 {
+		t.Errorf("graph sent GCS requests: %q", reqs)
+	}
+}
+
+func TestGraphForEach(t *testing.T) {
+	w := testWorkflow()
+	fe, _ := w.NewStep("fe")
+	var items []*Step
+	for _, name := range []string{"fe-0", "fe-1"} {
+		sw := w.NewSubWorkflow()
+		st, _ := sw.NewStep("s")
+		st.StopInstances = &StopInstances{}
+		items = append(items, &Step{name: name, w: w, forEach: fe, SubWorkflow: &SubWorkflow{Workflow: sw}})
+	}
+	fe.ForEach = &ForEach{steps: items}
+
+	got := w.graph(GraphMermaid, false)
+	want := `flowchart TD
+  subgraph n0 ["fe<br/>(ForEach)"]
+    subgraph n1 ["fe-0<br/>(SubWorkflow)"]
+      n2["s<br/>(StopInstances)"]
+    end
+    subgraph n3 ["fe-1<br/>(SubWorkflow)"]
+      n4["s<br/>(StopInstances)"]
+    end
+  end
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGraphUnknownFormat(t *testing.T) {
+	if _, err := testWorkflow().Graph(context.Background(), "png", false); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
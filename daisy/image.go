@@ -140,6 +140,7 @@ func (i *Image) populate(ctx context.Context, s *Step) dErr {
 	i.Name, errs = i.Resource.populateWithGlobal(ctx, s, i.Name)
 
 	i.Description = strOr(i.Description, fmt.Sprintf("Image created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+	i.Labels = s.w.addWorkflowLabels(&i.Resource, i.Labels)
 
 	if diskURLRgx.MatchString(i.SourceDisk) {
 		i.SourceDisk = extendPartialURL(i.SourceDisk, i.Project)
@@ -198,10 +199,13 @@ func (i *Image) validate(ctx context.Context, s *Step) dErr {
 	if i.RawDisk != nil {
 		sBkt, sObj, err := splitGCSPath(i.RawDisk.Source)
 		errs = addErrs(errs, err)
+		if err == nil {
+			errs = addErrs(errs, s.w.checkStorePath(i.RawDisk.Source))
+		}
 
 		// Check if this image object is created by this workflow, otherwise check if object exists.
 		if !strIn(path.Join(sBkt, sObj), s.w.objects.created) {
-			if _, err := s.w.StorageClient.Bucket(sBkt).Object(sObj).Attrs(ctx); err != nil {
+			if _, err := s.w.store().Attrs(ctx, sBkt, sObj); err != nil {
 				errs = addErrs(errs, errf("error reading object %s/%s: %v", sBkt, sObj, err))
 			}
 		}
@@ -233,9 +237,9 @@ func newImageRegistry(w *Workflow) *imageRegistry {
 	return ir
 }
 
-func (ir *imageRegistry) deleteFn(res *Resource) dErr {
+func (ir *imageRegistry) deleteFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(imageURLRgx, res.link)
-	err := ir.w.ComputeClient.DeleteImage(m["project"], m["image"])
+	err := ir.w.ComputeClient.WithContext(ctx).DeleteImage(m["project"], m["image"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
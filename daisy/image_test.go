@@ -126,6 +126,7 @@ func TestImagePopulate(t *testing.T) {
 		if tt.want != nil {
 			tt.want.Name = tt.input.RealName
 			tt.want.Description = tt.input.Description
+			tt.want.Labels = map[string]string{"daisy-workflow-id": "abcdef", "daisy-workflow-name": "test-wf"}
 		}
 		tt.input.Resource = Resource{} // These fields are tested in resource_test.
 
@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -104,7 +105,14 @@ func (i *Instance) populate(ctx context.Context, s *Step) dErr {
 	i.Name, i.Zone, errs = i.Resource.populateWithZone(ctx, s, i.Name, i.Zone)
 	i.Description = strOr(i.Description, fmt.Sprintf("Instance created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
 
+	i.Labels = s.w.addWorkflowLabels(&i.Resource, i.Labels)
+
 	errs = addErrs(errs, i.populateDisks(s.w))
+	for _, d := range i.Disks {
+		if d.InitializeParams != nil {
+			d.InitializeParams.Labels = s.w.addWorkflowLabels(&i.Resource, d.InitializeParams.Labels)
+		}
+	}
 	errs = addErrs(errs, i.populateMachineType())
 	errs = addErrs(errs, i.populateMetadata(s.w))
 	errs = addErrs(errs, i.populateNetworks())
@@ -177,17 +185,29 @@ func (i *Instance) populateMetadata(w *Workflow) dErr {
 	if i.Instance.Metadata == nil {
 		i.Instance.Metadata = &compute.Metadata{}
 	}
-	i.Metadata["daisy-sources-path"] = "gs://" + path.Join(w.bucket, w.sourcesPath)
-	i.Metadata["daisy-logs-path"] = "gs://" + path.Join(w.bucket, w.logsPath)
-	i.Metadata["daisy-outs-path"] = "gs://" + path.Join(w.bucket, w.outsPath)
+	i.Metadata["daisy-sources-path"] = w.store().URL(w.bucket, w.sourcesPath)
+	i.Metadata["daisy-logs-path"] = w.store().URL(w.bucket, w.logsPath)
+	i.Metadata["daisy-outs-path"] = w.store().URL(w.bucket, w.outsPath)
 	if i.StartupScript != "" {
 		if !w.sourceExists(i.StartupScript) {
 			return errf("bad value for StartupScript, source not found: %s", i.StartupScript)
 		}
-		i.StartupScript = "gs://" + path.Join(w.bucket, w.sourcesPath, i.StartupScript)
+		i.StartupScript = w.store().URL(w.bucket, path.Join(w.sourcesPath, i.StartupScript))
 		i.Metadata["startup-script-url"] = i.StartupScript
 		i.Metadata["windows-startup-script-url"] = i.StartupScript
 	}
+	// The keys of the metadata carrying secret Var values are listed in
+	// daisy-secret-keys, so that guest scripts know not to echo them.
+	var secretKeys []string
+	for k, v := range i.Metadata {
+		if w.Redact(v) != v {
+			secretKeys = append(secretKeys, k)
+		}
+	}
+	if len(secretKeys) > 0 {
+		sort.Strings(secretKeys)
+		i.Metadata["daisy-secret-keys"] = strings.Join(secretKeys, ",")
+	}
 	for k, v := range i.Metadata {
 		vCopy := v
 		i.Instance.Metadata.Items = append(i.Instance.Metadata.Items, &compute.MetadataItems{Key: k, Value: &vCopy})
@@ -376,10 +396,10 @@ func newInstanceRegistry(w *Workflow) *instanceRegistry {
 // SleepFn function is mocked on testing.
 var SleepFn = time.Sleep
 
-func (ir *instanceRegistry) deleteFn(res *Resource) dErr {
+func (ir *instanceRegistry) deleteFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(instanceURLRgx, res.link)
 	for i := 1; i < 4; i++ {
-		if _, err := ir.w.ComputeClient.GetInstance(m["project"], m["zone"], m["instance"]); err != nil {
+		if _, err := ir.w.ComputeClient.WithContext(ctx).GetInstance(m["project"], m["zone"], m["instance"]); err != nil {
 			// Can't remove an instance that was not even yet created!
 			// However as the command was already submitted, wait.
 			SleepFn((time.Duration(rand.Intn(1000))*time.Millisecond + 1*time.Second) * time.Duration(i))
@@ -387,25 +407,25 @@ func (ir *instanceRegistry) deleteFn(res *Resource) dErr {
 		}
 	}
 	// Proceed to instance deletion
-	err := ir.w.ComputeClient.DeleteInstance(m["project"], m["zone"], m["instance"])
+	err := ir.w.ComputeClient.WithContext(ctx).DeleteInstance(m["project"], m["zone"], m["instance"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
 	return newErr(err)
 }
 
-func (ir *instanceRegistry) startFn(res *Resource) dErr {
+func (ir *instanceRegistry) startFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(instanceURLRgx, res.link)
-	err := ir.w.ComputeClient.StartInstance(m["project"], m["zone"], m["instance"])
+	err := ir.w.ComputeClient.WithContext(ctx).StartInstance(m["project"], m["zone"], m["instance"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
 	return newErr(err)
 }
 
-func (ir *instanceRegistry) stopFn(res *Resource) dErr {
+func (ir *instanceRegistry) stopFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(instanceURLRgx, res.link)
-	err := ir.w.ComputeClient.StopInstance(m["project"], m["zone"], m["instance"])
+	err := ir.w.ComputeClient.WithContext(ctx).StopInstance(m["project"], m["zone"], m["instance"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
@@ -203,8 +203,10 @@ func TestInstancePopulateMetadata(t *testing.T) {
 		{"defaults case", nil, "", getWantMd(map[string]string{}), false},
 		{"startup script case", nil, "file", getWantMd(map[string]string{"startup-script-url": filePath, "windows-startup-script-url": filePath}), false},
 		{"bad startup script case", nil, "foo", nil, true},
+		{"secret case", map[string]string{"key": "s3cr3t", "script": "register s3cr3t", "other": "foo"}, "", getWantMd(map[string]string{"key": "s3cr3t", "script": "register s3cr3t", "other": "foo", "daisy-secret-keys": "key,script"}), false},
 	}
 
+	w.addSecret(Var{Value: "s3cr3t", Secret: true})
 	for _, tt := range tests {
 		i := Instance{Metadata: tt.md, StartupScript: tt.startupScript}
 		err := i.populateMetadata(w)
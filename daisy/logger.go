@@ -18,7 +18,10 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"path"
 	"strings"
 	"sync"
@@ -40,26 +43,101 @@ type cloudLogWriter interface {
 	Flush() error
 }
 
+// Severity is the severity of a log entry. The zero value is SeverityInfo.
+type Severity int
+
+// Log entry severities, in increasing order.
+const (
+	SeverityDebug Severity = iota - 1
+	SeverityInfo
+	SeverityWarn
+	SeverityError
+)
+
+var severityNames = map[Severity]string{
+	SeverityDebug: "DEBUG",
+	SeverityInfo:  "INFO",
+	SeverityWarn:  "WARN",
+	SeverityError: "ERROR",
+}
+
+func (s Severity) String() string {
+	if n, ok := severityNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("Severity(%d)", s)
+}
+
+// ParseSeverity parses the name of a severity, e.g. "info", in any case.
+func ParseSeverity(name string) (Severity, error) {
+	for s, n := range severityNames {
+		if strings.EqualFold(name, n) {
+			return s, nil
+		}
+	}
+	if strings.EqualFold(name, "WARNING") {
+		return SeverityWarn, nil
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity %q, not one of DEBUG, INFO, WARN or ERROR", name)
+}
+
+// MarshalText marshals a severity as its name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText unmarshals the name of a severity.
+func (s *Severity) UnmarshalText(b []byte) error {
+	var err error
+	*s, err = ParseSeverity(string(b))
+	return err
+}
+
+func (s Severity) cloudSeverity() logging.Severity {
+	switch s {
+	case SeverityDebug:
+		return logging.Debug
+	case SeverityWarn:
+		return logging.Warning
+	case SeverityError:
+		return logging.Error
+	}
+	return logging.Info
+}
+
+// Log formats of the entries logged to stdout and to log writers.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
 // daisyLog wraps the different logging mechanisms that can be used.
 type daisyLog struct {
-	gcsLogWriter  *syncedWriter
-	cloudLogger   cloudLogWriter
-	stdoutLogging bool
+	gcsLogWriter *syncedWriter
+	cloudLogger  cloudLogWriter
+	// Loggers writing to stdout and to the log writers of the workflow.
+	local []Logger
 }
 
 // createLogger builds a Logger.
 func (w *Workflow) createLogger(ctx context.Context) {
-	l := &daisyLog{
-		stdoutLogging: !w.stdoutLoggingDisabled,
+	l := &daisyLog{}
+	w.logStart = time.Now()
+	if !w.stdoutLoggingDisabled {
+		l.local = append(l.local, newLocalLogger(os.Stdout, w.logFormat, w.logLevel))
+	}
+	for _, lw := range w.logWriters {
+		l.local = append(l.local, newLocalLogger(lw, w.logFormat, w.logLevel))
 	}
 
-	w.addCleanupHook(func() dErr {
+	w.addCleanupHook(func(ctx context.Context) dErr {
 		w.logWait.Wait()
 		return nil
 	})
 
 	if !w.gcsLoggingDisabled {
-		gcsLogger := NewGCSLogger(ctx, w.StorageClient, w.bucket, path.Join(w.logsPath, "daisy.log"))
+		// The log is flushed during cleanup, after the run context is done.
+		gcsLogger := &GCSLogger{storage: w.store(), bucket: w.bucket, object: path.Join(w.logsPath, "daisy.log"), ctx: context.Background()}
 		l.gcsLogWriter = &syncedWriter{buf: bufio.NewWriter(gcsLogger)}
 		periodicFlush(func() { l.gcsLogWriter.Flush() })
 	}
@@ -69,7 +147,9 @@ func (w *Workflow) createLogger(ctx context.Context) {
 		if err := w.cloudLoggingClient.Ping(ctx); err != nil {
 			l.WriteLogEntry(&LogEntry{
 				LocalTimestamp: time.Now(),
+				Severity:       SeverityWarn,
 				WorkflowName:   getAbsoluteName(w),
+				WorkflowID:     w.id,
 				Message:        fmt.Sprintf("Unable to send logs to the Cloud Logging service, not sending logs: %v", err),
 			})
 			w.cloudLoggingClient = nil
@@ -82,7 +162,7 @@ func (w *Workflow) createLogger(ctx context.Context) {
 
 	w.Logger = l
 
-	w.addCleanupHook(func() dErr {
+	w.addCleanupHook(func(ctx context.Context) dErr {
 		w.Logger.Flush()
 		return nil
 	})
@@ -90,25 +170,45 @@ func (w *Workflow) createLogger(ctx context.Context) {
 
 // LogStepInfo logs information for the workflow step.
 func (w *Workflow) LogStepInfo(stepName, stepType, format string, a ...interface{}) {
-	entry := &LogEntry{
-		LocalTimestamp: time.Now(),
-		WorkflowName:   getAbsoluteName(w),
-		StepName:       stepName,
-		StepType:       stepType,
-		Message:        fmt.Sprintf(format, a...),
-		Type:           "Daisy",
-	}
-	w.Logger.WriteLogEntry(entry)
+	w.logStep(SeverityInfo, stepName, stepType, format, a...)
 }
 
 // LogWorkflowInfo logs information for the workflow.
 func (w *Workflow) LogWorkflowInfo(format string, a ...interface{}) {
-	entry := &LogEntry{
+	w.logWorkflow(SeverityInfo, format, a...)
+}
+
+// logStep logs a message of severity sev for the workflow step.
+func (w *Workflow) logStep(sev Severity, stepName, stepType, format string, a ...interface{}) {
+	entry := w.logEntry(sev, format, a...)
+	entry.StepName = stepName
+	entry.StepType = stepType
+	entry.Type = "Daisy"
+	if o := w.ownerName(); o != "" {
+		entry.StepPath = o + "." + stepName
+	} else {
+		entry.StepPath = stepName
+	}
+	w.Logger.WriteLogEntry(entry)
+}
+
+// logWorkflow logs a message of severity sev for the workflow.
+func (w *Workflow) logWorkflow(sev Severity, format string, a ...interface{}) {
+	w.Logger.WriteLogEntry(w.logEntry(sev, format, a...))
+}
+
+func (w *Workflow) logEntry(sev Severity, format string, a ...interface{}) *LogEntry {
+	e := &LogEntry{
 		LocalTimestamp: time.Now(),
+		Severity:       sev,
 		WorkflowName:   getAbsoluteName(w),
-		Message:        fmt.Sprintf(format, a...),
+		WorkflowID:     w.id,
+		Message:        w.Redact(fmt.Sprintf(format, a...)),
 	}
-	w.Logger.WriteLogEntry(entry)
+	if start := w.root().logStart; !start.IsZero() {
+		e.ElapsedSeconds = e.LocalTimestamp.Sub(start).Seconds()
+	}
+	return e
 }
 
 // WriteSerialPortLogs writes serial port logs to cloud logging.
@@ -121,11 +221,12 @@ func (l *daisyLog) WriteSerialPortLogs(w *Workflow, instance string, buf bytes.B
 		entry := &LogEntry{
 			LocalTimestamp: time.Now(),
 			WorkflowName:   getAbsoluteName(w),
+			WorkflowID:     w.id,
 			Message:        fmt.Sprintf("Serial port output for instance %q", instance),
 			SerialPort1:    str,
 			Type:           "Daisy",
 		}
-		l.cloudLogger.Log(logging.Entry{Timestamp: entry.LocalTimestamp, Payload: entry})
+		l.cloudLogger.Log(logging.Entry{Timestamp: entry.LocalTimestamp, Severity: entry.Severity.cloudSeverity(), Payload: entry})
 	}
 
 	// Write the output to cloud logging only after instance has stopped.
@@ -153,33 +254,105 @@ func (l *daisyLog) Flush() {
 	if l.cloudLogger != nil {
 		l.cloudLogger.Flush()
 	}
+
+	for _, ll := range l.local {
+		ll.Flush()
+	}
 }
 
 // LogEntry encapsulates a single log entry.
 type LogEntry struct {
 	LocalTimestamp time.Time `json:"localTimestamp"`
+	Severity       Severity  `json:"severity"`
 	WorkflowName   string    `json:"workflow"`
+	WorkflowID     string    `json:"workflowId,omitempty"`
 	StepName       string    `json:"stepName,omitempty"`
-	StepType       string    `json:"stepType,omitempty"`
-	SerialPort1    string    `json:"serialPort1,omitempty"`
-	Message        string    `json:"message"`
-	Type           string    `json:"type"`
+	// The step name qualified by the names of the IncludeWorkflow, SubWorkflow
+	// and ForEach steps that lead to it, e.g. "include.sub.step".
+	StepPath    string `json:"stepPath,omitempty"`
+	StepType    string `json:"stepType,omitempty"`
+	SerialPort1 string `json:"serialPort1,omitempty"`
+	Message     string `json:"message"`
+	Type        string `json:"type"`
+	// Time since the workflow started.
+	ElapsedSeconds float64 `json:"elapsedSeconds,omitempty"`
 }
 
 func (l *daisyLog) WriteLogEntry(e *LogEntry) {
 	if l.cloudLogger != nil {
-		l.cloudLogger.Log(logging.Entry{Timestamp: e.LocalTimestamp, Payload: e})
+		l.cloudLogger.Log(logging.Entry{Timestamp: e.LocalTimestamp, Severity: e.Severity.cloudSeverity(), Payload: e})
 	}
 
 	if l.gcsLogWriter != nil {
 		l.gcsLogWriter.Write([]byte(e.String()))
 	}
 
-	if l.stdoutLogging {
-		fmt.Print(e)
+	for _, ll := range l.local {
+		ll.WriteLogEntry(e)
+	}
+}
+
+func newLocalLogger(w io.Writer, format string, level Severity) Logger {
+	if format == LogFormatJSON {
+		return NewJSONLogger(w, level)
+	}
+	return &textLogger{w: w, level: level}
+}
+
+// textLogger writes the entries at or above a severity as text lines.
+type textLogger struct {
+	w     io.Writer
+	level Severity
+	mx    sync.Mutex
+}
+
+func (l *textLogger) WriteLogEntry(e *LogEntry) {
+	if e.Severity < l.level {
+		return
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	io.WriteString(l.w, e.String())
+}
+
+func (l *textLogger) WriteSerialPortLogs(w *Workflow, instance string, buf bytes.Buffer) {}
+
+func (l *textLogger) Flush() {}
+
+// JSONLogger is a Logger that writes the entries at or above a severity as
+// JSON lines, e.g. to a local file. Serial port logs are not written.
+type JSONLogger struct {
+	w     io.Writer
+	level Severity
+	mx    sync.Mutex
+}
+
+// NewJSONLogger creates a JSONLogger writing the entries at or above level
+// to w.
+func NewJSONLogger(w io.Writer, level Severity) *JSONLogger {
+	return &JSONLogger{w: w, level: level}
+}
+
+// WriteLogEntry writes e as a JSON line.
+func (l *JSONLogger) WriteLogEntry(e *LogEntry) {
+	if e.Severity < l.level {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
 	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.w.Write(append(b, '\n'))
 }
 
+// WriteSerialPortLogs does nothing, serial port logs are written to GCS.
+func (l *JSONLogger) WriteSerialPortLogs(w *Workflow, instance string, buf bytes.Buffer) {}
+
+// Flush does nothing, entries are written as they are logged.
+func (l *JSONLogger) Flush() {}
+
 type syncedWriter struct {
 	buf *bufio.Writer
 	mx  sync.Mutex
@@ -199,7 +372,7 @@ func (l *syncedWriter) Flush() error {
 
 // GCSLogger is a logger that writes to a GCS object.
 type GCSLogger struct {
-	client         *storage.Client
+	storage        Storage
 	bucket, object string
 	buf            *bytes.Buffer
 	ctx            context.Context
@@ -207,7 +380,7 @@ type GCSLogger struct {
 
 // NewGCSLogger creates a new GCSLogger.
 func NewGCSLogger(ctx context.Context, client *storage.Client, bucket, object string) *GCSLogger {
-	return &GCSLogger{client: client, bucket: bucket, object: object, ctx: ctx}
+	return &GCSLogger{storage: &gcsStorage{client: client}, bucket: bucket, object: object, ctx: ctx}
 }
 
 func (l *GCSLogger) Write(b []byte) (int, error) {
@@ -215,8 +388,7 @@ func (l *GCSLogger) Write(b []byte) (int, error) {
 		l.buf = new(bytes.Buffer)
 	}
 	l.buf.Write(b)
-	wc := l.client.Bucket(l.bucket).Object(l.object).NewWriter(l.ctx)
-	wc.ContentType = "text/plain"
+	wc := l.storage.NewWriter(l.ctx, l.bucket, l.object, "text/plain")
 	if _, err := wc.Write(l.buf.Bytes()); err != nil {
 		return 0, err
 	}
@@ -256,6 +428,9 @@ func (e *LogEntry) String() string {
 	} else {
 		msg = e.Message
 	}
+	if e.Severity != SeverityInfo {
+		msg = fmt.Sprintf("%s: %s", e.Severity, msg)
+	}
 
 	timestamp := e.LocalTimestamp.Format(time.RFC3339)
 	return fmt.Sprintf("[%s]: %s %s\n", prefix, timestamp, msg)
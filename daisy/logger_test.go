@@ -17,9 +17,13 @@ package daisy
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"regexp"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/logging"
 )
@@ -133,3 +137,146 @@ func TestSendSerialPortLogsToCloudDisabled(t *testing.T) {
 
 	// Nothing to verify. Nothing happened.
 }
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		name string
+		want Severity
+	}{
+		{"debug", SeverityDebug},
+		{"INFO", SeverityInfo},
+		{"Warn", SeverityWarn},
+		{"warning", SeverityWarn},
+		{"ERROR", SeverityError},
+	}
+	for _, tt := range tests {
+		got, err := ParseSeverity(tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSeverity(%q) = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := ParseSeverity("critical"); err == nil {
+		t.Error("expected error parsing unknown severity")
+	}
+	var zero Severity
+	if zero != SeverityInfo || SeverityDebug >= SeverityInfo || SeverityWarn >= SeverityError {
+		t.Error("severities are not ordered with INFO as zero value")
+	}
+	if got := SeverityWarn.cloudSeverity(); got != logging.Warning {
+		t.Errorf("got cloud severity %v for WARN", got)
+	}
+}
+
+func TestLogEntryFields(t *testing.T) {
+	w := testWorkflow()
+	w.logStart = time.Now().Add(-time.Minute)
+	l := &MockLogger{}
+	w.Logger = l
+
+	w.logStep(SeverityWarn, "s", "StepType", "test %s", "a")
+	w.LogWorkflowInfo("test")
+	entries := l.getEntries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	e := entries[0]
+	if e.Severity != SeverityWarn || e.WorkflowID != w.id || e.StepName != "s" || e.StepPath != "s" || e.StepType != "StepType" || e.Message != "test a" {
+		t.Errorf("unexpected step entry: %+v", e)
+	}
+	if e.ElapsedSeconds < 60 {
+		t.Errorf("got elapsed time %gs, want at least 60s", e.ElapsedSeconds)
+	}
+	if e := entries[1]; e.Severity != SeverityInfo || e.StepPath != "" {
+		t.Errorf("unexpected workflow entry: %+v", e)
+	}
+
+	// Steps of included workflows are qualified by the include step.
+	iw := New()
+	iw.Name = "included"
+	iw.parent = w
+	iw.Logger = l
+	inc, _ := w.NewStep("inc")
+	inc.IncludeWorkflow = &IncludeWorkflow{Workflow: iw}
+	iw.LogStepInfo("s", "StepType", "test")
+	entries = l.getEntries()
+	if e := entries[len(entries)-1]; e.StepPath != "inc.s" || e.WorkflowName != testWf+".included" {
+		t.Errorf("unexpected included workflow entry: %+v", e)
+	}
+}
+
+func TestLogEntryString(t *testing.T) {
+	e := &LogEntry{LocalTimestamp: time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), WorkflowName: "wf", StepName: "s", StepType: "CreateDisks", Message: "msg"}
+	if got, want := e.String(), "[wf.s]: 2018-01-02T03:04:05Z CreateDisks: msg\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	e.Severity = SeverityError
+	if got, want := e.String(), "[wf.s]: 2018-01-02T03:04:05Z ERROR: CreateDisks: msg\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := NewJSONLogger(&b, SeverityInfo)
+	ts := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.WriteLogEntry(&LogEntry{LocalTimestamp: ts, Severity: SeverityDebug, WorkflowName: "wf", Message: "debug"})
+	l.WriteLogEntry(&LogEntry{LocalTimestamp: ts, WorkflowName: "wf", WorkflowID: "abcdef", Message: "info"})
+	l.WriteLogEntry(&LogEntry{LocalTimestamp: ts, Severity: SeverityError, WorkflowName: "wf", StepName: "s", StepPath: "inc.s", StepType: "CreateDisks", Message: "error", ElapsedSeconds: 1.5})
+
+	want := `{"localTimestamp":"2018-01-02T03:04:05Z","severity":"INFO","workflow":"wf","workflowId":"abcdef","message":"info","type":""}
+{"localTimestamp":"2018-01-02T03:04:05Z","severity":"ERROR","workflow":"wf","stepName":"s","stepPath":"inc.s","stepType":"CreateDisks","message":"error","type":"","elapsedSeconds":1.5}
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	var e LogEntry
+	if err := json.Unmarshal([]byte(strings.SplitN(want, "\n", 2)[1]), &e); err != nil || e.Severity != SeverityError {
+		t.Errorf("unmarshalled %+v, error: %v", e, err)
+	}
+}
+
+func TestCreateLoggerLocal(t *testing.T) {
+	w := testWorkflow()
+	w.Logger = nil
+	w.DisableGCSLogging()
+	w.DisableCloudLogging()
+	w.DisableStdoutLogging()
+	if err := w.SetLogFormat("xml"); err == nil {
+		t.Error("expected error for unknown log format")
+	}
+	if err := w.SetLogFormat(LogFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	w.SetLogLevel(SeverityWarn)
+	var b bytes.Buffer
+	w.AddLogWriter(&b)
+	w.createLogger(context.Background())
+
+	w.LogWorkflowInfo("info")
+	w.logWorkflow(SeverityWarn, "warn")
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1: %q", len(lines), lines)
+	}
+	var e LogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Severity != SeverityWarn || e.Message != "warn" || e.WorkflowID != w.id {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+}
+
+func TestCloudLoggingSeverity(t *testing.T) {
+	w := New()
+	w.Name = "Test"
+	cl := &MockCloudLogWriter{}
+	w.Logger = &daisyLog{cloudLogger: cl}
+
+	w.logWorkflow(SeverityError, "error")
+	w.LogStepInfo("s", "StepType", "info")
+	if len(cl.entries) != 2 || cl.entries[0].Severity != logging.Error || cl.entries[1].Severity != logging.Info {
+		t.Errorf("unexpected cloud entries: %+v", cl.entries)
+	}
+}
@@ -72,7 +72,7 @@ func (n *Network) populate(ctx context.Context, s *Step) dErr {
 	var errs dErr
 	n.Name, errs = n.Resource.populateWithGlobal(ctx, s, n.Name)
 
-	n.Description = strOr(n.Description, defaultDescription("Network", s.w.Name, s.w.username))
+	n.Description = s.w.addWorkflowMarker(&n.Resource, strOr(n.Description, defaultDescription("Network", s.w.Name, s.w.username)))
 	n.link = fmt.Sprintf("projects/%s/global/networks/%s", n.Project, n.Name)
 
 	if n.AutoCreateSubnetworks != nil {
@@ -120,9 +120,9 @@ func newNetworkRegistry(w *Workflow) *networkRegistry {
 	return nr
 }
 
-func (nr *networkRegistry) deleteFn(res *Resource) dErr {
+func (nr *networkRegistry) deleteFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(networkURLRegex, res.link)
-	err := nr.w.ComputeClient.DeleteNetwork(m["project"], m["network"])
+	err := nr.w.ComputeClient.WithContext(ctx).DeleteNetwork(m["project"], m["network"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
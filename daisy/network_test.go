@@ -30,7 +30,7 @@ func TestNetworkPopulate(t *testing.T) {
 	pTrue := true
 	pFalse := false
 
-	desc := defaultDescription("Network", w.Name, w.username)
+	desc := defaultDescription("Network", w.Name, w.username) + " [daisy-workflow-id=abcdef daisy-workflow-name=test-wf]"
 	name := "name"
 	tests := []struct {
 		desc    string
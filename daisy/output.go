@@ -0,0 +1,143 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// outputVarRgx matches step output references: ${OUTPUT:stepname.key}.
+var outputVarRgx = regexp.MustCompile(`\$\{OUTPUT:([^.}]+)\.([^}]+)}`)
+
+// setOutput publishes the value of output key of step stepName.
+func (w *Workflow) setOutput(stepName, key, value string) {
+	w.outputsMx.Lock()
+	defer w.outputsMx.Unlock()
+	if w.outputs == nil {
+		w.outputs = map[string]string{}
+	}
+	w.outputs[stepName+"."+key] = value
+}
+
+// stepOutputs returns the outputs published by step stepName, keyed by output
+// key.
+func (w *Workflow) stepOutputs(stepName string) map[string]string {
+	w.outputsMx.Lock()
+	defer w.outputsMx.Unlock()
+	res := map[string]string{}
+	for k, v := range w.outputs {
+		if strings.HasPrefix(k, stepName+".") {
+			res[strings.TrimPrefix(k, stepName+".")] = v
+		}
+	}
+	return res
+}
+
+// outputNames returns the keys of the outputs s publishes when it succeeds.
+func (s *Step) outputNames() []string {
+	var names []string
+	if s.WaitForInstancesSignal != nil {
+		for _, is := range *s.WaitForInstancesSignal {
+			var rgxs []*regexp.Regexp
+			if is.SerialOutput != nil && is.SerialOutput.successRgx != nil {
+				rgxs = append(rgxs, is.SerialOutput.successRgx)
+			}
+			if is.GuestAttribute != nil && is.GuestAttribute.successRgx != nil {
+				rgxs = append(rgxs, is.GuestAttribute.successRgx)
+			}
+			for _, rgx := range rgxs {
+				for _, n := range rgx.SubexpNames() {
+					if n != "" {
+						names = append(names, n)
+					}
+				}
+			}
+		}
+	}
+	if s.RunLocalCommand != nil {
+		names = append(names, s.RunLocalCommand.Outputs...)
+	}
+	return names
+}
+
+// outputRefsData returns the fields of s that can reference step outputs.
+// Steps of IncludeWorkflows, SubWorkflows and ForEach items resolve their own
+// references.
+func (s *Step) outputRefsData() reflect.Value {
+	if s.IncludeWorkflow != nil || s.SubWorkflow != nil || s.ForEach != nil {
+		return reflect.Value{}
+	}
+	return reflect.ValueOf(s).Elem()
+}
+
+// validateOutputRefs checks that the step outputs s references are published
+// by steps s depends on.
+func (s *Step) validateOutputRefs() dErr {
+	v := s.outputRefsData()
+	if !v.IsValid() {
+		return nil
+	}
+	return traverseData(v, func(val reflect.Value) dErr {
+		if val.Kind() != reflect.String {
+			return nil
+		}
+		for _, m := range outputVarRgx.FindAllStringSubmatch(val.String(), -1) {
+			producer, ok := s.w.Steps[m[1]]
+			if !ok {
+				return errf("%s references output of non existent step %q", m[0], m[1])
+			}
+			if !s.depends(producer) {
+				return errf("%s references output of step %q, which this step does not depend on", m[0], m[1])
+			}
+			if producer.skipped {
+				return errf("%s references output of step %q, which is skipped", m[0], m[1])
+			}
+			if !strIn(m[2], producer.outputNames()) {
+				return errf("%s references output %q, which step %q does not publish", m[0], m[2], m[1])
+			}
+		}
+		return nil
+	})
+}
+
+// substituteOutputs replaces the step output references in s with their
+// values. It is run right before s runs, once the outputs are published.
+func (s *Step) substituteOutputs() dErr {
+	v := s.outputRefsData()
+	if !v.IsValid() {
+		return nil
+	}
+	return traverseData(v, func(val reflect.Value) dErr {
+		if val.Kind() != reflect.String {
+			return nil
+		}
+		var errs dErr
+		res := outputVarRgx.ReplaceAllStringFunc(val.String(), func(ref string) string {
+			m := outputVarRgx.FindStringSubmatch(ref)
+			out, ok := s.w.stepOutputs(m[1])[m[2]]
+			if !ok {
+				errs = addErrs(errs, errf("%s: step %q did not publish output %q", ref, m[1], m[2]))
+			}
+			return out
+		})
+		if errs != nil {
+			return errs
+		}
+		val.SetString(res)
+		return nil
+	})
+}
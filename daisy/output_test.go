@@ -0,0 +1,127 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestWaitForSerialOutputCapture(t *testing.T) {
+	w := testWorkflow()
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+		return &compute.SerialPortOutput{Contents: "booting\nImportDone: version=1.2.3 arch=x86\n", Next: 20}, nil
+	}
+
+	ws := &WaitForInstancesSignal{{Name: "i", SerialOutput: &SerialOutput{Port: 1, SuccessMatch: `ImportDone: version=(?P<ver>\S+) arch=(?P<arch>\S+)`}}}
+	s, _ := w.NewStep("wait")
+	s.WaitForInstancesSignal = ws
+	if err := ws.populate(context.Background(), s); err != nil {
+		t.Fatalf("error populating WaitForInstancesSignal: %v", err)
+	}
+	if err := waitForSerialOutput(context.Background(), s, testProject, testZone, "i", (*ws)[0].SerialOutput, time.Microsecond); err != nil {
+		t.Fatalf("error running waitForSerialOutput: %v", err)
+	}
+
+	want := map[string]string{"ver": "1.2.3", "arch": "x86"}
+	if diffRes := diff(w.stepOutputs("wait"), want, 0); diffRes != "" {
+		t.Errorf("outputs do not match expectation: (-got +want)\n%s", diffRes)
+	}
+	if diffRes := diff(s.outputNames(), []string{"ver", "arch"}, 0); diffRes != "" {
+		t.Errorf("output names do not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestSuccessMatchSubstring(t *testing.T) {
+	ws := &WaitForInstancesSignal{{Name: "i", SerialOutput: &SerialOutput{SuccessMatch: "done (100%)"}}}
+	if err := ws.populate(context.Background(), &Step{}); err != nil {
+		t.Fatalf("error populating WaitForInstancesSignal: %v", err)
+	}
+	if (*ws)[0].SerialOutput.successRgx != nil {
+		t.Error("SuccessMatch without named groups should not be a regex")
+	}
+
+	ws = &WaitForInstancesSignal{{Name: "i", SerialOutput: &SerialOutput{SuccessMatch: "(?P<ver>"}}}
+	if err := ws.populate(context.Background(), &Step{}); err == nil {
+		t.Error("invalid SuccessMatch regex should fail populate")
+	}
+}
+
+func TestValidateOutputRefs(t *testing.T) {
+	tests := []struct {
+		desc, ref string
+		deps      []string
+		skipped   bool
+		shouldErr bool
+	}{
+		{"good case", "${OUTPUT:wait.ver}", []string{"wait"}, false, false},
+		{"transitive dependency case", "${OUTPUT:wait.ver}", []string{"middle"}, false, false},
+		{"no dependency case", "${OUTPUT:wait.ver}", nil, false, true},
+		{"non existent step case", "${OUTPUT:foo.ver}", []string{"wait"}, false, true},
+		{"unknown output case", "${OUTPUT:wait.foo}", []string{"wait"}, false, true},
+		{"skipped producer case", "${OUTPUT:wait.ver}", []string{"wait"}, true, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		wait, _ := w.NewStep("wait")
+		wait.WaitForInstancesSignal = &WaitForInstancesSignal{{Name: "i", SerialOutput: &SerialOutput{SuccessMatch: "version=(?P<ver>.+)"}}}
+		if err := wait.WaitForInstancesSignal.populate(context.Background(), wait); err != nil {
+			t.Fatalf("%s: error populating WaitForInstancesSignal: %v", tt.desc, err)
+		}
+		wait.skipped = tt.skipped
+		w.NewStep("middle")
+		use, _ := w.NewStep("use")
+		use.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "d", Description: "version " + tt.ref}}}
+		w.Dependencies = map[string][]string{"use": tt.deps, "middle": {"wait"}}
+
+		err := use.validateOutputRefs()
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestSubstituteOutputs(t *testing.T) {
+	w := testWorkflow()
+	w.setOutput("wait", "ver", "1.2.3")
+	s, _ := w.NewStep("use")
+	s.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "d", Description: "version ${OUTPUT:wait.ver}"}}}
+	if err := s.substituteOutputs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := (*s.CreateDisks)[0].Description, "version 1.2.3"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	s.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "d", Description: "${OUTPUT:wait.foo}"}}}
+	if err := s.substituteOutputs(); err == nil {
+		t.Error("unpublished output should return an error")
+	}
+}
+
+func TestOutputVarsSubbed(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{"s": {CreateDisks: &CreateDisks{{Disk: compute.Disk{Name: "d", Description: "${OUTPUT:wait.ver}"}}}}}
+	if err := w.validateVarsSubbed(); err != nil {
+		t.Errorf("step output references should not be unresolved vars: %v", err)
+	}
+}
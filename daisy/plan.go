@@ -0,0 +1,691 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+)
+
+// PlannedAction is an API mutation a workflow would issue.
+type PlannedAction struct {
+	Call   string
+	Target string
+	Detail string `json:",omitempty"`
+}
+
+func (a PlannedAction) String() string {
+	if a.Detail == "" {
+		return fmt.Sprintf("%s %s", a.Call, a.Target)
+	}
+	return fmt.Sprintf("%s %s (%s)", a.Call, a.Target, a.Detail)
+}
+
+// PlannedStep lists the actions a step would take. Steps in the same Stage
+// can run concurrently.
+type PlannedStep struct {
+	Name    string
+	Type    string
+	Stage   []int
+	Skipped bool            `json:",omitempty"`
+	Actions []PlannedAction `json:",omitempty"`
+}
+
+func (ps *PlannedStep) stage() string {
+	var s []string
+	for _, i := range ps.Stage {
+		s = append(s, fmt.Sprint(i))
+	}
+	return strings.Join(s, ".")
+}
+
+// Plan is the result of simulating a workflow run: the API mutations its
+// sources upload, steps and cleanup would issue, in order.
+type Plan struct {
+	Workflow string
+	Sources  []PlannedAction `json:",omitempty"`
+	Steps    []*PlannedStep
+	Cleanup  []PlannedAction `json:",omitempty"`
+}
+
+func (p *Plan) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Plan for workflow %q:\n", p.Workflow)
+	if len(p.Sources) > 0 {
+		fmt.Fprintln(&b, "Sources:")
+		for _, a := range p.Sources {
+			fmt.Fprintf(&b, "    %s\n", a)
+		}
+	}
+	for i := 0; i < len(p.Steps); {
+		stage := p.Steps[i].stage()
+		j := i
+		for j < len(p.Steps) && p.Steps[j].stage() == stage {
+			j++
+		}
+		if j-i > 1 {
+			fmt.Fprintf(&b, "Stage %s (%d steps, run concurrently):\n", stage, j-i)
+		} else {
+			fmt.Fprintf(&b, "Stage %s:\n", stage)
+		}
+		for _, ps := range p.Steps[i:j] {
+			if ps.Skipped {
+				fmt.Fprintf(&b, "  %s (%s, skipped)\n", ps.Name, ps.Type)
+				continue
+			}
+			fmt.Fprintf(&b, "  %s (%s)\n", ps.Name, ps.Type)
+			for _, a := range ps.Actions {
+				fmt.Fprintf(&b, "    %s\n", a)
+			}
+		}
+		i = j
+	}
+	if len(p.Cleanup) > 0 {
+		fmt.Fprintln(&b, "Cleanup:")
+		for _, a := range p.Cleanup {
+			fmt.Fprintf(&b, "    %s\n", a)
+		}
+	}
+	return b.String()
+}
+
+// Plan populates and validates the workflow, then walks its steps against a
+// compute client and a GCS backend that record mutations instead of issuing
+// them. Reads are still sent to the APIs, with read only GCS credentials.
+// The workflow can't be run after being planned. Its GCSPath must be set, the
+// default bucket is not created for a plan.
+func (w *Workflow) Plan(ctx context.Context) (*Plan, error) {
+	if w.GCSPath == "" {
+		return nil, errf("GCSPath must be set to plan a workflow")
+	}
+	if err := w.Validate(ctx); err != nil {
+		return nil, err
+	}
+	// Workflows with their own Storage don't read from GCS.
+	base := http.DefaultTransport
+	if w.Storage == nil {
+		hc, _, err := transport.NewHTTPClient(ctx, option.WithCredentialsFile(w.OAuthPath), option.WithScopes(storage.ScopeReadOnly))
+		if err != nil {
+			return nil, typedErr(apiError, err)
+		}
+		base = hc.Transport
+	}
+	p, pErr := w.plan(ctx, base)
+	if pErr != nil {
+		w.logWorkflow(SeverityError, "Error planning workflow: %v", pErr)
+		return nil, pErr
+	}
+	return p, nil
+}
+
+// plan simulates a run of the populated and validated workflow. GCS reads are
+// sent to base.
+func (w *Workflow) plan(ctx context.Context, base http.RoundTripper) (*Plan, dErr) {
+	rec := &planRecorder{}
+	sc, err := storage.NewClient(ctx, option.WithHTTPClient(&http.Client{Transport: &planTransport{rec: rec, base: base}}))
+	if err != nil {
+		return nil, newErr(err)
+	}
+	var st Storage
+	if w.Storage != nil {
+		st = &planStorage{Storage: w.Storage, rec: rec}
+	}
+	w.setClients(&planClient{Client: w.ComputeClient, rec: rec}, sc, st)
+	defer w.cleanup()
+
+	p := &Plan{Workflow: w.Name}
+	var srcErr dErr
+	p.Sources = rec.capture(func() { srcErr = w.uploadSources(ctx) })
+	if srcErr != nil {
+		return nil, srcErr
+	}
+	if err := w.planSteps(ctx, rec, nil); err != nil {
+		return nil, err
+	}
+	for _, r := range w.resourceRegistries() {
+		p.Cleanup = append(p.Cleanup, rec.capture(func() { r.cleanup(ctx) })...)
+	}
+
+	p.Steps = rec.steps
+	sort.Slice(p.Steps, func(i, j int) bool {
+		si, sj := p.Steps[i].Stage, p.Steps[j].Stage
+		for k := 0; k < len(si) && k < len(sj); k++ {
+			if si[k] != sj[k] {
+				return si[k] < sj[k]
+			}
+		}
+		if len(si) != len(sj) {
+			return len(si) < len(sj)
+		}
+		return p.Steps[i].Name < p.Steps[j].Name
+	})
+	return p, nil
+}
+
+// setClients sets the API clients and storage of w and of its included and
+// sub workflows.
+func (w *Workflow) setClients(cc daisyCompute.Client, sc *storage.Client, st Storage) {
+	w.ComputeClient = cc
+	w.StorageClient = sc
+	w.Storage = st
+	steps := w.finalSteps()
+	for _, s := range w.Steps {
+		steps = append(steps, s)
+	}
+	for _, s := range steps {
+		if s.IncludeWorkflow != nil && s.IncludeWorkflow.Workflow != nil {
+			s.IncludeWorkflow.Workflow.setClients(cc, sc, st)
+		}
+		if s.SubWorkflow != nil && s.SubWorkflow.Workflow != nil {
+			s.SubWorkflow.Workflow.setClients(cc, sc, st)
+		}
+		if s.ForEach != nil {
+			for _, fs := range s.ForEach.steps {
+				fs.w.setClients(cc, sc, st)
+			}
+		}
+	}
+}
+
+// stepStages returns the stage each step runs in: 1 for steps without
+// dependencies, otherwise one more than the latest of its dependencies. The
+// Finally steps follow, one stage each.
+func (w *Workflow) stepStages() map[string]int {
+	stages := map[string]int{}
+	var stage func(name string) int
+	stage = func(name string) int {
+		if n, ok := stages[name]; ok {
+			return n
+		}
+		n := 1
+		for _, dep := range w.Dependencies[name] {
+			if d := stage(dep) + 1; d > n {
+				n = d
+			}
+		}
+		stages[name] = n
+		return n
+	}
+	last := 0
+	for name := range w.Steps {
+		if n := stage(name); n > last {
+			last = n
+		}
+	}
+	// The plan assumes the steps succeed, so only the Finally steps run
+	// after them.
+	for i, s := range w.Finally {
+		stages[s.name] = last + 1 + i
+	}
+	return stages
+}
+
+func (w *Workflow) planSteps(ctx context.Context, rec *planRecorder, parent []int) dErr {
+	stages := w.stepStages()
+	if err := w.traverseDAG(ctx, func(s *Step) dErr {
+		stage := append(append([]int{}, parent...), stages[s.name])
+		return w.planStep(ctx, rec, s, stage)
+	}); err != nil {
+		return err
+	}
+	for _, s := range w.Finally {
+		stage := append(append([]int{}, parent...), stages[s.name])
+		if err := w.planStep(ctx, rec, s, stage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Workflow) planStep(ctx context.Context, rec *planRecorder, s *Step, stage []int) dErr {
+	impl, err := s.stepImpl()
+	if err != nil {
+		return s.wrapRunError(err)
+	}
+	ps := &PlannedStep{Name: s.fullName(), Type: s.typeName(), Stage: stage, Skipped: s.skipped}
+	rec.addStep(ps)
+	if s.skipped {
+		return nil
+	}
+
+	switch st := impl.(type) {
+	case *IncludeWorkflow:
+		return st.Workflow.planSteps(ctx, rec, stage)
+	case *ForEach:
+		// Items run concurrently, in the stage of the ForEach step.
+		for _, item := range st.steps {
+			if err := w.planStep(ctx, rec, item, stage); err != nil {
+				return err
+			}
+		}
+	case *SubWorkflow:
+		sw := st.Workflow
+		ps.Actions = rec.capture(func() { err = sw.uploadSources(ctx) })
+		if err != nil {
+			return s.wrapRunError(err)
+		}
+		if err := sw.planSteps(ctx, rec, stage); err != nil {
+			return err
+		}
+		last := 0
+		for _, n := range sw.stepStages() {
+			if n > last {
+				last = n
+			}
+		}
+		cleanup := &PlannedStep{Name: ps.Name, Type: "SubWorkflow cleanup", Stage: append(append([]int{}, stage...), last+1)}
+		for _, r := range sw.resourceRegistries() {
+			cleanup.Actions = append(cleanup.Actions, rec.capture(func() { r.cleanup(ctx) })...)
+		}
+		rec.addStep(cleanup)
+	case *WaitForInstancesSignal:
+		// Waiting only reads from the API and would never finish in a plan.
+		for _, is := range *st {
+			target := is.Name
+			if i, ok := w.instances.get(is.Name); ok {
+				target = i.link
+			}
+			if is.Stopped {
+				ps.Actions = append(ps.Actions, PlannedAction{Call: "WaitForInstanceStopped", Target: target})
+			}
+			if is.SerialOutput != nil {
+				ps.Actions = append(ps.Actions, PlannedAction{Call: "WaitForSerialOutput", Target: target, Detail: fmt.Sprintf("port %d", is.SerialOutput.Port)})
+			}
+			if ga := is.GuestAttribute; ga != nil {
+				ps.Actions = append(ps.Actions, PlannedAction{Call: "WaitForGuestAttribute", Target: target, Detail: ga.Namespace + "/" + ga.KeyName})
+			}
+		}
+	case *RunLocalCommand:
+		// Local commands have effects a plan can't record.
+		ps.Actions = append(ps.Actions, PlannedAction{Call: "RunLocalCommand", Target: st.path, Detail: strings.Join(st.Args, " ")})
+	case *customStep:
+		// Custom steps may have effects a plan can't record, only those
+		// that opt in are simulated.
+		p, ok := st.impl.(StepPlanner)
+		if !ok {
+			ps.Actions = append(ps.Actions, PlannedAction{Call: st.name, Target: ps.Name, Detail: "not simulated"})
+			return nil
+		}
+		ps.Actions = rec.capture(func() { err = newErr(p.Plan(ctx, s)) })
+		if err != nil {
+			return s.wrapRunError(err)
+		}
+	default:
+		ps.Actions = rec.capture(func() { err = impl.run(ctx, s) })
+		if err != nil {
+			return s.wrapRunError(err)
+		}
+	}
+	return nil
+}
+
+// planStorage is a Storage that records mutations instead of issuing them.
+// Reads are sent to the wrapped Storage.
+type planStorage struct {
+	Storage
+	rec *planRecorder
+}
+
+// planWriter discards what is written to it.
+type planWriter struct{}
+
+func (planWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (planWriter) Close() error                { return nil }
+
+func (s *planStorage) NewWriter(ctx context.Context, bkt, obj, contentType string) io.WriteCloser {
+	s.rec.record("WriteGCSObject", s.URL(bkt, obj), "")
+	return planWriter{}
+}
+
+func (s *planStorage) Copy(ctx context.Context, sBkt, sObj, dBkt, dObj string) error {
+	s.rec.record("CopyGCSObject", s.URL(dBkt, dObj), "from "+s.URL(sBkt, sObj))
+	return nil
+}
+
+func (s *planStorage) Delete(ctx context.Context, bkt, obj string) error {
+	s.rec.record("DeleteGCSObject", s.URL(bkt, obj), "")
+	return nil
+}
+
+func (s *planStorage) SetACL(ctx context.Context, bkt, obj string, entity storage.ACLEntity, role storage.ACLRole) error {
+	s.rec.record("SetGCSObjectACL", s.URL(bkt, obj), string(entity))
+	return nil
+}
+
+// planRecorder collects the actions issued through a planClient,
+// planTransport or planStorage.
+type planRecorder struct {
+	// captureMx serializes captures so actions are attributed to the right
+	// step even though steps are traversed concurrently.
+	captureMx sync.Mutex
+	mx        sync.Mutex
+	actions   *[]PlannedAction
+	steps     []*PlannedStep
+}
+
+func (r *planRecorder) record(call, target, detail string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	// Actions issued outside of a capture, e.g. by background goroutines,
+	// are not part of the plan.
+	if r.actions != nil {
+		*r.actions = append(*r.actions, PlannedAction{Call: call, Target: target, Detail: detail})
+	}
+}
+
+func (r *planRecorder) addStep(ps *PlannedStep) {
+	r.mx.Lock()
+	r.steps = append(r.steps, ps)
+	r.mx.Unlock()
+}
+
+// capture returns the actions issued while running f. Concurrent calls are
+// issued in no particular order, so to keep plans stable actions are grouped
+// by call in the order each call was first issued, then ordered by target.
+func (r *planRecorder) capture(f func()) []PlannedAction {
+	r.captureMx.Lock()
+	defer r.captureMx.Unlock()
+
+	var actions []PlannedAction
+	r.mx.Lock()
+	r.actions = &actions
+	r.mx.Unlock()
+	f()
+	r.mx.Lock()
+	r.actions = nil
+	r.mx.Unlock()
+
+	first := map[string]int{}
+	for i, a := range actions {
+		if _, ok := first[a.Call]; !ok {
+			first[a.Call] = i
+		}
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		if fi, fj := first[actions[i].Call], first[actions[j].Call]; fi != fj {
+			return fi < fj
+		}
+		return actions[i].Target < actions[j].Target
+	})
+	return actions
+}
+
+// planClient is a compute client that records mutations instead of issuing
+// them. Reads of resources the workflow creates are answered as if the
+// resources existed and were stopped, other reads go to the embedded client.
+// Every method of the Client interface that isn't a read must be overridden
+// here, TestPlanClientMutations checks that none reaches the embedded client.
+type planClient struct {
+	daisyCompute.Client
+	rec *planRecorder
+}
+
+// WithContext returns a planClient that sends its reads with ctx.
+func (c *planClient) WithContext(ctx context.Context) daisyCompute.Client {
+	return &planClient{Client: c.Client.WithContext(ctx), rec: c.rec}
+}
+
+func zonalURL(project, zone, kind, name string) string {
+	return fmt.Sprintf("projects/%s/zones/%s/%s/%s", project, zone, kind, path.Base(name))
+}
+
+func regionalURL(project, region, kind, name string) string {
+	return fmt.Sprintf("projects/%s/regions/%s/%s/%s", project, region, kind, path.Base(name))
+}
+
+func globalURL(project, kind, name string) string {
+	return fmt.Sprintf("projects/%s/global/%s/%s", project, kind, path.Base(name))
+}
+
+func (c *planClient) AttachDisk(project, zone, instance string, d *compute.AttachedDisk) error {
+	c.rec.record("AttachDisk", zonalURL(project, zone, "instances", instance), d.Source)
+	return nil
+}
+
+func (c *planClient) DetachDisk(project, zone, instance, disk string) error {
+	c.rec.record("DetachDisk", zonalURL(project, zone, "instances", instance), disk)
+	return nil
+}
+
+func (c *planClient) CreateDisk(project, zone string, d *compute.Disk) error {
+	c.rec.record("CreateDisk", zonalURL(project, zone, "disks", d.Name), "")
+	return nil
+}
+
+func (c *planClient) CreateForwardingRule(project, region string, fr *compute.ForwardingRule) error {
+	c.rec.record("CreateForwardingRule", regionalURL(project, region, "forwardingRules", fr.Name), "")
+	return nil
+}
+
+func (c *planClient) CreateFirewallRule(project string, i *compute.Firewall) error {
+	c.rec.record("CreateFirewallRule", globalURL(project, "firewalls", i.Name), "")
+	return nil
+}
+
+func (c *planClient) CreateImage(project string, i *compute.Image) error {
+	c.rec.record("CreateImage", globalURL(project, "images", i.Name), "")
+	return nil
+}
+
+func (c *planClient) CreateInstance(project, zone string, i *compute.Instance) error {
+	c.rec.record("CreateInstance", zonalURL(project, zone, "instances", i.Name), "")
+	return nil
+}
+
+func (c *planClient) CreateNetwork(project string, n *compute.Network) error {
+	c.rec.record("CreateNetwork", globalURL(project, "networks", n.Name), "")
+	return nil
+}
+
+func (c *planClient) CreateSubnetwork(project, region string, n *compute.Subnetwork) error {
+	c.rec.record("CreateSubnetwork", regionalURL(project, region, "subnetworks", n.Name), "")
+	return nil
+}
+
+func (c *planClient) CreateTargetInstance(project, zone string, ti *compute.TargetInstance) error {
+	c.rec.record("CreateTargetInstance", zonalURL(project, zone, "targetInstances", ti.Name), "")
+	return nil
+}
+
+func (c *planClient) DeleteDisk(project, zone, name string) error {
+	c.rec.record("DeleteDisk", zonalURL(project, zone, "disks", name), "")
+	return nil
+}
+
+func (c *planClient) DeleteForwardingRule(project, region, name string) error {
+	c.rec.record("DeleteForwardingRule", regionalURL(project, region, "forwardingRules", name), "")
+	return nil
+}
+
+func (c *planClient) DeleteFirewallRule(project, name string) error {
+	c.rec.record("DeleteFirewallRule", globalURL(project, "firewalls", name), "")
+	return nil
+}
+
+func (c *planClient) DeleteImage(project, name string) error {
+	c.rec.record("DeleteImage", globalURL(project, "images", name), "")
+	return nil
+}
+
+func (c *planClient) DeleteInstance(project, zone, name string) error {
+	c.rec.record("DeleteInstance", zonalURL(project, zone, "instances", name), "")
+	return nil
+}
+
+func (c *planClient) StartInstance(project, zone, name string) error {
+	c.rec.record("StartInstance", zonalURL(project, zone, "instances", name), "")
+	return nil
+}
+
+func (c *planClient) StopInstance(project, zone, name string) error {
+	c.rec.record("StopInstance", zonalURL(project, zone, "instances", name), "")
+	return nil
+}
+
+func (c *planClient) DeleteNetwork(project, name string) error {
+	c.rec.record("DeleteNetwork", globalURL(project, "networks", name), "")
+	return nil
+}
+
+func (c *planClient) DeleteSubnetwork(project, region, name string) error {
+	c.rec.record("DeleteSubnetwork", regionalURL(project, region, "subnetworks", name), "")
+	return nil
+}
+
+func (c *planClient) DeleteTargetInstance(project, zone, name string) error {
+	c.rec.record("DeleteTargetInstance", zonalURL(project, zone, "targetInstances", name), "")
+	return nil
+}
+
+func (c *planClient) DeprecateImage(project, name string, deprecationstatus *compute.DeprecationStatus) error {
+	c.rec.record("DeprecateImage", globalURL(project, "images", name), deprecationstatus.State)
+	return nil
+}
+
+func (c *planClient) ResizeDisk(project, zone, disk string, drr *compute.DisksResizeRequest) error {
+	c.rec.record("ResizeDisk", zonalURL(project, zone, "disks", disk), fmt.Sprintf("%dGB", drr.SizeGb))
+	return nil
+}
+
+func (c *planClient) SetInstanceMetadata(project, zone, name string, md *compute.Metadata) error {
+	c.rec.record("SetInstanceMetadata", zonalURL(project, zone, "instances", name), "")
+	return nil
+}
+
+func (c *planClient) SetCommonInstanceMetadata(project string, md *compute.Metadata) error {
+	c.rec.record("SetCommonInstanceMetadata", "projects/"+project, "")
+	return nil
+}
+
+func (c *planClient) GetInstance(project, zone, name string) (*compute.Instance, error) {
+	return &compute.Instance{Name: name, Status: "TERMINATED"}, nil
+}
+
+func (c *planClient) GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error) {
+	return nil, errors.New("no serial port output in a plan")
+}
+
+func (c *planClient) InstanceStatus(project, zone, name string) (string, error) {
+	return "TERMINATED", nil
+}
+
+func (c *planClient) InstanceStopped(project, zone, name string) (bool, error) {
+	return true, nil
+}
+
+// Retry fails, the operation it would issue can't be recorded.
+func (c *planClient) Retry(f func(opts ...googleapi.CallOption) (*compute.Operation, error), opts ...googleapi.CallOption) (*compute.Operation, error) {
+	return nil, errors.New("arbitrary API calls are not supported in a plan")
+}
+
+var (
+	planRewriteRgx = regexp.MustCompile(`/b/([^/]+)/o/([^/]+)/rewriteTo/b/([^/]+)/o/([^/]+)$`)
+	planUploadRgx  = regexp.MustCompile(`/upload/storage/v1/b/([^/]+)/o$`)
+	planACLRgx     = regexp.MustCompile(`/b/([^/]+)/o/([^/]+)/acl/([^/]+)$`)
+	planObjectRgx  = regexp.MustCompile(`/b/([^/]+)/o/([^/]+)$`)
+	planNameRgx    = regexp.MustCompile(`"name":\s*("(?:[^"\\]|\\.)*")`)
+)
+
+// planTransport is a GCS transport that records mutations instead of sending
+// them. Reads are sent to base.
+type planTransport struct {
+	rec  *planRecorder
+	base http.RoundTripper
+}
+
+func gcsURL(bkt, escapedObj string) string {
+	obj, err := url.PathUnescape(escapedObj)
+	if err != nil {
+		obj = escapedObj
+	}
+	return fmt.Sprintf("gs://%s/%s", bkt, obj)
+}
+
+func (t *planTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+	}
+	p := req.URL.EscapedPath()
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Request:    req,
+	}
+	var respBody string
+
+	switch m := planRewriteRgx.FindStringSubmatch(p); {
+	case m != nil:
+		t.rec.record("CopyGCSObject", gcsURL(m[3], m[4]), "from "+gcsURL(m[1], m[2]))
+		respBody = fmt.Sprintf(`{"done":true,"resource":{"bucket":%q}}`, m[3])
+	case req.URL.Query().Get("upload_id") != "":
+		// Chunks of a resumable upload, the upload was recorded when it was started.
+		respBody = `{}`
+	case planUploadRgx.MatchString(p):
+		bkt := planUploadRgx.FindStringSubmatch(p)[1]
+		name := req.URL.Query().Get("name")
+		if name == "" {
+			if nm := planNameRgx.FindSubmatch(body); nm != nil {
+				json.Unmarshal(nm[1], &name)
+			}
+		}
+		t.rec.record("WriteGCSObject", fmt.Sprintf("gs://%s/%s", bkt, name), "")
+		if req.URL.Query().Get("uploadType") == "resumable" {
+			q := req.URL.Query()
+			q.Set("upload_id", "plan")
+			u := *req.URL
+			u.RawQuery = q.Encode()
+			resp.Header.Set("Location", u.String())
+		}
+		respBody = fmt.Sprintf(`{"bucket":%q,"name":%q}`, bkt, name)
+	case planACLRgx.MatchString(p):
+		m := planACLRgx.FindStringSubmatch(p)
+		t.rec.record("SetGCSObjectACL", gcsURL(m[1], m[2]), m[3])
+		respBody = `{}`
+	case req.Method == http.MethodDelete && planObjectRgx.MatchString(p):
+		m := planObjectRgx.FindStringSubmatch(p)
+		t.rec.record("DeleteGCSObject", gcsURL(m[1], m[2]), "")
+		resp.StatusCode = http.StatusNoContent
+	default:
+		t.rec.record(req.Method, req.URL.String(), "")
+		respBody = `{}`
+	}
+	resp.Body = ioutil.NopCloser(strings.NewReader(respBody))
+	return resp, nil
+}
@@ -0,0 +1,223 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	diskLink := fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone)
+	instLink := fmt.Sprintf("projects/%s/zones/%s/instances/i", testProject, testZone)
+
+	create, _ := w.NewStep("create")
+	create.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		if err := s.w.ComputeClient.CreateDisk(testProject, testZone, &compute.Disk{Name: "d"}); err != nil {
+			return newErr(err)
+		}
+		wc := s.w.StorageClient.Bucket("bkt").Object("obj").NewWriter(ctx)
+		if _, err := wc.Write([]byte("foo")); err != nil {
+			return newErr(err)
+		}
+		if err := wc.Close(); err != nil {
+			return newErr(err)
+		}
+		src := s.w.StorageClient.Bucket("bkt").Object("obj")
+		if _, err := s.w.StorageClient.Bucket("bkt").Object("copy").CopierFrom(src).Run(ctx); err != nil {
+			return newErr(err)
+		}
+		return nil
+	}}
+	wait, _ := w.NewStep("wait")
+	wait.WaitForInstancesSignal = &WaitForInstancesSignal{{Name: "i", Stopped: true}}
+	w.AddDependency(wait, create)
+	w.disks.m = map[string]*Resource{"d": {RealName: "d", link: diskLink, creator: create}}
+	w.instances.m = map[string]*Resource{"i": {RealName: "i", link: instLink, NoCleanup: true}}
+
+	noReads := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("unexpected read")
+	})
+	got, err := w.plan(ctx, noReads)
+	if err != nil {
+		t.Fatalf("error planning workflow: %v", err)
+	}
+
+	want := &Plan{
+		Workflow: testWf,
+		Steps: []*PlannedStep{
+			{Name: "create", Type: "mockStep", Stage: []int{1}, Actions: []PlannedAction{
+				{Call: "CreateDisk", Target: diskLink},
+				{Call: "WriteGCSObject", Target: "gs://bkt/obj"},
+				{Call: "CopyGCSObject", Target: "gs://bkt/copy", Detail: "from gs://bkt/obj"},
+			}},
+			{Name: "wait", Type: "WaitForInstancesSignal", Stage: []int{2}, Actions: []PlannedAction{
+				{Call: "WaitForInstanceStopped", Target: instLink},
+			}},
+		},
+		Cleanup: []PlannedAction{{Call: "DeleteDisk", Target: diskLink}},
+	}
+	if diffRes := diff(got, want, 0); diffRes != "" {
+		t.Errorf("plan does not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+type testPlannerStepType struct {
+	testStepType
+}
+
+func (t *testPlannerStepType) Plan(ctx context.Context, s *Step) error {
+	t.calls = append(t.calls, "plan")
+	return s.Workflow().ComputeClient.CreateDisk(testProject, testZone, &compute.Disk{Name: "d"})
+}
+
+func TestPlanCustomSteps(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	custom := &testStepType{}
+	planner := &testPlannerStepType{}
+	cs, _ := w.NewStep("custom")
+	cs.Custom = map[string]StepImpl{"TestStepType": custom}
+	ps, _ := w.NewStep("planner")
+	ps.Custom = map[string]StepImpl{"TestPlannerStepType": planner}
+
+	noReads := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("unexpected read")
+	})
+	got, err := w.plan(ctx, noReads)
+	if err != nil {
+		t.Fatalf("error planning workflow: %v", err)
+	}
+
+	want := []*PlannedStep{
+		{Name: "custom", Type: "TestStepType", Stage: []int{1}, Actions: []PlannedAction{
+			{Call: "TestStepType", Target: "custom", Detail: "not simulated"},
+		}},
+		{Name: "planner", Type: "TestPlannerStepType", Stage: []int{1}, Actions: []PlannedAction{
+			{Call: "CreateDisk", Target: fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone)},
+		}},
+	}
+	if diffRes := diff(got.Steps, want, 0); diffRes != "" {
+		t.Errorf("planned steps do not match expectation: (-got +want)\n%s", diffRes)
+	}
+	// Neither step is run.
+	if len(custom.calls) != 0 || strIn("run", planner.calls) || !strIn("plan", planner.calls) {
+		t.Errorf("got calls %q and %q, want no run", custom.calls, planner.calls)
+	}
+}
+
+func TestPlanNoGCSPath(t *testing.T) {
+	w := testWorkflow()
+	w.GCSPath = ""
+	want := "GCSPath must be set to plan a workflow"
+	if _, err := w.Plan(context.Background()); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestStepStages(t *testing.T) {
+	w := testWorkflow()
+	for _, n := range []string{"a", "b", "c", "d"} {
+		w.NewStep(n)
+	}
+	w.Dependencies = map[string][]string{"b": {"a"}, "c": {"a", "b"}}
+
+	want := map[string]int{"a": 1, "b": 2, "c": 3, "d": 1}
+	if diffRes := diff(w.stepStages(), want, 0); diffRes != "" {
+		t.Errorf("stages do not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestPlanString(t *testing.T) {
+	p := &Plan{
+		Workflow: "wf",
+		Steps: []*PlannedStep{
+			{Name: "a", Type: "CreateDisks", Stage: []int{1}, Actions: []PlannedAction{{Call: "CreateDisk", Target: "disk"}}},
+			{Name: "b", Type: "IncludeWorkflow", Stage: []int{1}},
+			{Name: "b.c", Type: "DeleteResources", Stage: []int{1, 1}, Actions: []PlannedAction{{Call: "DeleteImage", Target: "image"}}},
+		},
+		Cleanup: []PlannedAction{{Call: "DeleteDisk", Target: "disk"}},
+	}
+	want := `Plan for workflow "wf":
+Stage 1 (2 steps, run concurrently):
+  a (CreateDisks)
+    CreateDisk disk
+  b (IncludeWorkflow)
+Stage 1.1:
+  b.c (DeleteResources)
+    DeleteImage image
+Cleanup:
+    DeleteDisk disk
+`
+	if got := p.String(); got != want {
+		t.Errorf("unexpected plan output, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPlanClientMutations(t *testing.T) {
+	// The embedded client is nil, so a call that reaches it panics.
+	c := &planClient{rec: &planRecorder{}}
+	reads := []string{"Get", "List", "InstanceStatus", "InstanceStopped", "BasePath", "WithContext"}
+	ct := reflect.TypeOf((*daisyCompute.Client)(nil)).Elem()
+Methods:
+	for i := 0; i < ct.NumMethod(); i++ {
+		m := ct.Method(i)
+		for _, r := range reads {
+			if strings.HasPrefix(m.Name, r) {
+				continue Methods
+			}
+		}
+		var args []reflect.Value
+		for j := 0; j < m.Type.NumIn(); j++ {
+			at := m.Type.In(j)
+			if m.Type.IsVariadic() && j == m.Type.NumIn()-1 {
+				break
+			}
+			if at.Kind() == reflect.Ptr {
+				args = append(args, reflect.New(at.Elem()))
+			} else {
+				args = append(args, reflect.Zero(at))
+			}
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s reached the live client: %v", m.Name, r)
+				}
+			}()
+			acts := c.rec.capture(func() { reflect.ValueOf(c).MethodByName(m.Name).Call(args) })
+			// Retry fails instead, its operation is unknown.
+			if len(acts) != 1 && m.Name != "Retry" {
+				t.Errorf("%s recorded %d actions, want 1", m.Name, len(acts))
+			}
+		}()
+	}
+}
@@ -0,0 +1,310 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+// localSSDSizeGb is the size of a local SSD partition.
+const localSSDSizeGb = 375
+
+// quotaKey identifies a quota metric of a project, or of a region of a
+// project if region is set.
+type quotaKey struct {
+	project, region, metric string
+}
+
+func (k quotaKey) String() string {
+	if k.region == "" {
+		return fmt.Sprintf("%s quota of project %q", k.metric, k.project)
+	}
+	return fmt.Sprintf("%s quota of project %q in region %q", k.metric, k.project, k.region)
+}
+
+// quotaDemand is an amount of a quota used by a resource the workflow
+// creates, from its creator step until its deleter step, or until cleanup if
+// no step deletes it.
+type quotaDemand struct {
+	key              quotaKey
+	amount           float64
+	creator, deleter *Step
+}
+
+type quotaChecker struct {
+	w      *Workflow
+	client daisyCompute.Client
+	// Quotas by project, or by project and region, nil if the lookup failed.
+	quotas map[quotaKey]map[string]*compute.Quota
+	cpus   map[string]int64
+	images map[string]int64
+}
+
+// checkQuotas totals up the quota the resources created by the workflow tree
+// use at once and compares it with the available project and regional
+// quotas. Resources are used at once unless the DAG orders the deletion of
+// one before the creation of the other. It fails if the quota is exceeded in
+// every order the steps can run in, and warns if it is exceeded in some.
+// Quotas that can't be looked up are not checked, with a warning.
+func (w *Workflow) checkQuotas(ctx context.Context) dErr {
+	if w.SkipQuotaCheck {
+		w.LogWorkflowInfo("Skipping the quota check")
+		return nil
+	}
+	w.LogWorkflowInfo("Checking quotas")
+	qc := &quotaChecker{
+		w:      w,
+		client: w.ComputeClient.WithContext(ctx),
+		quotas: map[quotaKey]map[string]*compute.Quota{},
+		cpus:   map[string]int64{},
+		images: map[string]int64{},
+	}
+	demands := map[quotaKey][]quotaDemand{}
+	var add func(steps []*Step)
+	add = func(steps []*Step) {
+		for _, s := range steps {
+			if s.skipped {
+				continue
+			}
+			for _, d := range qc.stepDemands(s) {
+				demands[d.key] = append(demands[d.key], d)
+			}
+			nw, nested := nestedSteps(s)
+			if nw != nil {
+				nested = append(nested, nw.finalSteps()...)
+			}
+			add(nested)
+		}
+	}
+	add(append(sortedSteps(w), w.finalSteps()...))
+
+	var keys []quotaKey
+	for k := range demands {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	var errs dErr
+	for _, k := range keys {
+		q := qc.quota(k)
+		if q == nil {
+			continue
+		}
+		available := q.Limit - q.Usage
+		certain, possible := peakQuotaUsage(demands[k])
+		if certain > available {
+			errs = addErrs(errs, errf("not enough %s: the workflow needs %g, %g of %g is available", k, certain, available, q.Limit))
+		} else if possible > available {
+			w.logWorkflow(SeverityWarn, "The workflow may run out of %s: it needs up to %g, %g of %g is available", k, possible, available, q.Limit)
+		}
+	}
+	return errs
+}
+
+// peakQuotaUsage returns the peak usage of demands of a quota, certain in
+// every order the steps can run in, and possible in some order. The peak is
+// reached when a resource is created.
+func peakQuotaUsage(demands []quotaDemand) (certain, possible float64) {
+	precedes := func(a, b *Step) bool { return b.nestedDepends(a) }
+	for _, at := range demands {
+		c := at.creator
+		var cu, pu float64
+		for _, d := range demands {
+			// Created no later than c, and deleted after c.
+			if (d.creator == c || precedes(d.creator, c)) && (d.deleter == nil || precedes(c, d.deleter)) {
+				cu += d.amount
+			}
+			// Not created after c, and not deleted before c.
+			if !precedes(c, d.creator) && (d.deleter == nil || (d.deleter != c && !precedes(d.deleter, c))) {
+				pu += d.amount
+			}
+		}
+		if cu > certain {
+			certain = cu
+		}
+		if pu > possible {
+			possible = pu
+		}
+	}
+	return certain, possible
+}
+
+// stepDemands returns the quota demands of the resources s creates.
+func (qc *quotaChecker) stepDemands(s *Step) []quotaDemand {
+	var ds []quotaDemand
+	add := func(project, region, metric string, amount float64, res *Resource) {
+		if amount > 0 {
+			ds = append(ds, quotaDemand{key: quotaKey{project, region, metric}, amount: amount, creator: s, deleter: res.deleter})
+		}
+	}
+	switch {
+	case s.CreateInstances != nil:
+		for _, i := range *s.CreateInstances {
+			region := zoneRegion(i.Zone)
+			add(i.Project, region, "INSTANCES", 1, &i.Resource)
+			if cpus, ok := qc.machineTypeCPUs(i.MachineType); ok {
+				add(i.Project, region, qc.cpuMetric(i.Project, region, i.MachineType), float64(cpus), &i.Resource)
+				add(i.Project, "", "CPUS_ALL_REGIONS", float64(cpus), &i.Resource)
+			}
+			for _, d := range i.Disks {
+				if p := d.InitializeParams; p != nil {
+					metric := diskMetric(p.DiskType)
+					size := p.DiskSizeGb
+					if metric == "LOCAL_SSD_TOTAL_GB" {
+						size = localSSDSizeGb
+					} else if size == 0 {
+						size = qc.imageSize(p.SourceImage)
+					}
+					add(i.Project, region, metric, float64(size), &i.Resource)
+				}
+			}
+		}
+	case s.CreateDisks != nil:
+		for _, d := range *s.CreateDisks {
+			size := d.Disk.SizeGb
+			if size == 0 {
+				size = qc.imageSize(d.SourceImage)
+			}
+			add(d.Project, zoneRegion(d.Zone), diskMetric(d.Type), float64(size), &d.Resource)
+		}
+	case s.CreateImages != nil:
+		for _, i := range *s.CreateImages {
+			add(i.Project, "", "IMAGES", 1, &i.Resource)
+		}
+	case s.CreateNetworks != nil:
+		for _, n := range *s.CreateNetworks {
+			add(n.Project, "", "NETWORKS", 1, &n.Resource)
+		}
+	}
+	return ds
+}
+
+// quota returns the quota k, or nil if it can't be looked up.
+func (qc *quotaChecker) quota(k quotaKey) *compute.Quota {
+	return qc.quotas[qc.lookup(k.project, k.region)][k.metric]
+}
+
+// lookup looks up the quotas of a project, or of a region of a project, and
+// returns the key they are stored with.
+func (qc *quotaChecker) lookup(project, region string) quotaKey {
+	k := quotaKey{project: project, region: region}
+	if _, ok := qc.quotas[k]; ok {
+		return k
+	}
+	var quotas []*compute.Quota
+	if region == "" {
+		p, err := qc.client.GetProject(project)
+		if err != nil {
+			qc.w.logWorkflow(SeverityWarn, "Not checking quotas of project %q: %v", project, err)
+			qc.quotas[k] = nil
+			return k
+		}
+		quotas = p.Quotas
+	} else {
+		r, err := qc.client.GetRegion(project, region)
+		if err != nil {
+			qc.w.logWorkflow(SeverityWarn, "Not checking quotas of project %q in region %q: %v", project, region, err)
+			qc.quotas[k] = nil
+			return k
+		}
+		quotas = r.Quotas
+	}
+	m := map[string]*compute.Quota{}
+	for _, q := range quotas {
+		if q != nil {
+			m[q.Metric] = q
+		}
+	}
+	qc.quotas[k] = m
+	return k
+}
+
+// cpuMetric returns the regional CPU quota metric of machine type mt: the
+// metric of its family, e.g. N2_CPUS, if the region has one, or CPUS.
+func (qc *quotaChecker) cpuMetric(project, region, mt string) string {
+	family := strings.ToUpper(strings.SplitN(path.Base(mt), "-", 2)[0]) + "_CPUS"
+	if _, ok := qc.quotas[qc.lookup(project, region)][family]; ok {
+		return family
+	}
+	return "CPUS"
+}
+
+func (qc *quotaChecker) machineTypeCPUs(link string) (int64, bool) {
+	if cpus, ok := qc.cpus[link]; ok {
+		return cpus, cpus >= 0
+	}
+	m := namedSubexp(machineTypeURLRegex, link)
+	mt, err := qc.client.GetMachineType(m["project"], m["zone"], m["machinetype"])
+	if err != nil {
+		qc.w.logWorkflow(SeverityWarn, "Not checking CPU quota of machine type %q: %v", link, err)
+		qc.cpus[link] = -1
+		return 0, false
+	}
+	qc.cpus[link] = mt.GuestCpus
+	return mt.GuestCpus, true
+}
+
+// imageSize returns the disk size of the existing image link, or 0 if link
+// names an image created by the workflow or can't be looked up.
+func (qc *quotaChecker) imageSize(link string) int64 {
+	if !imageURLRgx.MatchString(link) {
+		return 0
+	}
+	if size, ok := qc.images[link]; ok {
+		return size
+	}
+	m := namedSubexp(imageURLRgx, link)
+	var img *compute.Image
+	var err error
+	if m["family"] != "" {
+		img, err = qc.client.GetImageFromFamily(m["project"], m["family"])
+	} else {
+		img, err = qc.client.GetImage(m["project"], m["image"])
+	}
+	if err != nil {
+		qc.w.logWorkflow(SeverityWarn, "Not checking disk quota of disks created from image %q: %v", link, err)
+		qc.images[link] = 0
+		return 0
+	}
+	qc.images[link] = img.DiskSizeGb
+	return img.DiskSizeGb
+}
+
+// diskMetric returns the regional quota metric of disks of type link.
+func diskMetric(link string) string {
+	switch path.Base(link) {
+	case "pd-ssd", "pd-balanced":
+		return "SSD_TOTAL_GB"
+	case "local-ssd":
+		return "LOCAL_SSD_TOTAL_GB"
+	}
+	return "DISKS_TOTAL_GB"
+}
+
+// zoneRegion returns the region of zone, e.g. us-central1 for us-central1-a.
+func zoneRegion(zone string) string {
+	zone = path.Base(zone)
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
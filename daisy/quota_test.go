@@ -0,0 +1,157 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestPeakQuotaUsage(t *testing.T) {
+	w := testWorkflow()
+	var steps []*Step
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s, _ := w.NewStep(name)
+		steps = append(steps, s)
+	}
+	a, b, c, d, e := steps[0], steps[1], steps[2], steps[3], steps[4]
+	// a -> b -> d -> e, c runs concurrently with all of them.
+	w.Dependencies = map[string][]string{"b": {"a"}, "d": {"b"}, "e": {"d"}}
+
+	tests := []struct {
+		desc              string
+		demands           []quotaDemand
+		certain, possible float64
+	}{
+		{
+			"sequential",
+			[]quotaDemand{{amount: 4, creator: a, deleter: d}, {amount: 4, creator: e}},
+			4, 4,
+		},
+		{
+			"overlapping",
+			[]quotaDemand{{amount: 4, creator: a, deleter: d}, {amount: 4, creator: b}},
+			8, 8,
+		},
+		{
+			"concurrent branch",
+			[]quotaDemand{{amount: 4, creator: a, deleter: d}, {amount: 4, creator: b}, {amount: 2, creator: c}},
+			8, 10,
+		},
+		{
+			"same step",
+			[]quotaDemand{{amount: 1, creator: c}, {amount: 1, creator: c}},
+			2, 2,
+		},
+	}
+	for _, tt := range tests {
+		certain, possible := peakQuotaUsage(tt.demands)
+		if certain != tt.certain || possible != tt.possible {
+			t.Errorf("%s: got certain %g, possible %g, want %g, %g", tt.desc, certain, possible, tt.certain, tt.possible)
+		}
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("create")
+	mt := "projects/test-project/zones/test-zone/machineTypes/n1-standard-4"
+	s.CreateInstances = &CreateInstances{
+		{Instance: compute.Instance{Name: "i1", Zone: testZone, MachineType: mt}, Resource: Resource{Project: testProject}},
+		{Instance: compute.Instance{Name: "i2", Zone: testZone, MachineType: mt}, Resource: Resource{Project: testProject}},
+	}
+	var usage float64
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetMachineTypeFn = func(_, _, _ string) (*compute.MachineType, error) {
+		return &compute.MachineType{GuestCpus: 4}, nil
+	}
+	tc.GetRegionFn = func(project, region string) (*compute.Region, error) {
+		if project != testProject || region != "test" {
+			t.Errorf("unexpected region lookup %q, %q", project, region)
+		}
+		return &compute.Region{Quotas: []*compute.Quota{{Metric: "CPUS", Limit: 10, Usage: usage}}}, nil
+	}
+	tc.GetProjectFn = func(_ string) (*compute.Project, error) {
+		return &compute.Project{Quotas: []*compute.Quota{{Metric: "CPUS_ALL_REGIONS", Limit: 100}}}, nil
+	}
+
+	if err := w.checkQuotas(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	usage = 4
+	want := `not enough CPUS quota of project "test-project" in region "test": the workflow needs 8, 6 of 10 is available`
+	if err := w.checkQuotas(context.Background()); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+
+	w.SkipQuotaCheck = true
+	if err := w.checkQuotas(context.Background()); err != nil {
+		t.Errorf("unexpected error with SkipQuotaCheck: %v", err)
+	}
+}
+
+func TestCheckQuotasLookupErrors(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("create")
+	s.CreateInstances = &CreateInstances{
+		{Instance: compute.Instance{Name: "i1", Zone: testZone, MachineType: "projects/test-project/zones/test-zone/machineTypes/n1-standard-4"}, Resource: Resource{Project: testProject}},
+	}
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetMachineTypeFn = func(_, _, _ string) (*compute.MachineType, error) {
+		return &compute.MachineType{GuestCpus: 4}, nil
+	}
+	tc.GetRegionFn = func(_, _ string) (*compute.Region, error) {
+		return nil, errors.New("permission denied")
+	}
+	tc.GetProjectFn = func(_ string) (*compute.Project, error) {
+		return &compute.Project{Quotas: []*compute.Quota{nil, {Metric: "CPUS_ALL_REGIONS", Limit: 100}}}, nil
+	}
+
+	// Quotas that can't be looked up only cause a warning.
+	if err := w.checkQuotas(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	var warned bool
+	for _, e := range w.Logger.(*MockLogger).getEntries() {
+		if e.Severity == SeverityWarn && strings.Contains(e.Message, "permission denied") {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Error("lookup error was not logged as a warning")
+	}
+}
+
+func TestQuotaMetrics(t *testing.T) {
+	tests := []struct{ diskType, want string }{
+		{"projects/p/zones/z/diskTypes/pd-standard", "DISKS_TOTAL_GB"},
+		{"projects/p/zones/z/diskTypes/pd-ssd", "SSD_TOTAL_GB"},
+		{"projects/p/zones/z/diskTypes/local-ssd", "LOCAL_SSD_TOTAL_GB"},
+	}
+	for _, tt := range tests {
+		if got := diskMetric(tt.diskType); got != tt.want {
+			t.Errorf("diskMetric(%q) = %q, want %q", tt.diskType, got, tt.want)
+		}
+	}
+	if got := zoneRegion("projects/p/zones/us-central1-a"); got != "us-central1" {
+		t.Errorf("zoneRegion: got %q, want %q", got, "us-central1")
+	}
+}
@@ -15,6 +15,7 @@
 package daisy
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -26,9 +27,9 @@ type baseResourceRegistry struct {
 	m  map[string]*Resource
 	mx sync.Mutex
 
-	deleteFn func(res *Resource) dErr
-	startFn  func(res *Resource) dErr
-	stopFn   func(res *Resource) dErr
+	deleteFn func(ctx context.Context, res *Resource) dErr
+	startFn  func(ctx context.Context, res *Resource) dErr
+	stopFn   func(ctx context.Context, res *Resource) dErr
 	typeName string
 	urlRgx   *regexp.Regexp
 }
@@ -37,16 +38,24 @@ func (r *baseResourceRegistry) init() {
 	r.m = map[string]*Resource{}
 }
 
-func (r *baseResourceRegistry) cleanup() {
+func (r *baseResourceRegistry) cleanup(ctx context.Context) {
 	var wg sync.WaitGroup
 	for name, res := range r.m {
 		if res.NoCleanup || res.deleted {
 			continue
 		}
+		if r.w.keepForResume(res) {
+			r.w.LogWorkflowInfo("Keeping %s %q for a resumed run.", r.typeName, res.RealName)
+			continue
+		}
+		if r.w.keptOnFailure() {
+			r.w.LogWorkflowInfo("Keeping %s %q of the failed workflow.", r.typeName, res.RealName)
+			continue
+		}
 		wg.Add(1)
 		go func(name string) {
 			defer wg.Done()
-			if err := r.delete(name); err != nil && err.Type() != resourceDNEError {
+			if err := r.delete(ctx, name); err != nil && err.Type() != resourceDNEError {
 				fmt.Println(err)
 			}
 		}(name)
@@ -54,7 +63,7 @@ func (r *baseResourceRegistry) cleanup() {
 	wg.Wait()
 }
 
-func (r *baseResourceRegistry) delete(name string) dErr {
+func (r *baseResourceRegistry) delete(ctx context.Context, name string) dErr {
 	res, ok := r.get(name)
 	if !ok {
 		return errf("cannot delete %s %q; does not exist in registry", r.typeName, name)
@@ -73,14 +82,20 @@ func (r *baseResourceRegistry) delete(name string) dErr {
 	if res.deleted {
 		return errf("cannot delete %q; already deleted", name)
 	}
-	if err := r.deleteFn(res); err != nil {
+	release, err := r.w.acquire(ctx, r.typeName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := r.deleteFn(ctx, res); err != nil {
 		return err
 	}
 	res.deleted = true
+	r.w.emit(Event{Type: EventResourceDeleted, ResourceType: r.typeName, Resource: res.link})
 	return nil
 }
 
-func (r *baseResourceRegistry) start(name string) dErr {
+func (r *baseResourceRegistry) start(ctx context.Context, name string) dErr {
 	res, ok := r.get(name)
 	if !ok {
 		return errf("cannot start %s %q; does not exist in registry", r.typeName, name)
@@ -89,14 +104,19 @@ func (r *baseResourceRegistry) start(name string) dErr {
 	if !res.stopped {
 		return errf("cannot start %q; already started", name)
 	}
-	if err := r.startFn(res); err != nil {
+	release, err := r.w.acquire(ctx, r.typeName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := r.startFn(ctx, res); err != nil {
 		return err
 	}
 	res.stopped = false
 	return nil
 }
 
-func (r *baseResourceRegistry) stop(name string) dErr {
+func (r *baseResourceRegistry) stop(ctx context.Context, name string) dErr {
 	res, ok := r.get(name)
 	if !ok {
 		return errf("cannot stop %s %q; does not exist in registry", r.typeName, name)
@@ -105,7 +125,12 @@ func (r *baseResourceRegistry) stop(name string) dErr {
 	if res.stopped {
 		return errf("cannot stop %q; already stopped", name)
 	}
-	if err := r.stopFn(res); err != nil {
+	release, err := r.w.acquire(ctx, r.typeName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := r.stopFn(ctx, res); err != nil {
 		return err
 	}
 	res.stopped = true
@@ -129,7 +154,8 @@ func (r *baseResourceRegistry) regCreate(name string, res *Resource, s *Step, ov
 		return errf("cannot create %s %q; already created by step %q", r.typeName, name, res.creator.name)
 	}
 
-	if !overWrite {
+	// Resources created by steps that completed in a resumed run already exist.
+	if !overWrite && !r.w.stepDone(s) {
 		if exists, err := resourceExists(r.w.ComputeClient, res.link); err != nil {
 			return errf("cannot create %s %q; resource lookup error: %v", r.typeName, name, err)
 		} else if exists {
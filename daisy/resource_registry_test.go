@@ -15,6 +15,7 @@
 package daisy
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sync"
@@ -85,9 +86,10 @@ func TestResourceRegistryConcurrency(t *testing.T) {
 }
 
 func TestResourceRegistryDelete(t *testing.T) {
+	ctx := context.Background()
 	var deleteFnErr dErr
-	r := &baseResourceRegistry{m: map[string]*Resource{}}
-	r.deleteFn = func(r *Resource) dErr {
+	r := &baseResourceRegistry{w: testWorkflow(), m: map[string]*Resource{}}
+	r.deleteFn = func(_ context.Context, r *Resource) dErr {
 		return deleteFnErr
 	}
 
@@ -107,7 +109,7 @@ func TestResourceRegistryDelete(t *testing.T) {
 
 	for _, tt := range tests {
 		deleteFnErr = tt.deleteFnErr
-		err := r.delete(tt.input)
+		err := r.delete(ctx, tt.input)
 		if tt.shouldErr && err == nil {
 			t.Errorf("%s: should have erred but didn't", tt.desc)
 		} else if !tt.shouldErr && err != nil {
@@ -125,22 +127,23 @@ func TestResourceRegistryDelete(t *testing.T) {
 }
 
 func TestResourceRegistryStart(t *testing.T) {
+	ctx := context.Background()
 	var startFnErr dErr
 	var stopFnErr dErr
-	r := &baseResourceRegistry{m: map[string]*Resource{}}
-	r.startFn = func(r *Resource) dErr {
+	r := &baseResourceRegistry{w: testWorkflow(), m: map[string]*Resource{}}
+	r.startFn = func(_ context.Context, r *Resource) dErr {
 		return startFnErr
 	}
-	r.stopFn = func(r *Resource) dErr {
+	r.stopFn = func(_ context.Context, r *Resource) dErr {
 		return stopFnErr
 	}
 
 	r.m["foo"] = &Resource{}
 	r.m["baz"] = &Resource{}
 	r.m["stopped"] = &Resource{}
-	r.stop("stopped")
+	r.stop(ctx, "stopped")
 	r.m["keep_stopped"] = &Resource{}
-	r.stop("keep_stopped")
+	r.stop(ctx, "keep_stopped")
 
 	tests := []struct {
 		desc, input string
@@ -156,7 +159,7 @@ func TestResourceRegistryStart(t *testing.T) {
 
 	for _, tt := range tests {
 		startFnErr = tt.startFnErr
-		err := r.start(tt.input)
+		err := r.start(ctx, tt.input)
 		if tt.shouldErr && err == nil {
 			t.Errorf("%s: should have erred but didn't", tt.desc)
 		} else if !tt.shouldErr && err != nil {
@@ -176,9 +179,10 @@ func TestResourceRegistryStart(t *testing.T) {
 }
 
 func TestResourceRegistryStop(t *testing.T) {
+	ctx := context.Background()
 	var stopFnErr dErr
-	r := &baseResourceRegistry{m: map[string]*Resource{}}
-	r.stopFn = func(r *Resource) dErr {
+	r := &baseResourceRegistry{w: testWorkflow(), m: map[string]*Resource{}}
+	r.stopFn = func(_ context.Context, r *Resource) dErr {
 		return stopFnErr
 	}
 
@@ -198,7 +202,7 @@ func TestResourceRegistryStop(t *testing.T) {
 
 	for _, tt := range tests {
 		stopFnErr = tt.stopFnErr
-		err := r.stop(tt.input)
+		err := r.stop(ctx, tt.input)
 		if tt.shouldErr && err == nil {
 			t.Errorf("%s: should have erred but didn't", tt.desc)
 		} else if !tt.shouldErr && err != nil {
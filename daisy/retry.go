@@ -0,0 +1,171 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = "10s"
+	defaultRetryMaxBackoff     = "5m"
+)
+
+// Retry is a step retry policy. A failed attempt is retried if its error
+// matches any of ErrorTypes or ErrorMatches. If neither is set, every error is
+// retried, even one a retry won't fix. Resources created by the failed attempt
+// are deleted before the next one. The step Timeout covers all attempts.
+type Retry struct {
+	// Number of attempts, including the first one (default 3).
+	MaxAttempts int `json:",omitempty"`
+	// Time to wait before the first retry (default 10s). The wait doubles
+	// after each retry, up to MaxBackoff (default 5m).
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	InitialBackoff string `json:",omitempty"`
+	MaxBackoff     string `json:",omitempty"`
+	// Retryable error types, e.g. "APIError".
+	ErrorTypes []string `json:",omitempty"`
+	// Regexes matched against the error message.
+	ErrorMatches []string `json:",omitempty"`
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	errorMatches   []*regexp.Regexp
+}
+
+func (r *Retry) populate() dErr {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if r.InitialBackoff == "" {
+		r.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if r.MaxBackoff == "" {
+		r.MaxBackoff = defaultRetryMaxBackoff
+	}
+
+	var err error
+	if r.initialBackoff, err = time.ParseDuration(r.InitialBackoff); err != nil {
+		return newErr(err)
+	}
+	if r.maxBackoff, err = time.ParseDuration(r.MaxBackoff); err != nil {
+		return newErr(err)
+	}
+	r.errorMatches = nil
+	for _, m := range r.ErrorMatches {
+		rgx, err := regexp.Compile(m)
+		if err != nil {
+			return errf("invalid Retry ErrorMatches regex %q: %v", m, err)
+		}
+		r.errorMatches = append(r.errorMatches, rgx)
+	}
+	return nil
+}
+
+func (r *Retry) validate(s *Step) dErr {
+	if r.MaxAttempts < 1 {
+		return errf("Retry MaxAttempts must be at least 1, got %d", r.MaxAttempts)
+	}
+	if r.initialBackoff < 0 || r.maxBackoff < r.initialBackoff {
+		return errf("Retry backoffs must be positive and MaxBackoff not less than InitialBackoff")
+	}
+	for _, t := range r.ErrorTypes {
+		if !strIn(t, errorTypes) {
+			return errf("unknown Retry ErrorTypes entry %q, want one of: %s", t, strings.Join(errorTypes, ", "))
+		}
+	}
+	// Nested workflows clean up after themselves, their steps should be
+	// retried instead.
+	if s.IncludeWorkflow != nil || s.SubWorkflow != nil || s.ForEach != nil {
+		return errf("Retry is not supported on IncludeWorkflow, SubWorkflow and ForEach steps")
+	}
+	return nil
+}
+
+func (r *Retry) retryable(err dErr) bool {
+	if len(r.ErrorTypes) == 0 && len(r.errorMatches) == 0 {
+		return true
+	}
+	if strIn(err.Type(), r.ErrorTypes) {
+		return true
+	}
+	for _, rgx := range r.errorMatches {
+		if rgx.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// runImpl runs impl, retrying it according to s.Retry.
+func (s *Step) runImpl(ctx context.Context, impl stepImpl) dErr {
+	s.recordAttempt()
+	err := impl.run(ctx, s)
+	if err == nil || s.Retry == nil {
+		return err
+	}
+
+	st := s.typeName()
+	backoff := s.Retry.initialBackoff
+	for attempt := 1; attempt < s.Retry.MaxAttempts && err != nil && s.Retry.retryable(err); attempt++ {
+		s.w.logStep(SeverityWarn, s.name, st, "Attempt %d of %d failed, retrying in %s: %v", attempt, s.Retry.MaxAttempts, backoff, err)
+		if cErr := s.cleanupAttempt(ctx); cErr != nil {
+			return addErrs(err, errf("error cleaning up before retry: %v", cErr))
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > s.Retry.maxBackoff {
+			backoff = s.Retry.maxBackoff
+		}
+		s.recordAttempt()
+		err = impl.run(ctx, s)
+	}
+	return err
+}
+
+// cleanupAttempt deletes the resources created by a failed attempt of s. The
+// resources stay registered so the next attempt can create them again.
+func (s *Step) cleanupAttempt(ctx context.Context) dErr {
+	var errs dErr
+	for _, r := range s.w.resourceRegistries() {
+		var created []*Resource
+		r.mx.Lock()
+		for _, res := range r.m {
+			if res.creator == s && !res.deleted {
+				created = append(created, res)
+			}
+		}
+		r.mx.Unlock()
+
+		for _, res := range created {
+			release, err := s.w.acquire(ctx, r.typeName)
+			if err != nil {
+				return addErrs(errs, err)
+			}
+			if err := r.deleteFn(ctx, res); err != nil && err.Type() != resourceDNEError {
+				errs = addErrs(errs, err)
+			}
+			release()
+		}
+	}
+	return errs
+}
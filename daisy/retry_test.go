@@ -0,0 +1,187 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+)
+
+func TestRetryPopulate(t *testing.T) {
+	r := &Retry{ErrorMatches: []string{"EXHAUSTED"}}
+	if err := r.populate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.MaxAttempts != defaultRetryMaxAttempts || r.initialBackoff != 10*time.Second || r.maxBackoff != 5*time.Minute || len(r.errorMatches) != 1 {
+		t.Errorf("unexpected populated Retry: %+v", r)
+	}
+
+	for _, r := range []*Retry{{InitialBackoff: "foo"}, {MaxBackoff: "foo"}, {ErrorMatches: []string{"("}}} {
+		if err := r.populate(); err == nil {
+			t.Errorf("populating %+v should have failed", r)
+		}
+	}
+}
+
+func TestRetryValidate(t *testing.T) {
+	tests := []struct {
+		desc      string
+		r         *Retry
+		s         *Step
+		shouldErr bool
+	}{
+		{"good case", &Retry{MaxAttempts: 2, initialBackoff: time.Second, maxBackoff: time.Minute}, &Step{}, false},
+		{"bad MaxAttempts case", &Retry{MaxAttempts: -1}, &Step{}, true},
+		{"bad backoff case", &Retry{MaxAttempts: 2, initialBackoff: time.Minute, maxBackoff: time.Second}, &Step{}, true},
+		{"ErrorTypes case", &Retry{MaxAttempts: 2, ErrorTypes: []string{apiError, resourceDNEError}}, &Step{}, false},
+		{"unknown ErrorTypes case", &Retry{MaxAttempts: 2, ErrorTypes: []string{"APIErrors"}}, &Step{}, true},
+		{"IncludeWorkflow case", &Retry{MaxAttempts: 2}, &Step{IncludeWorkflow: &IncludeWorkflow{}}, true},
+		{"SubWorkflow case", &Retry{MaxAttempts: 2}, &Step{SubWorkflow: &SubWorkflow{}}, true},
+	}
+
+	for _, tt := range tests {
+		err := tt.r.validate(tt.s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestRetryRetryable(t *testing.T) {
+	tests := []struct {
+		desc string
+		r    *Retry
+		err  dErr
+		want bool
+	}{
+		{"retry everything case", &Retry{}, errf("foo"), true},
+		{"retry everything typed case", &Retry{}, typedErrf(fileIOError, "foo"), true},
+		{"type match case", &Retry{ErrorTypes: []string{apiError}}, typedErrf(apiError, "foo"), true},
+		{"type mismatch case", &Retry{ErrorTypes: []string{apiError}}, errf("foo"), false},
+		{"regex match case", &Retry{ErrorTypes: []string{apiError}, ErrorMatches: []string{"EXHAUSTED"}}, errf("ZONE_RESOURCE_POOL_EXHAUSTED"), true},
+		{"regex mismatch case", &Retry{ErrorMatches: []string{"EXHAUSTED"}}, errf("QUOTA_EXCEEDED"), false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.r.populate(); err != nil {
+			t.Fatalf("%s: error populating Retry: %v", tt.desc, err)
+		}
+		if got := tt.r.retryable(tt.err); got != tt.want {
+			t.Errorf("%s: got: %t, want: %t", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestRunImplRetry(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		desc         string
+		errs         []dErr
+		retry        *Retry
+		wantAttempts int
+		shouldErr    bool
+	}{
+		{"no retry case", []dErr{errf("fail")}, nil, 1, true},
+		{"success after retry case", []dErr{errf("fail"), nil}, &Retry{MaxAttempts: 3, InitialBackoff: "1ms"}, 2, false},
+		{"default retries any error case", []dErr{typedErrf(fileIOError, "fail"), errf("fail"), nil}, &Retry{InitialBackoff: "1ms"}, 3, false},
+		{"attempts exhausted case", []dErr{errf("fail"), errf("fail"), errf("fail")}, &Retry{MaxAttempts: 2, InitialBackoff: "1ms"}, 2, true},
+		{"not retryable case", []dErr{errf("fail"), nil}, &Retry{InitialBackoff: "1ms", ErrorTypes: []string{apiError}}, 1, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("s")
+		s.Retry = tt.retry
+		if s.Retry != nil {
+			if err := s.Retry.populate(); err != nil {
+				t.Fatalf("%s: error populating Retry: %v", tt.desc, err)
+			}
+		}
+		attempts := 0
+		impl := &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+			err := tt.errs[attempts]
+			attempts++
+			return err
+		}}
+
+		err := s.runImpl(ctx, impl)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if attempts != tt.wantAttempts {
+			t.Errorf("%s: got %d attempts, want %d", tt.desc, attempts, tt.wantAttempts)
+		}
+	}
+}
+
+func TestRunImplRetryCleanup(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	other, _ := w.NewStep("other")
+	s.Retry = &Retry{MaxAttempts: 2, InitialBackoff: "1ms"}
+	if err := s.Retry.populate(); err != nil {
+		t.Fatalf("error populating Retry: %v", err)
+	}
+
+	link := func(n string) string { return fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, n) }
+	w.disks.m = map[string]*Resource{
+		"created": {link: link("created"), creator: s},
+		"missing": {link: link("missing"), creator: s},
+		"other":   {link: link("other"), creator: other},
+	}
+	var deleted []string
+	w.ComputeClient.(*daisyCompute.TestClient).DeleteDiskFn = func(_, _, name string) error {
+		deleted = append(deleted, name)
+		if name == "missing" {
+			return typedErr(resourceDNEError, errors.New("not found"))
+		}
+		return nil
+	}
+
+	attempts := 0
+	impl := &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		attempts++
+		if attempts == 1 {
+			if len(deleted) != 0 {
+				t.Errorf("no disks should be deleted before the first attempt, deleted: %v", deleted)
+			}
+			return errf("fail")
+		}
+		return nil
+	}}
+
+	if err := s.runImpl(ctx, impl); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 2 || !strIn("created", deleted) || !strIn("missing", deleted) {
+		t.Errorf("only disks created by the step should be deleted between attempts, deleted: %v", deleted)
+	}
+	for n, res := range w.disks.m {
+		if res.deleted {
+			t.Errorf("disk %q should still be registered as not deleted", n)
+		}
+	}
+}
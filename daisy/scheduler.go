@@ -0,0 +1,129 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// resourceKinds are the resource kinds ConcurrencyLimits can limit, the type
+// names of the resource registries.
+var resourceKinds = []string{"disk", "firewallRule", "forwardingRule", "image", "instance", "network", "subnetwork", "targetInstance"}
+
+// Scheduler limits how many steps, and how many API operations per resource
+// kind, run at once. A workflow tree shares the Scheduler of its root
+// workflow, workflows that are given the same Scheduler share its limits.
+type Scheduler struct {
+	steps chan struct{}
+	kinds map[string]chan struct{}
+}
+
+// NewScheduler returns a Scheduler that runs at most maxSteps steps and, for
+// each resource kind in kindLimits ("disk", "image", "instance", ...), at most
+// that many creations, deletions, starts or stops of resources of the kind at
+// once. A limit of 0 means no limit.
+func NewScheduler(maxSteps int, kindLimits map[string]int) (*Scheduler, error) {
+	if err := checkConcurrency(maxSteps, kindLimits); err != nil {
+		return nil, err
+	}
+	s := &Scheduler{kinds: map[string]chan struct{}{}}
+	if maxSteps > 0 {
+		s.steps = make(chan struct{}, maxSteps)
+	}
+	for kind, n := range kindLimits {
+		if n > 0 {
+			s.kinds[kind] = make(chan struct{}, n)
+		}
+	}
+	return s, nil
+}
+
+// ParseConcurrencyLimits parses a comma separated list of per resource kind
+// limits in the form 'kind=n', e.g. "instance=5,disk=10".
+func ParseConcurrencyLimits(s string) (map[string]int, error) {
+	limits := map[string]int{}
+	if s == "" {
+		return limits, nil
+	}
+	for _, l := range strings.Split(s, ",") {
+		i := strings.Index(l, "=")
+		if i == -1 {
+			return nil, errf("bad concurrency limit %q, want 'kind=n'", l)
+		}
+		n, err := strconv.Atoi(l[i+1:])
+		if err != nil {
+			return nil, errf("bad concurrency limit %q: %v", l, err)
+		}
+		limits[l[:i]] = n
+	}
+	return limits, checkConcurrency(0, limits)
+}
+
+// SetScheduler makes w run with s instead of a Scheduler built from its
+// MaxConcurrentSteps and ConcurrencyLimits fields.
+func (w *Workflow) SetScheduler(s *Scheduler) {
+	w.scheduler = s
+}
+
+// acquireSlot waits for a free slot of sem and returns the func that frees
+// it. A nil sem has no limit.
+func acquireSlot(ctx context.Context, sem chan struct{}) (func(), dErr) {
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, newErr(ctx.Err())
+	}
+}
+
+// acquireStep waits until s may run. Steps that run other steps, like
+// IncludeWorkflow, SubWorkflow and ForEach, don't take a slot, their nested
+// steps do.
+func (w *Workflow) acquireStep(ctx context.Context, s *Step) (func(), dErr) {
+	sch := w.root().scheduler
+	if sch == nil || s.IncludeWorkflow != nil || s.SubWorkflow != nil || s.ForEach != nil {
+		return func() {}, nil
+	}
+	return acquireSlot(ctx, sch.steps)
+}
+
+// acquire waits until an operation on a resource of kind may run.
+func (w *Workflow) acquire(ctx context.Context, kind string) (func(), dErr) {
+	sch := w.root().scheduler
+	if sch == nil {
+		return func() {}, nil
+	}
+	return acquireSlot(ctx, sch.kinds[kind])
+}
+
+func checkConcurrency(maxSteps int, kindLimits map[string]int) dErr {
+	if maxSteps < 0 {
+		return errf("maximum number of concurrent steps must not be negative")
+	}
+	for kind, n := range kindLimits {
+		if !strIn(kind, resourceKinds) {
+			return errf("unknown resource kind %q in concurrency limits, want one of: %s", kind, strings.Join(resourceKinds, ", "))
+		}
+		if n < 0 {
+			return errf("concurrency limit of %q must not be negative", kind)
+		}
+	}
+	return nil
+}
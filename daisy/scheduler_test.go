@@ -0,0 +1,200 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResourceKinds(t *testing.T) {
+	var got []string
+	for _, r := range New().resourceRegistries() {
+		got = append(got, r.typeName)
+	}
+	sort.Strings(got)
+	if diffRes := diff(got, resourceKinds, 0); diffRes != "" {
+		t.Errorf("resourceKinds do not match the registries: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestParseConcurrencyLimits(t *testing.T) {
+	got, err := ParseConcurrencyLimits("instance=5,disk=10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diffRes := diff(got, map[string]int{"instance": 5, "disk": 10}, 0); diffRes != "" {
+		t.Errorf("limits do not match expectation: (-got +want)\n%s", diffRes)
+	}
+
+	for _, s := range []string{"instance", "instance=x", "vm=5", "disk=-1"} {
+		if _, err := ParseConcurrencyLimits(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestNewSchedulerErrors(t *testing.T) {
+	if _, err := NewScheduler(-1, nil); err == nil {
+		t.Error("expected error for negative MaxConcurrentSteps")
+	}
+	if _, err := NewScheduler(0, map[string]int{"vm": 1}); err == nil {
+		t.Error("expected error for unknown resource kind")
+	}
+}
+
+func TestSchedulerMaxConcurrentSteps(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.scheduler, _ = NewScheduler(2, nil)
+
+	var mx sync.Mutex
+	running, peak := 0, 0
+	run := func(ctx context.Context, s *Step) dErr {
+		mx.Lock()
+		if running++; running > peak {
+			peak = running
+		}
+		mx.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mx.Lock()
+		running--
+		mx.Unlock()
+		return nil
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s, _ := w.NewStep(name)
+		s.timeout = time.Minute
+		s.testType = &mockStep{runImpl: run}
+	}
+	if err := w.run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peak != 2 {
+		t.Errorf("got %d steps running at once, want 2", peak)
+	}
+}
+
+func TestSchedulerNestedSteps(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.scheduler, _ = NewScheduler(1, nil)
+	iw := w.NewIncludedWorkflow()
+	inc, _ := w.NewStep("inc")
+	inc.IncludeWorkflow = &IncludeWorkflow{Workflow: iw}
+
+	// The IncludeWorkflow step doesn't take the only slot from its steps.
+	release, err := w.acquireStep(ctx, inc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+	s, _ := iw.NewStep("s")
+	release2, err := iw.acquireStep(ctx, s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The slot is shared by the workflow tree.
+	tctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	s2, _ := w.NewStep("s2")
+	if _, err := w.acquireStep(tctx, s2); err == nil {
+		t.Error("expected the step slot to be taken")
+	}
+	release2()
+	if _, err := w.acquireStep(ctx, s2); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSchedulerRunStepWaiting(t *testing.T) {
+	w := testWorkflow()
+	w.scheduler, _ = NewScheduler(1, nil)
+	release, err := w.acquireStep(context.Background(), &Step{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var ran bool
+	s, _ := w.NewStep("s")
+	s.timeout = time.Minute
+	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		ran = true
+		return nil
+	}}
+
+	// A step that never got a slot fails instead of passing as succeeded.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	want := `step "s" was canceled`
+	if err := w.runStep(ctx, s); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if ran {
+		t.Error("step ran without a slot")
+	}
+
+	// The wait for a slot doesn't count towards the step timeout.
+	s.timeout = 10 * time.Millisecond
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		release()
+	}()
+	if err := w.runStep(context.Background(), s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !ran {
+		t.Error("step did not run once it got a slot")
+	}
+}
+
+func TestSchedulerConcurrencyLimits(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.scheduler, _ = NewScheduler(0, map[string]int{"instance": 1})
+
+	release, err := w.acquire(ctx, "instance")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Other kinds are not limited.
+	if _, err := w.acquire(ctx, "disk"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if _, err := w.acquire(tctx, "instance"); err == nil {
+		t.Error("expected the instance slot to be taken")
+	}
+	release()
+	if _, err := w.acquire(ctx, "instance"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateConcurrencyLimits(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{"s": {testType: &mockStep{}}}
+	w.ConcurrencyLimits = map[string]int{"vm": 1}
+	want := `error validating workflow: bad workflow concurrency limits: unknown resource kind "vm" in concurrency limits, want one of: ` +
+		"disk, firewallRule, forwardingRule, image, instance, network, subnetwork, targetInstance"
+	if err := testValidateErrors(w, want); err != nil {
+		t.Error(err)
+	}
+}
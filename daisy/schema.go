@@ -0,0 +1,393 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ghodss/yaml"
+	"google.golang.org/api/compute/v1"
+)
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// jsonSchema is the subset of JSON Schema used to describe workflow files.
+type jsonSchema struct {
+	Schema            string                 `json:"$schema,omitempty"`
+	Ref               string                 `json:"$ref,omitempty"`
+	Type              string                 `json:"type,omitempty"`
+	Pattern           string                 `json:"pattern,omitempty"`
+	Properties        map[string]*jsonSchema `json:"properties,omitempty"`
+	PatternProperties map[string]*jsonSchema `json:"patternProperties,omitempty"`
+	// Either false or a *jsonSchema.
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Definitions          map[string]*jsonSchema `json:"definitions,omitempty"`
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// Schema returns the JSON Schema of workflow files, as indented JSON. Like
+// encoding/json, the schema matches field names case-insensitively.
+func Schema() ([]byte, error) {
+	return json.MarshalIndent(workflowSchema(), "", "  ")
+}
+
+func workflowSchema() *jsonSchema {
+	g := &schemaGenerator{defs: map[string]*jsonSchema{}, names: map[reflect.Type]string{}}
+	s := g.schema(reflect.TypeOf(Workflow{}))
+	s.Schema = schemaDraft
+	s.Definitions = g.defs
+	return s
+}
+
+type schemaGenerator struct {
+	defs  map[string]*jsonSchema
+	names map[reflect.Type]string
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// Types with custom unmarshalling.
+	switch t {
+	case reflect.TypeOf(Var{}):
+		return &jsonSchema{AnyOf: []*jsonSchema{{Type: "string"}, g.structSchema(t)}}
+	case reflect.TypeOf(guestOsFeatures{}):
+		return &jsonSchema{AnyOf: []*jsonSchema{
+			{Type: "array", Items: &jsonSchema{Type: "string"}},
+			{Type: "array", Items: g.schema(reflect.TypeOf(compute.GuestOsFeature{}))},
+		}}
+	}
+	// Step unmarshals its fields, and the custom step types added below.
+	if (t.Implements(unmarshalerType) || reflect.PtrTo(t).Implements(unmarshalerType)) && t != reflect.TypeOf(Step{}) {
+		// Can't tell what a custom unmarshaller accepts.
+		return &jsonSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		name, ok := g.names[t]
+		if !ok {
+			name = t.String()
+			g.names[t] = name
+			// Registered before generating the fields, for recursive types.
+			g.defs[name] = nil
+			g.defs[name] = g.structSchema(t)
+		}
+		return &jsonSchema{Ref: "#/definitions/" + name}
+	}
+	return &jsonSchema{}
+}
+
+type schemaField struct {
+	name   string
+	schema *jsonSchema
+}
+
+// structSchema returns the schema of struct t, with the fields of embedded
+// structs inlined as encoding/json does.
+func (g *schemaGenerator) structSchema(t reflect.Type) *jsonSchema {
+	s := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}, PatternProperties: map[string]*jsonSchema{}, AdditionalProperties: false}
+	seen := map[string]bool{}
+	for _, f := range g.fields(t) {
+		// Shallower fields hide deeper ones, fields are sorted by depth.
+		if seen[strings.ToLower(f.name)] {
+			continue
+		}
+		seen[strings.ToLower(f.name)] = true
+		s.Properties[f.name] = f.schema
+		s.PatternProperties[caseInsensitivePattern(f.name)] = f.schema
+	}
+	if t == reflect.TypeOf(Step{}) {
+		for _, n := range registeredStepTypes() {
+			ns := g.schema(reflect.TypeOf(newStepType(n)))
+			s.Properties[n] = ns
+			s.PatternProperties[caseInsensitivePattern(n)] = ns
+		}
+	}
+	return s
+}
+
+// fields returns the JSON fields of struct t, the fields of embedded structs
+// last.
+func (g *schemaGenerator) fields(t reflect.Type) []schemaField {
+	var fields, embedded []schemaField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && opts[0] == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, g.fields(ft)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if opts[0] != "" {
+			name = opts[0]
+		}
+		var fs *jsonSchema
+		if strIn("string", opts[1:]) && ft.Kind() != reflect.String {
+			// Numbers encoded as strings, e.g. compute.Disk.SizeGb.
+			fs = &jsonSchema{Type: "string", Pattern: `^-?[0-9]+$`}
+		} else {
+			fs = g.schema(f.Type)
+		}
+		fields = append(fields, schemaField{name, fs})
+	}
+	return append(fields, embedded...)
+}
+
+// caseInsensitivePattern returns a regex matching name in any case, as
+// JSON Schema regexes have no case-insensitive flag.
+func caseInsensitivePattern(name string) string {
+	var b bytes.Buffer
+	b.WriteString("^")
+	for _, r := range name {
+		if u, l := unicode.ToUpper(r), unicode.ToLower(r); u != l {
+			fmt.Fprintf(&b, "[%c%c]", u, l)
+			continue
+		}
+		b.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// ValidateSchema checks the contents of a JSON or YAML workflow file against
+// the workflow Schema, without API access. It returns one error for each
+// problem, with the path of the offending value.
+func ValidateSchema(file string, data []byte) []error {
+	jsonData := data
+	if IsYAML(file) {
+		var err error
+		if jsonData, err = yaml.YAMLToJSON(data); err != nil {
+			return []error{YAMLError(file, data, err)}
+		}
+	}
+	d := json.NewDecoder(bytes.NewReader(jsonData))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return []error{JSONError(file, jsonData, err)}
+	}
+
+	root := workflowSchema()
+	sv := &schemaValidator{root: root, rgxs: map[string]*regexp.Regexp{}}
+	sv.validate(root, v, "")
+	for i, err := range sv.errs {
+		sv.errs[i] = fmt.Errorf("%s: %v", file, err)
+	}
+	return sv.errs
+}
+
+type schemaValidator struct {
+	root *jsonSchema
+	rgxs map[string]*regexp.Regexp
+	errs []error
+}
+
+func (sv *schemaValidator) match(pattern, s string) bool {
+	rgx, ok := sv.rgxs[pattern]
+	if !ok {
+		rgx = regexp.MustCompile(pattern)
+		sv.rgxs[pattern] = rgx
+	}
+	return rgx.MatchString(s)
+}
+
+func (sv *schemaValidator) errorf(path, format string, a ...interface{}) {
+	if path == "" {
+		path = "(root)"
+	}
+	sv.errs = append(sv.errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, a...)))
+}
+
+func (sv *schemaValidator) resolve(s *jsonSchema) *jsonSchema {
+	for s.Ref != "" {
+		s = sv.root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+	}
+	return s
+}
+
+func (sv *schemaValidator) validate(s *jsonSchema, v interface{}, path string) {
+	s = sv.resolve(s)
+	// Like encoding/json, null is accepted anywhere.
+	if v == nil {
+		return
+	}
+
+	if len(s.AnyOf) > 0 {
+		// Report the errors of the first form of the same type as v.
+		var errs []error
+		for _, as := range s.AnyOf {
+			asv := &schemaValidator{root: sv.root, rgxs: sv.rgxs}
+			asv.validate(as, v, path)
+			if len(asv.errs) == 0 {
+				return
+			}
+			if errs == nil && typeMatches(sv.resolve(as).Type, v) {
+				errs = asv.errs
+			}
+		}
+		if errs == nil {
+			sv.errorf(path, "value does not match any of the accepted forms, got %s", jsonTypeName(v))
+		}
+		sv.errs = append(sv.errs, errs...)
+		return
+	}
+
+	switch s.Type {
+	case "":
+		return
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			sv.errorf(path, "want a string, got %s", jsonTypeName(v))
+			return
+		}
+		if s.Pattern != "" && !sv.match(s.Pattern, str) {
+			sv.errorf(path, "%q does not match %s", str, s.Pattern)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			sv.errorf(path, "want a boolean, got %s", jsonTypeName(v))
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			sv.errorf(path, "want an integer, got %s", jsonTypeName(v))
+		} else if _, err := strconv.ParseInt(n.String(), 10, 64); err != nil {
+			sv.errorf(path, "want an integer, got %s", n)
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			sv.errorf(path, "want a number, got %s", jsonTypeName(v))
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			sv.errorf(path, "want an array, got %s", jsonTypeName(v))
+			return
+		}
+		for i, e := range a {
+			sv.validate(s.Items, e, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "object":
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			sv.errorf(path, "want an object, got %s", jsonTypeName(v))
+			return
+		}
+		var keys []string
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sv.validateProperty(s, k, o[k], path)
+		}
+	}
+}
+
+func (sv *schemaValidator) validateProperty(s *jsonSchema, k string, v interface{}, path string) {
+	p := k
+	if path != "" {
+		p = path + "." + k
+	}
+	if ps, ok := s.Properties[k]; ok {
+		sv.validate(ps, v, p)
+		return
+	}
+	for pattern, ps := range s.PatternProperties {
+		if sv.match(pattern, k) {
+			sv.validate(ps, v, p)
+			return
+		}
+	}
+	switch ap := s.AdditionalProperties.(type) {
+	case bool:
+		if !ap {
+			sv.errorf(p, "unknown field %q", k)
+		}
+	case *jsonSchema:
+		sv.validate(ap, v, p)
+	}
+}
+
+func typeMatches(schemaType string, v interface{}) bool {
+	switch v.(type) {
+	case string:
+		return schemaType == "string"
+	case bool:
+		return schemaType == "boolean"
+	case json.Number:
+		return schemaType == "integer" || schemaType == "number"
+	case []interface{}:
+		return schemaType == "array"
+	case map[string]interface{}:
+		return schemaType == "object"
+	}
+	return false
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case json.Number:
+		return "a number"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,137 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	b, err := Schema()
+	if err != nil {
+		t.Fatalf("error generating schema: %v", err)
+	}
+	var s jsonSchema
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+
+	if s.Ref != "#/definitions/daisy.Workflow" {
+		t.Errorf("unexpected root $ref: %q", s.Ref)
+	}
+	tests := []struct{ def, field string }{
+		{"daisy.Workflow", "Steps"},
+		{"daisy.Step", "CreateInstances"},
+		{"daisy.Step", "WaitForInstancesSignal"},
+		{"daisy.Step", "ForEach"},
+		// Registered custom step type.
+		{"daisy.Step", "TestStepType"},
+		// Embedded compute.Instance and Resource fields.
+		{"daisy.Instance", "machineType"},
+		{"daisy.Instance", "NoCleanup"},
+		{"daisy.Instance", "StartupScript"},
+		{"daisy.Disk", "sizeGb"},
+		{"daisy.Image", "guestOsFeatures"},
+	}
+	for _, tt := range tests {
+		def, ok := s.Definitions[tt.def]
+		if !ok {
+			t.Errorf("missing definition %q", tt.def)
+			continue
+		}
+		if _, ok := def.Properties[tt.field]; !ok {
+			t.Errorf("definition %q is missing field %q", tt.def, tt.field)
+		}
+		if _, ok := def.PatternProperties[caseInsensitivePattern(tt.field)]; !ok {
+			t.Errorf("definition %q is missing case-insensitive field %q", tt.def, tt.field)
+		}
+	}
+	// daisy.Disk.SizeGb hides compute.Disk.SizeGb, which is a number
+	// encoded as a string.
+	if p := s.Definitions["daisy.Disk"].Properties["sizeGb"]; p.Type != "string" || p.Pattern != "" {
+		t.Errorf("unexpected daisy.Disk sizeGb schema: %+v", p)
+	}
+}
+
+func TestCaseInsensitivePattern(t *testing.T) {
+	if got, want := caseInsensitivePattern("sizeGb-1.x"), `^[Ss][Ii][Zz][Ee][Gg][Bb]-1\.[Xx]$`; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		desc, file, data string
+		want             []string
+	}{
+		{"good case", "f.wf.json", `{"Name": "foo", "vars": {"a": "b", "c": {"Value": "d", "Required": true}}, "Steps": {"s": {"createDisks": [{"Name": "d", "SizeGb": "10"}]}}}`, nil},
+		{"good YAML case", "f.wf.yaml", "Name: foo\nSteps:\n  s:\n    CreateImages:\n    - Name: i\n      guestOsFeatures: [WINDOWS]\n", nil},
+		{"custom step type case", "f.wf.json", `{"Steps": {"s": {"testStepType": {"Message": "hi"}}}}`, nil},
+		{"unknown field case", "f.wf.json", `{"Name": "foo", "Stpes": {}}`, []string{`f.wf.json: Stpes: unknown field "Stpes"`}},
+		{
+			"nested cases", "f.wf.json",
+			`{"Steps": {"s": {"CreateDisks": [{"Name": "d", "SizeGb": 10, "Tpye": "pd-ssd"}], "Timout": "1m"}}, "Dependencies": {"s": [1]}}`,
+			[]string{
+				"f.wf.json: Dependencies.s[0]: want a string, got a number",
+				"f.wf.json: Steps.s.CreateDisks[0].SizeGb: want a string, got a number",
+				`f.wf.json: Steps.s.CreateDisks[0].Tpye: unknown field "Tpye"`,
+				`f.wf.json: Steps.s.Timout: unknown field "Timout"`,
+			},
+		},
+		{"Var case", "f.wf.json", `{"Vars": {"a": {"Value": "b", "Requried": true}, "c": 1}}`, []string{
+			`f.wf.json: Vars.a.Requried: unknown field "Requried"`,
+			"f.wf.json: Vars.c: value does not match any of the accepted forms, got a number",
+		}},
+		{"number as string case", "f.wf.json", `{"Steps": {"s": {"CreateInstances": [{"Name": "i", "Disks": [{"InitializeParams": {"DiskSizeGb": "ten"}}]}]}}}`, []string{
+			`f.wf.json: Steps.s.CreateInstances[0].Disks[0].InitializeParams.DiskSizeGb: "ten" does not match ^-?[0-9]+$`,
+		}},
+		{"custom step type field case", "f.wf.json", `{"Steps": {"s": {"TestStepType": {"Mesage": "hi"}}}}`, []string{
+			`f.wf.json: Steps.s.TestStepType.Mesage: unknown field "Mesage"`,
+		}},
+		{"included workflow case", "f.wf.json", `{"Steps": {"s": {"IncludeWorkflow": {"Path": "x", "Workflow": {"Stepz": {}}}}}}`, []string{
+			`f.wf.json: Steps.s.IncludeWorkflow.Workflow.Stepz: unknown field "Stepz"`,
+		}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, err := range ValidateSchema(tt.file, []byte(tt.data)) {
+			got = append(got, err.Error())
+		}
+		if diffRes := diff(got, tt.want, 0); diffRes != "" {
+			t.Errorf("%s: errors do not match expectation: (-got +want)\n%s", tt.desc, diffRes)
+		}
+	}
+}
+
+func TestValidateSchemaFiles(t *testing.T) {
+	for _, f := range []string{"./test_data/test.wf.json", "./test_data/test.wf.yaml"} {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Region is not a workflow field.
+		want := []string{f + `: region: unknown field "region"`}
+		var got []string
+		for _, err := range ValidateSchema(f, data) {
+			got = append(got, err.Error())
+		}
+		if diffRes := diff(got, want, 0); diffRes != "" {
+			t.Errorf("%s: errors do not match expectation: (-got +want)\n%s", f, diffRes)
+		}
+	}
+}
@@ -27,9 +27,7 @@ import (
 	"strings"
 	"sync"
 
-	"cloud.google.com/go/storage"
 	"google.golang.org/api/googleapi"
-	"google.golang.org/api/iterator"
 )
 
 type objectRegistry struct {
@@ -56,18 +54,16 @@ func (o *objectRegistry) regCreate(object string) dErr {
 var sourceVarRgx = regexp.MustCompile(`\$\{SOURCE:([^}]+)}`)
 
 func (w *Workflow) recursiveGCS(ctx context.Context, bkt, prefix, dst string) dErr {
-	it := w.StorageClient.Bucket(bkt).Objects(ctx, &storage.Query{Prefix: prefix})
-	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
-		if err != nil {
-			return typedErr(apiError, err)
-		}
+	objs, err := w.store().List(ctx, bkt, prefix)
+	if err != nil {
+		return typedErr(apiError, err)
+	}
+	for _, objAttr := range objs {
 		if objAttr.Size == 0 {
 			continue
 		}
-		srcPath := w.StorageClient.Bucket(bkt).Object(objAttr.Name)
 		o := path.Join(w.sourcesPath, dst, strings.TrimPrefix(objAttr.Name, prefix))
-		dstPath := w.StorageClient.Bucket(w.bucket).Object(o)
-		if _, err := dstPath.CopierFrom(srcPath).Run(ctx); err != nil {
+		if err := w.store().Copy(ctx, bkt, objAttr.Name, w.bucket, o); err != nil {
 			return typedErr(apiError, err)
 		}
 	}
@@ -86,20 +82,26 @@ func (w *Workflow) sourceContent(ctx context.Context, s string) (string, error)
 	}
 	// Try GCS file first.
 	if bkt, objPath, err := splitGCSPath(src); err == nil {
+		if err := w.checkStorePath(src); err != nil {
+			return "", err
+		}
 		if objPath == "" || strings.HasSuffix(objPath, "/") {
 			return "", errf("source %s appears to be a GCS 'bucket'", src)
 
 		}
-		src := w.StorageClient.Bucket(bkt).Object(objPath)
-		r, err := src.NewReader(ctx)
+		attrs, err := w.store().Attrs(ctx, bkt, objPath)
 		if err != nil {
 			return "", errf("error reading from file %s/%s: %v", bkt, objPath, err)
 		}
-		defer r.Close()
+		if attrs.Size > 1024 {
+			return "", errf("file size is too large %s/%s: %d", bkt, objPath, attrs.Size)
+		}
 
-		if r.Size() > 1024 {
-			return "", errf("file size is too large %s/%s: %d", bkt, objPath, r.Size())
+		r, err := w.store().NewReader(ctx, bkt, objPath)
+		if err != nil {
+			return "", errf("error reading from file %s/%s: %v", bkt, objPath, err)
 		}
+		defer r.Close()
 
 		var buf bytes.Buffer
 		if _, err := io.Copy(&buf, r); err != nil {
@@ -125,8 +127,7 @@ func (w *Workflow) sourceContent(ctx context.Context, s string) (string, error)
 
 func (w *Workflow) uploadFile(ctx context.Context, src, obj string) dErr {
 	obj = filepath.ToSlash(obj)
-	dstPath := w.StorageClient.Bucket(w.bucket).Object(path.Join(w.sourcesPath, obj))
-	gcs := dstPath.NewWriter(ctx)
+	gcs := w.store().NewWriter(ctx, w.bucket, path.Join(w.sourcesPath, obj), "")
 	f, err := os.Open(src)
 	if err != nil {
 		return newErr(err)
@@ -144,15 +145,16 @@ func (w *Workflow) uploadSources(ctx context.Context) dErr {
 		}
 		// GCS to GCS.
 		if bkt, objPath, err := splitGCSPath(origPath); err == nil {
+			if err := w.checkStorePath(origPath); err != nil {
+				return err
+			}
 			if objPath == "" || strings.HasSuffix(objPath, "/") {
 				if err := w.recursiveGCS(ctx, bkt, objPath, dst); err != nil {
 					return errf("error copying from bucket %s: %v", origPath, err)
 				}
 				continue
 			}
-			src := w.StorageClient.Bucket(bkt).Object(objPath)
-			dstPath := w.StorageClient.Bucket(w.bucket).Object(path.Join(w.sourcesPath, dst))
-			if _, err := dstPath.CopierFrom(src).Run(ctx); err != nil {
+			if err := w.store().Copy(ctx, bkt, objPath, w.bucket, path.Join(w.sourcesPath, dst)); err != nil {
 				if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 					return typedErrf(resourceDNEError, "error copying from file %s: %v", origPath, err)
 				}
@@ -41,6 +41,13 @@ type Step struct {
 	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
 	Timeout string `json:",omitempty"`
 	timeout time.Duration
+	// Condition under which the step runs, see evalCondition. If the condition
+	// is false the step is skipped: it is not validated or run, resources it
+	// would create are absent and its dependents run as if it succeeded.
+	If      string `json:",omitempty"`
+	skipped bool
+	// Retry policy for failed attempts of the step, see Retry.
+	Retry *Retry `json:",omitempty"`
 	// Only one of the below fields should exist for each instance of Step.
 	AttachDisks            *AttachDisks            `json:",omitempty"`
 	DetachDisks            *DetachDisks            `json:",omitempty"`
@@ -54,15 +61,25 @@ type Step struct {
 	CreateTargetInstances  *CreateTargetInstances  `json:",omitempty"`
 	CopyGCSObjects         *CopyGCSObjects         `json:",omitempty"`
 	ResizeDisks            *ResizeDisks            `json:",omitempty"`
+	RunLocalCommand        *RunLocalCommand        `json:",omitempty"`
 	StartInstances         *StartInstances         `json:",omitempty"`
 	StopInstances          *StopInstances          `json:",omitempty"`
 	DeleteResources        *DeleteResources        `json:",omitempty"`
 	DeprecateImages        *DeprecateImages        `json:",omitempty"`
+	ForEach                *ForEach                `json:",omitempty"`
 	IncludeWorkflow        *IncludeWorkflow        `json:",omitempty"`
 	SubWorkflow            *SubWorkflow            `json:",omitempty"`
 	WaitForInstancesSignal *WaitForInstancesSignal `json:",omitempty"`
+	// Custom step types, see RegisterStepType, by type name. Workflow files
+	// set them under the type name, like the built in types.
+	Custom map[string]StepImpl `json:"-"`
 	// Used for unit tests.
 	testType stepImpl
+	// The ForEach step this step runs an item for.
+	forEach *Step
+	// The list of an OnFailure or Finally step and its index in the list.
+	stage stepStage
+	index int
 }
 
 func (s *Step) stepImpl() (stepImpl, dErr) {
@@ -132,6 +149,10 @@ func (s *Step) stepImpl() (stepImpl, dErr) {
 		matchCount++
 		result = s.DeprecateImages
 	}
+	if s.ForEach != nil {
+		matchCount++
+		result = s.ForEach
+	}
 	if s.IncludeWorkflow != nil {
 		matchCount++
 		result = s.IncludeWorkflow
@@ -144,6 +165,14 @@ func (s *Step) stepImpl() (stepImpl, dErr) {
 		matchCount++
 		result = s.WaitForInstancesSignal
 	}
+	if s.RunLocalCommand != nil {
+		matchCount++
+		result = s.RunLocalCommand
+	}
+	for name, impl := range s.Custom {
+		matchCount++
+		result = &customStep{name: name, impl: impl}
+	}
 	if s.testType != nil {
 		matchCount++
 		result = s.testType
@@ -162,6 +191,11 @@ func (s *Step) depends(other *Step) bool {
 	if s == nil || other == nil || s.w == nil || s.w != other.w {
 		return false
 	}
+	// OnFailure and Finally steps run one after the other, after the steps
+	// of the DAG.
+	if s.stage != stageDAG || other.stage != stageDAG {
+		return other.stage < s.stage || (other.stage == s.stage && other.index < s.index)
+	}
 	deps := s.w.Dependencies
 	steps := s.w.Steps
 	q := deps[s.name]
@@ -211,30 +245,62 @@ func (s *Step) nestedDepends(other *Step) bool {
 }
 
 // getChain returns the step chain getting to a step. A link in the chain represents an IncludeWorkflow step, a
-// SubWorkflow step, or the step itself.
+// SubWorkflow step, a ForEach step followed by the step running one of its items, or the step itself.
 // For example, workflow A has a step s1 which includes workflow B. B has a step s2 which subworkflows C. Finally,
 // C has a step s3. s3.getChain() will return []*Step{s1, s2, s3}
 func (s *Step) getChain() []*Step {
 	if s == nil || s.w == nil {
 		return nil
 	}
+	if s.forEach != nil {
+		return append(s.forEach.getChain(), s)
+	}
 	if s.w.parent == nil {
 		return []*Step{s}
 	}
-	for _, st := range s.w.parent.Steps {
-		if st.IncludeWorkflow != nil && st.IncludeWorkflow.Workflow == s.w {
-			return append(st.getChain(), s)
+	if st := s.w.ownerStep(); st != nil {
+		return append(st.getChain(), s)
+	}
+	// We shouldn't get here.
+	return nil
+}
+
+// ownerStep returns the step of w.parent that runs w: an IncludeWorkflow or
+// SubWorkflow step, or the step running a ForEach item.
+func (w *Workflow) ownerStep() *Step {
+	if w.parent == nil {
+		return nil
+	}
+	steps := w.parent.finalSteps()
+	for _, st := range w.parent.Steps {
+		steps = append(steps, st)
+	}
+	for _, st := range steps {
+		candidates := []*Step{st}
+		if st.ForEach != nil {
+			candidates = st.ForEach.steps
 		}
-		if st.SubWorkflow != nil && st.SubWorkflow.Workflow == s.w {
-			return append(st.getChain(), s)
+		for _, c := range candidates {
+			if (c.IncludeWorkflow != nil && c.IncludeWorkflow.Workflow == w) || (c.SubWorkflow != nil && c.SubWorkflow.Workflow == w) {
+				return c
+			}
 		}
 	}
-	// We shouldn't get here.
 	return nil
 }
 
+// fullName returns the step name qualified by the names of the IncludeWorkflow and SubWorkflow steps that lead to it,
+// using the same chain as getChain. For the example above, s3.fullName() returns "s1.s2.s3".
+func (s *Step) fullName() string {
+	var names []string
+	for _, st := range s.getChain() {
+		names = append(names, st.name)
+	}
+	return strings.Join(names, ".")
+}
+
 func (s *Step) populate(ctx context.Context) dErr {
-	s.w.LogWorkflowInfo("Populating step %q", s.name)
+	s.w.logWorkflow(SeverityDebug, "Populating step %q", s.name)
 	impl, err := s.stepImpl()
 	if err != nil {
 		return s.wrapPopulateError(err)
@@ -245,23 +311,43 @@ func (s *Step) populate(ctx context.Context) dErr {
 	return err
 }
 
-func (s *Step) run(ctx context.Context) dErr {
+// typeName returns the name of the step type, e.g. "CreateDisks".
+func (s *Step) typeName() string {
 	impl, err := s.stepImpl()
 	if err != nil {
-		return s.wrapRunError(err)
+		return ""
+	}
+	if c, ok := impl.(*customStep); ok {
+		return c.name
 	}
-	var st string
-	if t := reflect.TypeOf(impl); t.Kind() == reflect.Ptr {
-		st = t.Elem().Name()
-	} else {
-		st = t.Name()
+	t := reflect.TypeOf(impl)
+	if t.Kind() == reflect.Ptr {
+		return t.Elem().Name()
+	}
+	return t.Name()
+}
+
+func (s *Step) run(ctx context.Context) (err dErr) {
+	s.recordStart()
+	defer func() { s.recordEnd(err) }()
+	ctx, sp := s.w.startSpan(ctx, s.fullName(), "step", "type", s.typeName())
+	defer func() { sp.finish(err) }()
+	s.emit(EventStepStarted, nil)
+	defer func() { s.emitEnd(ctx, err) }()
+	impl, err := s.stepImpl()
+	if err != nil {
+		return s.wrapRunError(err)
 	}
+	st := s.typeName()
 	s.w.LogWorkflowInfo("Running step %q (%s)", s.name, st)
-	if err = impl.run(ctx, s); err != nil {
+	if err = s.substituteOutputs(); err != nil {
+		return s.wrapRunError(err)
+	}
+	if err = s.runImpl(ctx, impl); err != nil {
 		return s.wrapRunError(err)
 	}
 	select {
-	case <-s.w.Cancel:
+	case <-ctx.Done():
 	default:
 		s.w.LogWorkflowInfo("Step %q (%s) successfully finished.", s.name, st)
 	}
@@ -269,7 +355,7 @@ func (s *Step) run(ctx context.Context) dErr {
 }
 
 func (s *Step) validate(ctx context.Context) dErr {
-	s.w.LogWorkflowInfo("Validating step %q", s.name)
+	s.w.logWorkflow(SeverityDebug, "Validating step %q", s.name)
 	if !rfc1035Rgx.MatchString(strings.ToLower(s.name)) {
 		return s.wrapValidateError(errf("step name must start with a letter and only contain letters, numbers, and hyphens"))
 	}
@@ -277,6 +363,17 @@ func (s *Step) validate(ctx context.Context) dErr {
 	if err != nil {
 		return s.wrapValidateError(err)
 	}
+	if s.skipped {
+		return nil
+	}
+	if s.Retry != nil {
+		if err = s.Retry.validate(s); err != nil {
+			return s.wrapValidateError(err)
+		}
+	}
+	if err = s.validateOutputRefs(); err != nil {
+		return s.wrapValidateError(err)
+	}
 	if err = impl.validate(ctx, s); err != nil {
 		return s.wrapValidateError(err)
 	}
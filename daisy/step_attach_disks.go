@@ -94,7 +94,7 @@ func (a *AttachDisks) validate(ctx context.Context, s *Step) (errs dErr) {
 func (a *AttachDisks) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*a))
 	for _, ad := range *a {
 		wg.Add(1)
 		go func(ad *AttachDisk) {
@@ -111,7 +111,7 @@ func (a *AttachDisks) run(ctx context.Context, s *Step) dErr {
 			}
 
 			w.LogStepInfo(s.name, "AttachDisks", "Attaching disk %q to instance %q.", ad.AttachedDisk.Source, inst)
-			if err := w.ComputeClient.AttachDisk(ad.project, ad.zone, ad.Instance, &ad.AttachedDisk); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).AttachDisk(ad.project, ad.zone, ad.Instance, &ad.AttachedDisk); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -126,7 +126,7 @@ func (a *AttachDisks) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		wg.Wait()
 		return nil
 	}
@@ -22,7 +22,6 @@ import (
 	"sync"
 
 	"cloud.google.com/go/storage"
-	"google.golang.org/api/iterator"
 )
 
 // CopyGCSObjects is a Daisy CopyGCSObject workflow step.
@@ -53,6 +52,12 @@ func (c *CopyGCSObjects) validate(ctx context.Context, s *Step) dErr {
 		if err != nil {
 			return err
 		}
+		if err := s.w.checkStorePath(co.Source); err != nil {
+			return err
+		}
+		if err := s.w.checkStorePath(co.Destination); err != nil {
+			return err
+		}
 
 		// Add object to object list.
 		if err := s.w.objects.regCreate(path.Join(dBkt, dObj)); err != nil {
@@ -62,7 +67,7 @@ func (c *CopyGCSObjects) validate(ctx context.Context, s *Step) dErr {
 		// Check if source bucket exists and is readable.
 		readableBkts.mx.Lock()
 		if !strIn(sBkt, readableBkts.bkts) {
-			if _, err := s.w.StorageClient.Bucket(sBkt).Attrs(ctx); err != nil {
+			if err := s.w.store().CheckBucket(ctx, sBkt); err != nil {
 				return errf("error reading bucket %q: %v", sBkt, err)
 			}
 			readableBkts.bkts = append(readableBkts.bkts, sBkt)
@@ -72,21 +77,21 @@ func (c *CopyGCSObjects) validate(ctx context.Context, s *Step) dErr {
 		// Check if destination bucket exists and is readable.
 		writableBkts.mx.Lock()
 		if !strIn(dBkt, writableBkts.bkts) {
-			if _, err := s.w.StorageClient.Bucket(dBkt).Attrs(ctx); err != nil {
+			if err := s.w.store().CheckBucket(ctx, dBkt); err != nil {
 				return errf("error reading bucket %q: %v", dBkt, err)
 			}
 
 			// Check if destination bucket is writable.
-			tObj := s.w.StorageClient.Bucket(dBkt).Object(fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id))
-			w := tObj.NewWriter(ctx)
+			tObj := fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id)
+			w := s.w.store().NewWriter(ctx, dBkt, tObj, "")
 			if _, err := w.Write(nil); err != nil {
 				return newErr(err)
 			}
 			if err := w.Close(); err != nil {
 				return errf("error writing to bucket %q: %v", dBkt, err)
 			}
-			if err := tObj.Delete(ctx); err != nil {
-				return errf("error deleting file %+v after write validation: %v", tObj, err)
+			if err := s.w.store().Delete(ctx, dBkt, tObj); err != nil {
+				return errf("error deleting file %s after write validation: %v", s.w.store().URL(dBkt, tObj), err)
 			}
 			writableBkts.bkts = append(writableBkts.bkts, dBkt)
 		}
@@ -103,17 +108,17 @@ func (c *CopyGCSObjects) validate(ctx context.Context, s *Step) dErr {
 			}
 
 			// Test ACLRule.Entity.
-			tObj := s.w.StorageClient.Bucket(dBkt).Object(fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id))
-			w := tObj.NewWriter(ctx)
+			tObj := fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id)
+			w := s.w.store().NewWriter(ctx, dBkt, tObj, "")
 			if _, err := w.Write(nil); err != nil {
 				return newErr(err)
 			}
 			if err := w.Close(); err != nil {
 				return newErr(err)
 			}
-			defer tObj.Delete(ctx)
+			defer s.w.store().Delete(ctx, dBkt, tObj)
 
-			if err := tObj.ACL().Set(ctx, acl.Entity, acl.Role); err != nil {
+			if err := s.w.store().SetACL(ctx, dBkt, tObj, acl.Entity, acl.Role); err != nil {
 				return errf("error validating ACLRule %+v: %v", acl, err)
 			}
 		}
@@ -123,23 +128,21 @@ func (c *CopyGCSObjects) validate(ctx context.Context, s *Step) dErr {
 }
 
 func recursiveGCS(ctx context.Context, w *Workflow, sBkt, sPrefix, dBkt, dPrefix string, acls []*storage.ACLRule) dErr {
-	it := w.StorageClient.Bucket(sBkt).Objects(ctx, &storage.Query{Prefix: sPrefix})
-	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
-		if err != nil {
-			return typedErr(apiError, err)
-		}
+	objs, err := w.store().List(ctx, sBkt, sPrefix)
+	if err != nil {
+		return typedErr(apiError, err)
+	}
+	for _, objAttr := range objs {
 		if objAttr.Size == 0 {
 			continue
 		}
-		srcPath := w.StorageClient.Bucket(sBkt).Object(objAttr.Name)
 		o := path.Join(dPrefix, strings.TrimPrefix(objAttr.Name, sPrefix))
-		dstPath := w.StorageClient.Bucket(dBkt).Object(o)
-		if _, err := dstPath.CopierFrom(srcPath).Run(ctx); err != nil {
+		if err := w.store().Copy(ctx, sBkt, objAttr.Name, dBkt, o); err != nil {
 			return typedErr(apiError, err)
 		}
 
 		for _, acl := range acls {
-			if err := dstPath.ACL().Set(ctx, acl.Entity, acl.Role); err != nil {
+			if err := w.store().SetACL(ctx, dBkt, o, acl.Entity, acl.Role); err != nil {
 				return typedErr(apiError, err)
 			}
 		}
@@ -149,7 +152,6 @@ func recursiveGCS(ctx context.Context, w *Workflow, sBkt, sPrefix, dBkt, dPrefix
 
 func (c *CopyGCSObjects) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
-	w := s.w
 	e := make(chan dErr)
 	for _, co := range *c {
 		wg.Add(1)
@@ -174,14 +176,12 @@ func (c *CopyGCSObjects) run(ctx context.Context, s *Step) dErr {
 				return
 			}
 
-			src := s.w.StorageClient.Bucket(sBkt).Object(sObj)
-			dstPath := s.w.StorageClient.Bucket(dBkt).Object(dObj)
-			if _, err := dstPath.CopierFrom(src).Run(ctx); err != nil {
+			if err := s.w.store().Copy(ctx, sBkt, sObj, dBkt, dObj); err != nil {
 				e <- errf("error copying from %s to %s: %v", co.Source, co.Destination, err)
 				return
 			}
 			for _, acl := range co.ACLRules {
-				if err := dstPath.ACL().Set(ctx, acl.Entity, acl.Role); err != nil {
+				if err := s.w.store().SetACL(ctx, dBkt, dObj, acl.Entity, acl.Role); err != nil {
 					e <- errf("error setting ACLRule on %s: %v", co.Destination, err)
 					return
 				}
@@ -197,7 +197,7 @@ func (c *CopyGCSObjects) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		return nil
 	}
 }
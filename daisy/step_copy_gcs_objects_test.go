@@ -97,6 +97,7 @@ func TestCopyGCSObjectsValidate(t *testing.T) {
 		{{Source: "gs://bucket2", Destination: "gs://bucket1"}},
 		{{Source: "gs://bucket1", Destination: "gs://bucket2"}},
 		{{Source: "gs://bucket1", Destination: "gs://bucket3"}},
+		{{Source: "gs://bucket1", Destination: "file:///bucket1"}},
 		{{Source: "gs://bucket1", Destination: "gs://bucket1", ACLRules: []*storage.ACLRule{{Role: "owner"}}}},
 		{{Source: "gs://bucket1", Destination: "gs://bucket1", ACLRules: []*storage.ACLRule{{Entity: "allUsers", Role: "owner"}}}},
 		{{Source: "gs://bucket1", Destination: "gs://bucket1", ACLRules: []*storage.ACLRule{{Entity: "someUser", Role: "OWNER"}}}},
@@ -41,7 +41,7 @@ func (c *CreateDisks) validate(ctx context.Context, s *Step) dErr {
 func (c *CreateDisks) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*c))
 	for _, d := range *c {
 		wg.Add(1)
 		go func(cd *Disk) {
@@ -54,8 +54,15 @@ func (c *CreateDisks) run(ctx context.Context, s *Step) dErr {
 				}
 			}
 
+			release, err := w.acquire(ctx, "disk")
+			if err != nil {
+				e <- err
+				return
+			}
+			defer release()
+
 			w.LogStepInfo(s.name, "CreateDisks", "Creating disk %q.", cd.Name)
-			if err := w.ComputeClient.CreateDisk(cd.Project, cd.Zone, &cd.Disk); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).CreateDisk(cd.Project, cd.Zone, &cd.Disk); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -70,7 +77,7 @@ func (c *CreateDisks) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		// Wait so disks being created now can be deleted.
 		wg.Wait()
 		return nil
@@ -41,7 +41,7 @@ func (c *CreateFirewallRules) validate(ctx context.Context, s *Step) dErr {
 func (c *CreateFirewallRules) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*c))
 	for _, fir := range *c {
 		wg.Add(1)
 		go func(fir *FirewallRule) {
@@ -51,8 +51,15 @@ func (c *CreateFirewallRules) run(ctx context.Context, s *Step) dErr {
 				fir.Network = networkRes.link
 			}
 
+			release, err := w.acquire(ctx, "firewallRule")
+			if err != nil {
+				e <- err
+				return
+			}
+			defer release()
+
 			w.LogStepInfo(s.name, "CreateFirewallRules", "Creating firewall rule %q.", fir.Name)
-			if err := w.ComputeClient.CreateFirewallRule(fir.Project, &fir.Firewall); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).CreateFirewallRule(fir.Project, &fir.Firewall); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -67,7 +74,7 @@ func (c *CreateFirewallRules) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		// Wait so firewall rules being created now can be deleted.
 		wg.Wait()
 		return nil
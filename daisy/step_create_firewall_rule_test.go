@@ -84,7 +84,7 @@ func TestCreateFirewallRulesRun(t *testing.T) {
 	e := errf("error")
 
 	wantFirewallRule := compute.Firewall{}
-	wantFirewallRule.Description = "FirewallRule created by Daisy in workflow \"test-wf\" on behalf of ."
+	wantFirewallRule.Description = "FirewallRule created by Daisy in workflow \"test-wf\" on behalf of . [daisy-workflow-id=abcdef daisy-workflow-name=test-wf]"
 	wantFirewallRule.Name = "test-wf-abcdef"
 	wantFirewallRule.Network = "projects/test-project/global/networks/bar"
 
@@ -41,14 +41,21 @@ func (c *CreateForwardingRules) validate(ctx context.Context, s *Step) dErr {
 func (c *CreateForwardingRules) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*c))
 	for _, fr := range *c {
 		wg.Add(1)
 		go func(fr *ForwardingRule) {
 			defer wg.Done()
 
+			release, err := w.acquire(ctx, "forwardingRule")
+			if err != nil {
+				e <- err
+				return
+			}
+			defer release()
+
 			w.LogStepInfo(s.name, "CreateForwardingRules", "Creating forwarding-rule %q.", fr.Name)
-			if err := w.ComputeClient.CreateForwardingRule(fr.Project, fr.Region, &fr.ForwardingRule); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).CreateForwardingRule(fr.Project, fr.Region, &fr.ForwardingRule); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -63,7 +70,7 @@ func (c *CreateForwardingRules) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		// Wait so forwarding-rules being created now can be deleted.
 		wg.Wait()
 		return nil
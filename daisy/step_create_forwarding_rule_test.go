@@ -31,7 +31,7 @@ func TestCreateForwardingRulesRun(t *testing.T) {
 	e := errf("error")
 
 	wantForwardingRule := compute.ForwardingRule{}
-	wantForwardingRule.Description = "ForwardingRule created by Daisy in workflow \"test-wf\" on behalf of ."
+	wantForwardingRule.Description = "ForwardingRule created by Daisy in workflow \"test-wf\" on behalf of . [daisy-workflow-id=abcdef daisy-workflow-name=test-wf]"
 	wantForwardingRule.Name = "test-wf-abcdef"
 	wantForwardingRule.Target = "projects/test-project/zones/test-zone/targetInstances/"
 	wantForwardingRule.Region = "test-zo"
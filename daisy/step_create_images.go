@@ -46,7 +46,7 @@ func (c *CreateImages) validate(ctx context.Context, s *Step) dErr {
 func (c *CreateImages) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*c))
 	for _, i := range *c {
 		wg.Add(1)
 		go func(ci *Image) {
@@ -56,10 +56,17 @@ func (c *CreateImages) run(ctx context.Context, s *Step) dErr {
 				ci.SourceDisk = d.link
 			}
 
+			release, err := w.acquire(ctx, "image")
+			if err != nil {
+				e <- err
+				return
+			}
+			defer release()
+
 			// Delete existing if OverWrite is true.
 			if ci.OverWrite {
 				// Just try to delete it, a 404 here indicates the image doesn't exist.
-				if err := w.ComputeClient.DeleteImage(ci.Project, ci.Name); err != nil {
+				if err := w.ComputeClient.WithContext(ctx).DeleteImage(ci.Project, ci.Name); err != nil {
 					if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
 						e <- errf("error deleting existing image: %v", err)
 						return
@@ -68,7 +75,7 @@ func (c *CreateImages) run(ctx context.Context, s *Step) dErr {
 			}
 
 			w.LogStepInfo(s.name, "CreateImages", "Creating image %q.", ci.Name)
-			if err := w.ComputeClient.CreateImage(ci.Project, &ci.Image); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).CreateImage(ci.Project, &ci.Image); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -83,7 +90,7 @@ func (c *CreateImages) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		// Wait so images being created now will complete before we try to clean them up.
 		wg.Wait()
 		return nil
@@ -41,40 +41,39 @@ func logSerialOutput(ctx context.Context, s *Step, i *Instance, port int64, inte
 Loop:
 	for {
 		select {
-		case <-w.Cancel:
+		case <-ctx.Done():
 			break Loop
 		case <-tick:
-			resp, err := w.ComputeClient.GetSerialPortOutput(path.Base(i.Project), path.Base(i.Zone), i.Name, port, start)
+			resp, err := w.ComputeClient.WithContext(ctx).GetSerialPortOutput(path.Base(i.Project), path.Base(i.Zone), i.Name, port, start)
 			if err != nil {
 				// Instance is stopped or stopping.
-				status, sErr := w.ComputeClient.InstanceStatus(path.Base(i.Project), path.Base(i.Zone), i.Name)
+				status, sErr := w.ComputeClient.WithContext(ctx).InstanceStatus(path.Base(i.Project), path.Base(i.Zone), i.Name)
 				switch status {
 				case "TERMINATED", "STOPPED", "STOPPING":
 					if sErr == nil {
 						break Loop
 					}
 				}
-				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error getting serial port: %v", i.Name, err)
+				w.logStep(SeverityWarn, s.name, "CreateInstances", "Instance %q: error getting serial port: %v", i.Name, err)
 				break Loop
 			}
 			start = resp.Next
 			buf.WriteString(resp.Contents)
-			wc := w.StorageClient.Bucket(w.bucket).Object(logsObj).NewWriter(ctx)
-			wc.ContentType = "text/plain"
-			if _, err := wc.Write(buf.Bytes()); err != nil && !gcsErr {
+			wc := w.store().NewWriter(ctx, w.bucket, logsObj, "text/plain")
+			if _, err := wc.Write([]byte(w.Redact(buf.String()))); err != nil && !gcsErr {
 				gcsErr = true
-				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error writing log to GCS: %v", i.Name, err)
+				w.logStep(SeverityWarn, s.name, "CreateInstances", "Instance %q: error writing log to GCS: %v", i.Name, err)
 				continue
 			}
 			if err := wc.Close(); err != nil && !gcsErr {
 				gcsErr = true
-				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error saving log to GCS: %v", i.Name, err)
+				w.logStep(SeverityWarn, s.name, "CreateInstances", "Instance %q: error saving log to GCS: %v", i.Name, err)
 				continue
 			}
 		}
 	}
 
-	w.Logger.WriteSerialPortLogs(w, i.Name, buf)
+	w.Logger.WriteSerialPortLogs(w, i.Name, *bytes.NewBufferString(w.Redact(buf.String())))
 }
 
 // populate preprocesses fields: Name, Project, Zone, Description, MachineType, NetworkInterfaces, Scopes, ServiceAccounts, and daisyName.
@@ -99,7 +98,7 @@ func (c *CreateInstances) validate(ctx context.Context, s *Step) dErr {
 func (c *CreateInstances) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	eChan := make(chan dErr)
+	eChan := make(chan dErr, len(*c))
 	for _, ci := range *c {
 		wg.Add(1)
 		go func(i *Instance) {
@@ -125,12 +124,23 @@ func (c *CreateInstances) run(ctx context.Context, s *Step) dErr {
 				}
 			}
 
+			release, err := w.acquire(ctx, "instance")
+			if err != nil {
+				eChan <- err
+				return
+			}
+			defer release()
+
 			w.LogStepInfo(s.name, "CreateInstances", "Creating instance %q.", i.Name)
-			if err := w.ComputeClient.CreateInstance(i.Project, i.Zone, &i.Instance); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).CreateInstance(i.Project, i.Zone, &i.Instance); err != nil {
 				eChan <- newErr(err)
 				return
 			}
-			go logSerialOutput(ctx, s, i, 1, 3*time.Second)
+			// There is no serial port output to stream when planning.
+			// The output is streamed until the workflow ends, not just this step.
+			if _, ok := w.ComputeClient.(*planClient); !ok {
+				go logSerialOutput(w.runContext(), s, i, 1, 3*time.Second)
+			}
 		}(ci)
 	}
 
@@ -142,7 +152,7 @@ func (c *CreateInstances) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-eChan:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		// Wait so instances being created now can be deleted.
 		wg.Wait()
 		return nil
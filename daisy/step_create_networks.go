@@ -41,14 +41,21 @@ func (c *CreateNetworks) validate(ctx context.Context, s *Step) dErr {
 func (c *CreateNetworks) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*c))
 	for _, n := range *c {
 		wg.Add(1)
 		go func(n *Network) {
 			defer wg.Done()
 
+			release, err := w.acquire(ctx, "network")
+			if err != nil {
+				e <- err
+				return
+			}
+			defer release()
+
 			w.LogStepInfo(s.name, "CreateNetworks", "Creating network %q.", n.Name)
-			if err := w.ComputeClient.CreateNetwork(n.Project, &n.Network); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).CreateNetwork(n.Project, &n.Network); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -63,7 +70,7 @@ func (c *CreateNetworks) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		// Wait so networks being created now can be deleted.
 		wg.Wait()
 		return nil
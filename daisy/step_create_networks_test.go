@@ -31,7 +31,7 @@ func TestCreateNetworksRun(t *testing.T) {
 	e := errf("error")
 
 	wantNetwork := compute.Network{}
-	wantNetwork.Description = "Network created by Daisy in workflow \"test-wf\" on behalf of ."
+	wantNetwork.Description = "Network created by Daisy in workflow \"test-wf\" on behalf of . [daisy-workflow-id=abcdef daisy-workflow-name=test-wf]"
 	wantNetwork.Name = "test-wf-abcdef"
 
 	tests := []struct {
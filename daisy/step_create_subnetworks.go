@@ -41,7 +41,7 @@ func (c *CreateSubnetworks) validate(ctx context.Context, s *Step) dErr {
 func (c *CreateSubnetworks) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*c))
 	for _, sn := range *c {
 		wg.Add(1)
 		go func(sn *Subnetwork) {
@@ -51,8 +51,15 @@ func (c *CreateSubnetworks) run(ctx context.Context, s *Step) dErr {
 				sn.Network = networkRes.link
 			}
 
+			release, err := w.acquire(ctx, "subnetwork")
+			if err != nil {
+				e <- err
+				return
+			}
+			defer release()
+
 			w.LogStepInfo(s.name, "CreateSubnetworks", "Creating subnetwork %q.", sn.Name)
-			if err := w.ComputeClient.CreateSubnetwork(sn.Project, sn.Region, &sn.Subnetwork); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).CreateSubnetwork(sn.Project, sn.Region, &sn.Subnetwork); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -67,7 +74,7 @@ func (c *CreateSubnetworks) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		// Wait so subnetworks being created now can be deleted.
 		wg.Wait()
 		return nil
@@ -31,7 +31,7 @@ func TestCreateSubnetworksRun(t *testing.T) {
 	e := errf("error")
 
 	wantSubnetwork := compute.Subnetwork{}
-	wantSubnetwork.Description = "Subnetwork created by Daisy in workflow \"test-wf\" on behalf of ."
+	wantSubnetwork.Description = "Subnetwork created by Daisy in workflow \"test-wf\" on behalf of . [daisy-workflow-id=abcdef daisy-workflow-name=test-wf]"
 	wantSubnetwork.Name = "test-wf-abcdef"
 
 	tests := []struct {
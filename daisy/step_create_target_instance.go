@@ -41,14 +41,21 @@ func (c *CreateTargetInstances) validate(ctx context.Context, s *Step) dErr {
 func (c *CreateTargetInstances) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*c))
 	for _, ti := range *c {
 		wg.Add(1)
 		go func(ti *TargetInstance) {
 			defer wg.Done()
 
+			release, err := w.acquire(ctx, "targetInstance")
+			if err != nil {
+				e <- err
+				return
+			}
+			defer release()
+
 			w.LogStepInfo(s.name, "CreateTargetInstances", "Creating target instance %q.", ti.Name)
-			if err := w.ComputeClient.CreateTargetInstance(ti.Project, ti.Zone, &ti.TargetInstance); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).CreateTargetInstance(ti.Project, ti.Zone, &ti.TargetInstance); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -63,7 +70,7 @@ func (c *CreateTargetInstances) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		// Wait so target instances being created now can be deleted.
 		wg.Wait()
 		return nil
@@ -31,7 +31,7 @@ func TestCreateTargetInstancesRun(t *testing.T) {
 	e := errf("error")
 
 	wantTargetInstance := compute.TargetInstance{}
-	wantTargetInstance.Description = "TargetInstance created by Daisy in workflow \"test-wf\" on behalf of ."
+	wantTargetInstance.Description = "TargetInstance created by Daisy in workflow \"test-wf\" on behalf of . [daisy-workflow-id=abcdef daisy-workflow-name=test-wf]"
 	wantTargetInstance.Name = "test-wf-abcdef"
 	wantTargetInstance.Instance = "projects/test-project/zones/test-zone/instances/"
 	wantTargetInstance.Zone = "test-zone"
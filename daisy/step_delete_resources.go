@@ -21,10 +21,8 @@ import (
 	"strings"
 	"sync"
 
-	"cloud.google.com/go/storage"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
-	"google.golang.org/api/iterator"
 )
 
 // DeleteResources deletes GCE/GCS resources.
@@ -97,7 +95,7 @@ func (d *DeleteResources) validateInstance(i string, s *Step) dErr {
 
 func (d *DeleteResources) checkError(err dErr, s *Step) dErr {
 	if err != nil && err.Type() == resourceDNEError {
-		s.w.LogStepInfo(s.name, "DeleteResources", "WARNING: Error validating deletion: %v", err)
+		s.w.logStep(SeverityWarn, s.name, "DeleteResources", "Error validating deletion: %v", err)
 		return nil
 	} else if err != nil && err.Type() == imageObsoleteDeletedError {
 		return nil
@@ -147,24 +145,27 @@ func (d *DeleteResources) validate(ctx context.Context, s *Step) dErr {
 		if err != nil {
 			return err
 		}
+		if err := s.w.checkStorePath(p); err != nil {
+			return err
+		}
 
 		// Check if bucket exists and is writeable.
 		writableBkts.mx.Lock()
 		if !strIn(bkt, writableBkts.bkts) {
-			if _, err := s.w.StorageClient.Bucket(bkt).Attrs(ctx); err != nil {
+			if err := s.w.store().CheckBucket(ctx, bkt); err != nil {
 				return errf("error reading bucket %q: %v", bkt, err)
 			}
 
-			tObj := s.w.StorageClient.Bucket(bkt).Object(fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id))
-			w := tObj.NewWriter(ctx)
+			tObj := fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id)
+			w := s.w.store().NewWriter(ctx, bkt, tObj, "")
 			if _, err := w.Write(nil); err != nil {
 				return newErr(err)
 			}
 			if err := w.Close(); err != nil {
 				return errf("error writing to bucket %q: %v", bkt, err)
 			}
-			if err := tObj.Delete(ctx); err != nil {
-				return errf("error deleting file %+v after write validation: %v", tObj, err)
+			if err := s.w.store().Delete(ctx, bkt, tObj); err != nil {
+				return errf("error deleting file %s after write validation: %v", s.w.store().URL(bkt, tObj), err)
 			}
 			writableBkts.bkts = append(writableBkts.bkts, bkt)
 		}
@@ -175,15 +176,15 @@ func (d *DeleteResources) validate(ctx context.Context, s *Step) dErr {
 }
 
 func recursiveGCSDelete(ctx context.Context, w *Workflow, bkt, prefix string) dErr {
-	it := w.StorageClient.Bucket(bkt).Objects(ctx, &storage.Query{Prefix: prefix})
-	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
-		if err != nil {
-			return typedErr(apiError, err)
-		}
+	objs, err := w.store().List(ctx, bkt, prefix)
+	if err != nil {
+		return typedErr(apiError, err)
+	}
+	for _, objAttr := range objs {
 		if objAttr.Size == 0 {
 			continue
 		}
-		if err := w.StorageClient.Bucket(bkt).Object(objAttr.Name).Delete(ctx); err != nil {
+		if err := w.store().Delete(ctx, bkt, objAttr.Name); err != nil {
 			return typedErr(apiError, err)
 		}
 	}
@@ -192,7 +193,7 @@ func recursiveGCSDelete(ctx context.Context, w *Workflow, bkt, prefix string) dE
 
 // Waits for the whole group to run. Monitors for error and cancels.
 // Returns true if error should be raised, false otherwise.
-func waitGroup(wg *sync.WaitGroup, e chan dErr, w *Workflow) (bool, dErr) {
+func waitGroup(ctx context.Context, wg *sync.WaitGroup, e chan dErr) (bool, dErr) {
 	go func() {
 		wg.Wait()
 		e <- nil
@@ -203,7 +204,7 @@ func waitGroup(wg *sync.WaitGroup, e chan dErr, w *Workflow) (bool, dErr) {
 		if err != nil {
 			return true, err
 		}
-	case <-w.Cancel:
+	case <-ctx.Done():
 		return true, nil
 	}
 	return false, nil
@@ -219,9 +220,9 @@ func (d *DeleteResources) run(ctx context.Context, s *Step) dErr {
 		go func(i string) {
 			defer wg.Done()
 			w.LogStepInfo(s.name, "DeleteResources", "Deleting instance %q.", i)
-			if err := w.instances.delete(i); err != nil {
+			if err := w.instances.delete(ctx, i); err != nil {
 				if err.Type() == resourceDNEError {
-					w.LogStepInfo(s.name, "DeleteResources", "WARNING: Error deleting instance %q: %v", i, err)
+					w.logStep(SeverityWarn, s.name, "DeleteResources", "Error deleting instance %q: %v", i, err)
 					return
 				}
 				e <- err
@@ -234,9 +235,9 @@ func (d *DeleteResources) run(ctx context.Context, s *Step) dErr {
 		go func(i string) {
 			defer wg.Done()
 			w.LogStepInfo(s.name, "DeleteResources", "Deleting image %q.", i)
-			if err := w.images.delete(i); err != nil {
+			if err := w.images.delete(ctx, i); err != nil {
 				if err.Type() == resourceDNEError {
-					w.LogStepInfo(s.name, "DeleteResources", "WARNING: Error deleting image %q: %v", i, err)
+					w.logStep(SeverityWarn, s.name, "DeleteResources", "Error deleting image %q: %v", i, err)
 					return
 				}
 				e <- err
@@ -260,9 +261,9 @@ func (d *DeleteResources) run(ctx context.Context, s *Step) dErr {
 				return
 			}
 
-			if err := w.StorageClient.Bucket(bkt).Object(obj).Delete(ctx); err != nil {
+			if err := w.store().Delete(ctx, bkt, obj); err != nil {
 				if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
-					w.LogStepInfo(s.name, "DeleteResources", "WARNING: Error deleting GCS Path %q: %v", p, err)
+					w.logStep(SeverityWarn, s.name, "DeleteResources", "Error deleting GCS Path %q: %v", p, err)
 					return
 				}
 				e <- errf("error deleting GCS path %q: %v", p, err)
@@ -270,7 +271,7 @@ func (d *DeleteResources) run(ctx context.Context, s *Step) dErr {
 		}(p)
 	}
 
-	if abort, ret := waitGroup(&wg, e, w); abort {
+	if abort, ret := waitGroup(ctx, &wg, e); abort {
 		return ret
 	}
 
@@ -281,9 +282,9 @@ func (d *DeleteResources) run(ctx context.Context, s *Step) dErr {
 		go func(d string) {
 			defer wg.Done()
 			w.LogStepInfo(s.name, "DeleteResources", "Deleting disk %q.", d)
-			if err := w.disks.delete(d); err != nil {
+			if err := w.disks.delete(ctx, d); err != nil {
 				if err.Type() == resourceDNEError {
-					w.LogStepInfo(s.name, "DeleteResources", "WARNING: Error deleting disk %q: %v", d, err)
+					w.logStep(SeverityWarn, s.name, "DeleteResources", "Error deleting disk %q: %v", d, err)
 					return
 				}
 				e <- err
@@ -297,16 +298,16 @@ func (d *DeleteResources) run(ctx context.Context, s *Step) dErr {
 		go func(sn string) {
 			defer wg.Done()
 			w.LogStepInfo(s.name, "DeleteResources", "Deleting subnetwork %q.", sn)
-			if err := w.subnetworks.delete(sn); err != nil {
+			if err := w.subnetworks.delete(ctx, sn); err != nil {
 				if err.Type() == resourceDNEError {
-					w.LogStepInfo(s.name, "DeleteResources", "WARNING: Error deleting subnetwork %q: %v", sn, err)
+					w.logStep(SeverityWarn, s.name, "DeleteResources", "Error deleting subnetwork %q: %v", sn, err)
 				}
 				e <- err
 			}
 		}(sn)
 	}
 
-	if abort, ret := waitGroup(&wg, e, w); abort {
+	if abort, ret := waitGroup(ctx, &wg, e); abort {
 		return ret
 	}
 
@@ -316,15 +317,15 @@ func (d *DeleteResources) run(ctx context.Context, s *Step) dErr {
 		go func(n string) {
 			defer wg.Done()
 			w.LogStepInfo(s.name, "DeleteResources", "Deleting network %q.", n)
-			if err := w.networks.delete(n); err != nil {
+			if err := w.networks.delete(ctx, n); err != nil {
 				if err.Type() == resourceDNEError {
-					w.LogStepInfo(s.name, "DeleteResources", "WARNING: Error deleting network %q: %v", n, err)
+					w.logStep(SeverityWarn, s.name, "DeleteResources", "Error deleting network %q: %v", n, err)
 				}
 				e <- err
 			}
 		}(n)
 	}
 
-	_, ret := waitGroup(&wg, e, w)
+	_, ret := waitGroup(ctx, &wg, e)
 	return ret
 }
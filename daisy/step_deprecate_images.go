@@ -78,7 +78,7 @@ func (d *DeprecateImages) run(ctx context.Context, s *Step) dErr {
 			defer wg.Done()
 
 			w.LogStepInfo(s.name, "DeprecateImages", "%q --> %q.", di.Image, di.DeprecationStatus.State)
-			if err := w.ComputeClient.DeprecateImage(di.Project, di.Image, &di.DeprecationStatus); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).DeprecateImage(di.Project, di.Image, &di.DeprecationStatus); err != nil {
 				e <- newErr(err)
 			}
 		}(di)
@@ -92,7 +92,7 @@ func (d *DeprecateImages) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		return nil
 	}
 }
@@ -87,7 +87,7 @@ func (a *DetachDisks) validate(ctx context.Context, s *Step) (errs dErr) {
 func (a *DetachDisks) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*a))
 	for _, dd := range *a {
 		wg.Add(1)
 		go func(dd *DetachDisk) {
@@ -99,7 +99,7 @@ func (a *DetachDisks) run(ctx context.Context, s *Step) dErr {
 			}
 
 			w.LogStepInfo(s.name, "DetachDisks", "Detaching disk %q from instance %q.", dd.DeviceName, inst)
-			if err := w.ComputeClient.DetachDisk(dd.project, dd.zone, dd.Instance, dd.DeviceName); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).DetachDisk(dd.project, dd.zone, dd.Instance, dd.DeviceName); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -114,7 +114,7 @@ func (a *DetachDisks) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		wg.Wait()
 		return nil
 	}
@@ -0,0 +1,147 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// forEachVarRgx matches the vars ForEach sets for each item.
+var forEachVarRgx = regexp.MustCompile(`\$\{(ITEM|INDEX)}`)
+
+// ForEach runs an IncludeWorkflow or SubWorkflow once for each item, in
+// parallel. In the Path, Vars and workflow of each instance ${ITEM} is
+// replaced by the item and ${INDEX} by its index.
+type ForEach struct {
+	// Items to run the workflow for. Each item is split on commas, so
+	// a comma separated Var such as "${images}" expands to several items.
+	Items []string
+	// The workflow to run for each item, only one may be set. Path is
+	// required as each item gets its own copy of the workflow.
+	IncludeWorkflow *IncludeWorkflow `json:",omitempty"`
+	SubWorkflow     *SubWorkflow     `json:",omitempty"`
+
+	// The expanded items and the step running each of them.
+	items []string
+	steps []*Step
+}
+
+func (f *ForEach) populate(ctx context.Context, s *Step) dErr {
+	if (f.IncludeWorkflow == nil) == (f.SubWorkflow == nil) {
+		return errf("ForEach %q must have exactly one of IncludeWorkflow or SubWorkflow", s.name)
+	}
+	if (f.IncludeWorkflow != nil && f.IncludeWorkflow.Path == "") || (f.SubWorkflow != nil && f.SubWorkflow.Path == "") {
+		return errf("ForEach %q workflow must be given by Path", s.name)
+	}
+
+	f.items = nil
+	for _, item := range f.Items {
+		for _, i := range strings.Split(item, ",") {
+			if i = strings.TrimSpace(i); i != "" {
+				f.items = append(f.items, i)
+			}
+		}
+	}
+	if len(f.items) == 0 {
+		return errf("ForEach %q has no Items", s.name)
+	}
+
+	f.steps = nil
+	for index, item := range f.items {
+		r := strings.NewReplacer("${ITEM}", item, "${INDEX}", strconv.Itoa(index))
+		st := &Step{name: fmt.Sprintf("%s-%d", s.name, index), w: s.w, Timeout: s.Timeout, forEach: s}
+		if f.IncludeWorkflow != nil {
+			iw := &IncludeWorkflow{Path: r.Replace(f.IncludeWorkflow.Path), Vars: replaceVars(f.IncludeWorkflow.Vars, r)}
+			wf, err := s.w.NewIncludedWorkflowFromFile(iw.Path)
+			if err != nil {
+				return newErr(err)
+			}
+			substitute(reflect.ValueOf(wf).Elem(), r)
+			iw.Path, iw.Workflow = "", wf
+			st.IncludeWorkflow = iw
+		} else {
+			sw := &SubWorkflow{Path: r.Replace(f.SubWorkflow.Path), Vars: replaceVars(f.SubWorkflow.Vars, r)}
+			wf, err := s.w.NewSubWorkflowFromFile(sw.Path)
+			if err != nil {
+				return newErr(err)
+			}
+			substitute(reflect.ValueOf(wf).Elem(), r)
+			sw.Path, sw.Workflow = "", wf
+			st.SubWorkflow = sw
+		}
+		// Appended before populating, so the item's workflow can find the
+		// step that runs it.
+		f.steps = append(f.steps, st)
+		if err := s.w.populateStep(ctx, st); err != nil {
+			return errf("item %d (%q): %v", index, item, err)
+		}
+	}
+	return nil
+}
+
+func replaceVars(vars map[string]string, r *strings.Replacer) map[string]string {
+	if vars == nil {
+		return nil
+	}
+	res := map[string]string{}
+	for k, v := range vars {
+		res[k] = r.Replace(v)
+	}
+	return res
+}
+
+func (f *ForEach) validate(ctx context.Context, s *Step) dErr {
+	for _, st := range f.steps {
+		if err := st.validate(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *ForEach) run(ctx context.Context, s *Step) dErr {
+	var wg sync.WaitGroup
+	results := make([]dErr, len(f.steps))
+	for i, st := range f.steps {
+		wg.Add(1)
+		go func(i int, st *Step) {
+			defer wg.Done()
+			results[i] = st.run(ctx)
+		}(i, st)
+	}
+	wg.Wait()
+
+	var errs dErr
+	failed := 0
+	for i, err := range results {
+		if err != nil {
+			failed++
+			s.w.logStep(SeverityError, s.name, "ForEach", "Item %d (%q) failed: %v", i, f.items[i], err)
+			errs = addErrs(errs, errf("item %d (%q): %v", i, f.items[i], err))
+			continue
+		}
+		s.w.LogStepInfo(s.name, "ForEach", "Item %d (%q) succeeded.", i, f.items[i])
+	}
+	if errs != nil {
+		return addErrs(errf("%d of %d items failed", failed, len(f.items)), errs)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const forEachTestWorkflow = `{
+  "Vars": {"image": {"Required": true}},
+  "Steps": {
+    "create": {
+      "CreateDisks": [{"Name": "disk-${INDEX}", "SourceImage": "${image}", "Description": "${ITEM}"}]
+    }
+  }
+}`
+
+func writeForEachTestWorkflow(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "daisy-foreach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "item.wf.json")
+	if err := ioutil.WriteFile(p, []byte(forEachTestWorkflow), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestForEachPopulate(t *testing.T) {
+	ctx := context.Background()
+	p := writeForEachTestWorkflow(t)
+	defer os.RemoveAll(filepath.Dir(p))
+
+	w := testWorkflow()
+	w.populate(ctx)
+	s, _ := w.NewStep("fe")
+	s.ForEach = &ForEach{
+		Items:           []string{"a, b", "c"},
+		IncludeWorkflow: &IncludeWorkflow{Path: p, Vars: map[string]string{"image": "image-${ITEM}"}},
+	}
+	if err := w.populateStep(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diffRes := diff(s.ForEach.items, []string{"a", "b", "c"}, 0); diffRes != "" {
+		t.Errorf("expanded items do not match expectation: (-got +want)\n%s", diffRes)
+	}
+	if len(s.ForEach.steps) != 3 {
+		t.Fatalf("want 3 item steps, got %d", len(s.ForEach.steps))
+	}
+	for i, item := range []string{"a", "b", "c"} {
+		st := s.ForEach.steps[i]
+		iw := st.IncludeWorkflow.Workflow
+		cd := (*iw.Steps["create"].CreateDisks)[0]
+		wantName := fmt.Sprintf("disk-%d", i)
+		if cd.Name != wantName || cd.Description != item || !strings.HasSuffix(cd.SourceImage, "image-"+item) {
+			t.Errorf("item %d: unexpected disk: Name %q, Description %q, SourceImage %q", i, cd.Name, cd.Description, cd.SourceImage)
+		}
+		if want := fmt.Sprintf("fe.fe-%d.create", i); iw.Steps["create"].fullName() != want {
+			t.Errorf("item %d: got step fullName %q, want %q", i, iw.Steps["create"].fullName(), want)
+		}
+	}
+	if n0, n1 := s.ForEach.steps[0].IncludeWorkflow.Workflow.genName("disk"), s.ForEach.steps[1].IncludeWorkflow.Workflow.genName("disk"); n0 == n1 {
+		t.Errorf("item workflows should generate different names, both got %q", n0)
+	}
+}
+
+func TestForEachPopulateErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		f    *ForEach
+	}{
+		{"no workflow case", &ForEach{Items: []string{"a"}}},
+		{"two workflows case", &ForEach{Items: []string{"a"}, IncludeWorkflow: &IncludeWorkflow{Path: "foo"}, SubWorkflow: &SubWorkflow{Path: "foo"}}},
+		{"no path case", &ForEach{Items: []string{"a"}, SubWorkflow: &SubWorkflow{Workflow: New()}}},
+		{"no items case", &ForEach{Items: []string{" , "}, SubWorkflow: &SubWorkflow{Path: "foo"}}},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("fe")
+		s.ForEach = tt.f
+		if err := tt.f.populate(context.Background(), s); err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		}
+	}
+}
+
+func TestForEachRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("fe")
+	var ran []string
+	var mx sync.Mutex
+	mockItem := func(name string, err dErr) *Step {
+		return &Step{name: name, w: w, forEach: s, testType: &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+			mx.Lock()
+			ran = append(ran, name)
+			mx.Unlock()
+			return err
+		}}}
+	}
+	s.ForEach = &ForEach{
+		items: []string{"a", "b", "c"},
+		steps: []*Step{mockItem("fe-0", nil), mockItem("fe-1", errf("boom")), mockItem("fe-2", nil)},
+	}
+
+	err := s.ForEach.run(ctx, s)
+	if err == nil {
+		t.Fatal("a failed item should fail the step")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "1 of 3 items failed") || !strings.Contains(msg, `item 1 ("b")`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(ran) != 3 {
+		t.Errorf("all items should run, ran: %v", ran)
+	}
+}
+
+func TestForEachNestedDepends(t *testing.T) {
+	w := testWorkflow()
+	fe, _ := w.NewStep("fe")
+	before, _ := w.NewStep("before")
+	w.Dependencies = map[string][]string{"fe": {"before"}}
+	items := []*Step{{name: "fe-0", w: w, forEach: fe}, {name: "fe-1", w: w, forEach: fe}}
+	fe.ForEach = &ForEach{steps: items}
+
+	var nested []*Step
+	for _, item := range items {
+		iw := w.NewIncludedWorkflow()
+		item.IncludeWorkflow = &IncludeWorkflow{Workflow: iw}
+		st, _ := iw.NewStep("s")
+		nested = append(nested, st)
+	}
+
+	if !nested[0].nestedDepends(before) {
+		t.Error("item steps should depend on the dependencies of the ForEach step")
+	}
+	if nested[0].nestedDepends(nested[1]) || nested[1].nestedDepends(nested[0]) {
+		t.Error("steps of different items should not depend on each other")
+	}
+}
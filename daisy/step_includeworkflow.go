@@ -48,6 +48,7 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) dErr {
 	i.Workflow.username = i.Workflow.parent.username
 	i.Workflow.ComputeClient = i.Workflow.parent.ComputeClient
 	i.Workflow.StorageClient = i.Workflow.parent.StorageClient
+	i.Workflow.Storage = i.Workflow.parent.Storage
 	i.Workflow.cloudLoggingClient = i.Workflow.parent.cloudLoggingClient
 	i.Workflow.GCSPath = i.Workflow.parent.GCSPath
 	i.Workflow.Name = i.Workflow.parent.Name
@@ -70,7 +71,9 @@ Loop:
 	for k, v := range i.Vars {
 		for wv := range i.Workflow.Vars {
 			if k == wv {
-				i.Workflow.AddVar(k, v)
+				if err := i.Workflow.SetVar(k, v); err != nil {
+					errs = addErrs(errs, newErr(err))
+				}
 				continue Loop
 			}
 		}
@@ -79,6 +82,17 @@ Loop:
 	if errs != nil {
 		return errs
 	}
+	// Like those of the top-level workflow, the Vars get their defaults, their
+	// ENV and FILE references are resolved and their values are checked.
+	for k, v := range i.Workflow.Vars {
+		if err := v.populate(k, i.Workflow.workflowDir); err != nil {
+			return err
+		}
+		i.Workflow.Vars[k] = v
+		if v.Secret {
+			i.Workflow.addSecret(v)
+		}
+	}
 
 	var replacements []string
 	for k, v := range i.Workflow.autovars {
@@ -113,22 +127,26 @@ Loop:
 			return err
 		}
 	}
+	if err := i.Workflow.populateFinalSteps(ctx); err != nil {
+		return err
+	}
 
 	// Copy Sources up to parent resolving relative paths as we go.
 	for k, v := range i.Workflow.Sources {
 		if v == "" {
 			continue
 		}
-		if _, ok := s.w.Sources[k]; ok {
+		if _, _, err := splitGCSPath(v); err != nil && !filepath.IsAbs(v) {
+			v = filepath.Join(i.Workflow.workflowDir, v)
+		}
+		// The same workflow included several times, e.g. by ForEach, adds
+		// the same sources.
+		if sv, ok := s.w.Sources[k]; ok && sv != v {
 			return errf("source %q already exists in workflow", k)
 		}
 		if s.w.Sources == nil {
 			s.w.Sources = map[string]string{}
 		}
-
-		if _, _, err := splitGCSPath(v); err != nil && !filepath.IsAbs(v) {
-			v = filepath.Join(i.Workflow.workflowDir, v)
-		}
 		s.w.Sources[k] = v
 	}
 
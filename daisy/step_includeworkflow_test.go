@@ -17,6 +17,7 @@ package daisy
 import (
 	"context"
 	"fmt"
+	"os"
 	"testing"
 	"time"
 )
@@ -103,6 +104,64 @@ func TestIncludeWorkflowPopulate(t *testing.T) {
 	}
 }
 
+func TestIncludeWorkflowPopulateVars(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		desc      string
+		vars      map[string]string
+		want      string
+		shouldErr bool
+	}{
+		{"defaults case", nil, "10-false", false},
+		{"typed value case", map[string]string{"install": "TRUE"}, "10-true", false},
+		{"bad value case", map[string]string{"install": "yes"}, "", true},
+		{"bad value passed to default case", map[string]string{"size": "ten"}, "", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		iw := w.NewIncludedWorkflow()
+		iw.Vars = map[string]Var{
+			"size":    {Default: "+010", Type: VarTypeInt},
+			"install": {Default: "0", Type: VarTypeBool},
+		}
+		iw.Steps = map[string]*Step{"${size}-${install}": {testType: &mockStep{}}}
+		s := &Step{name: "include", w: w, IncludeWorkflow: &IncludeWorkflow{Vars: tt.vars, Workflow: iw}}
+
+		err := w.populateStep(ctx, s)
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if _, ok := iw.Steps[tt.want]; !ok {
+			t.Errorf("%s: got steps %v, want step %q", tt.desc, iw.Steps, tt.want)
+		}
+	}
+}
+
+func TestIncludeWorkflowPopulateSecretVars(t *testing.T) {
+	os.Setenv("DAISY_TEST_TOKEN", "env-token")
+	defer os.Unsetenv("DAISY_TEST_TOKEN")
+
+	w := testWorkflow()
+	iw := w.NewIncludedWorkflow()
+	iw.Vars = map[string]Var{"token": {Default: "${ENV:DAISY_TEST_TOKEN}", Secret: true}}
+	iw.Steps = map[string]*Step{"s": {testType: &mockStep{}}}
+	s := &Step{name: "include", w: w, IncludeWorkflow: &IncludeWorkflow{Workflow: iw}}
+	if err := w.populateStep(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if iw.Vars["token"].Value != "env-token" {
+		t.Errorf("got Value %q, want the resolved ENV reference", iw.Vars["token"].Value)
+	}
+	if got := w.Redact("token env-token"); got != "token ****" {
+		t.Errorf("secret of the included workflow not masked: %q", got)
+	}
+}
+
 func TestIncludeWorkflowRun(t *testing.T) {}
 
 func TestIncludeWorkflowValidate(t *testing.T) {
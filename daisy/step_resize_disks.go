@@ -63,14 +63,14 @@ func (r *ResizeDisks) validate(ctx context.Context, s *Step) dErr {
 func (r *ResizeDisks) run(ctx context.Context, s *Step) dErr {
 	var wg sync.WaitGroup
 	w := s.w
-	e := make(chan dErr)
+	e := make(chan dErr, len(*r))
 	for _, rd := range *r {
 		wg.Add(1)
 		go func(rd *ResizeDisk) {
 			defer wg.Done()
 
 			w.LogStepInfo(s.name, "ResizeDisks", "Resizing disk %q to %v GB.", rd.Name, rd.SizeGb)
-			if err := w.ComputeClient.ResizeDisk(s.w.Project, s.w.Zone, rd.Name, &rd.DisksResizeRequest); err != nil {
+			if err := w.ComputeClient.WithContext(ctx).ResizeDisk(s.w.Project, s.w.Zone, rd.Name, &rd.DisksResizeRequest); err != nil {
 				e <- newErr(err)
 				return
 			}
@@ -85,7 +85,7 @@ func (r *ResizeDisks) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		// Wait so disks being created now can be deleted.
 		wg.Wait()
 		return nil
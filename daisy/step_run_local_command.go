@@ -0,0 +1,201 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var outputNameRgx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// maxLocalCommandLine is the longest stdout or stderr line of a local command
+// that is logged.
+const maxLocalCommandLine = 1024 * 1024
+
+// RunLocalCommand is a Daisy RunLocalCommand workflow step. It runs a command
+// on the machine running the workflow, logging its stdout and stderr lines.
+// The step fails if the command exits with a non-zero status.
+type RunLocalCommand struct {
+	// Executable to run, looked up in PATH if it has no path separator.
+	// Relative paths are relative to Dir.
+	Command string
+	Args    []string `json:",omitempty"`
+	// Environment variables to set on top of the environment of the workflow
+	// process.
+	Env map[string]string `json:",omitempty"`
+	// Working directory of the command (default is ${WFDIR}). Relative paths
+	// are relative to ${WFDIR}.
+	Dir string `json:",omitempty"`
+	// Time to wait for the command to exit (default is the step timeout).
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	Timeout string `json:",omitempty"`
+	timeout time.Duration
+	// Keys of the stdout lines of the form key=value to publish as step
+	// outputs. The step fails if the command doesn't print one of them.
+	Outputs []string `json:",omitempty"`
+
+	path string
+}
+
+func (r *RunLocalCommand) populate(ctx context.Context, s *Step) dErr {
+	if r.Dir == "" {
+		r.Dir = s.w.workflowDir
+	} else if !filepath.IsAbs(r.Dir) {
+		r.Dir = filepath.Join(s.w.workflowDir, r.Dir)
+	}
+	if r.Timeout != "" {
+		d, err := time.ParseDuration(r.Timeout)
+		if err != nil {
+			return newErr(err)
+		}
+		r.timeout = d
+	}
+	return nil
+}
+
+func (r *RunLocalCommand) validate(ctx context.Context, s *Step) dErr {
+	if r.Command == "" {
+		return errf("RunLocalCommand: Command must be set")
+	}
+	if r.Dir != "" {
+		if fi, err := os.Stat(r.Dir); err != nil {
+			return errf("RunLocalCommand: bad Dir: %v", err)
+		} else if !fi.IsDir() {
+			return errf("RunLocalCommand: Dir %q is not a directory", r.Dir)
+		}
+	}
+	cmd := r.Command
+	if strings.ContainsRune(cmd, filepath.Separator) && !filepath.IsAbs(cmd) {
+		cmd = filepath.Join(r.Dir, cmd)
+	}
+	p, err := exec.LookPath(cmd)
+	if err != nil {
+		return errf("RunLocalCommand: executable %q not found: %v", r.Command, err)
+	}
+	r.path = p
+	for _, o := range r.Outputs {
+		if !outputNameRgx.MatchString(o) {
+			return errf("RunLocalCommand: bad output name %q, must match %s", o, outputNameRgx)
+		}
+	}
+	return nil
+}
+
+func (r *RunLocalCommand) run(ctx context.Context, s *Step) dErr {
+	w := s.w
+	cctx := ctx
+	if r.timeout != 0 {
+		var cancel context.CancelFunc
+		cctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(cctx, r.path, r.Args...)
+	cmd.Dir = r.Dir
+	cmd.Env = os.Environ()
+	var keys []string
+	for k := range r.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+r.Env[k])
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return newErr(err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return newErr(err)
+	}
+
+	w.LogStepInfo(s.name, "RunLocalCommand", "Running %q with args %q in %q.", r.path, r.Args, r.Dir)
+	if err := cmd.Start(); err != nil {
+		return errf("error starting %q: %v", r.Command, err)
+	}
+
+	outs := map[string]string{}
+	var wg sync.WaitGroup
+	stream := func(name string, rc io.Reader, capture bool) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(rc)
+		scanner.Buffer(make([]byte, 64*1024), maxLocalCommandLine)
+		for scanner.Scan() {
+			line := scanner.Text()
+			w.LogStepInfo(s.name, "RunLocalCommand", "%s: %s", name, line)
+			if !capture {
+				continue
+			}
+			if kv := strings.SplitN(line, "=", 2); len(kv) == 2 && strIn(kv[0], r.Outputs) {
+				outs[kv[0]] = kv[1]
+			}
+		}
+		if err := scanner.Err(); err != nil && cctx.Err() == nil {
+			w.logStep(SeverityWarn, s.name, "RunLocalCommand", "%s: error reading output, not logging the rest of it: %v", name, err)
+		}
+		// The command blocks if the pipe is full.
+		io.Copy(ioutil.Discard, rc)
+	}
+	// A process the command started in the background can keep the pipes
+	// open after the command is killed, so reading stops once cctx is done.
+	readDone := make(chan struct{})
+	go func() {
+		select {
+		case <-cctx.Done():
+			stdout.Close()
+			stderr.Close()
+		case <-readDone:
+		}
+	}()
+	wg.Add(2)
+	go stream("stdout", stdout, len(r.Outputs) > 0)
+	go stream("stderr", stderr, false)
+	// The pipes must be read to the end before calling Wait.
+	wg.Wait()
+	close(readDone)
+	err = cmd.Wait()
+
+	if ctx.Err() != nil {
+		// The workflow was cancelled, or the step timed out.
+		return nil
+	}
+	if cctx.Err() == context.DeadlineExceeded {
+		return errf("command %q did not exit within %s", r.Command, r.timeout)
+	}
+	if err != nil {
+		return errf("command %q failed: %v", r.Command, err)
+	}
+
+	for _, o := range r.Outputs {
+		v, ok := outs[o]
+		if !ok {
+			return errf("command %q did not print output %q as %s=VALUE", r.Command, o, o)
+		}
+		w.setOutput(s.name, o, v)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testShell(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh in PATH")
+	}
+}
+
+func TestRunLocalCommandPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.workflowDir = "/wf/dir"
+	s := &Step{w: w}
+
+	tests := []struct {
+		desc, dir, wantDir string
+	}{
+		{"default case", "", "/wf/dir"},
+		{"relative case", "sub", "/wf/dir/sub"},
+		{"absolute case", "/tmp", "/tmp"},
+	}
+	for _, tt := range tests {
+		r := &RunLocalCommand{Command: "sh", Dir: tt.dir}
+		if err := r.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if r.Dir != filepath.FromSlash(tt.wantDir) {
+			t.Errorf("%s: got Dir %q, want %q", tt.desc, r.Dir, tt.wantDir)
+		}
+	}
+
+	r := &RunLocalCommand{Command: "sh", Timeout: "1m"}
+	if err := r.populate(ctx, s); err != nil || r.timeout.Minutes() != 1 {
+		t.Errorf("got timeout %v, error: %v", r.timeout, err)
+	}
+	r = &RunLocalCommand{Command: "sh", Timeout: "1 minute"}
+	if err := r.populate(ctx, s); err == nil {
+		t.Error("expected error for bad Timeout")
+	}
+}
+
+func TestRunLocalCommandValidate(t *testing.T) {
+	testShell(t)
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	tests := []struct {
+		desc      string
+		r         *RunLocalCommand
+		shouldErr bool
+	}{
+		{"normal case", &RunLocalCommand{Command: "sh"}, false},
+		{"outputs case", &RunLocalCommand{Command: "sh", Outputs: []string{"ver", "image_name"}}, false},
+		{"not executable case", &RunLocalCommand{Command: "./test_data/test.txt", Dir: "."}, true},
+		{"no command case", &RunLocalCommand{}, true},
+		{"missing command case", &RunLocalCommand{Command: "daisy-dne-command"}, true},
+		{"missing dir case", &RunLocalCommand{Command: "sh", Dir: "./dne"}, true},
+		{"file dir case", &RunLocalCommand{Command: "sh", Dir: "./test_data/test.txt"}, true},
+		{"bad output case", &RunLocalCommand{Command: "sh", Outputs: []string{"a=b"}}, true},
+	}
+	for _, tt := range tests {
+		err := tt.r.validate(ctx, s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestRunLocalCommandRun(t *testing.T) {
+	testShell(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		desc      string
+		r         *RunLocalCommand
+		shouldErr bool
+		wantOuts  map[string]string
+		wantLog   string
+	}{
+		{
+			"normal case",
+			&RunLocalCommand{Command: "sh", Args: []string{"-c", "echo out; echo err >&2"}},
+			false, map[string]string{}, "stderr: err",
+		},
+		{
+			"env case",
+			&RunLocalCommand{Command: "sh", Args: []string{"-c", "echo $DAISY_TEST_VAR"}, Env: map[string]string{"DAISY_TEST_VAR": "value"}},
+			false, map[string]string{}, "stdout: value",
+		},
+		{
+			"outputs case",
+			&RunLocalCommand{Command: "sh", Args: []string{"-c", "echo ver=1.2=3; echo other=x; echo name=img"}, Outputs: []string{"ver", "name"}},
+			false, map[string]string{"ver": "1.2=3", "name": "img"}, "stdout: other=x",
+		},
+		{
+			"long line case",
+			&RunLocalCommand{Command: "sh", Args: []string{"-c", "head -c 100000 /dev/zero | tr '\\0' a; echo; echo done"}},
+			false, map[string]string{}, "stdout: done",
+		},
+		{
+			"too long line case",
+			&RunLocalCommand{Command: "sh", Args: []string{"-c", "head -c 2000000 /dev/zero | tr '\\0' a; echo; echo done"}},
+			false, map[string]string{}, "token too long",
+		},
+		{
+			"missing output case",
+			&RunLocalCommand{Command: "sh", Args: []string{"-c", "echo ver=1"}, Outputs: []string{"ver", "name"}},
+			true, map[string]string{}, "",
+		},
+		{
+			"exit status case",
+			&RunLocalCommand{Command: "sh", Args: []string{"-c", "exit 3"}},
+			true, map[string]string{}, "",
+		},
+		{
+			"timeout case",
+			&RunLocalCommand{Command: "sh", Args: []string{"-c", "exec sleep 10"}, Timeout: "100ms"},
+			true, map[string]string{}, "",
+		},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("s")
+		s.RunLocalCommand = tt.r
+		if err := tt.r.populate(ctx, s); err != nil {
+			t.Fatalf("%s: populate error: %v", tt.desc, err)
+		}
+		if err := tt.r.validate(ctx, s); err != nil {
+			t.Fatalf("%s: validate error: %v", tt.desc, err)
+		}
+		err := tt.r.run(ctx, s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diffRes := diff(w.stepOutputs("s"), tt.wantOuts, 0); diffRes != "" {
+			t.Errorf("%s: outputs do not match expectation: (-got +want)\n%s", tt.desc, diffRes)
+		}
+		if tt.wantLog == "" {
+			continue
+		}
+		var found bool
+		for _, e := range w.Logger.(*MockLogger).getEntries() {
+			if e.StepType == "RunLocalCommand" && strings.HasSuffix(e.Message, tt.wantLog) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: %q not logged", tt.desc, tt.wantLog)
+		}
+	}
+}
+
+func TestRunLocalCommandBackgroundProcess(t *testing.T) {
+	testShell(t)
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	// The backgrounded sleep keeps stdout open after the shell is killed.
+	r := &RunLocalCommand{Command: "sh", Args: []string{"-c", "sleep 30 & exec sleep 30"}, Timeout: "100ms"}
+	s.RunLocalCommand = r
+	if err := r.populate(ctx, s); err != nil {
+		t.Fatalf("populate error: %v", err)
+	}
+	if err := r.validate(ctx, s); err != nil {
+		t.Fatalf("validate error: %v", err)
+	}
+
+	start := time.Now()
+	want := `command "sh" did not exit within 100ms`
+	if err := r.run(ctx, s); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("run returned after %s, want it to return at the timeout", d)
+	}
+}
+
+func TestRunLocalCommandOutputNames(t *testing.T) {
+	s := &Step{RunLocalCommand: &RunLocalCommand{Outputs: []string{"a", "b"}}}
+	if got := s.outputNames(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("unexpected output names: %q", got)
+	}
+}
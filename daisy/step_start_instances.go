@@ -53,7 +53,7 @@ func (st *StartInstances) run(ctx context.Context, s *Step) dErr {
 		go func(i string) {
 			defer wg.Done()
 			w.LogStepInfo(s.name, "StartInstances", "Starting instance %q.", i)
-			if err := w.instances.start(i); err != nil {
+			if err := w.instances.start(ctx, i); err != nil {
 				e <- err
 			}
 		}(i)
@@ -67,7 +67,7 @@ func (st *StartInstances) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-ctx.Done():
 		return nil
 	}
 }
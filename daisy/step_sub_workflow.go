@@ -16,7 +16,6 @@ package daisy
 
 import (
 	"context"
-	"fmt"
 )
 
 // SubWorkflow defines a Daisy sub workflow.
@@ -39,13 +38,14 @@ func (s *SubWorkflow) populate(ctx context.Context, st *Step) dErr {
 	}
 
 	s.Workflow.parent = st.w
-	s.Workflow.GCSPath = fmt.Sprintf("gs://%s/%s", s.Workflow.parent.bucket, s.Workflow.parent.scratchPath)
+	s.Workflow.GCSPath = s.Workflow.parent.store().URL(s.Workflow.parent.bucket, s.Workflow.parent.scratchPath)
 	s.Workflow.Name = st.name
 	s.Workflow.Project = s.Workflow.parent.Project
 	s.Workflow.Zone = s.Workflow.parent.Zone
 	s.Workflow.OAuthPath = s.Workflow.parent.OAuthPath
 	s.Workflow.ComputeClient = s.Workflow.parent.ComputeClient
 	s.Workflow.StorageClient = s.Workflow.parent.StorageClient
+	s.Workflow.Storage = s.Workflow.parent.Storage
 	s.Workflow.Logger = s.Workflow.parent.Logger
 	s.Workflow.DefaultTimeout = st.Timeout
 
@@ -76,28 +76,35 @@ func (s *SubWorkflow) run(ctx context.Context, st *Step) dErr {
 		return err
 	}
 
-	swCleanup := func() {
+	swCleanup := func(ctx context.Context) {
+		ctx, sp := s.Workflow.startSpan(ctx, "cleanup "+s.Workflow.Name, "workflow")
+		defer sp.finish(nil)
 		s.Workflow.LogWorkflowInfo("SubWorkflow %q cleaning up (this may take up to 2 minutes).", s.Workflow.Name)
 		for _, hook := range s.Workflow.cleanupHooks {
-			if err := hook(); err != nil {
-				s.Workflow.LogWorkflowInfo("Error returned from SubWorkflow cleanup hook: %s", err)
+			if err := hook(ctx); err != nil {
+				s.Workflow.logWorkflow(SeverityError, "Error returned from SubWorkflow cleanup hook: %s", err)
 			}
 		}
 	}
 
-	defer swCleanup()
+	defer func() {
+		// ctx may be canceled by now, cleanup gets a context of its own.
+		cctx, cancel := context.WithTimeout(withSpanOf(context.Background(), ctx), cleanupTimeout)
+		defer cancel()
+		swCleanup(cctx)
+	}()
 	// If the workflow fails before the subworkflow completes, the previous
 	// "defer" cleanup won't happen. Add a failsafe here, have the workflow
 	// also call this subworkflow's cleanup.
-	st.w.addCleanupHook(func() dErr {
-		swCleanup()
+	st.w.addCleanupHook(func(ctx context.Context) dErr {
+		swCleanup(ctx)
 		return nil
 	})
 
 	// Prerun work has already been done. Just run(), not Run().
 	st.w.LogStepInfo(st.name, "SubWorkflow", "Running subworkflow %q", s.Workflow.Name)
 	if err := s.Workflow.run(ctx); err != nil {
-		s.Workflow.LogStepInfo(st.name, "SubWorkflow", "Error running subworkflow %q: %v", s.Workflow.Name, err)
+		s.Workflow.logStep(SeverityError, st.name, "SubWorkflow", "Error running subworkflow %q: %v", s.Workflow.Name, err)
 		return err
 	}
 	return nil
@@ -0,0 +1,245 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StepImpl is the implementation of a custom step type, see
+// RegisterStepType. Like the built in step types, the step is populated, then
+// validated along with the rest of the workflow before any step runs, then
+// run. Vars, ${SOURCE:...} and step output references are substituted in the
+// exported string fields of the step type.
+type StepImpl interface {
+	// Populate sets defaults and resolves partial values. It should not
+	// validate values.
+	Populate(ctx context.Context, s *Step) error
+	// Validate checks the step without making changes, and registers the
+	// resources the step creates, uses and deletes, see Step.RegisterCreate.
+	Validate(ctx context.Context, s *Step) error
+	// Run runs the step.
+	Run(ctx context.Context, s *Step) error
+}
+
+// StepPlanner is implemented by custom step types that can be planned, see
+// Workflow.Plan. Plan is called instead of Run and simulates the step: the
+// API clients and storage of the workflow record the calls that create,
+// modify or delete anything instead of sending them. Plans list the steps of
+// other custom step types without running them.
+type StepPlanner interface {
+	Plan(ctx context.Context, s *Step) error
+}
+
+var (
+	stepTypes   = map[string]func() StepImpl{}
+	stepTypesMx sync.Mutex
+)
+
+// RegisterStepType registers a custom step type. Steps of workflow files
+// holding name as key are of the type, the value is unmarshalled into the
+// StepImpl returned by factory, which must be a pointer. It panics if name is
+// already registered or is a field of Step, it is meant to be called from an
+// init function.
+func RegisterStepType(name string, factory func() StepImpl) {
+	stepTypesMx.Lock()
+	defer stepTypesMx.Unlock()
+	if name == "" || factory == nil {
+		panic("daisy: RegisterStepType needs a name and a factory")
+	}
+	for n := range stepTypes {
+		if strings.EqualFold(n, name) {
+			panic(fmt.Sprintf("daisy: step type %q already registered", name))
+		}
+	}
+	t := reflect.TypeOf(Step{})
+	for i := 0; i < t.NumField(); i++ {
+		if strings.EqualFold(t.Field(i).Name, name) {
+			panic(fmt.Sprintf("daisy: step type %q is a field of Step", name))
+		}
+	}
+	stepTypes[name] = factory
+}
+
+// registeredStepTypes returns the names of the custom step types, sorted.
+func registeredStepTypes() []string {
+	stepTypesMx.Lock()
+	defer stepTypesMx.Unlock()
+	var names []string
+	for n := range stepTypes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newStepType(name string) StepImpl {
+	stepTypesMx.Lock()
+	defer stepTypesMx.Unlock()
+	return stepTypes[name]()
+}
+
+// customStep runs a custom step type as a stepImpl.
+type customStep struct {
+	name string
+	impl StepImpl
+}
+
+func (c *customStep) populate(ctx context.Context, s *Step) dErr {
+	return newErr(c.impl.Populate(ctx, s))
+}
+
+func (c *customStep) validate(ctx context.Context, s *Step) dErr {
+	return newErr(c.impl.Validate(ctx, s))
+}
+
+func (c *customStep) run(ctx context.Context, s *Step) dErr {
+	return newErr(c.impl.Run(ctx, s))
+}
+
+// step has the fields of Step without its JSON methods.
+type step Step
+
+// UnmarshalJSON unmarshals a Step, and its custom step types.
+func (s *Step) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, (*step)(s)); err != nil {
+		return err
+	}
+	names := registeredStepTypes()
+	if len(names) == 0 {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		for _, n := range names {
+			if !strings.EqualFold(k, n) {
+				continue
+			}
+			impl := newStepType(n)
+			if err := json.Unmarshal(v, impl); err != nil {
+				return fmt.Errorf("error unmarshalling step type %s: %v", n, err)
+			}
+			if s.Custom == nil {
+				s.Custom = map[string]StepImpl{}
+			}
+			s.Custom[n] = impl
+		}
+	}
+	return nil
+}
+
+// MarshalJSON marshals a Step, and its custom step types.
+func (s *Step) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal((*step)(s))
+	if err != nil || len(s.Custom) == 0 {
+		return b, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for n, impl := range s.Custom {
+		if fields[n], err = json.Marshal(impl); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// Name returns the name of the step.
+func (s *Step) Name() string {
+	return s.name
+}
+
+// Workflow returns the workflow the step is part of.
+func (s *Step) Workflow() *Workflow {
+	return s.w
+}
+
+// registry returns the resource registry of the resource type, e.g. "disk".
+func (w *Workflow) registry(resourceType string) (*baseResourceRegistry, dErr) {
+	var types []string
+	for _, r := range w.resourceRegistries() {
+		if r.typeName == resourceType {
+			return r, nil
+		}
+		types = append(types, r.typeName)
+	}
+	sort.Strings(types)
+	return nil, errf("unknown resource type %q, not one of %q", resourceType, types)
+}
+
+// RegisterCreate registers s as the creator of a resource of resourceType:
+// "disk", "firewallRule", "forwardingRule", "image", "instance", "network",
+// "subnetwork" or "targetInstance". name is how other steps refer to the
+// resource, link is its partial URL, e.g. projects/p/zones/z/disks/d. Unless
+// noCleanup is set, the resource is deleted when the workflow ends. Like the
+// other Register methods, it is meant to be called from StepImpl.Validate.
+func (s *Step) RegisterCreate(resourceType, name, link string, noCleanup bool) error {
+	r, err := s.w.registry(resourceType)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(link, "/")
+	res := &Resource{RealName: parts[len(parts)-1], NoCleanup: noCleanup, daisyName: name, link: link}
+	if err := r.regCreate(name, res, s, false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RegisterUse registers s as a user of a resource of resourceType, see
+// RegisterCreate, and returns its partial URL. name is how steps refer to the
+// resource, or its partial URL.
+func (s *Step) RegisterUse(resourceType, name string) (string, error) {
+	r, err := s.w.registry(resourceType)
+	if err != nil {
+		return "", err
+	}
+	res, err := r.regUse(name, s)
+	if err != nil {
+		return "", err
+	}
+	return res.link, nil
+}
+
+// RegisterDelete registers s as the deleter of a resource of resourceType,
+// see RegisterCreate. name is how steps refer to the resource, or its partial
+// URL.
+func (s *Step) RegisterDelete(resourceType, name string) error {
+	var err dErr
+	if resourceType == "instance" {
+		// Instances also release their disks and networks.
+		err = s.w.instances.regDelete(name, s)
+	} else {
+		var r *baseResourceRegistry
+		if r, err = s.w.registry(resourceType); err == nil {
+			err = r.regDelete(name, s)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testStepType struct {
+	Message string
+	Disk    string `json:",omitempty"`
+
+	calls []string
+}
+
+func (t *testStepType) Populate(ctx context.Context, s *Step) error {
+	t.calls = append(t.calls, "populate")
+	if t.Message == "" {
+		t.Message = "default"
+	}
+	return nil
+}
+
+func (t *testStepType) Validate(ctx context.Context, s *Step) error {
+	t.calls = append(t.calls, "validate")
+	if t.Message == "bad" {
+		return errors.New("bad message")
+	}
+	return nil
+}
+
+func (t *testStepType) Run(ctx context.Context, s *Step) error {
+	t.calls = append(t.calls, "run")
+	s.Workflow().LogStepInfo(s.Name(), "TestStepType", "%s", t.Message)
+	return nil
+}
+
+func init() {
+	RegisterStepType("TestStepType", func() StepImpl { return &testStepType{} })
+}
+
+func TestRegisterStepType(t *testing.T) {
+	factory := func() StepImpl { return &testStepType{} }
+	tests := []struct {
+		desc, name string
+		factory    func() StepImpl
+	}{
+		{"duplicate case", "teststeptype", factory},
+		{"Step field case", "CreateDisks", factory},
+		{"no name case", "", factory},
+		{"no factory case", "OtherStepType", nil},
+	}
+
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: RegisterStepType did not panic", tt.desc)
+				}
+			}()
+			RegisterStepType(tt.name, tt.factory)
+		}()
+	}
+	if got := registeredStepTypes(); len(got) != 1 || got[0] != "TestStepType" {
+		t.Errorf("unexpected registered step types: %q", got)
+	}
+}
+
+func TestStepJSON(t *testing.T) {
+	var s Step
+	if err := json.Unmarshal([]byte(`{"Timeout": "1m", "testSteptype": {"Message": "hi"}}`), &s); err != nil {
+		t.Fatalf("error unmarshalling step: %v", err)
+	}
+	if s.Timeout != "1m" {
+		t.Errorf("unexpected Timeout: %q", s.Timeout)
+	}
+	impl, ok := s.Custom["TestStepType"].(*testStepType)
+	if !ok || impl.Message != "hi" {
+		t.Fatalf("unexpected custom steps: %v", s.Custom)
+	}
+
+	b, err := json.Marshal(&s)
+	if err != nil {
+		t.Fatalf("error marshalling step: %v", err)
+	}
+	var got Step
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("error unmarshalling %s: %v", b, err)
+	}
+	if impl, ok := got.Custom["TestStepType"].(*testStepType); !ok || impl.Message != "hi" || got.Timeout != "1m" {
+		t.Errorf("step did not survive a round trip: %s", b)
+	}
+
+	if err := json.Unmarshal([]byte(`{"TestStepType": {"Message": 1}}`), &s); err == nil {
+		t.Error("expected error unmarshalling a bad custom step")
+	}
+}
+
+func TestCustomStepImpl(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	s.Custom = map[string]StepImpl{"TestStepType": &testStepType{}}
+	impl, err := s.stepImpl()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := impl.(*customStep); !ok {
+		t.Errorf("unexpected step implementation %T", impl)
+	}
+	if got := s.typeName(); got != "TestStepType" {
+		t.Errorf("unexpected type name %q", got)
+	}
+
+	s.CreateDisks = &CreateDisks{}
+	if _, err := s.stepImpl(); err == nil {
+		t.Error("expected error for step with two types")
+	}
+}
+
+func TestCustomStepRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.Vars = map[string]Var{"msg": {Value: "hello"}}
+	impl := &testStepType{Message: "${msg} from ${NAME}"}
+	s, _ := w.NewStep("s")
+	s.Custom = map[string]StepImpl{"TestStepType": impl}
+	if err := w.Run(ctx); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+	if want := fmt.Sprintf("hello from %s", testWf); impl.Message != want {
+		t.Errorf("vars not substituted, got %q, want %q", impl.Message, want)
+	}
+	if got := fmt.Sprint(impl.calls); got != "[populate validate run]" {
+		t.Errorf("unexpected calls: %s", got)
+	}
+
+	w = testWorkflow()
+	s, _ = w.NewStep("s")
+	s.Custom = map[string]StepImpl{"TestStepType": &testStepType{Message: "bad"}}
+	if err := w.Run(ctx); err == nil {
+		t.Error("expected validation error")
+	}
+}
+
+func TestCustomStepRegister(t *testing.T) {
+	w := testWorkflow()
+	create, _ := w.NewStep("create")
+	use, _ := w.NewStep("use")
+	del, _ := w.NewStep("delete")
+	w.AddDependency(use, create)
+	w.AddDependency(del, use)
+
+	link := fmt.Sprintf("projects/%s/zones/%s/disks/custom", testProject, testZone)
+	if err := create.RegisterCreate("disk", "d", link, false); err != nil {
+		t.Fatalf("error registering create: %v", err)
+	}
+	if r := w.disks.m["d"]; r == nil || r.RealName != "custom" || r.creator != create {
+		t.Errorf("unexpected registered disk: %+v", r)
+	}
+	if err := create.RegisterCreate("disk", "d", link, false); err == nil {
+		t.Error("expected error creating a disk twice")
+	}
+	if err := create.RegisterCreate("widget", "d", link, false); err == nil {
+		t.Error("expected error for unknown resource type")
+	}
+
+	if _, err := del.RegisterUse("disk", "dne"); err == nil {
+		t.Error("expected error using a missing disk")
+	}
+	got, err := use.RegisterUse("disk", "d")
+	if err != nil || got != link {
+		t.Errorf("RegisterUse returned %q, error: %v", got, err)
+	}
+	if err := del.RegisterDelete("disk", "d"); err != nil {
+		t.Errorf("error registering delete: %v", err)
+	}
+	if r := w.disks.m["d"]; r.deleter != del {
+		t.Errorf("disk deleter not registered: %+v", r)
+	}
+}
@@ -17,9 +17,13 @@ package daisy
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
+
+	"google.golang.org/api/googleapi"
 )
 
 const (
@@ -35,11 +39,42 @@ type WaitForInstancesSignal []*InstanceSignal
 // This step will not complete until a line in the serial output matches
 // SuccessMatch or FailureMatch. A match with FailureMatch will cause the step
 // to fail.
+// A SuccessMatch containing named capture groups, e.g. "version=(?P<ver>\S+)",
+// is a regular expression. The captured values are published as step outputs,
+// which dependent steps reference as ${OUTPUT:stepname.ver}.
 type SerialOutput struct {
 	Port         int64  `json:",omitempty"`
 	SuccessMatch string `json:",omitempty"`
 	FailureMatch string `json:",omitempty"`
 	StatusMatch  string `json:",omitempty"`
+
+	successRgx *regexp.Regexp
+}
+
+// GuestAttribute describes a guest attribute the guest writes, through the
+// metadata server, to signal the result of its task. The instance must have
+// guest attributes enabled, with the metadata enable-guest-attributes set to
+// TRUE.
+// This step will not complete until the attribute is written. If neither a
+// success nor a failure condition is given, any value is a success, otherwise
+// a value matching none of them is ignored and the attribute is polled again.
+// The FailureValue and SuccessValue conditions match the whole value, the
+// FailureMatch and SuccessMatch regular expressions match part of it. The
+// named capture groups of SuccessMatch are published as step outputs.
+type GuestAttribute struct {
+	Namespace    string
+	KeyName      string
+	SuccessValue string `json:",omitempty"`
+	FailureValue string `json:",omitempty"`
+	SuccessMatch string `json:",omitempty"`
+	FailureMatch string `json:",omitempty"`
+	// Interval to poll the attribute (default is the interval of the
+	// InstanceSignal).
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	Interval string `json:",omitempty"`
+
+	interval               time.Duration
+	successRgx, failureRgx *regexp.Regexp
 }
 
 // InstanceSignal waits for a signal from an instance.
@@ -54,18 +89,20 @@ type InstanceSignal struct {
 	Stopped bool `json:",omitempty"`
 	// Wait for a string match in the serial output.
 	SerialOutput *SerialOutput `json:",omitempty"`
+	// Wait for a guest attribute to be written.
+	GuestAttribute *GuestAttribute `json:",omitempty"`
 }
 
-func waitForInstanceStopped(s *Step, project, zone, name string, interval time.Duration) dErr {
+func waitForInstanceStopped(ctx context.Context, s *Step, project, zone, name string, interval time.Duration) dErr {
 	w := s.w
 	w.LogStepInfo(s.name, "WaitForInstancesSignal", "Waiting for instance %q to stop.", name)
 	tick := time.Tick(interval)
 	for {
 		select {
-		case <-s.w.Cancel:
+		case <-ctx.Done():
 			return nil
 		case <-tick:
-			stopped, err := s.w.ComputeClient.InstanceStopped(project, zone, name)
+			stopped, err := s.w.ComputeClient.WithContext(ctx).InstanceStopped(project, zone, name)
 			if err != nil {
 				return typedErr(apiError, err)
 			}
@@ -77,7 +114,7 @@ func waitForInstanceStopped(s *Step, project, zone, name string, interval time.D
 	}
 }
 
-func waitForSerialOutput(s *Step, project, zone, name string, so *SerialOutput, interval time.Duration) dErr {
+func waitForSerialOutput(ctx context.Context, s *Step, project, zone, name string, so *SerialOutput, interval time.Duration) dErr {
 	w := s.w
 	msg := fmt.Sprintf("Instance %q: watching serial port %d", name, so.Port)
 	if so.SuccessMatch != "" {
@@ -95,12 +132,12 @@ func waitForSerialOutput(s *Step, project, zone, name string, so *SerialOutput,
 	tick := time.Tick(interval)
 	for {
 		select {
-		case <-s.w.Cancel:
+		case <-ctx.Done():
 			return nil
 		case <-tick:
-			resp, err := w.ComputeClient.GetSerialPortOutput(project, zone, name, so.Port, start)
+			resp, err := w.ComputeClient.WithContext(ctx).GetSerialPortOutput(project, zone, name, so.Port, start)
 			if err != nil {
-				status, sErr := w.ComputeClient.InstanceStatus(project, zone, name)
+				status, sErr := w.ComputeClient.WithContext(ctx).InstanceStatus(project, zone, name)
 				if sErr != nil {
 					err = fmt.Errorf("%v, error geting InstanceStatus: %v", err, sErr)
 				} else {
@@ -132,7 +169,17 @@ func waitForSerialOutput(s *Step, project, zone, name string, so *SerialOutput,
 						return errf("WaitForInstancesSignal FailureMatch found for %q: %q", name, strings.TrimSpace(ln[i:]))
 					}
 				}
-				if so.SuccessMatch != "" {
+				if so.successRgx != nil {
+					if m := so.successRgx.FindStringSubmatchIndex(ln); m != nil {
+						w.LogStepInfo(s.name, "WaitForInstancesSignal", "Instance %q: SuccessMatch found %q", name, strings.TrimSpace(ln[m[0]:]))
+						for i, n := range so.successRgx.SubexpNames() {
+							if n != "" && m[2*i] != -1 {
+								w.setOutput(s.name, n, ln[m[2*i]:m[2*i+1]])
+							}
+						}
+						return nil
+					}
+				} else if so.SuccessMatch != "" {
 					if i := strings.Index(ln, so.SuccessMatch); i != -1 {
 						w.LogStepInfo(s.name, "WaitForInstancesSignal", "Instance %q: SuccessMatch found %q", name, strings.TrimSpace(ln[i:]))
 						return nil
@@ -144,6 +191,55 @@ func waitForSerialOutput(s *Step, project, zone, name string, so *SerialOutput,
 	}
 }
 
+func waitForGuestAttribute(ctx context.Context, s *Step, project, zone, name string, ga *GuestAttribute) dErr {
+	w := s.w
+	key := ga.Namespace + "/" + ga.KeyName
+	w.LogStepInfo(s.name, "WaitForInstancesSignal", "Instance %q: waiting for guest attribute %q.", name, key)
+	var errs int
+	tick := time.Tick(ga.interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			attr, err := w.ComputeClient.WithContext(ctx).GetGuestAttributes(project, zone, name, "", key)
+			if err != nil {
+				// The attribute is not written yet.
+				if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+					errs = 0
+					continue
+				}
+				// Retry up to 3 times in a row on any other error.
+				if errs < 3 {
+					errs++
+					continue
+				}
+				return errf("WaitForInstancesSignal: instance %q: error getting guest attribute %q: %v", name, key, err)
+			}
+			errs = 0
+			v := attr.VariableValue
+			if (ga.FailureValue != "" && v == ga.FailureValue) || (ga.failureRgx != nil && ga.failureRgx.MatchString(v)) {
+				return errf("WaitForInstancesSignal failure value found for %q in guest attribute %q: %q", name, key, v)
+			}
+			if ga.successRgx != nil {
+				if m := ga.successRgx.FindStringSubmatch(v); m != nil {
+					w.LogStepInfo(s.name, "WaitForInstancesSignal", "Instance %q: SuccessMatch found in guest attribute %q: %q", name, key, v)
+					for i, n := range ga.successRgx.SubexpNames() {
+						if n != "" {
+							w.setOutput(s.name, n, m[i])
+						}
+					}
+					return nil
+				}
+			}
+			if (ga.SuccessValue != "" && v == ga.SuccessValue) || (ga.SuccessValue == "" && ga.SuccessMatch == "" && ga.FailureValue == "" && ga.FailureMatch == "") {
+				w.LogStepInfo(s.name, "WaitForInstancesSignal", "Instance %q: guest attribute %q set to %q", name, key, v)
+				return nil
+			}
+		}
+	}
+}
+
 func (w *WaitForInstancesSignal) populate(ctx context.Context, s *Step) dErr {
 	for _, ws := range *w {
 		if ws.Interval == "" {
@@ -154,6 +250,30 @@ func (w *WaitForInstancesSignal) populate(ctx context.Context, s *Step) dErr {
 		if err != nil {
 			return newErr(err)
 		}
+		// Plain SuccessMatch strings keep their substring semantics.
+		if so := ws.SerialOutput; so != nil && strings.Contains(so.SuccessMatch, "(?P<") {
+			if so.successRgx, err = regexp.Compile(so.SuccessMatch); err != nil {
+				return errf("%q: invalid SuccessMatch regex %q: %v", ws.Name, so.SuccessMatch, err)
+			}
+		}
+		if ga := ws.GuestAttribute; ga != nil {
+			ga.interval = ws.interval
+			if ga.Interval != "" {
+				if ga.interval, err = time.ParseDuration(ga.Interval); err != nil {
+					return newErr(err)
+				}
+			}
+			if ga.SuccessMatch != "" {
+				if ga.successRgx, err = regexp.Compile(ga.SuccessMatch); err != nil {
+					return errf("%q: invalid GuestAttribute SuccessMatch regex %q: %v", ws.Name, ga.SuccessMatch, err)
+				}
+			}
+			if ga.FailureMatch != "" {
+				if ga.failureRgx, err = regexp.Compile(ga.FailureMatch); err != nil {
+					return errf("%q: invalid GuestAttribute FailureMatch regex %q: %v", ws.Name, ga.FailureMatch, err)
+				}
+			}
+		}
 	}
 	return nil
 }
@@ -174,9 +294,13 @@ func (w *WaitForInstancesSignal) run(ctx context.Context, s *Step) dErr {
 			m := namedSubexp(instanceURLRgx, i.link)
 			serialSig := make(chan struct{})
 			stoppedSig := make(chan struct{})
+			guestSig := make(chan struct{})
 			if is.Stopped {
 				go func() {
-					if err := waitForInstanceStopped(s, m["project"], m["zone"], m["instance"], is.interval); err != nil {
+					wctx, sp := s.w.startSpan(ctx, "wait for stop of "+is.Name, "wait", "instance", is.Name)
+					err := waitForInstanceStopped(wctx, s, m["project"], m["zone"], m["instance"], is.interval)
+					sp.finish(err)
+					if err != nil {
 						e <- err
 					}
 					close(stoppedSig)
@@ -184,17 +308,33 @@ func (w *WaitForInstancesSignal) run(ctx context.Context, s *Step) dErr {
 			}
 			if is.SerialOutput != nil {
 				go func() {
-					if err := waitForSerialOutput(s, m["project"], m["zone"], m["instance"], is.SerialOutput, is.interval); err != nil {
+					wctx, sp := s.w.startSpan(ctx, "wait for serial output of "+is.Name, "wait", "instance", is.Name)
+					err := waitForSerialOutput(wctx, s, m["project"], m["zone"], m["instance"], is.SerialOutput, is.interval)
+					sp.finish(err)
+					if err != nil {
 						e <- err
 					}
 					close(serialSig)
 				}()
 			}
+			if is.GuestAttribute != nil {
+				go func() {
+					wctx, sp := s.w.startSpan(ctx, "wait for guest attribute of "+is.Name, "wait", "instance", is.Name)
+					err := waitForGuestAttribute(wctx, s, m["project"], m["zone"], m["instance"], is.GuestAttribute)
+					sp.finish(err)
+					if err != nil {
+						e <- err
+					}
+					close(guestSig)
+				}()
+			}
 			select {
 			case <-serialSig:
 				return
 			case <-stoppedSig:
 				return
+			case <-guestSig:
+				return
 			}
 		}(is)
 	}
@@ -207,7 +347,7 @@ func (w *WaitForInstancesSignal) run(ctx context.Context, s *Step) dErr {
 	select {
 	case err := <-e:
 		return err
-	case <-s.w.Cancel:
+	case <-ctx.Done():
 		return nil
 	}
 }
@@ -221,7 +361,7 @@ func (w *WaitForInstancesSignal) validate(ctx context.Context, s *Step) dErr {
 		if i.interval == 0*time.Second {
 			return errf("%q: cannot wait for instance signal, no interval given", i.Name)
 		}
-		if i.SerialOutput == nil && i.Stopped == false {
+		if i.SerialOutput == nil && i.GuestAttribute == nil && i.Stopped == false {
 			return errf("%q: cannot wait for instance signal, nothing to wait for", i.Name)
 		}
 		if i.SerialOutput != nil {
@@ -232,6 +372,14 @@ func (w *WaitForInstancesSignal) validate(ctx context.Context, s *Step) dErr {
 				return errf("%q: cannot wait for instance signal via SerialOutput, no SuccessMatch or FailureMatch given", i.Name)
 			}
 		}
+		if ga := i.GuestAttribute; ga != nil {
+			if ga.Namespace == "" || ga.KeyName == "" {
+				return errf("%q: cannot wait for instance signal via GuestAttribute, no Namespace or KeyName given", i.Name)
+			}
+			if ga.interval == 0*time.Second {
+				return errf("%q: cannot wait for instance signal via GuestAttribute, no interval given", i.Name)
+			}
+		}
 	}
 	return nil
 }
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	computeBeta "google.golang.org/api/compute/v0.beta"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 )
@@ -46,11 +47,67 @@ func TestWaitForInstanceStopped(t *testing.T) {
 
 	w.ComputeClient = c
 	s := &Step{name: "foo", w: w}
-	if err := waitForInstanceStopped(s, testProject, testZone, "foo", 1*time.Microsecond); err != nil {
+	if err := waitForInstanceStopped(context.Background(), s, testProject, testZone, "foo", 1*time.Microsecond); err != nil {
 		t.Fatalf("error running waitForInstanceStopped: %v", err)
 	}
 }
 
+func TestWaitForGuestAttribute(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		desc      string
+		values    []string
+		ga        *GuestAttribute
+		shouldErr bool
+		wantOuts  map[string]string
+	}{
+		{"any value case", []string{"", "done"}, &GuestAttribute{}, false, map[string]string{}},
+		{"SuccessValue case", []string{"", "running", "done"}, &GuestAttribute{SuccessValue: "done", FailureValue: "failed"}, false, map[string]string{}},
+		{"FailureValue case", []string{"running", "failed"}, &GuestAttribute{SuccessValue: "done", FailureValue: "failed"}, true, map[string]string{}},
+		{"SuccessMatch case", []string{"status=running", "status=done version=1.2"}, &GuestAttribute{SuccessMatch: `done version=(?P<ver>\S+)`, FailureMatch: "fail"}, false, map[string]string{"ver": "1.2"}},
+		{"FailureMatch case", []string{"status=running", "status=failed: disk full"}, &GuestAttribute{SuccessMatch: "done", FailureMatch: "fail"}, true, map[string]string{}},
+		{"API error case", []string{"error", "error", "error", "error"}, &GuestAttribute{}, true, map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		var calls int
+		w.ComputeClient.(*daisyCompute.TestClient).GetGuestAttributesFn = func(_, _, n, qp, vk string) (*computeBeta.GuestAttributes, error) {
+			if n != "foo" || qp != "" || vk != "daisy/result" {
+				return nil, fmt.Errorf("unexpected request for instance %q, queryPath %q, variableKey %q", n, qp, vk)
+			}
+			if calls >= len(tt.values) {
+				return nil, errors.New("attribute polled after the signal")
+			}
+			v := tt.values[calls]
+			calls++
+			switch v {
+			case "":
+				return nil, &googleapi.Error{Code: http.StatusNotFound}
+			case "error":
+				return nil, errors.New("fail")
+			}
+			return &computeBeta.GuestAttributes{VariableKey: vk, VariableValue: v}, nil
+		}
+		ws := &WaitForInstancesSignal{{Name: "foo", Interval: "1us", GuestAttribute: tt.ga}}
+		if err := ws.populate(ctx, &Step{}); err != nil {
+			t.Fatalf("%s: error running populate: %v", tt.desc, err)
+		}
+		tt.ga.Namespace = "daisy"
+		tt.ga.KeyName = "result"
+		s := &Step{name: "s", w: w}
+		err := waitForGuestAttribute(ctx, s, testProject, testZone, "foo", tt.ga)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diffRes := diff(w.stepOutputs("s"), tt.wantOuts, 0); diffRes != "" {
+			t.Errorf("%s: outputs do not match expectation: (-got +want)\n%s", tt.desc, diffRes)
+		}
+	}
+}
+
 func TestWaitForInstancesSignalPopulate(t *testing.T) {
 	got := &WaitForInstancesSignal{&InstanceSignal{Name: "test"}}
 	if err := got.populate(context.Background(), &Step{}); err != nil {
@@ -61,6 +118,30 @@ func TestWaitForInstancesSignalPopulate(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("got != want:\ngot:  %+v\nwant: %+v", got, want)
 	}
+
+	ws := &WaitForInstancesSignal{
+		{Name: "i1", GuestAttribute: &GuestAttribute{Namespace: "n", KeyName: "k"}},
+		{Name: "i2", GuestAttribute: &GuestAttribute{Namespace: "n", KeyName: "k", Interval: "1s", SuccessMatch: "(?P<ver>.+)"}},
+	}
+	if err := ws.populate(context.Background(), &Step{}); err != nil {
+		t.Fatalf("error running populate: %v", err)
+	}
+	if ga := (*ws)[0].GuestAttribute; ga.interval != 10*time.Second {
+		t.Errorf("GuestAttribute interval %v is not the InstanceSignal interval", ga.interval)
+	}
+	if ga := (*ws)[1].GuestAttribute; ga.interval != time.Second || ga.successRgx == nil {
+		t.Errorf("unexpected populated GuestAttribute: %+v", ga)
+	}
+	if names := (&Step{WaitForInstancesSignal: ws}).outputNames(); len(names) != 1 || names[0] != "ver" {
+		t.Errorf("unexpected output names: %q", names)
+	}
+
+	for _, ga := range []*GuestAttribute{{Interval: "1 second"}, {SuccessMatch: "("}, {FailureMatch: "("}} {
+		ws := &WaitForInstancesSignal{{Name: "i", GuestAttribute: ga}}
+		if err := ws.populate(context.Background(), &Step{}); err == nil {
+			t.Errorf("expected error populating GuestAttribute %+v", ga)
+		}
+	}
 }
 
 func TestWaitForInstancesSignalRun(t *testing.T) {
@@ -201,6 +282,9 @@ func TestWaitForInstancesSignalValidate(t *testing.T) {
 		{"instance DNE error check", WaitForInstancesSignal{{Name: "instance1", Stopped: true, interval: 1 * time.Second}, {Name: "instance2", Stopped: true, interval: 1 * time.Second}}, true},
 		{"no interval", WaitForInstancesSignal{{Name: "instance1", Stopped: true, Interval: "0s"}}, true},
 		{"no signal", WaitForInstancesSignal{{Name: "instance1", interval: 1 * time.Second}}, true},
+		{"normal GuestAttribute", WaitForInstancesSignal{{Name: "instance1", GuestAttribute: &GuestAttribute{Namespace: "n", KeyName: "k", interval: 1 * time.Second}, interval: 1 * time.Second}}, false},
+		{"GuestAttribute no KeyName", WaitForInstancesSignal{{Name: "instance1", GuestAttribute: &GuestAttribute{Namespace: "n", interval: 1 * time.Second}, interval: 1 * time.Second}}, true},
+		{"GuestAttribute no interval", WaitForInstancesSignal{{Name: "instance1", GuestAttribute: &GuestAttribute{Namespace: "n", KeyName: "k"}, interval: 1 * time.Second}}, true},
 	}
 
 	for _, tt := range tests {
@@ -15,10 +15,16 @@
 package daisy
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"path"
 	"regexp"
+	"strings"
 	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 )
 
 var (
@@ -44,6 +50,9 @@ var (
 	// http://commondatastorage.googleapis.com/<bucket>/<object>
 	// https://commondatastorage.googleapis.com/<bucket>/<object>
 	gsHTTPRegex3 = regexp.MustCompile(fmt.Sprintf(`^http[s]?://(?:commondata)?storage\.googleapis\.com/%s/%s$`, bucket, object))
+	// Local paths, file:///<bucket>/<object>, where the bucket is the first
+	// directory of the path.
+	fileRegex = regexp.MustCompile(`^file:///([^/]+)(?:/(.*))?$`)
 
 	gcsAPIBase = "https://storage.cloud.google.com"
 )
@@ -57,7 +66,7 @@ func getGCSAPIPath(p string) (string, dErr) {
 }
 
 func splitGCSPath(p string) (string, string, dErr) {
-	for _, rgx := range []*regexp.Regexp{gsRegex, gsHTTPRegex1, gsHTTPRegex2, gsHTTPRegex3} {
+	for _, rgx := range []*regexp.Regexp{gsRegex, gsHTTPRegex1, gsHTTPRegex2, gsHTTPRegex3, fileRegex} {
 		matches := rgx.FindStringSubmatch(p)
 		if matches != nil {
 			return matches[1], matches[2], nil
@@ -77,3 +86,106 @@ type validatedBkts struct {
 
 var writableBkts validatedBkts
 var readableBkts validatedBkts
+
+// Storage is the object store a workflow keeps its sources, logs and outputs
+// in, and that CopyGCSObjects and DeleteResources.GCSPaths act on. Objects
+// are addressed by bucket and object name, as returned by splitGCSPath.
+// Errors for objects or buckets that don't exist are *googleapi.Error with
+// code 404, as returned by the GCS API.
+type Storage interface {
+	// NewReader reads the content of an object.
+	NewReader(ctx context.Context, bkt, obj string) (io.ReadCloser, error)
+	// NewWriter replaces the content of an object. The object is written
+	// when the writer is closed.
+	NewWriter(ctx context.Context, bkt, obj, contentType string) io.WriteCloser
+	// Attrs returns the attributes of an object.
+	Attrs(ctx context.Context, bkt, obj string) (*storage.ObjectAttrs, error)
+	// List returns the attributes of the objects of a bucket whose names
+	// start with prefix.
+	List(ctx context.Context, bkt, prefix string) ([]*storage.ObjectAttrs, error)
+	// Copy copies an object.
+	Copy(ctx context.Context, sBkt, sObj, dBkt, dObj string) error
+	// Delete deletes an object.
+	Delete(ctx context.Context, bkt, obj string) error
+	// SetACL sets the role of entity on an object.
+	SetACL(ctx context.Context, bkt, obj string, entity storage.ACLEntity, role storage.ACLRole) error
+	// CheckBucket returns an error if a bucket doesn't exist or can't be
+	// read.
+	CheckBucket(ctx context.Context, bkt string) error
+	// URL returns the URL of an object, which splitGCSPath splits back into
+	// bkt and obj.
+	URL(bkt, obj string) string
+}
+
+// store returns the storage of w: Storage if set, otherwise GCS through
+// StorageClient.
+func (w *Workflow) store() Storage {
+	if w.Storage != nil {
+		return w.Storage
+	}
+	return &gcsStorage{client: w.StorageClient}
+}
+
+// checkStorePath returns an error if p and the GCSPath of w are not in the
+// same storage: all the paths of a workflow with a local GCSPath are local
+// paths, file:///bkt/obj, and those of other workflows are GCS paths.
+func (w *Workflow) checkStorePath(p string) dErr {
+	if local := strings.HasPrefix(w.GCSPath, "file://"); local != strings.HasPrefix(p, "file://") {
+		return errf("cannot use %q with GCSPath %q: local and GCS paths can't be mixed", p, w.GCSPath)
+	}
+	return nil
+}
+
+// gcsStorage is the Storage of Google Cloud Storage.
+type gcsStorage struct {
+	client *storage.Client
+}
+
+func (s *gcsStorage) NewReader(ctx context.Context, bkt, obj string) (io.ReadCloser, error) {
+	return s.client.Bucket(bkt).Object(obj).NewReader(ctx)
+}
+
+func (s *gcsStorage) NewWriter(ctx context.Context, bkt, obj, contentType string) io.WriteCloser {
+	wc := s.client.Bucket(bkt).Object(obj).NewWriter(ctx)
+	wc.ContentType = contentType
+	return wc
+}
+
+func (s *gcsStorage) Attrs(ctx context.Context, bkt, obj string) (*storage.ObjectAttrs, error) {
+	return s.client.Bucket(bkt).Object(obj).Attrs(ctx)
+}
+
+func (s *gcsStorage) List(ctx context.Context, bkt, prefix string) ([]*storage.ObjectAttrs, error) {
+	var objs []*storage.ObjectAttrs
+	it := s.client.Bucket(bkt).Objects(ctx, &storage.Query{Prefix: prefix})
+	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, objAttr)
+	}
+	return objs, nil
+}
+
+func (s *gcsStorage) Copy(ctx context.Context, sBkt, sObj, dBkt, dObj string) error {
+	src := s.client.Bucket(sBkt).Object(sObj)
+	_, err := s.client.Bucket(dBkt).Object(dObj).CopierFrom(src).Run(ctx)
+	return err
+}
+
+func (s *gcsStorage) Delete(ctx context.Context, bkt, obj string) error {
+	return s.client.Bucket(bkt).Object(obj).Delete(ctx)
+}
+
+func (s *gcsStorage) SetACL(ctx context.Context, bkt, obj string, entity storage.ACLEntity, role storage.ACLRole) error {
+	return s.client.Bucket(bkt).Object(obj).ACL().Set(ctx, entity, role)
+}
+
+func (s *gcsStorage) CheckBucket(ctx context.Context, bkt string) error {
+	_, err := s.client.Bucket(bkt).Attrs(ctx)
+	return err
+}
+
+func (s *gcsStorage) URL(bkt, obj string) string {
+	return "gs://" + path.Join(bkt, obj)
+}
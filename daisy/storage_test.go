@@ -46,6 +46,10 @@ func TestSplitGCSPath(t *testing.T) {
 		{"https://storage.googleapis.com/foo/bar", "foo", "bar", false},
 		{"http://commondatastorage.googleapis.com/foo/bar", "foo", "bar", false},
 		{"https://commondatastorage.googleapis.com/foo/bar", "foo", "bar", false},
+		{"file:///foo", "foo", "", false},
+		{"file:///foo/bar/", "foo", "bar/", false},
+		{"file:///foo/bar/bar", "foo", "bar/bar", false},
+		{"file://foo/bar", "", "", true},
 		{"/local/path", "", "", true},
 	}
 
@@ -61,3 +65,26 @@ func TestSplitGCSPath(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckStorePath(t *testing.T) {
+	tests := []struct {
+		desc, gcsPath, p string
+		shouldErr        bool
+	}{
+		{"gcs case", "gs://bucket", "gs://other/obj", false},
+		{"local case", "file:///tmp/daisy", "file:///tmp/obj", false},
+		{"local path with GCS case", "gs://bucket", "file:///tmp/obj", true},
+		{"GCS path with local case", "file:///tmp/daisy", "gs://tmp/obj", true},
+		{"GCS URL with local case", "file:///tmp/daisy", "https://storage.cloud.google.com/tmp/obj", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.GCSPath = tt.gcsPath
+		err := w.checkStorePath(tt.p)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
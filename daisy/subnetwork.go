@@ -74,7 +74,7 @@ func (sn *Subnetwork) populate(ctx context.Context, s *Step) dErr {
 	var errs dErr
 	sn.Name, errs = sn.Resource.populateWithGlobal(ctx, s, sn.Name)
 
-	sn.Description = strOr(sn.Description, defaultDescription("Subnetwork", s.w.Name, s.w.username))
+	sn.Description = s.w.addWorkflowMarker(&sn.Resource, strOr(sn.Description, defaultDescription("Subnetwork", s.w.Name, s.w.username)))
 	sn.link = fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", sn.Project, getRegionFromZone(s.w.Zone), sn.Name)
 	return errs
 }
@@ -117,9 +117,9 @@ func newSubnetworkRegistry(w *Workflow) *subnetworkRegistry {
 	return nr
 }
 
-func (nr *subnetworkRegistry) deleteFn(res *Resource) dErr {
+func (nr *subnetworkRegistry) deleteFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(subnetworkURLRegex, res.link)
-	err := nr.w.ComputeClient.DeleteSubnetwork(m["project"], m["region"], m["subnetwork"])
+	err := nr.w.ComputeClient.WithContext(ctx).DeleteSubnetwork(m["project"], m["region"], m["subnetwork"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
@@ -27,7 +27,7 @@ func TestSubnetworkPopulate(t *testing.T) {
 	w := testWorkflow()
 	s, _ := w.NewStep("s")
 
-	desc := defaultDescription("Subnetwork", w.Name, w.username)
+	desc := defaultDescription("Subnetwork", w.Name, w.username) + " [daisy-workflow-id=abcdef daisy-workflow-name=test-wf]"
 	name := "name"
 	tests := []struct {
 		desc     string
@@ -0,0 +1,332 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"sort"
+	"time"
+)
+
+const (
+	summaryFile = "summary.json"
+
+	// Step and workflow statuses in a Summary.
+	StatusSucceeded = "Succeeded"
+	StatusFailed    = "Failed"
+	StatusSkipped   = "Skipped"
+	StatusResumed   = "Resumed"
+	StatusNotRun    = "NotRun"
+)
+
+// Summary is a machine readable report of a workflow run. It is written to
+// ${OUTSPATH}/summary.json when the run finishes.
+type Summary struct {
+	Workflow        string
+	ID              string
+	Status          string
+	Error           string `json:",omitempty"`
+	StartTime       time.Time
+	EndTime         time.Time
+	DurationSeconds float64
+	// All steps, including the steps of included workflows, subworkflows and
+	// ForEach items, in order of their start time.
+	Steps []*StepSummary
+	// The chain of steps that determined the run time of the workflow, see
+	// criticalPath.
+	CriticalPath []string
+	// Resources created by steps that succeeded, and resources deleted by
+	// steps or by the cleanup of the workflow.
+	Created []*ResourceSummary `json:",omitempty"`
+	Deleted []*ResourceSummary `json:",omitempty"`
+}
+
+// StepSummary is the result of a step in a Summary.
+type StepSummary struct {
+	// Step name, qualified by the names of the steps it is nested in.
+	Name            string
+	Type            string
+	Status          string
+	StartTime       *time.Time `json:",omitempty"`
+	EndTime         *time.Time `json:",omitempty"`
+	DurationSeconds float64    `json:",omitempty"`
+	Attempts        int        `json:",omitempty"`
+	Error           string     `json:",omitempty"`
+}
+
+// ResourceSummary is a resource created or deleted by a workflow run.
+type ResourceSummary struct {
+	Type string
+	Name string
+	Link string `json:",omitempty"`
+	// The step that created or deleted the resource, empty for resources
+	// deleted during cleanup.
+	Step string `json:",omitempty"`
+}
+
+// stepRecord is the run history of a step.
+type stepRecord struct {
+	status     string
+	start, end time.Time
+	attempts   int
+	err        string
+}
+
+// SetSummaryPath makes the workflow also write its run summary to the local
+// file p.
+func (w *Workflow) SetSummaryPath(p string) {
+	w.summaryPath = p
+}
+
+// record updates the run history of s, which is kept in the root workflow.
+func (s *Step) record(f func(r *stepRecord)) {
+	root := s.w.root()
+	root.recordsMx.Lock()
+	defer root.recordsMx.Unlock()
+	if root.records == nil {
+		root.records = map[*Step]*stepRecord{}
+	}
+	r, ok := root.records[s]
+	if !ok {
+		r = &stepRecord{}
+		root.records[s] = r
+	}
+	f(r)
+}
+
+func (s *Step) recordStart() {
+	s.record(func(r *stepRecord) {
+		*r = stepRecord{start: time.Now()}
+	})
+}
+
+func (s *Step) recordAttempt() {
+	s.record(func(r *stepRecord) {
+		r.attempts++
+	})
+}
+
+// recordEnd records the result of s. The last result counts, runStep
+// replaces the result of a step that timed out or was canceled.
+func (s *Step) recordEnd(err dErr) {
+	s.record(func(r *stepRecord) {
+		r.end = time.Now()
+		r.status = StatusSucceeded
+		r.err = ""
+		if err != nil {
+			r.status = StatusFailed
+			r.err = err.Error()
+		}
+	})
+}
+
+func (s *Step) recordStatus(status string) {
+	s.record(func(r *stepRecord) {
+		r.status = status
+	})
+}
+
+// stepRecords returns a copy of the run history of all steps of the workflow
+// tree.
+func (w *Workflow) stepRecords() map[*Step]stepRecord {
+	root := w.root()
+	root.recordsMx.Lock()
+	defer root.recordsMx.Unlock()
+	recs := map[*Step]stepRecord{}
+	for s, r := range root.records {
+		recs[s] = *r
+	}
+	return recs
+}
+
+// summary builds the Summary of a run of w that started at start and ended
+// with err.
+func (w *Workflow) summary(start time.Time, err error) *Summary {
+	end := time.Now()
+	sum := &Summary{
+		Workflow:        w.Name,
+		ID:              w.id,
+		Status:          StatusSucceeded,
+		StartTime:       start,
+		EndTime:         end,
+		DurationSeconds: end.Sub(start).Seconds(),
+	}
+	if err != nil {
+		sum.Status = StatusFailed
+		sum.Error = err.Error()
+	}
+
+	recs := w.stepRecords()
+	workflows := []*Workflow{w}
+	var add func(steps []*Step)
+	add = func(steps []*Step) {
+		for _, s := range steps {
+			sum.Steps = append(sum.Steps, stepSummary(s, recs))
+			nw, nested := nestedSteps(s)
+			if nw != nil {
+				workflows = append(workflows, nw)
+				nested = append(nested, nw.finalSteps()...)
+			}
+			add(nested)
+		}
+	}
+	add(append(sortedSteps(w), w.finalSteps()...))
+	sort.SliceStable(sum.Steps, func(i, j int) bool {
+		si, sj := sum.Steps[i].StartTime, sum.Steps[j].StartTime
+		if si == nil || sj == nil {
+			return si != nil
+		}
+		return si.Before(*sj)
+	})
+	sum.CriticalPath = w.criticalPath(recs)
+
+	succeeded := func(s *Step) bool {
+		st := recs[s].status
+		return st == StatusSucceeded || st == StatusResumed
+	}
+	seen := map[*baseResourceRegistry]bool{}
+	for _, rw := range workflows {
+		for _, r := range rw.resourceRegistries() {
+			if seen[r] {
+				continue
+			}
+			seen[r] = true
+			r.mx.Lock()
+			var names []string
+			for name := range r.m {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				res := r.m[name]
+				if res.creator != nil && succeeded(res.creator) {
+					sum.Created = append(sum.Created, &ResourceSummary{Type: r.typeName, Name: name, Link: res.link, Step: res.creator.fullName()})
+				}
+				if res.deleted {
+					rs := &ResourceSummary{Type: r.typeName, Name: name, Link: res.link}
+					if res.deleter != nil && succeeded(res.deleter) {
+						rs.Step = res.deleter.fullName()
+					}
+					sum.Deleted = append(sum.Deleted, rs)
+				}
+			}
+			r.mx.Unlock()
+		}
+	}
+	return sum
+}
+
+func stepSummary(s *Step, recs map[*Step]stepRecord) *StepSummary {
+	ss := &StepSummary{Name: s.fullName(), Type: s.typeName(), Status: StatusNotRun}
+	if s.skipped {
+		ss.Status = StatusSkipped
+	}
+	r, ok := recs[s]
+	if !ok {
+		return ss
+	}
+	if r.status != "" {
+		ss.Status = r.status
+	}
+	ss.Attempts = r.attempts
+	ss.Error = r.err
+	if !r.start.IsZero() {
+		start := r.start
+		ss.StartTime = &start
+	}
+	if !r.end.IsZero() {
+		end := r.end
+		ss.EndTime = &end
+		ss.DurationSeconds = end.Sub(r.start).Seconds()
+	}
+	return ss
+}
+
+// criticalPath returns the fullNames of the chain of steps that determined
+// the run time of w: starting from the step that finished last, it follows
+// the dependency that finished last. Steps that run other steps are replaced
+// by the critical path of their nested steps.
+func (w *Workflow) criticalPath(recs map[*Step]stepRecord) []string {
+	cur := lastFinished(sortedSteps(w), recs)
+	var chain []*Step
+	for cur != nil {
+		chain = append([]*Step{cur}, chain...)
+		var deps []*Step
+		for _, d := range w.Dependencies[cur.name] {
+			if ds, ok := w.Steps[d]; ok {
+				deps = append(deps, ds)
+			}
+		}
+		cur = lastFinished(deps, recs)
+	}
+
+	var p []string
+	for _, s := range chain {
+		p = append(p, expandCriticalPath(s, recs)...)
+	}
+	return p
+}
+
+func expandCriticalPath(s *Step, recs map[*Step]stepRecord) []string {
+	nw, nested := nestedSteps(s)
+	if nw != nil {
+		if p := nw.criticalPath(recs); len(p) > 0 {
+			return p
+		}
+	} else if last := lastFinished(nested, recs); last != nil {
+		return expandCriticalPath(last, recs)
+	}
+	return []string{s.fullName()}
+}
+
+// lastFinished returns the step of steps that finished last, or nil if none
+// of them ran.
+func lastFinished(steps []*Step, recs map[*Step]stepRecord) *Step {
+	var last *Step
+	var end time.Time
+	for _, s := range steps {
+		if r := recs[s]; !r.end.IsZero() && r.end.After(end) {
+			last, end = s, r.end
+		}
+	}
+	return last
+}
+
+// writeSummary writes the Summary of a run of w to ${OUTSPATH}/summary.json
+// and, if set, to the local summary path, with the secret values masked.
+func (w *Workflow) writeSummary(ctx context.Context, start time.Time, err error) {
+	data, mErr := json.MarshalIndent(w.summary(start, err), "", "  ")
+	if mErr != nil {
+		w.logWorkflow(SeverityError, "Error marshalling run summary: %v", mErr)
+		return
+	}
+	data = []byte(w.Redact(string(data)))
+
+	wc := w.store().NewWriter(ctx, w.bucket, path.Join(w.outsPath, summaryFile), "application/json")
+	if _, wErr := wc.Write(data); wErr != nil {
+		w.logWorkflow(SeverityError, "Error writing run summary: %v", wErr)
+	} else if cErr := wc.Close(); cErr != nil {
+		w.logWorkflow(SeverityError, "Error writing run summary: %v", cErr)
+	}
+
+	if w.summaryPath != "" {
+		if wErr := ioutil.WriteFile(w.summaryPath, data, 0644); wErr != nil {
+			w.logWorkflow(SeverityError, "Error writing run summary to %q: %v", w.summaryPath, wErr)
+		}
+	}
+}
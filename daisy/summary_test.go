@@ -0,0 +1,158 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	w := testWorkflow()
+	a, _ := w.NewStep("a")
+	a.CreateDisks = &CreateDisks{}
+	b, _ := w.NewStep("b")
+	b.CreateImages = &CreateImages{}
+	c, _ := w.NewStep("c")
+	c.StopInstances = &StopInstances{}
+	c.skipped = true
+	inc, _ := w.NewStep("inc")
+	iw := w.NewIncludedWorkflow()
+	inc.IncludeWorkflow = &IncludeWorkflow{Workflow: iw}
+	x, _ := iw.NewStep("x")
+	x.CreateDisks = &CreateDisks{}
+	y, _ := iw.NewStep("y")
+	y.DeleteResources = &DeleteResources{}
+	iw.Dependencies = map[string][]string{"y": {"x"}}
+	w.Dependencies = map[string][]string{"b": {"a"}, "inc": {"a"}}
+
+	t0 := time.Unix(1500000000, 0)
+	at := func(sec int) time.Time { return t0.Add(time.Duration(sec) * time.Second) }
+	w.records = map[*Step]*stepRecord{
+		a:   {status: StatusSucceeded, start: at(0), end: at(1), attempts: 1},
+		b:   {status: StatusFailed, start: at(1), end: at(2), attempts: 2, err: "boom"},
+		inc: {status: StatusSucceeded, start: at(1), end: at(5), attempts: 1},
+		x:   {status: StatusSucceeded, start: at(1), end: at(3), attempts: 1},
+		y:   {status: StatusSucceeded, start: at(3), end: at(5), attempts: 1},
+	}
+	w.disks.m = map[string]*Resource{
+		"d": {link: "projects/p/zones/z/disks/d", creator: a, deleter: y, deleted: true},
+		"e": {link: "projects/p/zones/z/disks/e", creator: x, deleted: true},
+	}
+	w.images.m = map[string]*Resource{"i": {link: "projects/p/global/images/i", creator: b}}
+
+	got := w.summary(at(0), errf("boom"))
+
+	if got.Status != StatusFailed || got.Error != "boom" {
+		t.Errorf("unexpected workflow status %q, error %q", got.Status, got.Error)
+	}
+	stepSum := func(name, typ, status string, start, end, attempts int, err string) *StepSummary {
+		s, e := at(start), at(end)
+		return &StepSummary{Name: name, Type: typ, Status: status, StartTime: &s, EndTime: &e, DurationSeconds: float64(end - start), Attempts: attempts, Error: err}
+	}
+	wantSteps := []*StepSummary{
+		stepSum("a", "CreateDisks", StatusSucceeded, 0, 1, 1, ""),
+		stepSum("b", "CreateImages", StatusFailed, 1, 2, 2, "boom"),
+		stepSum("inc", "IncludeWorkflow", StatusSucceeded, 1, 5, 1, ""),
+		stepSum("inc.x", "CreateDisks", StatusSucceeded, 1, 3, 1, ""),
+		stepSum("inc.y", "DeleteResources", StatusSucceeded, 3, 5, 1, ""),
+		{Name: "c", Type: "StopInstances", Status: StatusSkipped},
+	}
+	if diffRes := diff(got.Steps, wantSteps, 0); diffRes != "" {
+		t.Errorf("steps do not match expectation: (-got +want)\n%s", diffRes)
+	}
+	if diffRes := diff(got.CriticalPath, []string{"a", "inc.x", "inc.y"}, 0); diffRes != "" {
+		t.Errorf("critical path does not match expectation: (-got +want)\n%s", diffRes)
+	}
+	wantCreated := []*ResourceSummary{
+		{Type: "disk", Name: "d", Link: "projects/p/zones/z/disks/d", Step: "a"},
+		{Type: "disk", Name: "e", Link: "projects/p/zones/z/disks/e", Step: "inc.x"},
+	}
+	if diffRes := diff(got.Created, wantCreated, 0); diffRes != "" {
+		t.Errorf("created resources do not match expectation: (-got +want)\n%s", diffRes)
+	}
+	wantDeleted := []*ResourceSummary{
+		{Type: "disk", Name: "d", Link: "projects/p/zones/z/disks/d", Step: "inc.y"},
+		{Type: "disk", Name: "e", Link: "projects/p/zones/z/disks/e"},
+	}
+	if diffRes := diff(got.Deleted, wantDeleted, 0); diffRes != "" {
+		t.Errorf("deleted resources do not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestRecordEnd(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	s.recordStart()
+	s.recordEnd(nil)
+	// runStep replaces the result of a step that timed out.
+	s.recordEnd(errf("timeout"))
+	if r := w.stepRecords()[s]; r.status != StatusFailed || r.err != "timeout" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+}
+
+func TestRunStepRecords(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	s.timeout = time.Minute
+	s.testType = &mockStep{}
+	if err := w.runStep(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	skipped, _ := w.NewStep("skipped")
+	skipped.skipped = true
+	w.runStep(ctx, skipped)
+
+	recs := w.stepRecords()
+	if r := recs[s]; r.status != StatusSucceeded || r.attempts != 1 || r.start.IsZero() || r.end.Before(r.start) {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	if got := stepSummary(skipped, recs).Status; got != StatusSkipped {
+		t.Errorf("skipped step: got status %q, want %q", got, StatusSkipped)
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "daisy-summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := testWorkflow()
+	w.populate(ctx)
+	w.SetSummaryPath(filepath.Join(dir, summaryFile))
+	w.writeSummary(ctx, time.Now(), nil)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, summaryFile))
+	if err != nil {
+		t.Fatalf("summary not written: %v", err)
+	}
+	var got Summary
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("summary is not valid JSON: %v", err)
+	}
+	if got.Workflow != w.Name || got.ID != w.id || got.Status != StatusSucceeded {
+		t.Errorf("unexpected summary: %+v", got)
+	}
+}
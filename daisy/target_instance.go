@@ -79,7 +79,7 @@ func (ti *TargetInstance) populate(ctx context.Context, s *Step) dErr {
 		ti.Instance = fmt.Sprintf("projects/%s/zones/%s/instances/%s", ti.Project, ti.Zone, ti.Instance)
 	}
 
-	ti.Description = strOr(ti.Description, defaultDescription("TargetInstance", s.w.Name, s.w.username))
+	ti.Description = s.w.addWorkflowMarker(&ti.Resource, strOr(ti.Description, defaultDescription("TargetInstance", s.w.Name, s.w.username)))
 	ti.link = fmt.Sprintf("projects/%s/zones/%s/TargetInstances/%s", ti.Project, ti.Zone, ti.Name)
 	return errs
 }
@@ -115,9 +115,9 @@ func newTargetInstanceRegistry(w *Workflow) *targetInstanceRegistry {
 	return tir
 }
 
-func (tir *targetInstanceRegistry) deleteFn(res *Resource) dErr {
+func (tir *targetInstanceRegistry) deleteFn(ctx context.Context, res *Resource) dErr {
 	m := namedSubexp(targetInstanceURLRegex, res.link)
-	err := tir.w.ComputeClient.DeleteTargetInstance(m["project"], m["zone"], m["targetInstance"])
+	err := tir.w.ComputeClient.WithContext(ctx).DeleteTargetInstance(m["project"], m["zone"], m["targetInstance"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, err)
 	}
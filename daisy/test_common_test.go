@@ -108,7 +108,6 @@ func testWorkflow() *Workflow {
 	w.ComputeClient, _ = newTestGCEClient()
 	w.StorageClient, _ = newTestGCSClient()
 	w.cloudLoggingClient, _ = newTestLoggingClient()
-	w.Cancel = make(chan struct{})
 	w.Logger = &MockLogger{}
 	return w
 }
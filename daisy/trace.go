@@ -0,0 +1,441 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+// SetTraceFile makes the workflow write a trace of its run to the local file
+// path, in the Chrome trace event format. The trace shows the workflow, its
+// steps, included and sub workflows, API operation waits and instance signal
+// waits on a timeline, it can be opened with chrome://tracing or Perfetto.
+func (w *Workflow) SetTraceFile(path string) {
+	w.traceFile = path
+}
+
+// SetTraceEndpoint makes the workflow send the spans of its run to an
+// OpenTelemetry collector, as OTLP/HTTP JSON, e.g. http://localhost:4318.
+// If endpoint has no path, spans are sent to /v1/traces.
+func (w *Workflow) SetTraceEndpoint(endpoint string) {
+	w.traceEndpoint = endpoint
+}
+
+// span is a timed part of a workflow run, e.g. the run of a step. The parent
+// of a span is the span it is part of, spans nest like getChain.
+type span struct {
+	id, parent     uint64
+	name, category string
+	start, end     time.Time
+	attrs          map[string]string
+	err            string
+	t              *tracer
+}
+
+// tracer records the spans of the run of a workflow tree, it is only set on
+// the root workflow.
+type tracer struct {
+	traceID string
+	root    *span
+	spans   []*span
+	// Trace and span IDs are random, so that the spans of different runs sent
+	// to the same collector don't collide.
+	gen *rand.Rand
+	mx  sync.Mutex
+}
+
+func newTracer() *tracer {
+	// Runs started at the same time get different IDs too.
+	seed := time.Now().UnixNano()
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err == nil {
+		seed = int64(binary.BigEndian.Uint64(b[:]))
+	}
+	gen := rand.New(rand.NewSource(seed))
+	return &tracer{traceID: fmt.Sprintf("%016x%016x", gen.Uint64(), gen.Uint64()), gen: gen}
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) *span {
+	sp, _ := ctx.Value(spanKey{}).(*span)
+	return sp
+}
+
+// withSpanOf returns a copy of ctx holding the span of from, for work that is
+// part of the span of from but must not be canceled along with from.
+func withSpanOf(ctx, from context.Context) context.Context {
+	if sp := spanFromContext(from); sp != nil {
+		return context.WithValue(ctx, spanKey{}, sp)
+	}
+	return ctx
+}
+
+// startSpan starts a span, the child of the span of ctx, or of the root span
+// of the run. attrs are key value pairs. It returns a copy of ctx holding the
+// span, through which the operation waits of the Compute API clients using the
+// context are traced. If the run is not traced, it returns ctx and a nil span,
+// whose methods do nothing.
+func (w *Workflow) startSpan(ctx context.Context, name, category string, attrs ...string) (context.Context, *span) {
+	t := w.root().tracer
+	if t == nil {
+		return ctx, nil
+	}
+	sp := t.start(spanFromContext(ctx), name, category, attrs...)
+	ctx = context.WithValue(ctx, spanKey{}, sp)
+	return daisyCompute.WithOperationWaitHook(ctx, t.operationWaitHook), sp
+}
+
+func (t *tracer) start(parent *span, name, category string, attrs ...string) *span {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	// 0 is no span, the parent of the root span.
+	id := t.gen.Uint64()
+	for id == 0 {
+		id = t.gen.Uint64()
+	}
+	sp := &span{id: id, name: name, category: category, start: time.Now(), attrs: map[string]string{}, t: t}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		sp.attrs[attrs[i]] = attrs[i+1]
+	}
+	if parent == nil {
+		parent = t.root
+	}
+	if parent != nil {
+		sp.parent = parent.id
+	}
+	if t.root == nil {
+		t.root = sp
+	}
+	t.spans = append(t.spans, sp)
+	return sp
+}
+
+func (sp *span) setAttr(k, v string) {
+	if sp == nil {
+		return
+	}
+	sp.t.mx.Lock()
+	defer sp.t.mx.Unlock()
+	sp.attrs[k] = v
+}
+
+// finish ends sp, err is the result of the traced work.
+func (sp *span) finish(err error) {
+	if sp == nil {
+		return
+	}
+	sp.t.mx.Lock()
+	defer sp.t.mx.Unlock()
+	sp.end = time.Now()
+	if err != nil {
+		sp.err = err.Error()
+	}
+}
+
+// operationWaitHook traces an operation wait of a Compute API client.
+func (t *tracer) operationWaitHook(ctx context.Context, project, name string) func(*compute.Operation, error) {
+	sp := t.start(spanFromContext(ctx), "operation "+name, "api", "project", project, "operation", name)
+	return func(op *compute.Operation, err error) {
+		if op != nil && op.OperationType != "" {
+			t.mx.Lock()
+			sp.name = fmt.Sprintf("%s %s", op.OperationType, path.Base(op.TargetLink))
+			sp.attrs["operationType"] = op.OperationType
+			sp.attrs["targetLink"] = op.TargetLink
+			t.mx.Unlock()
+		}
+		sp.finish(err)
+	}
+}
+
+// finishedSpans returns the spans sorted by start time, the spans that did not
+// end end now.
+func (t *tracer) finishedSpans() []*span {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	now := time.Now()
+	spans := make([]*span, len(t.spans))
+	for i, sp := range t.spans {
+		c := *sp
+		if c.end.IsZero() {
+			c.end = now
+		}
+		spans[i] = &c
+	}
+	// Spans that start at the same time are sorted longest first, so that
+	// parents come before their children.
+	sort.SliceStable(spans, func(i, j int) bool {
+		if !spans[i].start.Equal(spans[j].start) {
+			return spans[i].start.Before(spans[j].start)
+		}
+		return spans[i].end.After(spans[j].end)
+	})
+	return spans
+}
+
+// chromeLanes assigns the spans, sorted by start time, to the threads of a
+// Chrome trace, where the spans of a thread must nest. A span goes on the
+// thread of its parent if the parent is the innermost span running there,
+// else on a free thread, so that concurrent steps and operations get threads
+// of their own.
+func chromeLanes(spans []*span) map[uint64]int {
+	var lanes [][]*span
+	laneOf := map[uint64]int{}
+	fits := func(l int, sp *span) bool {
+		open := lanes[l]
+		for len(open) > 0 && !open[len(open)-1].end.After(sp.start) {
+			open = open[:len(open)-1]
+		}
+		lanes[l] = open
+		if len(open) == 0 {
+			return true
+		}
+		top := open[len(open)-1]
+		return top.id == sp.parent && !sp.end.After(top.end)
+	}
+	for _, sp := range spans {
+		lane := -1
+		if l, ok := laneOf[sp.parent]; ok && fits(l, sp) {
+			lane = l
+		}
+		for l := 0; lane == -1 && l < len(lanes); l++ {
+			if fits(l, sp) {
+				lane = l
+			}
+		}
+		if lane == -1 {
+			lanes = append(lanes, nil)
+			lane = len(lanes) - 1
+		}
+		lanes[lane] = append(lanes[lane], sp)
+		laneOf[sp.id] = lane
+	}
+	return laneOf
+}
+
+type chromeEvent struct {
+	Name     string            `json:"name"`
+	Category string            `json:"cat"`
+	Phase    string            `json:"ph"`
+	Time     int64             `json:"ts"`
+	Duration int64             `json:"dur"`
+	PID      int               `json:"pid"`
+	TID      int               `json:"tid"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+type chromeTrace struct {
+	TraceEvents     []chromeEvent     `json:"traceEvents"`
+	DisplayTimeUnit string            `json:"displayTimeUnit"`
+	OtherData       map[string]string `json:"otherData,omitempty"`
+}
+
+// chromeTrace returns the spans in the Chrome trace event format.
+func (t *tracer) chromeTrace(w *Workflow) ([]byte, error) {
+	spans := t.finishedSpans()
+	lanes := chromeLanes(spans)
+	ct := chromeTrace{
+		TraceEvents:     []chromeEvent{},
+		DisplayTimeUnit: "ms",
+		OtherData:       map[string]string{"workflow": w.Name, "workflowId": w.id, "traceId": t.traceID},
+	}
+	for _, sp := range spans {
+		args := map[string]string{}
+		for k, v := range sp.attrs {
+			args[k] = v
+		}
+		if sp.err != "" {
+			args["error"] = sp.err
+		}
+		ct.TraceEvents = append(ct.TraceEvents, chromeEvent{
+			Name:     sp.name,
+			Category: sp.category,
+			Phase:    "X",
+			Time:     sp.start.UnixNano() / 1000,
+			Duration: sp.end.Sub(sp.start).Nanoseconds() / 1000,
+			PID:      1,
+			TID:      lanes[sp.id],
+			Args:     args,
+		})
+	}
+	return json.MarshalIndent(ct, "", "  ")
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	var keys []string
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var res []otlpAttribute
+	for _, k := range keys {
+		res = append(res, otlpAttribute{Key: k, Value: otlpValue{StringValue: attrs[k]}})
+	}
+	return res
+}
+
+// otlpRequest returns the spans as an OTLP/HTTP JSON export request.
+func (t *tracer) otlpRequest(w *Workflow) ([]byte, error) {
+	ss := otlpScopeSpans{Spans: []otlpSpan{}}
+	ss.Scope.Name = "daisy"
+	for _, sp := range t.finishedSpans() {
+		attrs := map[string]string{"daisy.category": sp.category}
+		for k, v := range sp.attrs {
+			attrs[k] = v
+		}
+		os := otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            fmt.Sprintf("%016x", sp.id),
+			Name:              sp.name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: fmt.Sprint(sp.start.UnixNano()),
+			EndTimeUnixNano:   fmt.Sprint(sp.end.UnixNano()),
+			Attributes:        otlpAttributes(attrs),
+		}
+		if sp.parent != 0 {
+			os.ParentSpanID = fmt.Sprintf("%016x", sp.parent)
+		}
+		if sp.err != "" {
+			os.Status = otlpStatus{Code: 2, Message: sp.err} // STATUS_CODE_ERROR
+		}
+		ss.Spans = append(ss.Spans, os)
+	}
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{ss}}
+	rs.Resource.Attributes = otlpAttributes(map[string]string{"service.name": "daisy", "daisy.workflow": w.Name, "daisy.workflow_id": w.id})
+	return json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{rs}})
+}
+
+// otlpURL returns the URL spans are sent to for an OTLP/HTTP endpoint.
+func otlpURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("trace endpoint %q is not an http or https URL", endpoint)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// writeTrace exports the spans of the run of w, once it ended.
+func (w *Workflow) writeTrace(ctx context.Context) {
+	t := w.tracer
+	if t == nil {
+		return
+	}
+	if w.traceFile != "" {
+		data, err := t.chromeTrace(w)
+		if err == nil {
+			err = ioutil.WriteFile(w.traceFile, []byte(w.Redact(string(data))), 0644)
+		}
+		if err != nil {
+			w.logWorkflow(SeverityError, "Error writing trace to %q: %v", w.traceFile, err)
+		}
+	}
+	if w.traceEndpoint != "" {
+		if err := w.sendTrace(ctx); err != nil {
+			w.logWorkflow(SeverityError, "Error sending trace to %q: %v", w.traceEndpoint, err)
+		}
+	}
+}
+
+func (w *Workflow) sendTrace(ctx context.Context) error {
+	u, err := otlpURL(w.traceEndpoint)
+	if err != nil {
+		return err
+	}
+	data, err := w.tracer.otlpRequest(w)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(w.Redact(string(data))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func spanByName(t *tracer, name string) *span {
+	for _, sp := range t.spans {
+		if sp.name == name {
+			return sp
+		}
+	}
+	return nil
+}
+
+func TestStartSpan(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	if sctx, sp := w.startSpan(ctx, "untraced", "workflow"); sctx != ctx || sp != nil {
+		t.Error("untraced workflow started a span")
+	}
+	// The methods of the nil span do nothing.
+	var sp *span
+	sp.setAttr("k", "v")
+	sp.finish(errors.New("error"))
+
+	w.tracer = newTracer()
+	rctx, root := w.startSpan(ctx, "run", "workflow")
+	sw := testWorkflow()
+	sw.parent = w
+	sctx, child := sw.startSpan(rctx, "step", "step", "type", "CreateDisks")
+	_, other := w.startSpan(ctx, "cleanup", "workflow")
+	child.finish(errors.New("step failed"))
+
+	if root.parent != 0 {
+		t.Errorf("root span has parent %d", root.parent)
+	}
+	if child.parent != root.id || other.parent != root.id {
+		t.Errorf("got parents %d and %d, want the root span %d", child.parent, other.parent, root.id)
+	}
+	if spanFromContext(sctx) != child || len(w.tracer.spans) != 3 || sw.tracer != nil {
+		t.Error("span not recorded by the tracer of the root workflow")
+	}
+	if child.attrs["type"] != "CreateDisks" || child.err != "step failed" || child.end.IsZero() {
+		t.Errorf("unexpected child span: %+v", child)
+	}
+
+	// Operation waits of clients using the context are traced.
+	if err := w.ComputeClient.WithContext(sctx).CreateDisk(testProject, testZone, &compute.Disk{Name: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.tracer.spans) != 4 {
+		t.Fatalf("got %d spans, want an operation span", len(w.tracer.spans))
+	}
+	if op := w.tracer.spans[3]; op.category != "api" || op.parent != child.id || op.end.IsZero() {
+		t.Errorf("unexpected operation span: %+v", op)
+	}
+}
+
+func TestTracerIDs(t *testing.T) {
+	t1, t2 := newTracer(), newTracer()
+	if t1.traceID == t2.traceID {
+		t.Errorf("two runs got the same trace ID %q", t1.traceID)
+	}
+	s1, s2 := t1.start(nil, "run", "workflow"), t2.start(nil, "run", "workflow")
+	if s1.id == 0 || s1.id == s2.id {
+		t.Errorf("got span IDs %d and %d for the first spans of two runs", s1.id, s2.id)
+	}
+}
+
+func TestOperationWaitHookSpan(t *testing.T) {
+	tr := newTracer()
+	root := tr.start(nil, "run", "workflow")
+	done := tr.operationWaitHook(context.Background(), "p", "op-1")
+	done(&compute.Operation{OperationType: "insert", TargetLink: "projects/p/zones/z/disks/d"}, nil)
+	sp := tr.spans[1]
+	if sp.name != "insert d" || sp.parent != root.id || sp.attrs["project"] != "p" || sp.attrs["operationType"] != "insert" {
+		t.Errorf("unexpected operation span: %+v", sp)
+	}
+}
+
+func TestChromeLanes(t *testing.T) {
+	t0 := time.Now()
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	spans := []*span{
+		{id: 1, start: at(0), end: at(10)},
+		{id: 2, parent: 1, start: at(1), end: at(5)},
+		{id: 3, parent: 1, start: at(2), end: at(4)},
+		{id: 4, parent: 2, start: at(2), end: at(3)},
+		{id: 5, parent: 1, start: at(6), end: at(9)},
+	}
+	// 3 runs along with 2 and gets a lane of its own, 4 nests in 2 and 5
+	// starts after 2 ended.
+	want := map[uint64]int{1: 0, 2: 0, 3: 1, 4: 0, 5: 0}
+	if diffRes := diff(chromeLanes(spans), want, 0); diffRes != "" {
+		t.Errorf("lanes do not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestChromeTrace(t *testing.T) {
+	w := testWorkflow()
+	tr := newTracer()
+	root := tr.start(nil, "run", "workflow")
+	step := tr.start(root, "step", "step", "type", "CreateDisks")
+	step.finish(errors.New("failed"))
+	root.finish(nil)
+
+	data, err := tr.chromeTrace(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got chromeTrace
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.TraceEvents) != 2 || got.OtherData["workflowId"] != w.id {
+		t.Fatalf("unexpected trace: %s", data)
+	}
+	e := got.TraceEvents[1]
+	if e.Name != "step" || e.Category != "step" || e.Phase != "X" || e.Args["type"] != "CreateDisks" || e.Args["error"] != "failed" {
+		t.Errorf("unexpected step event: %+v", e)
+	}
+	if e.Time != step.start.UnixNano()/1000 || e.Duration != step.end.Sub(step.start).Nanoseconds()/1000 {
+		t.Errorf("unexpected step event times: %+v", e)
+	}
+}
+
+func TestOTLPURL(t *testing.T) {
+	tests := []struct {
+		desc, endpoint, want string
+		shouldErr            bool
+	}{
+		{"host case", "http://localhost:4318", "http://localhost:4318/v1/traces", false},
+		{"slash case", "https://collector/", "https://collector/v1/traces", false},
+		{"path case", "https://collector/otlp/traces", "https://collector/otlp/traces", false},
+		{"scheme case", "collector:4318", "", true},
+	}
+	for _, tt := range tests {
+		got, err := otlpURL(tt.endpoint)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && (err != nil || got != tt.want) {
+			t.Errorf("%s: got %q, error: %v, want %q", tt.desc, got, err, tt.want)
+		}
+	}
+}
+
+func TestSendTrace(t *testing.T) {
+	var got otlpRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(rw, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	w := testWorkflow()
+	w.tracer = newTracer()
+	w.SetTraceEndpoint(ts.URL)
+	root := w.tracer.start(nil, "run", "workflow")
+	w.tracer.start(root, "step", "step").finish(errors.New("failed"))
+	if err := w.sendTrace(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].TraceID != w.tracer.traceID || len(spans[0].TraceID) != 32 || spans[0].ParentSpanID != "" {
+		t.Errorf("unexpected root span: %+v", spans[0])
+	}
+	if spans[1].ParentSpanID != spans[0].SpanID || len(spans[1].SpanID) != 16 || spans[1].Status.Code != 2 || spans[1].Status.Message != "failed" {
+		t.Errorf("unexpected step span: %+v", spans[1])
+	}
+
+	w.SetTraceEndpoint(ts.URL + "/dne")
+	if err := w.sendTrace(context.Background()); err == nil {
+		t.Error("expected error for a failed request")
+	}
+}
+
+func TestRunTrace(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	gcsPath := "file://" + filepath.ToSlash(td)
+
+	w := testWorkflow()
+	w.GCSPath = gcsPath
+	w.StorageClient = nil
+	w.Sources = map[string]string{"test.txt": "./test_data/test.txt"}
+	s, _ := w.NewStep("copy")
+	s.CopyGCSObjects = &CopyGCSObjects{{Source: "${SOURCESPATH}/test.txt", Destination: gcsPath + "/out/test.txt"}}
+	traceFile := filepath.Join(td, "trace.json")
+	w.SetTraceFile(traceFile)
+	if err := w.Run(ctx); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	run := spanByName(w.tracer, "run "+w.Name)
+	wf := spanByName(w.tracer, "workflow "+w.Name)
+	step := spanByName(w.tracer, "copy")
+	if run == nil || wf == nil || step == nil {
+		t.Fatalf("missing spans: %+v", w.tracer.spans)
+	}
+	for _, n := range []string{"validate", "upload sources", "cleanup"} {
+		if sp := spanByName(w.tracer, n); sp == nil || sp.parent != run.id {
+			t.Errorf("span %q is not a child of the run span: %+v", n, sp)
+		}
+	}
+	if wf.parent != run.id || step.parent != wf.id || step.attrs["type"] != "CopyGCSObjects" {
+		t.Errorf("unexpected spans: %+v, %+v", wf, step)
+	}
+
+	data, err := ioutil.ReadFile(traceFile)
+	if err != nil {
+		t.Fatalf("trace not written: %v", err)
+	}
+	var ct chromeTrace
+	if err := json.Unmarshal(data, &ct); err != nil || len(ct.TraceEvents) != len(w.tracer.spans) {
+		t.Errorf("unexpected trace, error: %v:\n%s", err, data)
+	}
+}
@@ -57,6 +57,9 @@ func (w *Workflow) validateRequiredFields() dErr {
 	if len(w.Steps) == 0 {
 		return errf("must provide at least one step in workflow field 'Steps'")
 	}
+	if err := checkConcurrency(w.MaxConcurrentSteps, w.ConcurrencyLimits); err != nil {
+		return errf("bad workflow concurrency limits: %v", err)
+	}
 	for name := range w.Steps {
 		if name == "" {
 			return errf("no name defined for Step %q", name)
@@ -66,6 +69,11 @@ func (w *Workflow) validateRequiredFields() dErr {
 }
 
 func (w *Workflow) validate(ctx context.Context) dErr {
+	for _, h := range w.Webhooks {
+		if err := h.validate(); err != nil {
+			return err
+		}
+	}
 	return w.validateDAG(ctx)
 }
 
@@ -99,7 +107,10 @@ func (w *Workflow) validateDAG(ctx context.Context) dErr {
 			return errf("cyclic dependency on step %v", s)
 		}
 	}
-	return w.traverseDAG(func(s *Step) dErr { return s.validate(ctx) })
+	if err := w.traverseDAG(ctx, func(s *Step) dErr { return s.validate(ctx) }); err != nil {
+		return err
+	}
+	return w.validateFinalSteps(ctx)
 }
 
 func (w *Workflow) validateVarsSubbed() dErr {
@@ -108,7 +119,7 @@ func (w *Workflow) validateVarsSubbed() dErr {
 		switch v.Interface().(type) {
 		case string:
 			if match := unsubbedVarRgx.FindStringSubmatch(v.String()); match != nil {
-				if !sourceVarRgx.MatchString(v.String()) {
+				if !sourceVarRgx.MatchString(v.String()) && !outputVarRgx.MatchString(v.String()) && !forEachVarRgx.MatchString(v.String()) && !failureVarRgx.MatchString(v.String()) {
 					return errf("Unresolved var %q found in %q", match[0], v.String())
 				}
 			}
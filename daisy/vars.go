@@ -0,0 +1,316 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Var types.
+const (
+	VarTypeString   = "string"
+	VarTypeInt      = "int"
+	VarTypeBool     = "bool"
+	VarTypeDuration = "duration"
+	VarTypeList     = "list"
+)
+
+var varTypes = []string{VarTypeString, VarTypeInt, VarTypeBool, VarTypeDuration, VarTypeList}
+
+// varRefRgx matches the references to an environment variable (${ENV:NAME})
+// or to the content of a file (${FILE:path}) a Var value is sourced from.
+var varRefRgx = regexp.MustCompile(`\$\{(ENV|FILE):([^}]+)}`)
+
+// redacted replaces the values of secret Vars.
+const redacted = "****"
+
+// minSecretLength is the length of the shortest secret value, or list item,
+// that is masked. Shorter values would mask common words and characters.
+const minSecretLength = 4
+
+// populate sets the Value of v, the Var k, to its Default if it is not set,
+// resolves its ENV and FILE references, and checks and normalizes it.
+// Relative FILE paths are relative to dir.
+func (v *Var) populate(k, dir string) dErr {
+	if err := v.validate(); err != nil {
+		return errf("bad declaration of var %q: %v", k, err)
+	}
+	if v.Value == "" {
+		v.Value = v.Default
+	}
+	val, err := resolveVarRefs(v.Value, dir)
+	if err != nil {
+		return errf("cannot populate var %q: %v", k, err)
+	}
+	if val == "" {
+		if v.Required {
+			return errf("cannot populate workflow, required var %q is unset", k)
+		}
+		v.Value = ""
+		return nil
+	}
+	checked, err := v.check(val)
+	if err != nil {
+		return errf("bad value %s for var %q: %v", v.quote(val), k, err)
+	}
+	v.Value = checked
+	if v.Secret {
+		for _, s := range v.secretValues() {
+			if s != "" && len(s) < minSecretLength {
+				return errf("bad value for secret var %q: values and list items must be at least %d characters long to be masked", k, minSecretLength)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveVarRefs replaces the ${ENV:NAME} and ${FILE:path} references in
+// value with the value of the environment variable NAME and the content of
+// the file at path, without its trailing newlines.
+func resolveVarRefs(value, dir string) (string, dErr) {
+	var err dErr
+	value = varRefRgx.ReplaceAllStringFunc(value, func(ref string) string {
+		m := varRefRgx.FindStringSubmatch(ref)
+		if m[1] == "ENV" {
+			env, ok := os.LookupEnv(m[2])
+			if !ok && err == nil {
+				err = errf("environment variable %q is not set", m[2])
+			}
+			return env
+		}
+		p := m[2]
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		b, rErr := ioutil.ReadFile(p)
+		if rErr != nil && err == nil {
+			err = errf("error reading %q: %v", m[2], rErr)
+		}
+		return strings.TrimRight(string(b), "\r\n")
+	})
+	return value, err
+}
+
+// quote quotes value for an error message, or masks it if v is secret.
+func (v *Var) quote(value string) string {
+	if v.Secret {
+		return redacted
+	}
+	return strconv.Quote(value)
+}
+
+// validate checks the Type, Pattern and Default of v.
+func (v *Var) validate() dErr {
+	if v.Type != "" && !strIn(v.Type, varTypes) {
+		return errf("unknown Type %q, must be one of %q", v.Type, varTypes)
+	}
+	if _, err := v.pattern(); err != nil {
+		return errf("bad Pattern: %v", err)
+	}
+	// A Default sourced from the environment or a file is checked once it is
+	// resolved.
+	if v.Default != "" && !varRefRgx.MatchString(v.Default) {
+		if _, err := v.check(v.Default); err != nil {
+			return errf("bad Default %s: %v", v.quote(v.Default), err)
+		}
+	}
+	return nil
+}
+
+func (v *Var) pattern() (*regexp.Regexp, error) {
+	if v.Pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + v.Pattern + ")$")
+}
+
+// check checks value against the Type, Enum and Pattern of v. It returns the
+// value normalized: bools are true or false, ints are in decimal and lists
+// have no blank items or spaces around the items.
+func (v *Var) check(value string) (string, dErr) {
+	items := []string{value}
+	switch v.Type {
+	case "", VarTypeString:
+	case VarTypeInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", errf("not an int")
+		}
+		value = strconv.FormatInt(i, 10)
+		items = []string{value}
+	case VarTypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", errf("not a bool, must be true or false")
+		}
+		value = strconv.FormatBool(b)
+		items = []string{value}
+	case VarTypeDuration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return "", errf("not a duration, e.g. 90s or 10m")
+		}
+	case VarTypeList:
+		items = nil
+		for _, i := range strings.Split(value, ",") {
+			if i = strings.TrimSpace(i); i != "" {
+				items = append(items, i)
+			}
+		}
+		value = strings.Join(items, ",")
+	default:
+		return "", errf("unknown Type %q", v.Type)
+	}
+
+	rgx, err := v.pattern()
+	if err != nil {
+		return "", newErr(err)
+	}
+	for _, i := range items {
+		if len(v.Enum) > 0 && !strIn(i, v.Enum) {
+			return "", errf("%s is not one of %q", v.quote(i), v.Enum)
+		}
+		if rgx != nil && !rgx.MatchString(i) {
+			return "", errf("%s does not match %q", v.quote(i), v.Pattern)
+		}
+	}
+	return value, nil
+}
+
+// SetVar sets the value of the Var k of the workflow to v. Unlike AddVar, it
+// returns an error if the workflow does not declare k, or if v does not fit
+// the Type, Enum or Pattern of k. A v with ENV or FILE references is checked
+// once they are resolved, when the workflow is populated.
+func (w *Workflow) SetVar(k, v string) error {
+	vr, ok := w.Vars[k]
+	if !ok {
+		return errf("unknown workflow Var %q passed to Workflow %q", k, w.Name)
+	}
+	if err := vr.validate(); err != nil {
+		return errf("bad declaration of workflow Var %q: %v", k, err)
+	}
+	if v != "" && !varRefRgx.MatchString(v) {
+		val, err := vr.check(v)
+		if err != nil {
+			return errf("bad value %s for workflow Var %q: %v", vr.quote(v), k, err)
+		}
+		v = val
+	}
+	vr.Value = v
+	w.Vars[k] = vr
+	return nil
+}
+
+// DescribeVars returns a table of the Vars of the workflow, with their type,
+// whether they are required, their default, the values they allow and their
+// description. The defaults of secret Vars are masked, unless they are only
+// ENV and FILE references.
+func (w *Workflow) DescribeVars() string {
+	var names []string
+	for k := range w.Vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tREQUIRED\tDEFAULT\tALLOWED\tDESCRIPTION")
+	for _, k := range names {
+		v := w.Vars[k]
+		typ := v.Type
+		if typ == "" {
+			typ = VarTypeString
+		}
+		req := "no"
+		if v.Required {
+			req = "yes"
+		}
+		def := v.Default
+		if v.Value != "" {
+			def = v.Value
+		}
+		if v.Secret && varRefRgx.ReplaceAllString(def, "") != "" {
+			def = redacted
+		}
+		var allowed []string
+		if len(v.Enum) > 0 {
+			allowed = append(allowed, strings.Join(v.Enum, "|"))
+		}
+		if v.Pattern != "" {
+			allowed = append(allowed, "/"+v.Pattern+"/")
+		}
+		desc := strings.Join(strings.Fields(v.Description), " ")
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", k, typ, req, def, strings.Join(allowed, " "), desc)
+	}
+	tw.Flush()
+	return b.String()
+}
+
+// addSecret makes the logs and reports of the workflow tree of w mask the
+// value of the secret Var v, and the items of a list. The JSON escaped forms
+// are masked too, for the JSON reports.
+func (w *Workflow) addSecret(v Var) {
+	root := w.root()
+	root.secretsMx.Lock()
+	defer root.secretsMx.Unlock()
+	for _, s := range v.secretValues() {
+		b, _ := json.Marshal(s)
+		for _, s := range []string{s, strings.Trim(string(b), `"`)} {
+			if s != "" && !strIn(s, root.secrets) {
+				root.secrets = append(root.secrets, s)
+			}
+		}
+	}
+	// The replacer tries the values in order, longer values go first so that
+	// a value containing another one is masked whole.
+	sort.SliceStable(root.secrets, func(i, j int) bool { return len(root.secrets[i]) > len(root.secrets[j]) })
+	var oldnew []string
+	for _, s := range root.secrets {
+		oldnew = append(oldnew, s, redacted)
+	}
+	root.secretsReplacer = strings.NewReplacer(oldnew...)
+}
+
+// secretValues returns the value of v, and the items of a list.
+func (v *Var) secretValues() []string {
+	values := []string{v.Value}
+	if v.Type == VarTypeList {
+		values = append(values, strings.Split(v.Value, ",")...)
+	}
+	return values
+}
+
+// Redact masks the values of the secret Vars of the workflow tree of w in s,
+// e.g. in an error message.
+func (w *Workflow) Redact(s string) string {
+	root := w.root()
+	root.secretsMx.RLock()
+	r := root.secretsReplacer
+	root.secretsMx.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
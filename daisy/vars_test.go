@@ -0,0 +1,269 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVarPopulate(t *testing.T) {
+	tests := []struct {
+		desc      string
+		v         Var
+		want      string
+		shouldErr bool
+	}{
+		{"string case", Var{Value: "foo"}, "foo", false},
+		{"default case", Var{Default: "foo"}, "foo", false},
+		{"value over default case", Var{Value: "bar", Default: "foo"}, "bar", false},
+		{"unset case", Var{}, "", false},
+		{"required case", Var{Required: true}, "", true},
+		{"required default case", Var{Required: true, Default: "foo"}, "foo", false},
+		{"int case", Var{Value: "+042", Type: VarTypeInt}, "42", false},
+		{"bad int case", Var{Value: "4.2", Type: VarTypeInt}, "", true},
+		{"bool case", Var{Value: "TRUE", Type: VarTypeBool}, "true", false},
+		{"bad bool case", Var{Value: "yes", Type: VarTypeBool}, "", true},
+		{"duration case", Var{Value: "90s", Type: VarTypeDuration}, "90s", false},
+		{"bad duration case", Var{Value: "90", Type: VarTypeDuration}, "", true},
+		{"list case", Var{Value: " a, b,,c ", Type: VarTypeList}, "a,b,c", false},
+		{"enum case", Var{Value: "b", Enum: []string{"a", "b"}}, "b", false},
+		{"bad enum case", Var{Value: "c", Enum: []string{"a", "b"}}, "", true},
+		{"list enum case", Var{Value: "a,b", Type: VarTypeList, Enum: []string{"a", "b"}}, "a,b", false},
+		{"bad list enum case", Var{Value: "a,c", Type: VarTypeList, Enum: []string{"a", "b"}}, "", true},
+		{"pattern case", Var{Value: "disk-1", Pattern: "[a-z]+-[0-9]"}, "disk-1", false},
+		{"unanchored pattern case", Var{Value: "disk-12", Pattern: "[a-z]+-[0-9]"}, "", true},
+		{"alternation pattern case", Var{Value: "xa", Pattern: "a|b"}, "", true},
+		{"bad pattern case", Var{Value: "a", Pattern: "("}, "", true},
+		{"bad type case", Var{Value: "a", Type: "float"}, "", true},
+		{"bad default case", Var{Value: "1", Default: "one", Type: VarTypeInt}, "", true},
+		{"secret case", Var{Value: "abcd", Secret: true}, "abcd", false},
+		{"short secret case", Var{Value: "abc", Secret: true}, "", true},
+		{"short secret item case", Var{Value: "abcd,ab", Type: VarTypeList, Secret: true}, "", true},
+		{"empty secret case", Var{Secret: true}, "", false},
+	}
+
+	for _, tt := range tests {
+		v := tt.v
+		err := v.populate("foo", "")
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if !tt.shouldErr && v.Value != tt.want {
+			t.Errorf("%s: got Value %q, want %q", tt.desc, v.Value, tt.want)
+		}
+	}
+}
+
+func TestPopulateTypedVars(t *testing.T) {
+	w := testWorkflow()
+	w.Vars = map[string]Var{
+		"install": {Value: "1", Type: VarTypeBool},
+		"size":    {Default: "10", Type: VarTypeInt},
+	}
+	w.Project = "${size}-${install}"
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if w.Project != "10-true" {
+		t.Errorf("got Project %q, want %q", w.Project, "10-true")
+	}
+
+	w = testWorkflow()
+	w.Vars = map[string]Var{"install": {Value: "yes", Type: VarTypeBool}}
+	if err := w.populate(context.Background()); err == nil {
+		t.Error("expected error for bad bool Var")
+	}
+}
+
+func TestSetVar(t *testing.T) {
+	w := testWorkflow()
+	w.Vars = map[string]Var{
+		"install": {Type: VarTypeBool, Description: "install packages"},
+		"bad":     {Type: "float"},
+	}
+
+	if err := w.SetVar("install", "T"); err != nil {
+		t.Fatal(err)
+	}
+	want := Var{Value: "true", Type: VarTypeBool, Description: "install packages"}
+	if diffRes := diff(w.Vars["install"], want, 0); diffRes != "" {
+		t.Errorf("Var not set as expected: (-got +want)\n%s", diffRes)
+	}
+	if err := w.SetVar("install", ""); err != nil || w.Vars["install"].Value != "" {
+		t.Errorf("empty value not set, error: %v", err)
+	}
+
+	tests := []struct {
+		desc, k, v, wantErr string
+	}{
+		{"unknown case", "dne", "a", `unknown workflow Var "dne" passed to Workflow`},
+		{"bad value case", "install", "yes", `bad value "yes" for workflow Var "install": not a bool`},
+		{"bad declaration case", "bad", "1", `bad declaration of workflow Var "bad"`},
+	}
+	for _, tt := range tests {
+		err := w.SetVar(tt.k, tt.v)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want %q", tt.desc, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAddVarKeepsDeclaration(t *testing.T) {
+	w := testWorkflow()
+	w.Vars = map[string]Var{"install": {Type: VarTypeBool, Required: true}}
+	w.AddVar("install", "false")
+	w.AddVar("new", "foo")
+	want := map[string]Var{
+		"install": {Value: "false", Type: VarTypeBool, Required: true},
+		"new":     {Value: "foo"},
+	}
+	if diffRes := diff(w.Vars, want, 0); diffRes != "" {
+		t.Errorf("Vars do not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestDescribeVars(t *testing.T) {
+	w := testWorkflow()
+	w.Vars = map[string]Var{
+		"size":    {Default: "10", Type: VarTypeInt, Pattern: "[1-9][0-9]*", Description: "disk size\n in GB"},
+		"install": {Value: "true", Type: VarTypeBool},
+		"machine": {Required: true, Enum: []string{"a", "b"}},
+	}
+	want := "NAME     TYPE    REQUIRED  DEFAULT  ALLOWED        DESCRIPTION\n" +
+		"install  bool    no        true                    \n" +
+		"machine  string  yes                a|b            \n" +
+		"size     int     no        10       /[1-9][0-9]*/  disk size in GB\n"
+	if got := w.DescribeVars(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestResolveVarRefs(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	if err := ioutil.WriteFile(filepath.Join(td, "key.txt"), []byte("file-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("DAISY_TEST_TOKEN", "env-token")
+	defer os.Unsetenv("DAISY_TEST_TOKEN")
+	os.Unsetenv("DAISY_TEST_DNE")
+
+	tests := []struct {
+		desc, value, want string
+		shouldErr         bool
+	}{
+		{"no ref case", "foo", "foo", false},
+		{"env case", "${ENV:DAISY_TEST_TOKEN}", "env-token", false},
+		{"relative file case", "${FILE:key.txt}", "file-key", false},
+		{"absolute file case", "${FILE:" + filepath.Join(td, "key.txt") + "}", "file-key", false},
+		{"mixed case", "${ENV:DAISY_TEST_TOKEN}:${FILE:key.txt}", "env-token:file-key", false},
+		{"unset env case", "${ENV:DAISY_TEST_DNE}", "", true},
+		{"missing file case", "${FILE:dne.txt}", "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveVarRefs(tt.value, td)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && (err != nil || got != tt.want) {
+			t.Errorf("%s: got %q, error: %v, want %q", tt.desc, got, err, tt.want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	w := testWorkflow()
+	if got := w.Redact("foo"); got != "foo" {
+		t.Errorf("got %q without secrets, want %q", got, "foo")
+	}
+
+	sw := testWorkflow()
+	sw.parent = w
+	sw.addSecret(Var{Value: "abcd", Secret: true})
+	sw.addSecret(Var{Value: "abcdefg", Secret: true})
+	sw.addSecret(Var{Value: "a&bc", Secret: true})
+	sw.addSecret(Var{Value: "key1,key2", Type: VarTypeList, Secret: true})
+
+	tests := []struct {
+		desc, s, want string
+	}{
+		{"value case", "token abcd", "token ****"},
+		{"longer value case", "token abcdefg", "token ****"},
+		{"json case", `{"token":"a&bc"}`, `{"token":"****"}`},
+		{"list case", "keys key1,key2, key key2", "keys ****, key ****"},
+	}
+	for _, tt := range tests {
+		// The secrets of a child workflow are masked in the whole tree.
+		if got := w.Redact(tt.s); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestSecretVars(t *testing.T) {
+	os.Setenv("DAISY_TEST_TOKEN", "env-token")
+	defer os.Unsetenv("DAISY_TEST_TOKEN")
+
+	w := testWorkflow()
+	w.Vars = map[string]Var{
+		"token":   {Value: "${ENV:DAISY_TEST_TOKEN}", Secret: true},
+		"license": {Default: "${ENV:DAISY_TEST_TOKEN}", Secret: true},
+		"key":     {Enum: []string{"a", "b"}, Secret: true},
+	}
+	if desc := w.DescribeVars(); strings.Contains(desc, "env-token") || !strings.Contains(desc, "${ENV:DAISY_TEST_TOKEN}") {
+		t.Errorf("secret Var references not shown in:\n%s", desc)
+	}
+	w.Project = "${token}"
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if w.Project != "env-token" || w.Vars["token"].Value != "env-token" {
+		t.Errorf("secret Var not substituted: Project %q, Value %q", w.Project, w.Vars["token"].Value)
+	}
+	// The Default of license keeps its ENV reference, which is resolved in
+	// its Value, while references anywhere else are unresolved.
+	if err := w.validateVarsSubbed(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	w.Zone = "${ENV:DAISY_TEST_TOKEN}"
+	if err := w.validateVarsSubbed(); err == nil {
+		t.Error("expected error for an ENV reference outside of a Var")
+	}
+	w.Zone = testZone
+
+	w.LogWorkflowInfo("using token %s", w.Project)
+	w.LogStepInfo("step", "CreateInstances", "token=env-token")
+	for _, e := range w.Logger.(*MockLogger).getEntries() {
+		if strings.Contains(e.Message, "env-token") {
+			t.Errorf("secret value logged: %q", e.Message)
+		}
+	}
+
+	err := w.SetVar("key", "env-token")
+	if err == nil || strings.Contains(err.Error(), "env-token") {
+		t.Errorf("got error %v, want an error without the secret value", err)
+	}
+	if desc := w.DescribeVars(); strings.Contains(desc, "env-token") {
+		t.Errorf("secret values not masked in:\n%s", desc)
+	}
+}
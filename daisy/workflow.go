@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -37,10 +38,25 @@ import (
 	"google.golang.org/api/option"
 )
 
-const defaultTimeout = "10m"
+const (
+	defaultTimeout = "10m"
+	// cleanupTimeout bounds the cleanup of a workflow, which runs after the
+	// run context is canceled.
+	cleanupTimeout = 10 * time.Minute
+)
+
+// daisyBktName returns the name of the default bucket of project.
+func daisyBktName(project string) string {
+	return strings.Replace(project, ":", "-", -1) + "-daisy-bkt"
+}
 
 func daisyBkt(ctx context.Context, client *storage.Client, project string) (string, dErr) {
-	dBkt := strings.Replace(project, ":", "-", -1) + "-daisy-bkt"
+	// Workflows with their own Storage have no GCS client to create the
+	// bucket with.
+	if client == nil {
+		return "", errf("GCSPath must be set for workflows with their own Storage")
+	}
+	dBkt := daisyBktName(project)
 	it := client.Buckets(ctx, project)
 	for bucketAttrs, err := it.Next(); err != iterator.Done; bucketAttrs, err = it.Next() {
 		if err != nil {
@@ -64,6 +80,20 @@ type Var struct {
 	Value       string
 	Required    bool   `json:",omitempty"`
 	Description string `json:",omitempty"`
+	// Type of the value: string (the default), int, bool, duration or list.
+	// The value of a list is its items separated by commas.
+	Type string `json:",omitempty"`
+	// Value to use if Value is not set. Var substitution and its checks skip
+	// the Default, which keeps its ENV and FILE references once they are
+	// resolved in Value.
+	Default string `json:",omitempty" daisy:"-"`
+	// Allowed values, or allowed items of a list.
+	Enum []string `json:",omitempty"`
+	// Regex the value, or each item of a list, must match in full.
+	Pattern string `json:",omitempty"`
+	// Whether the value is masked in logs, Print output and the files Daisy
+	// writes to GCS.
+	Secret bool `json:",omitempty"`
 }
 
 // UnmarshalJSON unmarshals a Var.
@@ -81,7 +111,10 @@ func (v *Var) UnmarshalJSON(b []byte) error {
 
 // Workflow is a single Daisy workflow workflow.
 type Workflow struct {
-	// Populated on New() construction.
+	// Populated on New() construction. Closing Cancel cancels the run
+	// context, Cancel is closed when the workflow fails validation or ends.
+	//
+	// Deprecated: Cancel the context passed to Run instead.
 	Cancel chan struct{} `json:"-"`
 
 	// Workflow template fields.
@@ -102,10 +135,33 @@ type Workflow struct {
 	Steps map[string]*Step `json:",omitempty"`
 	// Map of steps to their dependencies.
 	Dependencies map[string][]string `json:",omitempty"`
+	// Steps to run one after the other once the steps of the DAG end, if one
+	// of them failed, before the resources of the workflow are cleaned up.
+	// ${FAILED_STEP} and ${ERROR} are replaced by the name and the error of
+	// the step that failed.
+	OnFailure []*Step `json:",omitempty"`
+	// Steps to run one after the other after the OnFailure steps, whether a
+	// step failed or not.
+	Finally []*Step `json:",omitempty"`
 	// Default timout for each step, defaults to 10m.
 	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
 	DefaultTimeout string `json:",omitempty"`
 	defaultTimeout time.Duration
+	// Maximum number of steps that run at once across the workflow tree,
+	// including included and sub workflows, 0 means no limit. Steps that
+	// run other steps don't count. Only used on the top-level workflow.
+	MaxConcurrentSteps int `json:",omitempty"`
+	// Maximum number of API operations per resource kind ("disk", "image",
+	// "instance", ...) that run at once across the workflow tree. Only used
+	// on the top-level workflow.
+	ConcurrencyLimits map[string]int `json:",omitempty"`
+	scheduler         *Scheduler
+	// Whether validation skips the quota check. Only used on the top-level
+	// workflow.
+	SkipQuotaCheck bool `json:",omitempty"`
+	// Webhooks to send the lifecycle events of the run to. Only used on the
+	// top-level workflow.
+	Webhooks []*Webhook `json:",omitempty"`
 
 	// Working fields.
 	autovars              map[string]string
@@ -118,19 +174,76 @@ type Workflow struct {
 	outsPath              string
 	username              string
 	externalLogging       bool
+	noBucketCreation      bool
 	gcsLoggingDisabled    bool
 	cloudLoggingDisabled  bool
 	stdoutLoggingDisabled bool
+	logFormat             string
+	logLevel              Severity
+	logWriters            []io.Writer
+	logStart              time.Time
 	id                    string
 	Logger                Logger `json:"-"`
-	cleanupHooks          []func() dErr
+	cleanupHooks          []func(ctx context.Context) dErr
 	cleanupHooksMx        sync.Mutex
 	logWait               sync.WaitGroup
 
+	// Context of the running workflow tree and its cancel func, only set on
+	// the root workflow. The context is derived from the one the workflow is
+	// run with and is canceled when the run fails or ends.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Checkpointing and resume, only set on the root workflow.
+	checkpointing bool
+	resumeFrom    string
+	checkpoint    *checkpoint
+	checkpointMx  sync.Mutex
+
+	// The first step of the workflow, or of its included and sub workflows,
+	// that failed and its error, for the OnFailure and Finally steps.
+	failedStep string
+	failedErr  dErr
+	failedMx   sync.Mutex
+	// Whether the run failed and whether to keep the resources of the
+	// workflow tree if it does, only set on the root workflow.
+	runFailed     bool
+	keepOnFailure bool
+
+	// Step outputs, keyed by "stepname.key".
+	outputs   map[string]string
+	outputsMx sync.Mutex
+
+	// Run history of the steps of the workflow tree for the run summary, only
+	// set on the root workflow.
+	records     map[*Step]*stepRecord
+	recordsMx   sync.Mutex
+	summaryPath string
+
+	// Tracing of the run, only set on the root workflow.
+	traceFile     string
+	traceEndpoint string
+	tracer        *tracer
+
+	// Lifecycle event delivery of the run, only set on the root workflow.
+	eventSinks  []EventSink
+	eventQueues []*eventQueue
+	eventSeq     int64
+	eventsMx     sync.Mutex
+	eventsWait   sync.WaitGroup
+	eventsCancel context.CancelFunc
+
+	// Values of the secret Vars of the workflow tree and the replacer that
+	// masks them, only set on the root workflow.
+	secrets         []string
+	secretsReplacer *strings.Replacer
+	secretsMx       sync.RWMutex
+
 	// Optional compute endpoint override.
 	ComputeEndpoint    string          `json:",omitempty"`
 	ComputeClient      compute.Client  `json:"-"`
 	StorageClient      *storage.Client `json:"-"`
+	Storage            Storage         `json:"-"`
 	cloudLoggingClient *logging.Client
 
 	// Resource registries.
@@ -160,15 +273,42 @@ func (w *Workflow) DisableStdoutLogging() {
 	w.stdoutLoggingDisabled = true
 }
 
-// AddVar adds a variable set to the Workflow.
+// SetLogFormat sets the format of the entries logged to stdout and to the
+// log writers: LogFormatText, the default, or LogFormatJSON.
+func (w *Workflow) SetLogFormat(format string) error {
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("unknown log format %q, not one of %q", format, []string{LogFormatText, LogFormatJSON})
+	}
+	w.logFormat = format
+	return nil
+}
+
+// SetLogLevel sets the minimum severity of the entries logged to stdout and
+// to the log writers, the default is SeverityInfo. All entries are logged to
+// GCS and Cloud Logging.
+func (w *Workflow) SetLogLevel(level Severity) {
+	w.logLevel = level
+}
+
+// AddLogWriter makes the workflow also log to lw, e.g. a local file, in the
+// log format and at the log level of stdout.
+func (w *Workflow) AddLogWriter(lw io.Writer) {
+	w.logWriters = append(w.logWriters, lw)
+}
+
+// AddVar sets the value of a variable of the Workflow, adding the variable if
+// the workflow doesn't declare it. The value is checked against the Type,
+// Enum and Pattern of the variable when the workflow is populated.
 func (w *Workflow) AddVar(k, v string) {
 	if w.Vars == nil {
 		w.Vars = map[string]Var{}
 	}
-	w.Vars[k] = Var{Value: v}
+	vr := w.Vars[k]
+	vr.Value = v
+	w.Vars[k] = vr
 }
 
-func (w *Workflow) addCleanupHook(hook func() dErr) {
+func (w *Workflow) addCleanupHook(hook func(ctx context.Context) dErr) {
 	w.cleanupHooksMx.Lock()
 	w.cleanupHooks = append(w.cleanupHooks, hook)
 	w.cleanupHooksMx.Unlock()
@@ -177,24 +317,29 @@ func (w *Workflow) addCleanupHook(hook func() dErr) {
 // Validate runs validation on the workflow.
 func (w *Workflow) Validate(ctx context.Context) error {
 	if err := w.PopulateClients(ctx); err != nil {
-		close(w.Cancel)
+		w.cancelRun()
 		return errf("error populating workflow: %v", err)
 	}
 
 	if err := w.validateRequiredFields(); err != nil {
-		close(w.Cancel)
+		w.cancelRun()
 		return errf("error validating workflow: %v", err)
 	}
 
 	if err := w.populate(ctx); err != nil {
-		close(w.Cancel)
+		w.cancelRun()
 		return errf("error populating workflow: %v", err)
 	}
 
 	w.LogWorkflowInfo("Validating workflow")
 	if err := w.validate(ctx); err != nil {
-		w.LogWorkflowInfo("Error validating workflow: %v", err)
-		close(w.Cancel)
+		w.logWorkflow(SeverityError, "Error validating workflow: %v", err)
+		w.cancelRun()
+		return err
+	}
+	if err := w.checkQuotas(ctx); err != nil {
+		w.logWorkflow(SeverityError, "Error checking quotas: %v", err)
+		w.cancelRun()
 		return err
 	}
 	w.LogWorkflowInfo("Validation Complete")
@@ -204,36 +349,86 @@ func (w *Workflow) Validate(ctx context.Context) error {
 // WorkflowModifier is a function type for functions that can modify a Workflow object.
 type WorkflowModifier func(*Workflow)
 
-// Run runs a workflow.
+// Run runs a workflow. Canceling ctx stops the running steps, the resources
+// of the workflow are cleaned up regardless.
 func (w *Workflow) Run(ctx context.Context) error {
 	return w.RunWithModifier(ctx, nil)
 }
 
 // RunWithModifier runs a workflow with the ability to modify it once validated but before it's actually run.
-func (w *Workflow) RunWithModifier(ctx context.Context, workflowModifier WorkflowModifier) error {
+func (w *Workflow) RunWithModifier(ctx context.Context, workflowModifier WorkflowModifier) (err error) {
 	w.externalLogging = true
-	if err := w.Validate(ctx); err != nil {
+	// The trace is exported last, once cleanup is done.
+	if w.traceFile != "" || w.traceEndpoint != "" {
+		w.tracer = newTracer()
+	}
+	ctx = w.startRun(ctx)
+	ctx, sp := w.startSpan(ctx, "run "+w.Name, "workflow")
+	defer func() {
+		sp.finish(err)
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		w.writeTrace(ctx)
+	}()
+	vctx, vsp := w.startSpan(ctx, "validate", "workflow")
+	err = w.Validate(vctx)
+	vsp.finish(err)
+	if err != nil {
 		return err
 	}
+	sp.setAttr("workflowId", w.id)
 
 	if workflowModifier != nil {
 		workflowModifier(w)
 	}
-	defer w.cleanup()
+	if w.scheduler == nil {
+		// The limits were checked by Validate.
+		w.scheduler, _ = NewScheduler(w.MaxConcurrentSteps, w.ConcurrencyLimits)
+	}
+	w.startEvents()
+	w.emit(Event{Type: EventWorkflowStarted})
+	defer func() {
+		e := Event{Type: EventWorkflowFinished}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		w.emit(e)
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		w.stopEvents(ctx)
+	}()
+	// The summary is written after cleanup, so it lists the resources cleanup
+	// deleted.
+	start := time.Now()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		w.writeSummary(ctx, start, err)
+	}()
+	defer func() {
+		if err != nil {
+			w.failedMx.Lock()
+			w.runFailed = true
+			w.failedMx.Unlock()
+		}
+		w.cleanup()
+	}()
 	w.LogWorkflowInfo("Workflow Project: %s", w.Project)
 	w.LogWorkflowInfo("Workflow Zone: %s", w.Zone)
 	w.LogWorkflowInfo("Workflow GCSPath: %s", w.GCSPath)
 	w.LogWorkflowInfo("Daisy scratch path: https://console.cloud.google.com/storage/browser/%s", path.Join(w.bucket, w.scratchPath))
 
 	w.LogWorkflowInfo("Uploading sources")
-	if err := w.uploadSources(ctx); err != nil {
-		w.LogWorkflowInfo("Error uploading sources: %v", err)
-		close(w.Cancel)
-		return err
+	uctx, usp := w.startSpan(ctx, "upload sources", "workflow")
+	uErr := w.uploadSources(uctx)
+	usp.finish(uErr)
+	if uErr != nil {
+		w.logWorkflow(SeverityError, "Error uploading sources: %v", uErr)
+		return uErr
 	}
 	w.LogWorkflowInfo("Running workflow")
 	if err := w.run(ctx); err != nil {
-		w.LogWorkflowInfo("Error running workflow: %v", err)
+		w.logWorkflow(SeverityError, "Error running workflow: %v", err)
 		return err
 	}
 	return nil
@@ -241,16 +436,61 @@ func (w *Workflow) RunWithModifier(ctx context.Context, workflowModifier Workflo
 
 func (w *Workflow) cleanup() {
 	w.LogWorkflowInfo("Workflow %q cleaning up (this may take up to 2 minutes).", w.Name)
+	// Stop the steps that are still running. Cleanup itself is not stopped
+	// by the cancellation of the run, it has a context of its own.
+	w.cancelRun()
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	ctx, sp := w.startSpan(ctx, "cleanup", "workflow")
+	defer sp.finish(nil)
+	for _, hook := range w.cleanupHooks {
+		if err := hook(ctx); err != nil {
+			w.logWorkflow(SeverityError, "Error returned from cleanup hook: %s", err)
+		}
+	}
+	if w.keptOnFailure() {
+		w.LogWorkflowInfo("Kept the resources of the failed workflow, delete them with: daisy cleanup -project %s -id %s", w.Project, w.id)
+	}
+}
+
+// startRun derives the run context of the workflow tree from ctx. Closing the
+// deprecated Cancel channel cancels it.
+func (w *Workflow) startRun(ctx context.Context) context.Context {
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.ctx = ctx
+	go func() {
+		select {
+		case <-w.Cancel:
+			w.cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx
+}
+
+// cancelRun cancels the run context and closes Cancel, for the users that
+// still wait on it.
+func (w *Workflow) cancelRun() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	if w.Cancel == nil {
+		return
+	}
 	select {
 	case <-w.Cancel:
 	default:
 		close(w.Cancel)
 	}
-	for _, hook := range w.cleanupHooks {
-		if err := hook(); err != nil {
-			w.LogWorkflowInfo("Error returned from cleanup hook: %s", err)
-		}
+}
+
+// runContext returns the context of the running workflow tree, for work
+// that outlives the step that starts it.
+func (w *Workflow) runContext() context.Context {
+	if root := w.root(); root.ctx != nil {
+		return root.ctx
 	}
+	return context.Background()
 }
 
 func (w *Workflow) genName(n string) string {
@@ -284,6 +524,10 @@ func (w *Workflow) PopulateClients(ctx context.Context) error {
 	computeOptions := []option.ClientOption{option.WithCredentialsFile(w.OAuthPath)}
 	if w.ComputeEndpoint != "" {
 		computeOptions = append(computeOptions, option.WithEndpoint(w.ComputeEndpoint))
+		// Plain HTTP endpoints, like the compute emulator, take no credentials.
+		if strings.HasPrefix(w.ComputeEndpoint, "http://") {
+			computeOptions = append(computeOptions, option.WithoutAuthentication())
+		}
 	}
 
 	if w.ComputeClient == nil {
@@ -293,8 +537,13 @@ func (w *Workflow) PopulateClients(ctx context.Context) error {
 		}
 	}
 
+	// Workflows with a local GCSPath keep everything in the file system.
+	if w.Storage == nil && strings.HasPrefix(w.GCSPath, "file://") {
+		w.Storage = &fileStorage{}
+	}
+
 	storageOptions := []option.ClientOption{option.WithCredentialsFile(w.OAuthPath)}
-	if w.StorageClient == nil {
+	if w.StorageClient == nil && w.Storage == nil {
 		w.StorageClient, err = storage.NewClient(ctx, storageOptions...)
 		if err != nil {
 			return err
@@ -302,7 +551,7 @@ func (w *Workflow) PopulateClients(ctx context.Context) error {
 	}
 
 	loggingOptions := []option.ClientOption{option.WithCredentialsFile(w.OAuthPath)}
-	if w.externalLogging && w.cloudLoggingClient == nil {
+	if w.externalLogging && !w.cloudLoggingDisabled && w.cloudLoggingClient == nil {
 		w.cloudLoggingClient, err = logging.NewClient(ctx, w.Project, loggingOptions...)
 		if err != nil {
 			return err
@@ -322,16 +571,33 @@ func (w *Workflow) populateStep(ctx context.Context, s *Step) dErr {
 	}
 	s.timeout = timeout
 
+	if s.Retry != nil {
+		if err := s.Retry.populate(); err != nil {
+			return err
+		}
+	}
+
 	var derr dErr
 	var step stepImpl
 	if step, derr = s.stepImpl(); derr != nil {
 		return derr
 	}
+	if s.If != "" {
+		run, err := evalCondition(s.If)
+		if err != nil {
+			return err
+		}
+		s.skipped = !run
+	}
+	if s.skipped {
+		return nil
+	}
 	return step.populate(ctx, s)
 }
 
 // populate does the following:
-// - checks that all required Vars are set.
+// - sets Var defaults, resolves the ENV and FILE references in Var values,
+//   checks that all required Vars are set and checks the Var values.
 // - instantiates API clients, if needed.
 // - sets generic autovars and do first round of var substitution.
 // - sets GCS path information.
@@ -340,8 +606,22 @@ func (w *Workflow) populateStep(ctx context.Context, s *Step) dErr {
 // - runs populate on each step.
 func (w *Workflow) populate(ctx context.Context) dErr {
 	for k, v := range w.Vars {
-		if v.Required && v.Value == "" {
-			return errf("cannot populate workflow, required var %q is unset", k)
+		if err := v.populate(k, w.workflowDir); err != nil {
+			return err
+		}
+		w.Vars[k] = v
+		if v.Secret {
+			w.addSecret(v)
+		}
+	}
+
+	if w.parent == nil && w.checkpointing && w.checkpoint == nil {
+		if w.resumeFrom != "" {
+			if err := w.loadCheckpoint(ctx); err != nil {
+				return err
+			}
+		} else {
+			w.checkpoint = newCheckpoint()
 		}
 	}
 
@@ -349,6 +629,14 @@ func (w *Workflow) populate(ctx context.Context) dErr {
 	cwd, _ := os.Getwd()
 	now := time.Now().UTC()
 	w.username = getUser()
+	// A resumed workflow keeps the ID and timestamps of the run it continues,
+	// so generated resource names and the scratch path stay the same.
+	if av := w.resumedAutovars(); av != nil {
+		w.id = av["ID"]
+		if ts, err := strconv.ParseInt(av["TIMESTAMP"], 10, 64); err == nil {
+			now = time.Unix(ts, 0).UTC()
+		}
+	}
 
 	w.autovars = map[string]string{
 		"ID":        w.id,
@@ -377,7 +665,9 @@ func (w *Workflow) populate(ctx context.Context) dErr {
 	w.defaultTimeout = timeout
 
 	// Set up GCS paths.
-	if w.GCSPath == "" {
+	if w.GCSPath == "" && w.noBucketCreation {
+		w.GCSPath = "gs://" + daisyBktName(w.Project)
+	} else if w.GCSPath == "" {
 		dBkt, err := daisyBkt(ctx, w.StorageClient, w.Project)
 		if err != nil {
 			return err
@@ -400,10 +690,11 @@ func (w *Workflow) populate(ctx context.Context) dErr {
 	w.autovars["ZONE"] = w.Zone
 	w.autovars["PROJECT"] = w.Project
 	w.autovars["GCSPATH"] = w.GCSPath
-	w.autovars["SCRATCHPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.scratchPath)
-	w.autovars["SOURCESPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.sourcesPath)
-	w.autovars["LOGSPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.logsPath)
-	w.autovars["OUTSPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.outsPath)
+	w.autovars["SCRATCHPATH"] = w.store().URL(w.bucket, w.scratchPath)
+	w.autovars["SOURCESPATH"] = w.store().URL(w.bucket, w.sourcesPath)
+	w.autovars["LOGSPATH"] = w.store().URL(w.bucket, w.logsPath)
+	w.autovars["OUTSPATH"] = w.store().URL(w.bucket, w.outsPath)
+	w.recordAutovars()
 
 	replacements = []string{}
 	for k, v := range w.autovars {
@@ -421,6 +712,12 @@ func (w *Workflow) populate(ctx context.Context) dErr {
 		return err
 	}
 
+	for _, h := range w.Webhooks {
+		if err := h.populate(); err != nil {
+			return errf("error populating Webhook %q: %v", h.URL, err)
+		}
+	}
+
 	if w.Logger == nil {
 		w.createLogger(ctx)
 	}
@@ -433,7 +730,7 @@ func (w *Workflow) populate(ctx context.Context) dErr {
 			return errf("error populating step %q: %v", name, err)
 		}
 	}
-	return nil
+	return w.populateFinalSteps(ctx)
 }
 
 // AddDependency creates a dependency of dependent on each dependency. Returns an
@@ -473,6 +770,21 @@ func (w *Workflow) NewIncludedWorkflow() *Workflow {
 	return iw
 }
 
+// resourceRegistries returns the GCE resource registries of w in cleanup
+// order: instances need to be done before disks/networks.
+func (w *Workflow) resourceRegistries() []*baseResourceRegistry {
+	return []*baseResourceRegistry{
+		&w.instances.baseResourceRegistry,
+		&w.images.baseResourceRegistry,
+		&w.disks.baseResourceRegistry,
+		&w.forwardingRules.baseResourceRegistry,
+		&w.targetInstances.baseResourceRegistry,
+		&w.firewallRules.baseResourceRegistry,
+		&w.subnetworks.baseResourceRegistry,
+		&w.networks.baseResourceRegistry,
+	}
+}
+
 // ID is the unique identifyier for this Workflow.
 func (w *Workflow) ID() string {
 	return w.id
@@ -524,52 +836,88 @@ func (w *Workflow) NewSubWorkflowFromFile(file string) (*Workflow, error) {
 	return sw, nil
 }
 
-// Print populates then pretty prints the workflow.
+// Print populates then pretty prints the workflow, with the values of the
+// secret Vars masked.
 func (w *Workflow) Print(ctx context.Context) {
 	w.externalLogging = false
 	if err := w.PopulateClients(ctx); err != nil {
 		fmt.Println("Error running PopulateClients:", err)
 	}
 	if err := w.populate(ctx); err != nil {
-		fmt.Println("Error running populate:", err)
+		fmt.Println("Error running populate:", w.Redact(err.Error()))
 	}
 
 	b, err := json.MarshalIndent(w, "", "  ")
 	if err != nil {
 		fmt.Println("Error marshalling workflow for printing:", err)
 	}
-	fmt.Println(string(b))
+	fmt.Println(w.Redact(string(b)))
 }
 
-func (w *Workflow) run(ctx context.Context) dErr {
-	return w.traverseDAG(func(s *Step) dErr {
-		return w.runStep(ctx, s)
+func (w *Workflow) run(ctx context.Context) (err dErr) {
+	ctx, sp := w.startSpan(ctx, "workflow "+w.Name, "workflow")
+	defer func() { sp.finish(err) }()
+	// Steps still running when a step fails are stopped before the OnFailure
+	// and Finally steps run.
+	dctx, cancel := context.WithCancel(ctx)
+	err = w.traverseDAG(dctx, func(s *Step) dErr {
+		err := w.runStep(dctx, s)
+		if err != nil {
+			cancel()
+		}
+		return err
 	})
+	cancel()
+	return w.runFinalSteps(ctx, err)
 }
 
 func (w *Workflow) runStep(ctx context.Context, s *Step) dErr {
-	timeout := make(chan struct{})
-	go func() {
-		time.Sleep(s.timeout)
-		close(timeout)
-	}()
-
-	e := make(chan dErr)
-	go func() {
-		e <- s.run(ctx)
-	}()
+	if s.skipped {
+		w.LogWorkflowInfo("Skipping step %q, condition %q is false.", s.name, s.If)
+		return nil
+	}
+	if w.stepDone(s) {
+		w.skipDoneStep(s)
+		s.recordStatus(StatusResumed)
+		return nil
+	}
 
-	select {
-	case err := <-e:
-		return err
-	case <-timeout:
-		return errf("step %q did not complete within the specified timeout of %s", s.name, s.timeout)
+	// Waiting for the scheduler is only bounded by the run, the step timeout
+	// starts when the step does.
+	var err dErr
+	sctx := ctx
+	release, aErr := w.acquireStep(ctx, s)
+	if aErr != nil {
+		err = aErr
+	} else {
+		// Steps stop when their context is done, so a step that timed out or
+		// was canceled doesn't keep running.
+		var cancel context.CancelFunc
+		sctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+		err = s.run(sctx)
+		release()
+	}
+	switch {
+	case ctx.Err() != nil:
+		if err == nil || aErr != nil {
+			err = errf("step %q was canceled", s.name)
+			s.recordEnd(err)
+		}
+	case sctx.Err() == context.DeadlineExceeded:
+		err = errf("step %q did not complete within the specified timeout of %s", s.name, s.timeout)
+		s.recordEnd(err)
 	}
+	if err != nil {
+		w.recordFailure(s, err)
+	}
+	w.recordStep(ctx, s, err)
+	return err
 }
 
 // Concurrently traverse the DAG, running func f on each step.
 // Return an error if f returns an error on any step.
-func (w *Workflow) traverseDAG(f func(*Step) dErr) dErr {
+func (w *Workflow) traverseDAG(ctx context.Context, f func(*Step) dErr) dErr {
 	// waiting = steps and the dependencies they are waiting for.
 	// running = the currently running steps.
 	// start = map of steps' start channels/semaphores.
@@ -598,12 +946,14 @@ func (w *Workflow) traverseDAG(f func(*Step) dErr) dErr {
 		}(name, s)
 	}
 
-	// Main signaling logic.
+	// Main signaling logic. After the first step error no more steps are
+	// started, but the steps already running are waited for.
+	var firstErr dErr
 	for len(waiting) != 0 || len(running) != 0 {
-		// If we got a Cancel signal, kill all waiting steps.
+		// If the context is done, kill all waiting steps.
 		// Let running steps finish.
 		select {
-		case <-w.Cancel:
+		case <-ctx.Done():
 			waiting = map[string][]string{}
 		default:
 		}
@@ -623,10 +973,11 @@ func (w *Workflow) traverseDAG(f func(*Step) dErr) dErr {
 			continue
 		}
 
-		// Get next finished step. Return the step error if it erred.
+		// Get next finished step. Keep the first step error.
 		finished, err := stepsListen(running, done)
-		if err != nil {
-			return err
+		if err != nil && firstErr == nil {
+			firstErr = err
+			waiting = map[string][]string{}
 		}
 
 		// Remove finished step from other steps' waiting lists.
@@ -637,12 +988,11 @@ func (w *Workflow) traverseDAG(f func(*Step) dErr) dErr {
 		// Remove finished from currently running list.
 		running = filter(running, finished)
 	}
-	return nil
+	return firstErr
 }
 
 // New instantiates a new workflow.
 func New() *Workflow {
-	// We can't use context.WithCancel as we use the context even after cancel for cleanup.
 	w := &Workflow{Cancel: make(chan struct{})}
 	// Init nil'ed fields
 	w.Sources = map[string]string{}
@@ -662,15 +1012,10 @@ func New() *Workflow {
 	w.subnetworks = newSubnetworkRegistry(w)
 	w.objects = newObjectRegistry(w)
 	w.targetInstances = newTargetInstanceRegistry(w)
-	w.addCleanupHook(func() dErr {
-		w.instances.cleanup() // instances need to be done before disks/networks
-		w.images.cleanup()
-		w.disks.cleanup()
-		w.forwardingRules.cleanup()
-		w.targetInstances.cleanup()
-		w.firewallRules.cleanup()
-		w.subnetworks.cleanup()
-		w.networks.cleanup()
+	w.addCleanupHook(func(ctx context.Context) dErr {
+		for _, r := range w.resourceRegistries() {
+			r.cleanup(ctx)
+		}
 		return nil
 	})
 
@@ -727,8 +1072,8 @@ func readWorkflow(file string, w *Workflow) error {
 		return err
 	}
 
-	if err := json.Unmarshal(data, &w); err != nil {
-		return JSONError(file, data, err)
+	if err := UnmarshalWorkflow(file, data, &w); err != nil {
+		return err
 	}
 
 	if w.OAuthPath != "" && !filepath.IsAbs(w.OAuthPath) {
@@ -739,6 +1084,7 @@ func readWorkflow(file string, w *Workflow) error {
 		s.name = name
 		s.w = w
 	}
+	w.nameFinalSteps()
 
 	return nil
 }
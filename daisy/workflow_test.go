@@ -98,15 +98,15 @@ func TestDaisyBkt(t *testing.T) {
 func TestCleanup(t *testing.T) {
 	cleanedup1 := false
 	cleanedup2 := false
-	cleanup1 := func() dErr {
+	cleanup1 := func(ctx context.Context) dErr {
 		cleanedup1 = true
 		return nil
 	}
-	cleanup2 := func() dErr {
+	cleanup2 := func(ctx context.Context) dErr {
 		cleanedup2 = true
 		return nil
 	}
-	cleanupFail := func() dErr {
+	cleanupFail := func(ctx context.Context) dErr {
 		return errf("failed cleanup")
 	}
 
@@ -669,11 +669,15 @@ func TestPrint(t *testing.T) {
 }
 
 func testValidateErrors(w *Workflow, want string) error {
-	if err := w.Validate(context.Background()); err == nil {
+	ctx := w.startRun(context.Background())
+	if err := w.Validate(ctx); err == nil {
 		return errors.New("expected error, got nil")
 	} else if err.Error() != want {
 		return fmt.Errorf("did not get expected error from Validate():\ngot: %q\nwant: %q", err.Error(), want)
 	}
+	if ctx.Err() == nil {
+		return errors.New("expected run context to be canceled after error")
+	}
 	select {
 	case <-w.Cancel:
 		return nil
@@ -735,10 +739,10 @@ func TestWrite(t *testing.T) {
 		t.Fatal(err)
 	}
 	l := GCSLogger{
-		client: gcsClient,
-		bucket: testBucket,
-		object: testObject,
-		ctx:    context.Background(),
+		storage: &gcsStorage{client: gcsClient},
+		bucket:  testBucket,
+		object:  testObject,
+		ctx:     context.Background(),
 	}
 
 	tests := []struct {
@@ -770,7 +774,11 @@ func TestRunStepTimeout(t *testing.T) {
 	s, _ := w.NewStep("test")
 	s.timeout = 1 * time.Nanosecond
 	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+		case <-time.After(1 * time.Second):
+			t.Error("step was not stopped at its timeout")
+		}
 		return nil
 	}}
 	want := `step "test" did not complete within the specified timeout of 1ns`
@@ -779,6 +787,65 @@ func TestRunStepTimeout(t *testing.T) {
 	}
 }
 
+func TestRunStepCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := testWorkflow()
+	s, _ := w.NewStep("test")
+	s.timeout = 1 * time.Minute
+	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		cancel()
+		<-ctx.Done()
+		return nil
+	}}
+	want := `step "test" was canceled`
+	if err := w.runStep(ctx, s); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+	if r := w.stepRecords()[s]; r.status != StatusFailed || r.err != want {
+		t.Errorf("unexpected record: %+v", r)
+	}
+}
+
+func TestRunCleanupContext(t *testing.T) {
+	w := testWorkflow()
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	var cleanupErr error
+	w.addCleanupHook(func(ctx context.Context) dErr {
+		cleanupErr = ctx.Err()
+		return nil
+	})
+	w.cleanup()
+	if w.ctx.Err() == nil {
+		t.Error("run context was not canceled by cleanup")
+	}
+	if cleanupErr != nil {
+		t.Errorf("cleanup hook got a done context: %v", cleanupErr)
+	}
+}
+
+func TestCancelChannel(t *testing.T) {
+	w := testWorkflow()
+	ctx := w.startRun(context.Background())
+	close(w.Cancel)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("closing Cancel did not cancel the run context")
+	}
+	// Cancel is already closed, cancelRun must not close it again.
+	w.cancelRun()
+
+	w = testWorkflow()
+	sw := w.NewSubWorkflow()
+	w.startRun(context.Background())
+	w.cleanup()
+	select {
+	case <-sw.Cancel:
+	default:
+		t.Error("Cancel of the subworkflow was not closed by cleanup")
+	}
+}
+
 func TestPopulateClients(t *testing.T) {
 	w := testWorkflow()
 
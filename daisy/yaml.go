@@ -0,0 +1,259 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+var yamlErrLineRgx = regexp.MustCompile(`line (\d+)(?:, column (\d+))?`)
+
+// IsYAML returns true if file is a YAML workflow file, i.e. it has a .yaml or
+// .yml extension.
+func IsYAML(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// UnmarshalWorkflow unmarshals the contents of a workflow file into v. YAML
+// files are converted to JSON first, so they use the same field names and
+// unmarshalling as JSON files.
+func UnmarshalWorkflow(file string, data []byte, v interface{}) error {
+	if !IsYAML(file) {
+		if err := json.Unmarshal(data, v); err != nil {
+			return JSONError(file, data, err)
+		}
+		return nil
+	}
+
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return YAMLError(file, data, err)
+	}
+	if err := json.Unmarshal(jsonData, v); err != nil {
+		// Offsets of these errors are in the converted JSON, type errors are
+		// located in the YAML by their field path instead.
+		return yamlTypeError(file, data, err)
+	}
+	return nil
+}
+
+// MarshalWorkflow marshals v in the format of file: YAML for YAML files,
+// indented JSON otherwise.
+func MarshalWorkflow(file string, v interface{}) ([]byte, error) {
+	if IsYAML(file) {
+		return yaml.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// YAMLError turns an error from parsing YAML into a more user friendly error
+// showing the offending line.
+func YAMLError(file string, data []byte, err error) error {
+	m := yamlErrLineRgx.FindStringSubmatch(err.Error())
+	if m == nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	line, _ := strconv.Atoi(m[1])
+	lines := bytes.Split(data, []byte("\n"))
+	if line < 1 || line > len(lines) {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	// Most YAML parser errors don't have a column, point at the start of the
+	// line content then.
+	ln := lines[line-1]
+	pos := len(ln) - len(bytes.TrimLeft(ln, " \t"))
+	if col, _ := strconv.Atoi(m[2]); col > 0 && col <= len(ln)+1 {
+		pos = col - 1
+	}
+	return fmt.Errorf("%s: YAML syntax error in line %d: %s \n%s\n%s^", file, line, err, ln, strings.Repeat(" ", pos))
+}
+
+// yamlTypeError turns a json.UnmarshalTypeError of a YAML workflow into an
+// error showing the line of the offending field.
+func yamlTypeError(file string, data []byte, err error) error {
+	tErr, ok := err.(*json.UnmarshalTypeError)
+	if !ok || tErr.Field == "" {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	lines := strings.Split(string(data), "\n")
+	p, ok := yamlFieldPos(lines, tErr.Field)
+	if !ok {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	return fmt.Errorf("%s: YAML type error in line %d: %s \n%s\n%s^", file, p.line+1, err, lines[p.line], strings.Repeat(" ", p.col))
+}
+
+// yamlPos is the position of a YAML node. The content of an inline node, a
+// sequence item, starts at col of line, other nodes have their content on the
+// lines after line indented more than col.
+type yamlPos struct {
+	line, col int
+	inline    bool
+}
+
+// yamlFieldPos finds the node of a dotted field path, e.g.
+// "Steps.create.CreateInstances.0.Name", in block style YAML. Flow style
+// collections aren't searched, the position of the deepest node found from the
+// document root is returned instead.
+func yamlFieldPos(lines []string, field string) (yamlPos, bool) {
+	keys := strings.Split(field, ".")
+	root := yamlPos{line: -1, col: -1}
+	p, n := yamlPath(lines, root, keys)
+	if n == len(keys) {
+		return p, true
+	}
+	// Fields of types with their own UnmarshalJSON, Step and Var, have paths
+	// relative to that type. Look for them under each step and Var, a field
+	// found under more than one of them has no known position.
+	var found []yamlPos
+	for _, a := range yamlAnchors(lines, root) {
+		if c, cn := yamlPath(lines, a, keys); cn == len(keys) {
+			found = append(found, c)
+		}
+	}
+	switch {
+	case len(found) == 1:
+		return found[0], true
+	case len(found) > 1:
+		return yamlPos{}, false
+	}
+	return p, n > 0
+}
+
+// yamlAnchors returns the nodes of the steps and Vars of the workflow at root.
+func yamlAnchors(lines []string, root yamlPos) []yamlPos {
+	var anchors []yamlPos
+	for _, key := range []string{"Steps", "Vars", "OnFailure", "Finally"} {
+		if p, ok := yamlChild(lines, root, key); ok {
+			anchors = append(anchors, yamlChildren(lines, p)...)
+		}
+	}
+	return anchors
+}
+
+// yamlPath follows keys from p, it returns the deepest node found and the
+// number of keys followed.
+func yamlPath(lines []string, p yamlPos, keys []string) (yamlPos, int) {
+	for i, key := range keys {
+		c, ok := yamlChild(lines, p, key)
+		if !ok {
+			return p, i
+		}
+		p = c
+	}
+	return p, len(keys)
+}
+
+// yamlChild finds the child node key of p, key is a map key or a sequence
+// index.
+func yamlChild(lines []string, p yamlPos, key string) (yamlPos, bool) {
+	idx, err := strconv.Atoi(key)
+	isIdx := err == nil
+	start := p.line + 1
+	if p.inline {
+		start = p.line
+	}
+	childCol, n := -1, 0
+	for i := start; i < len(lines); i++ {
+		c := len(lines[i]) - len(strings.TrimLeft(lines[i], " "))
+		if i == p.line {
+			c = p.col
+		}
+		if c >= len(lines[i]) {
+			continue
+		}
+		content := strings.TrimSpace(lines[i][c:])
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+		isItem := content == "-" || strings.HasPrefix(content, "- ")
+		// Block sequences can have the indentation of their key.
+		if c < p.col || (c == p.col && !p.inline && !(isIdx && isItem)) {
+			break
+		}
+		if childCol == -1 {
+			childCol = c
+		}
+		if c != childCol {
+			continue
+		}
+		if isIdx {
+			if !isItem {
+				break
+			}
+			if n == idx {
+				return yamlPos{line: i, col: c + 2, inline: true}, true
+			}
+			n++
+			continue
+		}
+		for _, k := range []string{key, `"` + key + `"`, "'" + key + "'"} {
+			if strings.HasPrefix(content, k+":") {
+				return yamlPos{line: i, col: c}, true
+			}
+		}
+	}
+	return yamlPos{}, false
+}
+
+// yamlChildren returns the map entries or sequence items of p.
+func yamlChildren(lines []string, p yamlPos) []yamlPos {
+	var children []yamlPos
+	start := p.line + 1
+	if p.inline {
+		start = p.line
+	}
+	childCol := -1
+	for i := start; i < len(lines); i++ {
+		c := len(lines[i]) - len(strings.TrimLeft(lines[i], " "))
+		if i == p.line {
+			c = p.col
+		}
+		if c >= len(lines[i]) {
+			continue
+		}
+		content := strings.TrimSpace(lines[i][c:])
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+		isItem := content == "-" || strings.HasPrefix(content, "- ")
+		// Block sequences can have the indentation of their key.
+		if c < p.col || (c == p.col && !p.inline && !isItem) {
+			break
+		}
+		if childCol == -1 {
+			childCol = c
+		}
+		if c != childCol {
+			continue
+		}
+		if isItem {
+			children = append(children, yamlPos{line: i, col: c + 2, inline: true})
+		} else if strings.Contains(content, ":") {
+			children = append(children, yamlPos{line: i, col: c})
+		}
+	}
+	return children
+}
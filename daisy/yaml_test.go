@@ -0,0 +1,211 @@
+//  Copyright 2018 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIsYAML(t *testing.T) {
+	for file, want := range map[string]bool{
+		"foo.wf.yaml": true,
+		"foo.wf.yml":  true,
+		"foo.WF.YAML": true,
+		"foo.wf.json": false,
+		"foo":         false,
+	} {
+		if got := IsYAML(file); got != want {
+			t.Errorf("IsYAML(%q) = %t, want %t", file, got, want)
+		}
+	}
+}
+
+func TestNewFromFileYAML(t *testing.T) {
+	want, err := NewFromFile("./test_data/test.wf.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewFromFile("./test_data/test.wf.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// These are difficult to validate and irrelevant, so we cheat.
+	got.id = want.id
+	got.Cancel = want.Cancel
+	got.cleanupHooks = want.cleanupHooks
+
+	if diffRes := diff(got, want, 0); diffRes != "" {
+		t.Errorf("YAML workflow does not match JSON workflow: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestUnmarshalWorkflowYAML(t *testing.T) {
+	data := []byte(`Vars:
+  str: foo
+  struct:
+    Value: bar
+    Required: true
+Steps:
+  create:
+    CreateInstances:
+    - Name: instance
+      Disks:
+      - Source: disk
+      Metadata:
+        startup-script: |
+          echo hello
+          shutdown -h now
+  images:
+    CreateImages:
+    - Name: image1
+      SourceDisk: disk
+      guestOsFeatures: [WINDOWS]
+    - Name: image2
+      SourceDisk: disk
+      guestOsFeatures:
+      - Type: MULTI_IP_SUBNET
+`)
+
+	var w *Workflow
+	if err := UnmarshalWorkflow("test.wf.yaml", data, &w); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantVars := map[string]Var{"str": {Value: "foo"}, "struct": {Value: "bar", Required: true}}
+	if diffRes := diff(w.Vars, wantVars, 0); diffRes != "" {
+		t.Errorf("Vars do not match expectation: (-got +want)\n%s", diffRes)
+	}
+	md := (*w.Steps["create"].CreateInstances)[0].Metadata["startup-script"]
+	if want := "echo hello\nshutdown -h now\n"; md != want {
+		t.Errorf("startup-script: got %q, want %q", md, want)
+	}
+	ci := *w.Steps["images"].CreateImages
+	if diffRes := diff([]guestOsFeatures{ci[0].GuestOsFeatures, ci[1].GuestOsFeatures}, []guestOsFeatures{{"WINDOWS"}, {"MULTI_IP_SUBNET"}}, 0); diffRes != "" {
+		t.Errorf("guestOsFeatures do not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestUnmarshalWorkflowYAMLError(t *testing.T) {
+	data := []byte("Name: foo\nSteps:\n\tbad: tab\n")
+	var w *Workflow
+	err := UnmarshalWorkflow("test.wf.yaml", data, &w)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "test.wf.yaml: YAML syntax error in line 3:") || !strings.Contains(msg, "\n\tbad: tab\n") {
+		t.Errorf("unexpected error: %q", msg)
+	}
+
+	tests := []struct {
+		desc, data, wantPrefix, wantLine string
+	}{
+		{"root field case", "Name: foo\nVars: [foo]\n", "test.wf.yaml: YAML type error in line 2:", "\nVars: [foo]\n^"},
+		{"var field case", "Vars:\n  a:\n    Value: foo\n    Required: maybe\n", "test.wf.yaml: YAML type error in line 4:", "\n    Required: maybe\n    ^"},
+		{"step field case", "Steps:\n  create:\n    Timeout: 1m\n    CreateInstances: foo\n", "test.wf.yaml: YAML type error in line 4:", "\n    CreateInstances: foo\n    ^"},
+		{"ambiguous step field case", "Steps:\n  a:\n    Timeout: 1m\n  b:\n    Timeout: 5\n", "test.wf.yaml: json: cannot unmarshal number", "of type string"},
+	}
+	for _, tt := range tests {
+		var w *Workflow
+		err := UnmarshalWorkflow("test.wf.yaml", []byte(tt.data), &w)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.wantPrefix) || !strings.HasSuffix(err.Error(), tt.wantLine) {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestYAMLFieldPos(t *testing.T) {
+	lines := strings.Split(`Vars:
+  a:
+    Required: true
+Steps:
+  create:
+    CreateInstances:
+    - Name: i1
+    - Name: i2
+      # comment
+      Disks:
+      - SizeGb: 10
+      - "SizeGb": ten
+  flow:
+    CreateInstances: [{Name: i3, Disks: [{SizeGb: ten}]}]
+`, "\n")
+	tests := []struct {
+		field string
+		want  yamlPos
+		found bool
+	}{
+		{"Vars.a.Required", yamlPos{line: 2, col: 4}, true},
+		{"Steps.create.CreateInstances.1", yamlPos{line: 7, col: 6, inline: true}, true},
+		{"Steps.create.CreateInstances.1.Name", yamlPos{line: 7, col: 6}, true},
+		{"Steps.create.CreateInstances.1.Disks.1.SizeGb", yamlPos{line: 11, col: 8}, true},
+		{"CreateInstances.1.Disks", yamlPos{line: 9, col: 6}, true},
+		{"Required", yamlPos{line: 2, col: 4}, true},
+		// Relative paths are only searched under the steps and Vars.
+		{"Disks.1.SizeGb", yamlPos{}, false},
+		// Both steps have CreateInstances.
+		{"CreateInstances", yamlPos{}, false},
+		{"Steps.flow.CreateInstances.0.Disks", yamlPos{line: 13, col: 4}, true},
+		{"Steps.create.CreateInstances.2", yamlPos{line: 5, col: 4}, true},
+		{"dne", yamlPos{line: -1, col: -1}, false},
+	}
+	for _, tt := range tests {
+		got, found := yamlFieldPos(lines, tt.field)
+		if found != tt.found || (found && got != tt.want) {
+			t.Errorf("%s: got %+v, %t, want %+v, %t", tt.field, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestYAMLError(t *testing.T) {
+	data := []byte("Name: foo\nSteps:\n  bad: [\n")
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("yaml: line 3: did not find expected node content"), "f: YAML syntax error in line 3: yaml: line 3: did not find expected node content \n  bad: [\n  ^"},
+		{errors.New("yaml: line 3, column 8: foo"), "f: YAML syntax error in line 3: yaml: line 3, column 8: foo \n  bad: [\n       ^"},
+		{errors.New("yaml: line 10: foo"), "f: yaml: line 10: foo"},
+		{errors.New("foo"), "f: foo"},
+	}
+
+	for _, tt := range tests {
+		if got := YAMLError("f", data, tt.err).Error(); got != tt.want {
+			t.Errorf("got: %q, want: %q", got, tt.want)
+		}
+	}
+}
+
+func TestMarshalWorkflow(t *testing.T) {
+	w := &Workflow{Name: "foo", Vars: map[string]Var{"a": {Value: "b", Required: true}}}
+	for _, file := range []string{"foo.wf.json", "foo.wf.yaml"} {
+		data, err := MarshalWorkflow(file, w)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", file, err)
+		}
+		if IsYAML(file) == strings.HasPrefix(string(data), "{") {
+			t.Errorf("%s: marshalled in the wrong format:\n%s", file, data)
+		}
+		var got *Workflow
+		if err := UnmarshalWorkflow(file, data, &got); err != nil {
+			t.Fatalf("%s: error unmarshalling: %v", file, err)
+		}
+		if got.Name != w.Name || diff(got.Vars, w.Vars, 0) != "" {
+			t.Errorf("%s: round trip mismatch, got: %+v", file, got)
+		}
+	}
+}
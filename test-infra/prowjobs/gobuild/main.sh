@@ -33,6 +33,7 @@ GOBUILD_OUT=0
 cd /
 
 TARGETS=("github.com/${REPO_OWNER}/${REPO_NAME}/cli_tools/daisy"
+         "github.com/${REPO_OWNER}/${REPO_NAME}/cli_tools/compute_emulator"
          "github.com/${REPO_OWNER}/${REPO_NAME}/cli_tools/gce_export"
          "github.com/${REPO_OWNER}/${REPO_NAME}/cli_tools/gce_image_publish" 
          "github.com/${REPO_OWNER}/${REPO_NAME}/cli_tools/gce_inventory_agent" 